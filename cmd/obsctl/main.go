@@ -32,6 +32,8 @@ func main() {
         runImport(os.Args[2:])
     case "midi":
         runMidi(os.Args[2:])
+    case "sync":
+        runSync(os.Args[2:])
     case "version":
         printVersion()
     case "help", "-h", "--help":
@@ -43,6 +45,8 @@ func main() {
                 importUsage()
             case "midi":
                 midiUsage()
+            case "sync":
+                syncUsage()
             default:
                 usage()
             }
@@ -68,17 +72,20 @@ func usage() {
     fmt.Println("  trigger   複数OBSへ同時発火（シーン切替/将来のメディア操作）")
     fmt.Println("  import    ディレクトリからシーン+Media Sourceを生成")
     fmt.Println("  midi      MIDI入力を待機してシーン切替（試験的）")
+    fmt.Println("  sync      シーン同期（親機/子機）のツール（simulate: 機材なしで複数台をシミュレーション）")
     fmt.Println("  version   バージョン情報を表示")
     fmt.Println("")
     fmt.Println("ヘルプ:")
     fmt.Println("  obsctl help trigger   トリガーの詳細ヘルプ")
     fmt.Println("  obsctl help import    インポートの詳細ヘルプ")
+    fmt.Println("  obsctl help sync      同期シミュレーションの詳細ヘルプ")
     fmt.Println("")
     fmt.Println("例:")
     fmt.Println("  obsctl trigger -addrs 127.0.0.1:4455,127.0.0.1:4456 -password ****** -scene SceneA -at 2025-08-12T01:30:00+09:00 -spinwin 2ms")
     fmt.Println("  obsctl trigger -addrs 10.0.0.21:4455,10.0.0.22:4455 -passwords passA,passB -scene SceneA  # 個別パスワードの例")
     fmt.Println("  obsctl import -addr 127.0.0.1:4455 -password ****** -dir ./videos -loop -activate")
     fmt.Println("  obsctl midi -addrs 127.0.0.1:4455 -password ****** -device 'IAC Driver Bus 1'")
+    fmt.Println("  obsctl sync simulate -children 5 -latency 8ms -jitter 20ms -loss 0.02")
 }
 
 func printVersion() {
@@ -238,22 +245,49 @@ func importUsage() {
 }
 
 func midiUsage() {
-    fmt.Fprintln(os.Stderr, "Usage: obsctl midi [options] | ls-devices | gen-json [options]")
+    fmt.Fprintln(os.Stderr, "Usage: obsctl midi [options] | ls-devices | gen-json [options] | learn [options] | play [options] song.mid | chase [options] | record [options]")
     fmt.Fprintln(os.Stderr, "\n説明: MIDI 入力を監視し、イベントに応じて OBS のシーンを切り替えます（試験的）。")
     fmt.Fprintln(os.Stderr, "\n主なコマンド:")
     fmt.Fprintln(os.Stderr, "  ls-devices     利用可能な MIDI 入力デバイス一覧を表示")
-    fmt.Fprintln(os.Stderr, "  gen-json       OBSのシーン一覧から NoteOn マッピングJSONを生成し標準出力へ")
+    fmt.Fprintln(os.Stderr, "  gen-json       OBSのシーン一覧から NoteOn / PC / CC マッピングJSONを生成（-merge で既存の設定に追記）")
+    fmt.Fprintln(os.Stderr, "  learn          シーンを選んで MIDI ボタン/パッドを押すとマッピングを -config に保存（MIDI Learn）")
+    fmt.Fprintln(os.Stderr, "  play           Standard MIDI File をキュータイムラインとして再生（-dry-run で予定一覧）")
+    fmt.Fprintln(os.Stderr, "  chase          MIDI タイムコード（MTC）に追従し、timecode_cues の位置でシーンを切り替え")
+    fmt.Fprintln(os.Stderr, "  record         受信した MIDI イベントを JSONL に記録（-device replay://log.jsonl?speed=2 で再生）")
     fmt.Fprintln(os.Stderr, "\n主なオプション:")
-    fmt.Fprintln(os.Stderr, "  -addrs         OBS のアドレスをカンマ区切り (host:port)")
+    fmt.Fprintln(os.Stderr, "  -addrs         OBS のアドレスをカンマ区切り (host:port)。未指定で -config に hosts があればそちらを使用")
     fmt.Fprintln(os.Stderr, "  -password      パスワード（全接続共通）")
     fmt.Fprintln(os.Stderr, "  -passwords     個別パスワードをカンマ区切り（-addrs と同順・同数）。一致しない場合は無視して -password を使用")
-    fmt.Fprintln(os.Stderr, "  -device        監視する MIDI 入力デバイス名")
+    fmt.Fprintln(os.Stderr, "  -device        監視する MIDI 入力デバイス名（ネットワーク MIDI は rtpmidi://:5004、記録の再生は replay://log.jsonl）")
     fmt.Fprintln(os.Stderr, "  -channel       受け付ける MIDI チャネル (1-16、カンマ区切り)")
     fmt.Fprintln(os.Stderr, "  -debounce      デバウンス間隔 (例: 30ms)")
     fmt.Fprintln(os.Stderr, "  -ratelimit     レート制限の最短間隔 (例: 50ms)")
     fmt.Fprintln(os.Stderr, "  -timeout       OBS リクエストのタイムアウト (例: 5s)")
-    fmt.Fprintln(os.Stderr, "  -map-note      ノート→シーンの対応（複数可）。例: 1:36=028_エンドロール（ch:note=scene）")
-    fmt.Fprintln(os.Stderr, "  -config        JSON設定ファイルパス（device/channel/debounce/rate_limit/mappings）")
+    fmt.Fprintln(os.Stderr, "  -map-note      ノート→シーンの対応（複数可）。例: 1:36=028_エンドロール（ch:note=scene。CC は 1:cc64=…、PC は 1:pc5=…）")
+    fmt.Fprintln(os.Stderr, "  -config        JSON設定ファイルパス（device/channel/debounce/rate_limit/mappings/inputs/banks/hosts）")
+    fmt.Fprintln(os.Stderr, "  -watch         -config の変更確認間隔 (例: 1s、0 で無効)。変更時はマッピング等を再読込")
     fmt.Fprintln(os.Stderr, "  -debug         デバッグログを有効化")
     fmt.Fprintln(os.Stderr, "\n注: ネイティブMIDI入出力はビルドタグ 'midi_native' が必要です。詳細は docs/MIDI_SCENE_SWITCH.md を参照。")
 }
+
+func syncUsage() {
+    fmt.Fprintln(os.Stderr, "Usage: obsctl sync simulate [options]")
+    fmt.Fprintln(os.Stderr, "\n説明: 親機1台と子機N台のシーン同期を1プロセス内のメモリ内ネットワークで動かし、ACK 遅延と取りこぼしを集計します（OBS には接続しません）。")
+    fmt.Fprintln(os.Stderr, "\n主なオプション:")
+    fmt.Fprintln(os.Stderr, "  -children   子機の台数 (default: 5)")
+    fmt.Fprintln(os.Stderr, "  -events     シーン切替の回数 (default: 20)")
+    fmt.Fprintln(os.Stderr, "  -interval   シーン切替の間隔 (default: 500ms)")
+    fmt.Fprintln(os.Stderr, "  -lead       同期待ち時間 (default: 300ms)")
+    fmt.Fprintln(os.Stderr, "  -auto-lead  親機が測った遅延から同期待ち時間を決める（-lead は測れるまでの値）")
+    fmt.Fprintln(os.Stderr, "  -accept-late 子機の遅延許容 (default: 500ms)")
+    fmt.Fprintln(os.Stderr, "  -latency    片道の遅延 (default: 5ms)")
+    fmt.Fprintln(os.Stderr, "  -jitter     遅延の揺らぎ。latency より大きいと順序が入れ替わる (default: 5ms)")
+    fmt.Fprintln(os.Stderr, "  -loss       メッセージ損失率 0-1 (default: 0)")
+    fmt.Fprintln(os.Stderr, "  -flap       シーン切替ごとに子機1台を切断する確率 0-1 (default: 0)")
+    fmt.Fprintln(os.Stderr, "  -down       -flap で切断している時間 (default: 1s)")
+    fmt.Fprintln(os.Stderr, "  -drop-missed 切れた子機への配送を打ち切り再接続後に再送しない（drop_missed_events）")
+    fmt.Fprintln(os.Stderr, "  -apply      偽 OBS のシーン切替にかかる時間 (default: 0)")
+    fmt.Fprintln(os.Stderr, "  -skew       子機の時計のずれの最大値。各子機に ±skew を割り当て、時計合わせの推定値と比べる (default: 0)")
+    fmt.Fprintln(os.Stderr, "  -seed       乱数の種（同じ値で同じ損失・切断パターンを再現）")
+    fmt.Fprintln(os.Stderr, "  -v          各ノードの同期ログを表示")
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+    "context"
     "flag"
     "fmt"
     "log"
     "os"
     "encoding/json"
+    "regexp"
+    "strconv"
     "strings"
+    "sync"
     "time"
 
     "awesomeProject/internal/midi"
+    "awesomeProject/internal/midimap"
     "awesomeProject/internal/obsws"
     "github.com/andreykaipov/goobs"
 )
@@ -34,14 +39,26 @@ func runMidi(args []string) {
     } else if len(args) > 0 && (args[0] == "gen-json" || args[0] == "gen" ) {
         runMidiGenJSON(args[1:])
         return
+    } else if len(args) > 0 && args[0] == "learn" {
+        runMidiLearn(args[1:])
+        return
+    } else if len(args) > 0 && args[0] == "play" {
+        runMidiPlay(args[1:])
+        return
+    } else if len(args) > 0 && args[0] == "chase" {
+        runMidiChase(args[1:])
+        return
+    } else if len(args) > 0 && args[0] == "record" {
+        runMidiRecord(args[1:])
+        return
     }
 
     fs := flag.NewFlagSet("midi", flag.ExitOnError)
 
-    addrs := fs.String("addrs", "127.0.0.1:4455", "OBS WebSocket のアドレスをカンマ区切り（host:port）")
+    addrs := fs.String("addrs", "127.0.0.1:4455", "OBS WebSocket のアドレスをカンマ区切り（host:port。未指定で -config に hosts があればそちらを使用）")
     password := fs.String("password", "", "OBS WebSocket のパスワード（共通）")
     passwords := fs.String("passwords", "", "複数接続の個別パスワード。-addrs と同じ順でカンマ区切り（数が合わない場合は無視）")
-    device := fs.String("device", "", "監視する MIDI 入力デバイス名")
+    device := fs.String("device", "", "監視する MIDI 入力デバイス名（ネットワーク MIDI は rtpmidi://:5004、記録の再生は replay://log.jsonl）")
     channel := fs.String("channel", "", "受け付ける MIDI チャネル (1-16、カンマ区切り。未指定は全て)")
     debounce := fs.Duration("debounce", 30*time.Millisecond, "デバウンス間隔")
     ratelimit := fs.Duration("ratelimit", 50*time.Millisecond, "レート制限の最短間隔")
@@ -49,7 +66,8 @@ func runMidi(args []string) {
     debug := fs.Bool("debug", false, "デバッグログを有効化")
     mapNotes := multiFlag{}
     fs.Var(&mapNotes, "map-note", "ノート→シーンの対応（複数可）。例: 1:36=028_エンドロール（ch:note=scene）")
-    configPath := fs.String("config", "", "JSON設定ファイルへのパス（device/channel/debounce/rate_limit/mappings を読込）")
+    configPath := fs.String("config", "", "JSON設定ファイルへのパス（device/channel/debounce/rate_limit/mappings/inputs/banks/hosts を読込）")
+    watch := fs.Duration("watch", time.Second, "-config の変更を確認する間隔（変更時はマッピング等を再読込。0 で無効）")
 
     fs.Usage = midiUsage
     _ = fs.Parse(args)
@@ -59,6 +77,12 @@ func runMidi(args []string) {
     setFlags := map[string]bool{}
     fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
     cfgNoteMap := map[string]string{}
+    var mscDevice *uint8
+    var controls map[string]*midimap.Control
+    modes := map[string]midimap.ModeSpec{}
+    var routes map[string]midimap.Route
+    var inputs []*midimap.File // inputs（追加の入力デバイス）
+    var showCfg *midimap.File  // hosts / banks / feedback_device
     if strings.TrimSpace(*configPath) != "" {
         // 未指定のときはゼロ値にして JSON を適用可能にする
         if !setFlags["debounce"] { *debounce = 0 }
@@ -66,6 +90,23 @@ func runMidi(args []string) {
         if err := loadJSONConfig(*configPath, device, channel, debounce, ratelimit, cfgNoteMap); err != nil {
             log.Fatalf("-config の読み込みに失敗しました: %v", err)
         }
+        // 再読込（reloadMidiRules）と同じく、読めない・検証に通らない設定では起動しない
+        cfg, err := midimap.LoadFile(*configPath)
+        if err != nil {
+            log.Fatalf("-config の読み込みに失敗しました: %v", err)
+        }
+        if err := cfg.Validate(); err != nil {
+            log.Fatalf("-config に問題があります:\n%v", err)
+        }
+        mscDevice = cfg.MSCDeviceID()
+        controls = cfg.Controls()
+        modes = cfg.Modes()
+        routes = cfg.Routes()
+        inputs = cfg.DeviceFiles()[1:]
+        showCfg = cfg
+        if n := cfg.IgnoredTransitions(); n > 0 {
+            log.Printf("注意: %d 件のマッピングの transition を無視します（使うには \"apply_transitions\": true を指定してください）", n)
+        }
     }
 
     if *device == "" {
@@ -78,7 +119,23 @@ func runMidi(args []string) {
     }
 
     // MIDI ドライバをオープン（ビルドタグ未指定の通常ビルドではエラーになるスタブ）
-    drv, events, err := midi.OpenInput(*device)
+    // -device（JSON の device）に加えて inputs のデバイスも開き、イベントを 1 本にまとめる。
+    // 抜き差しを検出して自動で再接続する。イベントチャネルは終了まで閉じない。
+    devices := []string{*device}
+    for _, in := range inputs {
+        if in.Device == "" || in.Device == *device {
+            continue // Validate で警告済み
+        }
+        devices = append(devices, in.Device)
+    }
+    drv, events, err := midi.OpenInputsAuto(devices, midi.ReconnectOptions{}, func(dev string, state midi.State, port string, err error) {
+        switch state {
+        case midi.StateConnected:
+            log.Printf("MIDI 接続: %s (%s)", port, dev)
+        case midi.StateDisconnected:
+            log.Printf("MIDI 切断: %s (%s, %v)。再接続を待機します", port, dev, err)
+        }
+    })
     if err != nil {
         log.Printf("MIDI 入力のオープンに失敗: %v", err)
         log.Println("ネイティブMIDI機能はビルドタグ 'midi_native' が必要です。詳細は docs/MIDI_SCENE_SWITCH.md を参照してください。")
@@ -86,70 +143,187 @@ func runMidi(args []string) {
     }
     defer drv.Close()
 
-    // マッピングの構築（NoteOn）: JSON→CLI の順にマージ（CLI優先）
+    // マッピングの構築（NoteOn/CC/PC）: JSON→CLI の順にマージ（CLI優先）
     noteMap := map[string]string{}
     for k, v := range cfgNoteMap { noteMap[k] = v }
     for k, v := range parseNoteMaps(mapNotes) { noteMap[k] = v }
     if *debug && len(noteMap) > 0 {
         log.Printf("NoteMap: %d entries", len(noteMap))
     }
-    if len(noteMap) == 0 {
+    if len(noteMap) == 0 && len(controls) == 0 && len(devices) == 1 && (showCfg == nil || len(showCfg.Banks) == 0) {
         log.Println("警告: ノート→シーンのマッピングが指定されていません。-map-note \"1:36=Scene\" のように指定してください。")
     }
+    eval := midimap.NewEvaluator(showCfg.ApplyBanks(&midimap.Rules{Channels: parseChannels(*channel), Debounce: *debounce, RateLimit: *ratelimit, Scenes: noteMap, MSCDevice: mscDevice, Controls: controls, Modes: mergeNoteModes(modes, mapNotes), Routes: withoutNoteRoutes(routes, mapNotes)}))
+    // バンクの LED フィードバック（feedback_device 指定時）。開けなくても切替自体は続ける
+    var feedback midi.Output
+    if showCfg != nil && strings.TrimSpace(showCfg.FeedbackDevice) != "" {
+        out, err := midi.OpenOutput(showCfg.FeedbackDevice)
+        if err != nil {
+            log.Printf("警告: MIDI フィードバック出力を開けません（バンク表示なしで継続）: %v", err)
+        } else {
+            feedback = out
+            defer out.Close()
+        }
+    }
+    if i, name := eval.Bank(); name != "" {
+        log.Printf("バンク: %s (%d/%d)", name, i+1, len(eval.Rules().Banks))
+        sendBankFeedback(feedback, eval)
+    }
+    // デバイスごとの判定器。inputs のデバイスは各自のチャネルとマッピングで判定する（-map-note は 1 台目のみ）
+    evals := map[string]*midimap.Evaluator{*device: eval}
+    for _, in := range inputs {
+        if _, dup := evals[in.Device]; dup || in.Device == "" {
+            continue
+        }
+        evals[in.Device] = midimap.NewEvaluator(inputRules(in, *debounce, *ratelimit))
+    }
 
-    log.Printf("MIDI 受信開始: device=%s", *device)
-    lastAt := map[string]time.Time{}
-    targets := strings.Split(*addrs, ",")
-    var pwlist []string
-    if strings.TrimSpace(*passwords) != "" {
-        pws := strings.Split(*passwords, ",")
-        if len(pws) == len(targets) {
-            for i := range pws { pws[i] = strings.TrimSpace(pws[i]) }
-            pwlist = pws
+    // 切替先: -addrs を明示しなければ JSON の hosts を使う（マッピングの hosts で送り先を絞れる）
+    hosts := flagHosts(*addrs, *password, *passwords)
+    if showCfg != nil && len(showCfg.Hosts) > 0 {
+        if setFlags["addrs"] {
+            log.Println("警告: -addrs を指定したため -config の hosts とマッピングの送り先指定（hosts）は無視します")
         } else {
-            log.Printf("警告: -passwords の数 (%d) が -addrs の数 (%d) と一致しません。-password（共通）を使用します。", len(pws), len(targets))
+            hosts = configHosts(showCfg)
         }
     }
+    log.Printf("切替先: %s", hosts)
+
+    // -config のホットリロード: 検証に通った場合だけルールを丸ごと差し替える（明示したフラグは引き続き優先）
+    if strings.TrimSpace(*configPath) != "" && *watch > 0 {
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        go midimap.WatchFile(ctx, *configPath, *watch, func() {
+            rules, cfg, inRules, err := reloadMidiRules(*configPath, setFlags, *channel, *debounce, *ratelimit, mapNotes)
+            if err != nil {
+                log.Printf("-config の再読込に失敗しました（現在のマッピングを継続）:\n%v", err)
+                return
+            }
+            if dev := strings.TrimSpace(cfg.Device); dev != "" && dev != *device && !setFlags["device"] {
+                log.Printf("警告: device の変更 (%s) は再起動後に反映されます", dev)
+            }
+            if len(hosts.names) > 0 && configHosts(cfg).String() != hosts.String() {
+                log.Println("警告: hosts の変更は再起動後に反映されます（マッピングの送り先指定は現在の hosts で解決します）")
+            }
+            eval.Swap(rules)
+            sendBankFeedback(feedback, eval)
+            for dev, r := range inRules {
+                if e, ok := evals[dev]; ok && e != eval {
+                    e.Swap(r)
+                } else if !ok {
+                    log.Printf("警告: inputs に追加したデバイス (%s) は再起動後に反映されます", dev)
+                }
+            }
+            log.Printf("-config を再読込しました: mappings=%d controls=%d banks=%d channel=%v debounce=%s ratelimit=%s", len(rules.Scenes), len(rules.Controls), len(rules.Banks), rules.Channels, rules.Debounce, rules.RateLimit)
+        })
+    }
+
+    log.Printf("MIDI 受信開始: device=%s", strings.Join(devices, ", "))
+    // フェーダー等の連続値操作は常時接続の Pool で送る（接続は最初の操作時）
+    pool := obsws.NewPool(hosts.addrs, hosts.password, hosts.passwords, *timeout)
+    defer pool.Close()
+    driver := midimap.NewControlDriver(sendControl(pool, hosts), controlErrorLogger())
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go driver.Run(ctx, 10*time.Millisecond)
+    // momentary / toggle の戻り先（alt_scene 未指定時）に使うため、起動時のシーンを取得しておく
+    go func() {
+        if scene, err := pool.CurrentProgramScene(); err == nil && eval.Current() == "" {
+            for _, e := range evals {
+                e.SetCurrent(scene)
+            }
+            if *debug {
+                log.Printf("現在のシーン: %s", scene)
+            }
+        }
+    }()
+
     for ev := range events {
-        if *channel != "" && !containsChannel(parseChannels(*channel), int(ev.Channel)) {
+        if ev.Type == midi.TimecodeQuarterFrame || ev.Type == midi.TimecodeFull {
+            continue // MTC は midi chase で扱う
+        }
+        e := evals[ev.Device]
+        if e == nil {
+            e = eval
+        }
+        res := e.Evaluate(ev, time.Now())
+        if res.Skip == "channel" {
+            continue
+        }
+        if res.Bank != "" {
+            if res.Release {
+                log.Printf("バンク: %s (from %s, シフト解除)", res.Bank, res.Key)
+            } else {
+                log.Printf("バンク: %s (from %s)", res.Bank, res.Key)
+            }
+            if e == eval {
+                sendBankFeedback(feedback, e)
+            }
+            continue
+        }
+        if res.Control != nil {
+            if *debug {
+                log.Printf("MIDI: %s=%d → %s %.3f", res.Key, res.Value, res.Control.Target(), res.Control.Scale(res.Value))
+            }
+            driver.Set(res.Control, res.Value, time.Now())
             continue
         }
         if *debug {
-            log.Printf("MIDI: type=%s ch=%d data1=%d data2=%d t=%s", ev.Type, ev.Channel, ev.Data1, ev.Data2, ev.Time.Format(time.RFC3339Nano))
-        }
-        if ev.Type == midi.NoteOn {
-            key := fmt.Sprintf("%d:%d", ev.Channel, ev.Data1)
-            if scene, ok := noteMap[key]; ok {
-                if cool, ok2 := lastAt[key]; ok2 {
-                    if since := time.Since(cool); since < *ratelimit {
-                        if *debug {
-                            log.Printf("skip by ratelimit %s for %s (remain %s)", ratelimit.String(), key, (*ratelimit - since).String())
-                        }
-                        continue
-                    }
-                }
-                lastAt[key] = time.Now()
-
-                opts := obsws.TriggerOptions{
-                    Addrs:     targets,
-                    Password:  *password,
-                    Passwords: pwlist,
-                    Scene:     scene,
-                    Media:     "",
-                    Action:    "none",
-                    FireTime:  time.Now(),
-                    SpinWin:   0,
-                    Timeout:   *timeout,
-                    SkewLog:   false,
-                }
-                if err := obsws.Trigger(opts); err != nil {
-                    log.Printf("シーン切替失敗: %v", err)
-                } else {
-                    log.Printf("シーン切替: %s (from CH%d Note%d)", scene, ev.Channel, ev.Data1)
-                }
+            if ev.MSC != nil {
+                log.Printf("MIDI[%s]: type=%s device=%d command=%s cue=%q list=%q t=%s", ev.Device, ev.Type, ev.MSC.DeviceID, ev.MSC.Command, ev.MSC.Cue, ev.MSC.List, ev.Time.Format(time.RFC3339Nano))
+            } else {
+                log.Printf("MIDI[%s]: type=%s ch=%d data1=%d data2=%d t=%s", ev.Device, ev.Type, ev.Channel, ev.Data1, ev.Data2, ev.Time.Format(time.RFC3339Nano))
+            }
+            if res.Skip == "device" {
+                log.Printf("skip by msc_device_id for %s", ev.MSC.Command)
+            }
+            if res.Skip == "debounce" || res.Skip == "ratelimit" || res.Skip == "latched" {
+                log.Printf("skip by %s for %s", res.Skip, res.Key)
             }
+            if res.Skip == "norevert" {
+                log.Printf("skip: %s (%s) の戻り先シーンが不明です。alt_scene を指定してください", res.Key, res.Mode)
+            }
+        }
+        if !res.Fire() {
+            continue
+        }
+        addrsFor, pwsFor := hosts.pick(res.Route.Hosts)
+        if len(addrsFor) == 0 {
+            log.Printf("skip: %s の送り先ホスト %v が hosts にありません", res.Key, res.Route.Hosts)
+            continue
+        }
+
+        opts := obsws.TriggerOptions{
+            Addrs:     addrsFor,
+            Password:  hosts.password,
+            Passwords: pwsFor,
+            Scene:     res.Scene,
+            Transition: res.Route.Transition,
+            Media:     "",
+            Action:    "none",
+            FireTime:  time.Now(),
+            SpinWin:   0,
+            Timeout:   *timeout,
+            SkewLog:   false,
+        }
+        if err := obsws.Trigger(opts); err != nil {
+            log.Printf("シーン切替失敗: %v", err)
+            continue
+        }
+        // 他のデバイスの momentary / toggle の戻り先も揃える
+        for _, other := range evals {
+            if other != e {
+                other.SetCurrent(res.Scene)
+            }
+        }
+        if res.Release {
+            log.Printf("シーン切替: %s (from %s, 離した)", res.Scene, res.Key)
+        } else {
+            log.Printf("シーン切替: %s (from %s)", res.Scene, res.Key)
         }
     }
+    log.Println("MIDI 入力が終了しました") // replay:// を末尾まで再生したとき
+    obsws.WaitTransitionRestores(15 * time.Second) // 切替で変えたトランジションを戻してから終了する
 }
 
 func parseChannels(s string) []int {
@@ -171,68 +345,105 @@ func parseChannels(s string) []int {
     return out
 }
 
-func containsChannel(list []int, v int) bool {
-    if len(list) == 0 {
-        return true
-    }
-    for _, x := range list {
-        if x == v {
-            return true
-        }
-    }
-    return false
-}
-
 // multiFlag は同名フラグの複数指定を受け取るためのヘルパ。
 type multiFlag []string
 func (m *multiFlag) String() string { return strings.Join(*m, ",") }
 func (m *multiFlag) Set(s string) error { *m = append(*m, s); return nil }
 
-// parseNoteMaps は "ch:note=Scene Name" 形式の配列を解析し、
-// key "ch:note" → scene の map を返す。
+// parseNoteMaps は "ch:note=Scene Name" 形式（CC は "ch:cc64=..."、PC は "ch:pc5=..."）の配列を解析し、
+// key "ch:note" → scene の map を返す。不正な行は無視する。
 func parseNoteMaps(values []string) map[string]string {
     out := map[string]string{}
     for _, v := range values {
-        v = strings.TrimSpace(v)
-        if v == "" { continue }
-        parts := strings.SplitN(v, "=", 2)
-        if len(parts) != 2 { continue }
-        left := strings.TrimSpace(parts[0])
-        right := strings.TrimSpace(parts[1])
-        if right == "" { continue }
-        ln := strings.Split(left, ":")
-        if len(ln) != 2 { continue }
-        ch := strings.TrimSpace(ln[0])
-        note := strings.TrimSpace(ln[1])
-        var chv, nv int
-        if _, err := fmt.Sscanf(ch, "%d", &chv); err != nil || chv < 1 || chv > 16 { continue }
-        if _, err := fmt.Sscanf(note, "%d", &nv); err != nil || nv < 0 || nv > 127 { continue }
-        key := fmt.Sprintf("%d:%d", chv, nv)
-        out[key] = right
+        m, err := midimap.ParseLine(v)
+        if err != nil { continue }
+        key, _ := m.Key()
+        out[key] = m.Scene
+    }
+    return out
+}
+
+// reloadMidiRules は -config を読み直して検証し、新しいルールと読み込んだ設定、inputs のデバイスごとのルールを返す。
+// 起動時に明示したフラグ（-channel/-debounce/-ratelimit/-map-note）は JSON より優先する。
+func reloadMidiRules(path string, setFlags map[string]bool, channel string, debounce, ratelimit time.Duration, mapNotes []string) (*midimap.Rules, *midimap.File, map[string]*midimap.Rules, error) {
+    cfg, err := midimap.LoadFile(path)
+    if err != nil { return nil, nil, nil, err }
+    if err := cfg.Validate(); err != nil { return nil, nil, nil, err }
+
+    device := ""
+    if !setFlags["channel"] { channel = "" }
+    if !setFlags["debounce"] { debounce = 0 }
+    if !setFlags["ratelimit"] { ratelimit = 0 }
+    noteMap := map[string]string{}
+    applyJSONConfig(cfg, &device, &channel, &debounce, &ratelimit, noteMap)
+    for k, v := range parseNoteMaps(mapNotes) { noteMap[k] = v }
+    inRules := map[string]*midimap.Rules{}
+    for _, in := range cfg.DeviceFiles()[1:] {
+        inRules[in.Device] = inputRules(in, debounce, ratelimit)
     }
+    rules := &midimap.Rules{Channels: parseChannels(channel), Debounce: debounce, RateLimit: ratelimit, Scenes: noteMap, MSCDevice: cfg.MSCDeviceID(), Controls: cfg.Controls(), Modes: mergeNoteModes(cfg.Modes(), mapNotes), Routes: withoutNoteRoutes(cfg.Routes(), mapNotes)}
+    return cfg.ApplyBanks(rules), cfg, inRules, nil
+}
+
+// inputRules は inputs の 1 台分のルールを作る。debounce / ratelimit は 1 台目と同じ値を使う。
+func inputRules(in *midimap.File, debounce, ratelimit time.Duration) *midimap.Rules {
+    device, channel := "", ""
+    noteMap := map[string]string{}
+    applyJSONConfig(in, &device, &channel, &debounce, &ratelimit, noteMap)
+    return &midimap.Rules{Channels: parseChannels(channel), Debounce: debounce, RateLimit: ratelimit, Scenes: noteMap, MSCDevice: in.MSCDeviceID(), Controls: in.Controls(), Modes: in.Modes(), Routes: in.Routes()}
+}
+
+// mergeNoteModes は JSON のモードに -map-note（"1:36=Scene|momentary" 形式）のモードを上書きで加える。
+// -map-note で同じトリガをモードなしで指定した場合は trigger に戻す。
+func mergeNoteModes(modes map[string]midimap.ModeSpec, mapNotes []string) map[string]midimap.ModeSpec {
+    out := map[string]midimap.ModeSpec{}
+    for k, v := range modes { out[k] = v }
+    cli := midimap.NewRules(nil, 0, 0, mapNotes)
+    for k := range cli.Scenes { delete(out, k) }
+    for k, v := range cli.Modes { out[k] = v }
     return out
 }
 
+// sendBankFeedback は有効なバンクの切替トリガを点灯（他は消灯）するメッセージを out に送る（out が nil なら何もしない）。
+func sendBankFeedback(out midi.Output, e *midimap.Evaluator) {
+    if out == nil {
+        return
+    }
+    i, _ := e.Bank()
+    for _, msg := range e.Rules().BankFeedback(i) {
+        if err := out.Send(msg); err != nil {
+            log.Printf("MIDI フィードバックの送信に失敗: %v", err)
+            return
+        }
+    }
+}
+
+// controlErrorLogger は連続値操作の送信エラーを対象ごとに 2 秒に 1 回までログに出す。
+func controlErrorLogger() func(c *midimap.Control, err error) {
+    var mu sync.Mutex
+    last := map[string]time.Time{}
+    return func(c *midimap.Control, err error) {
+        mu.Lock()
+        defer mu.Unlock()
+        if t, ok := last[c.Target()]; ok && time.Since(t) < 2*time.Second {
+            return
+        }
+        last[c.Target()] = time.Now()
+        log.Printf("%s の送信に失敗: %v", c.Target(), err)
+    }
+}
+
 // JSON設定の読み込みと反映。
 // device, channel, debounce, ratelimit は未指定時のデフォルトとして上書きし、noteMap に mappings を追加する。
 func loadJSONConfig(path string, device *string, channel *string, debounce *time.Duration, ratelimit *time.Duration, noteMap map[string]string) error {
-    bt, err := os.ReadFile(path)
+    cfg, err := midimap.LoadFile(path)
     if err != nil { return err }
-    var cfg struct {
-        Device    string `json:"device"`
-        Channel   int    `json:"channel"`
-        Debounce  string `json:"debounce"`
-        RateLimit string `json:"rate_limit"`
-        Mappings  []struct{
-            Type       string `json:"type"`
-            Channel    int    `json:"channel"`
-            Note       int    `json:"note"`
-            Scene      string `json:"scene"`
-            Transition string `json:"transition"`
-        } `json:"mappings"`
-    }
-    if err := json.Unmarshal(bt, &cfg); err != nil { return err }
+    applyJSONConfig(cfg, device, channel, debounce, ratelimit, noteMap)
+    return nil
+}
 
+// applyJSONConfig は読み込み済みの JSON 設定を反映する（不正なマッピングは読み捨てる）。
+func applyJSONConfig(cfg *midimap.File, device *string, channel *string, debounce *time.Duration, ratelimit *time.Duration, noteMap map[string]string) {
     if *device == "" && strings.TrimSpace(cfg.Device) != "" { *device = cfg.Device }
     if strings.TrimSpace(*channel) == "" && cfg.Channel >= 1 && cfg.Channel <= 16 { *channel = fmt.Sprintf("%d", cfg.Channel) }
     if d := strings.TrimSpace(cfg.Debounce); d != "" && *debounce == 0 {
@@ -242,35 +453,77 @@ func loadJSONConfig(path string, device *string, channel *string, debounce *time
         if rv, err := time.ParseDuration(r); err == nil { *ratelimit = rv }
     }
     for _, m := range cfg.Mappings {
-        if strings.ToLower(strings.TrimSpace(m.Type)) != "note_on" { continue }
-        if m.Channel < 1 || m.Channel > 16 { continue }
-        if m.Note < 0 || m.Note > 127 { continue }
+        key, err := m.Key()
+        if err != nil { continue }
         if strings.TrimSpace(m.Scene) == "" { continue }
-        key := fmt.Sprintf("%d:%d", m.Channel, m.Note)
         noteMap[key] = m.Scene
     }
-    return nil
 }
 
-// runMidiGenJSON は OBS からシーン一覧を取得し、NoteOnの連番マッピングをJSONで出力する。
+// runMidiGenJSON は OBS からシーン一覧を取得し、NoteOn（または PC / CC）の連番マッピングをJSONで出力する。
+// -merge で既存の設定を読み込むと、手で編集したマッピングは残したまま未割り当てのシーンだけを追加する。
 // 例: obsctl midi gen-json -addr 127.0.0.1:4455 -password ****** -channel 1 -start-note 36 -device "IACドライバ バス1"
+// 例: obsctl midi gen-json -merge midi.json -out midi.json -filter '^0' -prefix
 func runMidiGenJSON(args []string) {
     fs := flag.NewFlagSet("midi gen-json", flag.ExitOnError)
     addr := fs.String("addr", "127.0.0.1:4455", "OBS のアドレス (host:port)")
     password := fs.String("password", "", "OBS のパスワード")
-    channel := fs.Int("channel", 1, "割り当てる MIDI チャネル (1-16)")
-    start := fs.Int("start-note", 36, "割り当て開始ノート番号 (0-127、既定36:C1)")
+    channel := fs.String("channel", "1", "割り当てる MIDI チャネル (1-16、カンマ区切りで複数。先頭から順に使い切る)")
+    start := fs.Int("start-note", 36, "割り当て開始番号 (0-127、既定36:C1。-type pc/cc ではプログラム/CC 番号)")
+    msgType := fs.String("type", "note", "割り当てるメッセージ: note|pc|cc")
+    filter := fs.String("filter", "", "割り当てるシーン名の正規表現（未指定は全て）")
+    prefix := fs.Bool("prefix", false, "シーン名の数字の接頭辞（例: 012_）を番号に使う（範囲外・使用済みは連番）")
+    merge := fs.String("merge", "", "既存の JSON 設定に追記する（既存のマッピングは変更しない）")
+    out := fs.String("out", "-", "書き出し先の JSON ファイル（- は標準出力。-merge と同じパスで上書き可）")
     device := fs.String("device", "", "推奨デバイス名（出力JSONに記録するだけ）")
-    transition := fs.String("transition", "fade", "トランジション: fade|cut（JSONに記録）")
+    transition := fs.String("transition", "", "トランジション: fade|cut（マッピングの transition。指定すると apply_transitions も有効にし、obsctl midi が切替の直前に設定する）")
     pretty := fs.Bool("pretty", true, "インデント付きで出力")
     _ = fs.Parse(args)
 
-    if *channel < 1 || *channel > 16 {
-        log.Fatalf("-channel は 1..16 を指定してください: %d", *channel)
+    var channels []int
+    for _, p := range strings.Split(*channel, ",") {
+        v, err := strconv.Atoi(strings.TrimSpace(p))
+        if err != nil || v < 1 || v > 16 {
+            log.Fatalf("-channel は 1..16 を指定してください: %q", p)
+        }
+        channels = append(channels, v)
     }
     if *start < 0 || *start > 127 {
         log.Fatalf("-start-note は 0..127 を指定してください: %d", *start)
     }
+    opts := midimap.GenOptions{Channels: channels, Start: *start, FromPrefix: *prefix, Transition: *transition}
+    switch strings.ToLower(*msgType) {
+    case "note", "note_on":
+        opts.Type = midi.NoteOn
+    case "pc", "program", "program_change":
+        opts.Type = midi.ProgramChange
+    case "cc", "control", "control_change":
+        opts.Type = midi.ControlChange
+    default:
+        log.Fatalf("-type は note|pc|cc を指定してください: %q", *msgType)
+    }
+    if *filter != "" {
+        re, err := regexp.Compile(*filter)
+        if err != nil {
+            log.Fatalf("-filter が不正です: %v", err)
+        }
+        opts.Filter = re
+    }
+
+    cfg := &midimap.File{Debounce: "30ms", RateLimit: "50ms"}
+    if len(channels) == 1 {
+        cfg.Channel = channels[0]
+    }
+    if *merge != "" {
+        f, err := midimap.LoadFile(*merge)
+        if err != nil {
+            log.Fatalf("-merge の読み込みに失敗しました: %v", err)
+        }
+        cfg = f
+    }
+    if *device != "" {
+        cfg.Device = *device
+    }
 
     // 接続
     cli, err := goobs.New(obsws.NormalizeObsAddr(*addr), goobs.WithPassword(*password))
@@ -283,54 +536,40 @@ func runMidiGenJSON(args []string) {
     if err != nil {
         log.Fatalf("シーン一覧取得失敗: %v", err)
     }
-
-    // マッピング生成
-    type mapping struct {
-        Type       string `json:"type"`
-        Channel    int    `json:"channel"`
-        Note       int    `json:"note"`
-        Scene      string `json:"scene"`
-        Transition string `json:"transition,omitempty"`
-    }
-    payload := struct {
-        Device     string    `json:"device,omitempty"`
-        Channel    int       `json:"channel"`
-        Debounce   string    `json:"debounce,omitempty"`
-        RateLimit  string    `json:"rate_limit,omitempty"`
-        Mappings   []mapping `json:"mappings"`
-    }{
-        Device:    *device,
-        Channel:   *channel,
-        Debounce:  "30ms",
-        RateLimit: "50ms",
-    }
-
-    n := *start
+    names := make([]string, 0, len(lst.Scenes))
     for _, s := range lst.Scenes {
-        if n > 127 {
-            // これ以上割り当て不可。残りは無視（重複名もそのまま）。
-            break
-        }
-        payload.Mappings = append(payload.Mappings, mapping{
-            Type:       "note_on",
-            Channel:    *channel,
-            Note:       n,
-            Scene:      s.SceneName,
-            Transition: *transition,
-        })
-        n++
+        names = append(names, s.SceneName)
+    }
+
+    // マッピング生成（既存のシーンと使用中の番号は避ける）
+    res := cfg.Generate(names, opts)
+    log.Printf("割り当て: 追加 %d / 既存 %d / 対象外 %d", len(res.Added), len(res.Kept), len(res.Filtered))
+    for _, s := range res.Fallback {
+        log.Printf("警告: %s の接頭辞の番号は使えないため連番で割り当てました", s)
+    }
+    if len(res.Skipped) > 0 {
+        // これ以上割り当て不可。残りは出力しない
+        log.Printf("警告: 空き番号が無いため %d 件を割り当てませんでした: %s", len(res.Skipped), strings.Join(res.Skipped, ", "))
     }
 
-    var out []byte
+    if cfg.Mappings == nil {
+        cfg.Mappings = []midimap.Mapping{}
+    }
+    var bt []byte
     if *pretty {
-        out, err = json.MarshalIndent(payload, "", "  ")
+        bt, err = json.MarshalIndent(cfg, "", "  ")
     } else {
-        out, err = json.Marshal(payload)
+        bt, err = json.Marshal(cfg)
     }
     if err != nil {
         log.Fatalf("JSON 生成失敗: %v", err)
     }
-
-    os.Stdout.Write(out)
-    os.Stdout.WriteString("\n")
+    bt = append(bt, '\n')
+    if *out == "-" {
+        os.Stdout.Write(bt)
+        return
+    }
+    if err := os.WriteFile(*out, bt, 0o644); err != nil {
+        log.Fatalf("-out の書き込みに失敗しました: %v", err)
+    }
 }
@@ -0,0 +1,220 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "awesomeProject/internal/midi"
+    "awesomeProject/internal/midimap"
+    "awesomeProject/internal/obsws"
+)
+
+// runMidiChase は MIDI タイムコード（MTC）に追従し、timecode_cues の位置で全接続先のシーンを切り替える。
+// 例: obsctl midi chase -addrs 127.0.0.1:4455 -password ****** -device "IAC Driver Bus 1" -config show.json
+func runMidiChase(args []string) {
+    fs := flag.NewFlagSet("midi chase", flag.ExitOnError)
+    addrs := fs.String("addrs", "127.0.0.1:4455", "OBS WebSocket のアドレスをカンマ区切り（host:port）")
+    password := fs.String("password", "", "OBS WebSocket のパスワード（共通）")
+    passwords := fs.String("passwords", "", "複数接続の個別パスワード。-addrs と同じ順でカンマ区切り（数が合わない場合は無視）")
+    device := fs.String("device", "", "MTC を受信する MIDI 入力デバイス名（未指定は JSON の device）")
+    configPath := fs.String("config", "", "JSON設定ファイルへのパス（timecode_cues / frame_rate を使用）")
+    fps := fs.String("fps", "", "フレームレート 24/25/29.97df/30（未指定は JSON の frame_rate、それも無ければ受信した MTC のレート）")
+    lookahead := fs.Duration("lookahead", 500*time.Millisecond, "キューを前もって準備する時間（OBS への接続時間を見込む）")
+    jump := fs.String("jump", midimap.JumpChase, "タイムコードが飛んだときの扱い: chase（直前のキューのシーンに合わせる）/ skip（次のキューから再開）")
+    loss := fs.Duration("loss", 250*time.Millisecond, "クォーターフレームがこの時間途絶えたら停止とみなす")
+    dryRun := fs.Bool("dry-run", false, "接続せずにキュー一覧を表示して終了")
+    timeout := fs.Duration("timeout", 5*time.Second, "OBS リクエストのタイムアウト")
+    spinwin := fs.Duration("spinwin", 2*time.Millisecond, "発火前スピン時間 (精度/CPUバランス)")
+    debug := fs.Bool("debug", false, "デバッグログを有効化（ロック中の位置を 1 秒ごとに表示）")
+    fs.Usage = midiChaseUsage
+    _ = fs.Parse(args)
+
+    if strings.TrimSpace(*configPath) == "" {
+        midiChaseUsage()
+        os.Exit(2)
+    }
+    if *jump != midimap.JumpChase && *jump != midimap.JumpSkip {
+        log.Fatalf("-jump は chase か skip を指定してください: %q", *jump)
+    }
+    if *loss < 2*time.Millisecond {
+        // 停止の判定は -loss の半分ごとに行うため、0 以下や極端に短い値は使えない
+        log.Printf("-loss は 2ms 以上を指定してください: %s", *loss)
+        midiChaseUsage()
+        os.Exit(2)
+    }
+    cfg, err := midimap.LoadFile(*configPath)
+    if err != nil {
+        log.Fatalf("-config の読み込みに失敗しました: %v", err)
+    }
+    rate, err := cfg.TimecodeRate()
+    if err != nil {
+        log.Fatalf("-config: %v", err)
+    }
+    if strings.TrimSpace(*fps) != "" {
+        if rate, err = midi.ParseFrameRate(*fps); err != nil {
+            log.Fatalf("-fps: %v", err)
+        }
+    }
+    chaser, err := midimap.NewChaser(cfg.TimecodeCues, rate)
+    if err != nil {
+        log.Fatalf("-config の timecode_cues が不正です:\n%v", err)
+    }
+    chaser.Lookahead = *lookahead
+    chaser.JumpMode = *jump
+    if len(chaser.Cues()) == 0 {
+        log.Fatal("timecode_cues がありません。-config に {\"at\": \"01:00:00:00\", \"scene\": \"...\"} の形式で指定してください。")
+    }
+    if *dryRun {
+        for i, c := range chaser.Cues() {
+            fmt.Printf("%4d  %s  → %s\n", i+1, c.Timecode, c.Scene)
+        }
+        return
+    }
+
+    if *device == "" {
+        *device = cfg.Device
+    }
+    if *device == "" {
+        log.Println("-device を指定してください（JSONの device も利用可）。利用可能なデバイスは 'obsctl midi ls-devices' で確認できます。")
+        os.Exit(2)
+    }
+    targets := strings.Split(*addrs, ",")
+    var pwlist []string
+    if strings.TrimSpace(*passwords) != "" {
+        pws := strings.Split(*passwords, ",")
+        if len(pws) == len(targets) {
+            for i := range pws { pws[i] = strings.TrimSpace(pws[i]) }
+            pwlist = pws
+        } else {
+            log.Printf("警告: -passwords の数 (%d) が -addrs の数 (%d) と一致しません。-password（共通）を使用します。", len(pws), len(targets))
+        }
+    }
+
+    drv, events, err := midi.OpenInputAuto(*device, midi.ReconnectOptions{
+        OnState: func(state midi.State, port string, err error) {
+            switch state {
+            case midi.StateConnected:
+                log.Printf("MIDI 接続: %s", port)
+            case midi.StateDisconnected:
+                log.Printf("MIDI 切断: %s (%v)。再接続を待機します", port, err)
+            }
+        },
+    })
+    if err != nil {
+        log.Printf("MIDI 入力のオープンに失敗: %v", err)
+        log.Println("ネイティブMIDI機能はビルドタグ 'midi_native' が必要です。詳細は docs/MIDI_SCENE_SWITCH.md を参照してください。")
+        os.Exit(1)
+    }
+    defer drv.Close()
+
+    log.Printf("MTC 追従開始: device=%s cues=%d fps=%s jump=%s", *device, len(chaser.Cues()), rateLabel(rate), *jump)
+    clock := midi.NewMTCClock(rate, *loss)
+    // 準備中のキューは abort を閉じると発火直前で取り消される（停止・ジャンプ時）
+    abort := make(chan struct{})
+    var lastDebug time.Time
+    handle := func(u midi.ClockUpdate, prev midi.ClockState) {
+        switch {
+        case u.State == midi.ClockLocked && prev != midi.ClockLocked:
+            log.Printf("MTC ロック: %s (%s fps)", u.Timecode, u.Timecode.Rate)
+        case u.State != midi.ClockLocked && prev == midi.ClockLocked && !u.Jump:
+            log.Printf("MTC 停止: %s", u.Timecode)
+        case u.Jump:
+            log.Printf("MTC 位置変更: %s", u.Timecode)
+        }
+        if rate == "" && u.Timecode.Rate != "" && u.Timecode.Rate != chaser.Rate() {
+            // -fps / frame_rate 未指定: 受信したレートでキューを解釈し直す
+            next, err := midimap.NewChaser(cfg.TimecodeCues, u.Timecode.Rate)
+            if err != nil {
+                log.Printf("警告: 受信した MTC のレート %s では timecode_cues を解釈できません（%s のまま継続）:\n%v", u.Timecode.Rate, chaser.Rate(), err)
+            } else {
+                next.Lookahead, next.JumpMode = chaser.Lookahead, chaser.JumpMode
+                chaser = next
+                log.Printf("timecode_cues を %s fps で解釈します", u.Timecode.Rate)
+            }
+        }
+        if *debug && u.State == midi.ClockLocked && u.At.Sub(lastDebug) >= time.Second {
+            lastDebug = u.At
+            log.Printf("MTC: %s", u.Timecode)
+        }
+        res := chaser.Update(u)
+        if res.Cancel {
+            close(abort)
+            abort = make(chan struct{})
+            log.Println("準備中のキューを取り消しました")
+        }
+        for _, c := range res.Due {
+            go func(c midimap.ChaseCue, fire time.Time, abort <-chan struct{}) {
+                opts := obsws.TriggerOptions{
+                    Addrs:     targets,
+                    Password:  *password,
+                    Passwords: pwlist,
+                    Scene:     c.Scene,
+                    Action:    "none",
+                    FireTime:  fire,
+                    SpinWin:   *spinwin,
+                    Timeout:   *timeout,
+                    Abort:     abort,
+                }
+                err := obsws.Trigger(opts)
+                switch {
+                case errors.Is(err, obsws.ErrAborted):
+                    log.Printf("キュー取消: %s → %s", c.Timecode, c.Scene)
+                case err != nil:
+                    log.Printf("キュー失敗: %s → %s: %v", c.Timecode, c.Scene, err)
+                default:
+                    log.Printf("キュー: %s → %s", c.Timecode, c.Scene)
+                }
+            }(c, u.At.Add(c.Delay), abort)
+        }
+    }
+
+    ticker := time.NewTicker(*loss / 2)
+    defer ticker.Stop()
+    for {
+        select {
+        case ev, ok := <-events:
+            if !ok {
+                return
+            }
+            prev := clock.State()
+            if u, ok := clock.Feed(ev); ok {
+                handle(u, prev)
+            }
+        case now := <-ticker.C:
+            prev := clock.State()
+            if u, ok := clock.Check(now); ok {
+                handle(u, prev)
+            }
+        }
+    }
+}
+
+func rateLabel(r midi.FrameRate) string {
+    if r == "" {
+        return "auto"
+    }
+    return string(r)
+}
+
+func midiChaseUsage() {
+    fmt.Fprintln(os.Stderr, "Usage: obsctl midi chase -config show.json [options]")
+    fmt.Fprintln(os.Stderr, "\n説明: MIDI タイムコード（MTC）に追従し、timecode_cues に書いた位置で全接続先のシーンを切り替えます。")
+    fmt.Fprintln(os.Stderr, "\n主なオプション:")
+    fmt.Fprintln(os.Stderr, "  -addrs         OBS のアドレスをカンマ区切り (host:port)")
+    fmt.Fprintln(os.Stderr, "  -password      パスワード（全接続共通）")
+    fmt.Fprintln(os.Stderr, "  -passwords     個別パスワードをカンマ区切り（-addrs と同順・同数）")
+    fmt.Fprintln(os.Stderr, "  -device        MTC を受信する MIDI 入力デバイス名")
+    fmt.Fprintln(os.Stderr, "  -config        JSON設定ファイルパス（timecode_cues / frame_rate を使用）")
+    fmt.Fprintln(os.Stderr, "  -fps           フレームレート 24/25/29.97df/30（未指定は受信値）")
+    fmt.Fprintln(os.Stderr, "  -lookahead     キューを前もって準備する時間 (例: 500ms)")
+    fmt.Fprintln(os.Stderr, "  -jump          位置が飛んだときの扱い chase/skip")
+    fmt.Fprintln(os.Stderr, "  -loss          MTC 停止とみなすまでの時間 (例: 250ms)")
+    fmt.Fprintln(os.Stderr, "  -dry-run       キュー一覧を表示して終了")
+    fmt.Fprintln(os.Stderr, "  -timeout       OBS リクエストのタイムアウト (例: 5s)")
+    fmt.Fprintln(os.Stderr, "  -spinwin       発火前スピン時間 (精度/CPUバランス)")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "log"
+    "strings"
+
+    "awesomeProject/internal/midimap"
+    "awesomeProject/internal/obsws"
+)
+
+// showHosts は切替先の OBS 一覧（-addrs / -passwords、または JSON の hosts）。
+// names は hosts から作ったときだけ設定し、マッピングの hosts（送り先の指定）はこの名前で引く。
+type showHosts struct {
+    names     []string
+    addrs     []string
+    password  string   // 共通パスワード
+    passwords []string // addrs と同数のときだけ使う個別パスワード
+}
+
+// flagHosts は -addrs / -password / -passwords から切替先を作る。
+func flagHosts(addrs, password, passwords string) showHosts {
+    h := showHosts{addrs: strings.Split(addrs, ","), password: password}
+    if strings.TrimSpace(passwords) != "" {
+        pws := strings.Split(passwords, ",")
+        if len(pws) == len(h.addrs) {
+            for i := range pws { pws[i] = strings.TrimSpace(pws[i]) }
+            h.passwords = pws
+        } else {
+            log.Printf("警告: -passwords の数 (%d) が -addrs の数 (%d) と一致しません。-password（共通）を使用します。", len(pws), len(h.addrs))
+        }
+    }
+    return h
+}
+
+// configHosts は JSON の hosts から切替先を作る（パスワードは hosts ごと。password_env を優先）。
+func configHosts(cfg *midimap.File) showHosts {
+    h := showHosts{}
+    h.addrs, h.passwords = cfg.HostAddrs()
+    for _, host := range cfg.Hosts {
+        h.names = append(h.names, strings.TrimSpace(host.Name))
+    }
+    return h
+}
+
+// pick は names のホストだけのアドレスと個別パスワードを返す。names が空なら全ホスト。
+// -addrs で指定したとき（ホスト名が無いとき）は送り先の指定を無視して全ホストを返す。
+func (h showHosts) pick(names []string) ([]string, []string) {
+    if len(names) == 0 || len(h.names) == 0 {
+        return h.addrs, h.passwords
+    }
+    want := map[string]bool{}
+    for _, n := range names { want[n] = true }
+    var addrs, pws []string
+    for i, n := range h.names {
+        if !want[n] {
+            continue
+        }
+        addrs = append(addrs, h.addrs[i])
+        if len(h.passwords) == len(h.addrs) {
+            pws = append(pws, h.passwords[i])
+        }
+    }
+    return addrs, pws
+}
+
+// String はログ用に "name=addr" の一覧を返す（-addrs のときはアドレスのみ）。
+func (h showHosts) String() string {
+    parts := make([]string, len(h.addrs))
+    for i, a := range h.addrs {
+        parts[i] = strings.TrimSpace(a)
+        if i < len(h.names) {
+            parts[i] = h.names[i] + "=" + parts[i]
+        }
+    }
+    return strings.Join(parts, ", ")
+}
+
+// sendControl は連続値操作を Pool 経由で送る ControlSender を返す（hosts 指定のある操作はそのホストだけ）。
+func sendControl(pool *obsws.Pool, hosts showHosts) midimap.ControlSender {
+    return func(c *midimap.Control, v float64, final bool) error {
+        p := pool
+        if len(c.Hosts) > 0 && len(hosts.names) > 0 {
+            addrs, _ := hosts.pick(c.Hosts)
+            p = pool.Select(addrs)
+        }
+        switch c.Action {
+        case midimap.ActionVolume:
+            return p.SetInputVolume(c.Input, v)
+        case midimap.ActionTBar:
+            return p.SetTBarPosition(v, final)
+        default:
+            return p.SetFilterSetting(c.Input, c.Filter, c.Setting, v)
+        }
+    }
+}
+
+// withoutNoteRoutes は -map-note で指定したトリガの送り先指定を除く（-map-note のマッピングは全ホスト・現在のトランジション）。
+func withoutNoteRoutes(routes map[string]midimap.Route, mapNotes []string) map[string]midimap.Route {
+    out := map[string]midimap.Route{}
+    for k, v := range routes { out[k] = v }
+    for k := range parseNoteMaps(mapNotes) { delete(out, k) }
+    return out
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "awesomeProject/internal/midi"
+    "awesomeProject/internal/midimap"
+    "awesomeProject/internal/obsws"
+    "github.com/andreykaipov/goobs"
+)
+
+// runMidiLearn は OBS のシーン一覧からシーンを選ばせ、次に届いた MIDI 入力（NoteOn/CC/PC）を
+// そのシーンに割り当てて JSON 設定（-config）へ書き込む。
+// 例: obsctl midi learn -addr 127.0.0.1:4455 -password ****** -device "IACドライバ バス1" -config midi.json
+func runMidiLearn(args []string) {
+    fs := flag.NewFlagSet("midi learn", flag.ExitOnError)
+    addr := fs.String("addr", "127.0.0.1:4455", "OBS のアドレス (host:port)")
+    password := fs.String("password", "", "OBS のパスワード")
+    device := fs.String("device", "", "監視する MIDI 入力デバイス名（未指定なら -config の device）")
+    channel := fs.String("channel", "", "受け付ける MIDI チャネル (1-16、カンマ区切り。未指定は全て)")
+    configPath := fs.String("config", "", "書き込み先の JSON 設定ファイル（既存なら追記・同じトリガは置換）")
+    scene := fs.String("scene", "", "割り当てるシーン名（指定時は1件だけ学習して終了）")
+    wait := fs.Duration("wait", 30*time.Second, "MIDI 入力を待つ最大時間")
+    fs.Usage = midiLearnUsage
+    _ = fs.Parse(args)
+
+    if strings.TrimSpace(*configPath) == "" {
+        log.Fatal("-config を指定してください（学習結果の書き込み先）")
+    }
+
+    file, err := midimap.LoadFile(*configPath)
+    if err != nil {
+        if !errors.Is(err, os.ErrNotExist) {
+            log.Fatalf("-config の読み込みに失敗しました: %v", err)
+        }
+        file = &midimap.File{Debounce: "30ms", RateLimit: "50ms"}
+    }
+    if *device == "" {
+        *device = file.Device
+    }
+    if *device == "" {
+        log.Println("-device を指定してください。利用可能なデバイスは 'obsctl midi ls-devices' で確認できます。")
+        os.Exit(2)
+    }
+    if file.Device == "" {
+        file.Device = *device
+    }
+
+    cli, err := goobs.New(obsws.NormalizeObsAddr(*addr), goobs.WithPassword(*password))
+    if err != nil {
+        log.Fatalf("OBS 接続失敗: %v", err)
+    }
+    defer cli.Disconnect()
+
+    lst, err := cli.Scenes.GetSceneList(nil)
+    if err != nil {
+        log.Fatalf("シーン一覧取得失敗: %v", err)
+    }
+    names := make([]string, 0, len(lst.Scenes))
+    for _, s := range lst.Scenes {
+        names = append(names, s.SceneName)
+    }
+    if *scene != "" && !containsString(names, *scene) {
+        log.Fatalf("シーンが見つかりません: %s", *scene)
+    }
+
+    drv, events, err := midi.OpenInput(*device)
+    if err != nil {
+        log.Printf("MIDI 入力のオープンに失敗: %v", err)
+        log.Println("ネイティブMIDI機能はビルドタグ 'midi_native' が必要です。詳細は docs/MIDI_SCENE_SWITCH.md を参照してください。")
+        os.Exit(1)
+    }
+    defer drv.Close()
+
+    chs := parseChannels(*channel)
+    learnOne := func(sceneName string) bool {
+        fmt.Printf("MIDI 入力待ち: 「%s」に割り当てるボタン/パッドを押してください（%s でタイムアウト）\n", sceneName, wait.String())
+        ctx, cancel := context.WithTimeout(context.Background(), *wait)
+        m, err := midimap.Learn(ctx, events, time.Now(), chs)
+        cancel()
+        if err != nil {
+            log.Printf("学習できませんでした: %v", err)
+            return !errors.Is(err, midimap.ErrInputClosed)
+        }
+        m.Scene = sceneName
+        replaced := file.Bind(m)
+        if err := midimap.SaveFile(*configPath, file); err != nil {
+            log.Fatalf("-config の書き込みに失敗しました: %v", err)
+        }
+        key, _ := m.Key()
+        if replaced {
+            fmt.Printf("割当（置換）: %s → %s\n", key, sceneName)
+        } else {
+            fmt.Printf("割当: %s → %s\n", key, sceneName)
+        }
+        return true
+    }
+
+    if *scene != "" {
+        learnOne(*scene)
+        return
+    }
+
+    in := bufio.NewScanner(os.Stdin)
+    for {
+        fmt.Println("")
+        for i, n := range names {
+            fmt.Printf("  %3d) %s\n", i+1, n)
+        }
+        fmt.Print("割り当てるシーン番号を入力（空 Enter で終了）: ")
+        if !in.Scan() {
+            return
+        }
+        text := strings.TrimSpace(in.Text())
+        if text == "" {
+            fmt.Printf("保存先: %s\n", *configPath)
+            return
+        }
+        idx, err := strconv.Atoi(text)
+        if err != nil || idx < 1 || idx > len(names) {
+            fmt.Printf("1..%d の番号を入力してください\n", len(names))
+            continue
+        }
+        if !learnOne(names[idx-1]) {
+            return
+        }
+    }
+}
+
+func containsString(list []string, v string) bool {
+    for _, x := range list {
+        if x == v {
+            return true
+        }
+    }
+    return false
+}
+
+func midiLearnUsage() {
+    fmt.Fprintln(os.Stderr, "Usage: obsctl midi learn [options]")
+    fmt.Fprintln(os.Stderr, "\n説明: OBS のシーンを選び、次に押した MIDI ボタン/パッド（NoteOn/CC/PC）を割り当てて JSON 設定に保存します。")
+    fmt.Fprintln(os.Stderr, "\n主なオプション:")
+    fmt.Fprintln(os.Stderr, "  -addr          OBS のアドレス (host:port)")
+    fmt.Fprintln(os.Stderr, "  -password      パスワード")
+    fmt.Fprintln(os.Stderr, "  -device        監視する MIDI 入力デバイス名（未指定なら -config の device）")
+    fmt.Fprintln(os.Stderr, "  -channel       受け付ける MIDI チャネル (1-16、カンマ区切り)")
+    fmt.Fprintln(os.Stderr, "  -config        書き込み先の JSON 設定ファイル（必須。既存なら追記・同じトリガは置換）")
+    fmt.Fprintln(os.Stderr, "  -scene         割り当てるシーン名（指定時は1件だけ学習して終了）")
+    fmt.Fprintln(os.Stderr, "  -wait          MIDI 入力を待つ最大時間 (例: 30s)")
+}
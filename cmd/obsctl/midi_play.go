@@ -0,0 +1,172 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "awesomeProject/internal/midi"
+    "awesomeProject/internal/midimap"
+    "awesomeProject/internal/obsws"
+)
+
+// playCue は SMF 再生で発火する 1 件のキュー。At は再生開始からの時刻（オフセット・テンポ倍率適用済み）。
+type playCue struct {
+    At     time.Duration
+    Source time.Duration // SMF 上の時刻
+    Key    string
+    Scene  string
+}
+
+// buildPlaySchedule は SMF のイベントからマッピングに一致するものを抜き出し、発火予定を作る。
+// offset より前のイベントは捨て、tempo（>0、2 なら倍速）で時間を縮める。
+func buildPlaySchedule(smf *midi.SMF, rules *midimap.Rules, offset time.Duration, tempo float64) []playCue {
+    var out []playCue
+    for _, te := range smf.Events {
+        if te.At < offset {
+            continue
+        }
+        res := rules.Lookup(te.Event)
+        if !res.Fire() {
+            continue
+        }
+        at := time.Duration(float64(te.At-offset) / tempo)
+        out = append(out, playCue{At: at, Source: te.At, Key: res.Key, Scene: res.Scene})
+    }
+    return out
+}
+
+// formatCueTime は "mm:ss.mmm" 形式で返す。
+func formatCueTime(d time.Duration) string {
+    ms := d.Milliseconds()
+    return fmt.Sprintf("%02d:%02d.%03d", ms/60000, (ms/1000)%60, ms%1000)
+}
+
+// runMidiPlay は SMF をキュータイムラインとして再生し、マッピングに一致したノート/CC/PC の時刻でシーンを切り替える。
+// 例: obsctl midi play -addrs 127.0.0.1:4455 -password ****** -config midi.json song.mid
+func runMidiPlay(args []string) {
+    fs := flag.NewFlagSet("midi play", flag.ExitOnError)
+    addrs := fs.String("addrs", "127.0.0.1:4455", "OBS WebSocket のアドレスをカンマ区切り（host:port）")
+    password := fs.String("password", "", "OBS WebSocket のパスワード（共通）")
+    passwords := fs.String("passwords", "", "複数接続の個別パスワード。-addrs と同じ順でカンマ区切り（数が合わない場合は無視）")
+    channel := fs.String("channel", "", "対象の MIDI チャネル (1-16、カンマ区切り。未指定は全て)")
+    mapNotes := multiFlag{}
+    fs.Var(&mapNotes, "map-note", "ノート→シーンの対応（複数可）。例: 1:36=028_エンドロール（CC は 1:cc64=…、PC は 1:pc5=…）")
+    configPath := fs.String("config", "", "JSON設定ファイルへのパス（channel/mappings を使用）")
+    offset := fs.Duration("offset", 0, "曲頭からの再生開始位置（例: 1m30s）。これより前のキューは発火しない")
+    tempo := fs.Float64("tempo", 1.0, "テンポ倍率（2 で倍速、0.5 で半速）")
+    lead := fs.Duration("lead", 2*time.Second, "コマンド開始から再生開始（offset 位置）までの待ち時間")
+    dryRun := fs.Bool("dry-run", false, "発火せずにスケジュールを一覧表示して終了")
+    timeout := fs.Duration("timeout", 5*time.Second, "OBS リクエストのタイムアウト")
+    spinwin := fs.Duration("spinwin", 2*time.Millisecond, "発火前スピン時間 (精度/CPUバランス)")
+    fs.Usage = midiPlayUsage
+    _ = fs.Parse(args)
+
+    if fs.NArg() != 1 {
+        midiPlayUsage()
+        os.Exit(2)
+    }
+    if *tempo <= 0 {
+        log.Fatal("-tempo は 0 より大きい値を指定してください")
+    }
+
+    smf, err := midi.ReadSMFFile(fs.Arg(0))
+    if err != nil {
+        log.Fatalf("SMF の読み込みに失敗しました: %v", err)
+    }
+
+    noteMap := map[string]string{}
+    var mscDevice *uint8
+    if strings.TrimSpace(*configPath) != "" {
+        // device/debounce/rate_limit は再生では使わない
+        device := ""
+        var debounce, ratelimit time.Duration
+        if err := loadJSONConfig(*configPath, &device, channel, &debounce, &ratelimit, noteMap); err != nil {
+            log.Fatalf("-config の読み込みに失敗しました: %v", err)
+        }
+        if cfg, err := midimap.LoadFile(*configPath); err == nil {
+            mscDevice = cfg.MSCDeviceID()
+        }
+    }
+    for k, v := range parseNoteMaps(mapNotes) { noteMap[k] = v }
+    if len(noteMap) == 0 {
+        log.Fatal("マッピングがありません。-config か -map-note を指定してください。")
+    }
+
+    rules := &midimap.Rules{Channels: parseChannels(*channel), Scenes: noteMap, MSCDevice: mscDevice}
+    cues := buildPlaySchedule(smf, rules, *offset, *tempo)
+    log.Printf("SMF: format=%d events=%d length=%s → キュー %d 件（offset=%s tempo=x%g）", smf.Format, len(smf.Events), smf.Length, len(cues), *offset, *tempo)
+    if *dryRun {
+        for i, c := range cues {
+            fmt.Printf("%4d  +%s  (SMF %s)  %-8s → %s\n", i+1, formatCueTime(c.At), formatCueTime(c.Source), c.Key, c.Scene)
+        }
+        return
+    }
+    if len(cues) == 0 {
+        return
+    }
+
+    targets := strings.Split(*addrs, ",")
+    var pwlist []string
+    if strings.TrimSpace(*passwords) != "" {
+        pws := strings.Split(*passwords, ",")
+        if len(pws) == len(targets) {
+            for i := range pws { pws[i] = strings.TrimSpace(pws[i]) }
+            pwlist = pws
+        } else {
+            log.Printf("警告: -passwords の数 (%d) が -addrs の数 (%d) と一致しません。-password（共通）を使用します。", len(pws), len(targets))
+        }
+    }
+
+    // 接続に時間がかかるため、各キューは発火時刻の少し前に Trigger を開始し、Trigger 内の WaitUntil で揃えて発火する。
+    const prepare = time.Second
+    start := time.Now().Add(*lead)
+    log.Printf("再生開始: %s", start.Format(time.RFC3339Nano))
+    var wg sync.WaitGroup
+    for i, c := range cues {
+        fire := start.Add(c.At)
+        obsws.WaitUntil(fire.Add(-prepare), 0)
+        wg.Add(1)
+        go func(i int, c playCue, fire time.Time) {
+            defer wg.Done()
+            opts := obsws.TriggerOptions{
+                Addrs:     targets,
+                Password:  *password,
+                Passwords: pwlist,
+                Scene:     c.Scene,
+                Action:    "none",
+                FireTime:  fire,
+                SpinWin:   *spinwin,
+                Timeout:   *timeout,
+            }
+            if err := obsws.Trigger(opts); err != nil {
+                log.Printf("キュー %d 失敗: %s → %s: %v", i+1, c.Key, c.Scene, err)
+                return
+            }
+            log.Printf("キュー %d: +%s %s → %s", i+1, formatCueTime(c.At), c.Key, c.Scene)
+        }(i, c, fire)
+    }
+    wg.Wait()
+}
+
+func midiPlayUsage() {
+    fmt.Fprintln(os.Stderr, "Usage: obsctl midi play [options] song.mid")
+    fmt.Fprintln(os.Stderr, "\n説明: Standard MIDI File（format 0/1）をキュータイムラインとして再生し、マッピングに一致したノート/CC/PC の時刻に全接続先のシーンを切り替えます。")
+    fmt.Fprintln(os.Stderr, "\n主なオプション:")
+    fmt.Fprintln(os.Stderr, "  -addrs         OBS のアドレスをカンマ区切り (host:port)")
+    fmt.Fprintln(os.Stderr, "  -password      パスワード（全接続共通）")
+    fmt.Fprintln(os.Stderr, "  -passwords     個別パスワードをカンマ区切り（-addrs と同順・同数）")
+    fmt.Fprintln(os.Stderr, "  -config        JSON設定ファイルパス（channel/mappings を使用）")
+    fmt.Fprintln(os.Stderr, "  -map-note      ノート→シーンの対応（複数可）。例: 1:36=028_エンドロール")
+    fmt.Fprintln(os.Stderr, "  -channel       対象の MIDI チャネル (1-16、カンマ区切り)")
+    fmt.Fprintln(os.Stderr, "  -offset        曲頭からの再生開始位置 (例: 1m30s)")
+    fmt.Fprintln(os.Stderr, "  -tempo         テンポ倍率 (例: 1.05)")
+    fmt.Fprintln(os.Stderr, "  -lead          再生開始までの待ち時間 (例: 2s)")
+    fmt.Fprintln(os.Stderr, "  -dry-run       発火せずにスケジュールを一覧表示")
+    fmt.Fprintln(os.Stderr, "  -timeout       OBS リクエストのタイムアウト (例: 5s)")
+    fmt.Fprintln(os.Stderr, "  -spinwin       発火前スピン時間 (精度/CPUバランス)")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "awesomeProject/internal/midi"
+    "awesomeProject/internal/midimap"
+)
+
+// runMidiRecord は MIDI 入力をそのまま JSONL に記録する（シーン切替は行わない）。
+// 記録は -device replay://log.jsonl で再生でき、コントローラが無くても同じ入力をマッピングに流せる。
+// 例: obsctl midi record -device "Launchpad Mini" -out log.jsonl
+func runMidiRecord(args []string) {
+    fs := flag.NewFlagSet("midi record", flag.ExitOnError)
+    devices := multiFlag{}
+    fs.Var(&devices, "device", "記録する MIDI 入力デバイス名（複数可。未指定は -config の device と inputs）")
+    configPath := fs.String("config", "", "JSON設定ファイルへのパス（device / inputs を記録対象にする）")
+    out := fs.String("out", "-", "書き出し先の JSONL ファイル（- は標準出力）")
+    duration := fs.Duration("duration", 0, "記録する時間（0 は中断するまで）")
+    debug := fs.Bool("debug", false, "受信したイベントを標準エラーにも表示")
+    fs.Usage = midiRecordUsage
+    _ = fs.Parse(args)
+
+    if len(devices) == 0 && strings.TrimSpace(*configPath) != "" {
+        cfg, err := midimap.LoadFile(*configPath)
+        if err != nil {
+            log.Fatalf("-config の読み込みに失敗しました: %v", err)
+        }
+        for _, f := range cfg.DeviceFiles() {
+            if f.Device != "" && !containsString(devices, f.Device) {
+                devices = append(devices, f.Device)
+            }
+        }
+    }
+    if len(devices) == 0 {
+        log.Println("-device を指定してください（-config の device / inputs も利用可）。利用可能なデバイスは 'obsctl midi ls-devices' で確認できます。")
+        os.Exit(2)
+    }
+
+    var w io.Writer = os.Stdout
+    if *out != "-" {
+        f, err := os.Create(*out)
+        if err != nil {
+            log.Fatalf("-out を作成できません: %v", err)
+        }
+        defer f.Close()
+        w = f
+    }
+
+    drv, events, err := midi.OpenInputsAuto(devices, midi.ReconnectOptions{}, func(dev string, state midi.State, port string, err error) {
+        switch state {
+        case midi.StateConnected:
+            log.Printf("MIDI 接続: %s (%s)", port, dev)
+        case midi.StateDisconnected:
+            log.Printf("MIDI 切断: %s (%s, %v)。再接続を待機します", port, dev, err)
+        }
+    })
+    if err != nil {
+        log.Printf("MIDI 入力のオープンに失敗: %v", err)
+        log.Println("ネイティブMIDI機能はビルドタグ 'midi_native' が必要です。詳細は docs/MIDI_SCENE_SWITCH.md を参照してください。")
+        os.Exit(1)
+    }
+    defer drv.Close()
+
+    start := time.Now()
+    rec := midi.NewRecorder(w, start)
+    var stop <-chan time.Time
+    if *duration > 0 {
+        stop = time.After(*duration)
+    }
+    log.Printf("記録開始: device=%s out=%s（Ctrl+C で終了）", strings.Join(devices, ", "), *out)
+    n := 0
+    for {
+        select {
+        case <-stop:
+            log.Printf("記録終了: %d 件 (%s)", n, time.Since(start).Round(time.Millisecond))
+            return
+        case ev, ok := <-events:
+            if !ok {
+                log.Printf("記録終了: %d 件", n)
+                return
+            }
+            if err := rec.Write(ev); err != nil {
+                log.Fatalf("書き込みに失敗しました: %v", err)
+            }
+            n++
+            if *debug {
+                log.Printf("MIDI[%s]: type=%s ch=%d data1=%d data2=%d +%s", ev.Device, ev.Type, ev.Channel, ev.Data1, ev.Data2, ev.Time.Sub(start).Round(time.Millisecond))
+            }
+        }
+    }
+}
+
+func midiRecordUsage() {
+    fmt.Fprintln(os.Stderr, "Usage: obsctl midi record -device NAME [-out log.jsonl] [options]")
+    fmt.Fprintln(os.Stderr, "\n説明: 受信した MIDI イベントを時刻付きで JSONL に記録します。-device replay://log.jsonl で再生できます。")
+    fmt.Fprintln(os.Stderr, "\n主なオプション:")
+    fmt.Fprintln(os.Stderr, "  -device        記録する MIDI 入力デバイス名（複数可）")
+    fmt.Fprintln(os.Stderr, "  -config        JSON設定ファイルパス（device / inputs を記録対象にする）")
+    fmt.Fprintln(os.Stderr, "  -out           書き出し先（既定 - は標準出力）")
+    fmt.Fprintln(os.Stderr, "  -duration      記録する時間 (例: 10m、0 は中断するまで)")
+    fmt.Fprintln(os.Stderr, "  -debug         受信したイベントを表示")
+}
@@ -5,6 +5,9 @@ import (
     "path/filepath"
     "testing"
     "time"
+
+    "awesomeProject/internal/midi"
+    "awesomeProject/internal/midimap"
 )
 
 func TestParseNoteMaps_Basics(t *testing.T) {
@@ -93,3 +96,73 @@ func TestLoadJSONConfig_CLIOverrides(t *testing.T) {
     if debounce != 10*time.Millisecond { t.Fatalf("debounce override failed: %v", debounce) }
     if ratelimit != 15*time.Millisecond { t.Fatalf("ratelimit override failed: %v", ratelimit) }
 }
+
+func TestLoadJSONConfig_ControlAndProgramChange(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "midi.json")
+    data := []byte(`{
+        "mappings": [
+          {"type":"control_change","channel":1,"control":20,"scene":"SceneCC"},
+          {"type":"program_change","channel":2,"program":5,"scene":"ScenePC"}
+        ]
+    }`)
+    if err := os.WriteFile(path, data, 0o644); err != nil {
+        t.Fatal(err)
+    }
+    device, channel := "", ""
+    var debounce, ratelimit time.Duration
+    noteMap := map[string]string{}
+    if err := loadJSONConfig(path, &device, &channel, &debounce, &ratelimit, noteMap); err != nil {
+        t.Fatalf("loadJSONConfig error: %v", err)
+    }
+    if noteMap["1:cc20"] != "SceneCC" { t.Fatalf("1:cc20 => %q", noteMap["1:cc20"]) }
+    if noteMap["2:pc5"] != "ScenePC" { t.Fatalf("2:pc5 => %q", noteMap["2:pc5"]) }
+}
+
+func TestBuildPlaySchedule_OffsetTempoAndMapping(t *testing.T) {
+    smf := &midi.SMF{Events: []midi.TimedEvent{
+        {At: 0, Event: midi.Event{Type: midi.NoteOn, Channel: 1, Data1: 36, Data2: 100}},
+        {At: 2 * time.Second, Event: midi.Event{Type: midi.NoteOn, Channel: 1, Data1: 37, Data2: 100}},
+        {At: 3 * time.Second, Event: midi.Event{Type: midi.NoteOff, Channel: 1, Data1: 37}},
+        {At: 4 * time.Second, Event: midi.Event{Type: midi.ControlChange, Channel: 2, Data1: 20, Data2: 127}},
+        {At: 5 * time.Second, Event: midi.Event{Type: midi.NoteOn, Channel: 1, Data1: 99, Data2: 100}},
+    }}
+    scenes := map[string]string{"1:36": "A", "1:37": "B", "2:cc20": "C"}
+    cues := buildPlaySchedule(smf, &midimap.Rules{Scenes: scenes}, time.Second, 2)
+    if len(cues) != 2 {
+        t.Fatalf("cues=%+v", cues)
+    }
+    if cues[0].Scene != "B" || cues[0].At != 500*time.Millisecond || cues[0].Source != 2*time.Second {
+        t.Fatalf("cue[0]=%+v", cues[0])
+    }
+    if cues[1].Scene != "C" || cues[1].At != 1500*time.Millisecond {
+        t.Fatalf("cue[1]=%+v", cues[1])
+    }
+    if got := buildPlaySchedule(smf, &midimap.Rules{Channels: []int{2}, Scenes: scenes}, 0, 1); len(got) != 1 || got[0].Key != "2:cc20" {
+        t.Fatalf("channel filter: %+v", got)
+    }
+}
+
+func TestShowHosts_PickByName(t *testing.T) {
+    cfg := &midimap.File{Hosts: []midimap.Host{
+        {Name: "main", Addr: "10.0.0.1:4455", Password: "a"},
+        {Name: "backup", Addr: "10.0.0.2:4455", PasswordEnv: "OBSCTL_TEST_BACKUP_PW"},
+    }}
+    t.Setenv("OBSCTL_TEST_BACKUP_PW", "b")
+    h := configHosts(cfg)
+    addrs, pws := h.pick([]string{"backup"})
+    if len(addrs) != 1 || addrs[0] != "10.0.0.2:4455" || len(pws) != 1 || pws[0] != "b" {
+        t.Fatalf("pick backup: %v %v", addrs, pws)
+    }
+    if addrs, _ := h.pick(nil); len(addrs) != 2 {
+        t.Fatalf("pick all: %v", addrs)
+    }
+    if addrs, _ := h.pick([]string{"nope"}); len(addrs) != 0 {
+        t.Fatalf("pick unknown: %v", addrs)
+    }
+    // -addrs のときは送り先の指定を無視して全ホスト
+    f := flagHosts("127.0.0.1:4455,127.0.0.1:4456", "pw", "")
+    if addrs, _ := f.pick([]string{"main"}); len(addrs) != 2 {
+        t.Fatalf("flag hosts pick: %v", addrs)
+    }
+}
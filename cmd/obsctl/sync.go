@@ -0,0 +1,353 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "math/rand"
+    "os"
+    "sort"
+    "sync"
+    "time"
+
+    "awesomeProject/internal/btsync"
+)
+
+func runSync(args []string) {
+    if len(args) > 0 && args[0] == "simulate" {
+        runSyncSimulate(args[1:])
+        return
+    }
+    syncUsage()
+    os.Exit(2)
+}
+
+// simStats はシミュレーション中の計測値（Tap と偽 OBS から集める）。
+type simStats struct {
+    mu         sync.Mutex
+    dispatched map[string]time.Time            // scene → DispatchScene を呼んだ時刻
+    eventScene map[string]string               // event_id → scene
+    applied    map[string]map[string]time.Time // node → scene → 切替時刻
+    acks       map[string]map[string]string    // event_id → child → status（最初の ACK）
+    ackLatency []time.Duration
+    paired     map[string]bool
+    caughtUp   map[string]int // node → 再接続後に最後のシーンへ追いついた回数
+    deliveries []*btsync.Delivery
+}
+
+func (s *simStats) tap(from, to btsync.PeerRef, payload []byte, dropped bool) {
+    msg, err := btsync.UnmarshalMessage(payload)
+    if err != nil {
+        return
+    }
+    now := time.Now()
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    switch msg.Type {
+    case btsync.MsgSceneCommand:
+        s.eventScene[msg.EventID] = msg.SceneName
+    case btsync.MsgSceneAck:
+        // received は受信確認（再送を止めるだけ）なので結果の集計には入れない
+        if dropped || msg.Status == string(btsync.AckReceived) {
+            return
+        }
+        if s.acks[msg.EventID] == nil {
+            s.acks[msg.EventID] = map[string]string{}
+        }
+        if _, ok := s.acks[msg.EventID][from.PeerID]; ok {
+            return
+        }
+        s.acks[msg.EventID][from.PeerID] = msg.Status
+        if at, ok := s.dispatched[s.eventScene[msg.EventID]]; ok {
+            s.ackLatency = append(s.ackLatency, now.Sub(at))
+        }
+    }
+}
+
+func (s *simStats) apply(node, scene string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.applied[node] == nil {
+        s.applied[node] = map[string]time.Time{}
+    }
+    s.applied[node][scene] = time.Now()
+}
+
+// runSyncSimulate は親機1台と子機 -children 台の btsync.Manager を1プロセス内のメモリ内ネットワークで動かし、
+// ACK の遅延と取りこぼしを集計する（機材なしのリハーサル用。OBS には接続しない）。
+// 例: obsctl sync simulate -children 5 -latency 8ms -jitter 20ms -loss 0.02 -flap 0.1
+func runSyncSimulate(args []string) {
+    fs := flag.NewFlagSet("sync simulate", flag.ExitOnError)
+    children := fs.Int("children", 5, "子機の台数")
+    events := fs.Int("events", 20, "親機から送るシーン切替の回数")
+    interval := fs.Duration("interval", 500*time.Millisecond, "シーン切替の間隔")
+    lead := fs.Duration("lead", 300*time.Millisecond, "同期待ち時間（lead_time_ms）")
+    autoLead := fs.Bool("auto-lead", false, "親機が測った遅延から同期待ち時間を決める（auto_lead_time。-lead は測れるまでの値）")
+    acceptLate := fs.Duration("accept-late", 500*time.Millisecond, "子機の遅延許容（accept_late_ms）")
+    latency := fs.Duration("latency", 5*time.Millisecond, "片道の遅延")
+    jitter := fs.Duration("jitter", 5*time.Millisecond, "遅延の揺らぎ（0..jitter を加算。大きいと順序が入れ替わる）")
+    loss := fs.Float64("loss", 0, "メッセージ損失率 (0-1)")
+    flap := fs.Float64("flap", 0, "シーン切替ごとに子機1台を切断する確率 (0-1)")
+    down := fs.Duration("down", time.Second, "-flap で切断している時間")
+    dropMissed := fs.Bool("drop-missed", false, "切れた子機への配送を打ち切り再接続後に再送しない（drop_missed_events）")
+    applyDelay := fs.Duration("apply", 0, "偽 OBS のシーン切替にかかる時間")
+    skew := fs.Duration("skew", 0, "子機の時計のずれの最大値（各子機に ±skew の範囲で割り当て、時計合わせの効果を確認）")
+    seed := fs.Int64("seed", 0, "乱数の種（0 は毎回変える）")
+    verbose := fs.Bool("v", false, "各ノードの同期ログを表示")
+    fs.Usage = syncUsage
+    _ = fs.Parse(args)
+
+    if *children < 1 || *events < 1 {
+        log.Println("-children と -events は 1 以上を指定してください")
+        os.Exit(2)
+    }
+    if *seed == 0 {
+        *seed = time.Now().UnixNano()
+    }
+
+    st := &simStats{
+        dispatched: map[string]time.Time{},
+        eventScene: map[string]string{},
+        applied:    map[string]map[string]time.Time{},
+        acks:       map[string]map[string]string{},
+        paired:     map[string]bool{},
+        caughtUp:   map[string]int{},
+    }
+    nw := btsync.NewLoopbackNetwork(btsync.LoopbackOptions{Latency: *latency, Jitter: *jitter, Loss: *loss, Seed: *seed, Tap: st.tap})
+
+    rng := rand.New(rand.NewSource(*seed))
+    skews := map[string]time.Duration{}
+    newNode := func(id string, role btsync.Role) *btsync.Manager {
+        var clock func() time.Time
+        if d := skews[id]; d != 0 {
+            clock = func() time.Time { return time.Now().Add(d) }
+        }
+        mgr := btsync.NewManager(nw.NewTransport(id), btsync.ManagerOptions{
+            ApplyScene: func(scene string, src btsync.Source) error {
+                if *applyDelay > 0 {
+                    time.Sleep(*applyDelay)
+                }
+                if src == btsync.SourceCatchUp {
+                    // 追いつきは発火時刻が後になるので、切替ズレの集計には入れない
+                    st.mu.Lock()
+                    st.caughtUp[id]++
+                    st.mu.Unlock()
+                    return nil
+                }
+                st.apply(id, scene)
+                return nil
+            },
+            PersistTrustedPeers: func(peers []btsync.TrustedPeer) error {
+                st.mu.Lock()
+                defer st.mu.Unlock()
+                for _, p := range peers {
+                    if role == btsync.RoleChild && p.Secret != "" {
+                        st.paired[id] = true
+                    }
+                }
+                return nil
+            },
+            Logf: func(level, msg string) {
+                if *verbose {
+                    log.Printf("[%s] %s: %s", id, level, msg)
+                }
+            },
+            Clock: clock,
+        })
+        mgr.SetConfig(btsync.Config{
+            Enabled:          true,
+            Role:             role,
+            DeviceName:       id,
+            LeadTimeMs:       int(lead.Milliseconds()),
+            AutoLeadTime:     *autoLead,
+            AcceptLateMs:     int(acceptLate.Milliseconds()),
+            MaxNodes:         *children + 1,
+            DropMissedEvents: *dropMissed,
+        })
+        if err := mgr.Start(); err != nil {
+            log.Fatalf("%s を開始できません: %v", id, err)
+        }
+        return mgr
+    }
+
+    parent := newNode("parent", btsync.RoleParent)
+    defer parent.Stop()
+    ids := make([]string, *children)
+    nodes := make([]*btsync.Manager, *children)
+    for i := range nodes {
+        ids[i] = fmt.Sprintf("child-%d", i+1)
+        if *skew > 0 {
+            skews[ids[i]] = time.Duration(rng.Int63n(2*int64(*skew)+1)) - *skew
+        }
+        nodes[i] = newNode(ids[i], btsync.RoleChild)
+        defer nodes[i].Stop()
+    }
+
+    // ペアリング（損失があるので揃うまで再送する。親機は1秒未満の再要求を拒否する）
+    code, err := parent.GeneratePairingCode()
+    if err != nil {
+        log.Fatalf("ペアリングコードを発行できません: %v", err)
+    }
+    deadline := time.Now().Add(30 * time.Second)
+    for {
+        st.mu.Lock()
+        n := len(st.paired)
+        st.mu.Unlock()
+        if n == *children {
+            break
+        }
+        if time.Now().After(deadline) {
+            hint := "-v で各ノードのログを確認してください"
+            if *loss > 0 {
+                hint = "-loss を下げるか、" + hint
+            }
+            log.Fatalf("ペアリングが完了しません（%d/%d 台）。%s", n, *children, hint)
+        }
+        for i, mgr := range nodes {
+            st.mu.Lock()
+            done := st.paired[ids[i]]
+            st.mu.Unlock()
+            if !done {
+                _ = mgr.JoinByCode(code)
+            }
+        }
+        time.Sleep(1500 * time.Millisecond)
+    }
+    log.Printf("ペアリング完了: 子機 %d 台。シーン切替を %d 回送ります（間隔 %s, seed=%d）", *children, *events, *interval, *seed)
+
+    for i := 0; i < *events; i++ {
+        if *flap > 0 && rng.Float64() < *flap {
+            id := ids[rng.Intn(len(ids))]
+            nw.Disconnect(id)
+            time.AfterFunc(*down, func() { nw.Connect(id) })
+            if *verbose {
+                log.Printf("切断: %s (%s)", id, *down)
+            }
+        }
+        scene := fmt.Sprintf("Scene-%03d", i+1)
+        st.mu.Lock()
+        st.dispatched[scene] = time.Now()
+        st.mu.Unlock()
+        d, err := parent.DispatchScene(scene, btsync.SourceMIDI)
+        if err != nil {
+            log.Printf("送信失敗 %s: %v", scene, err)
+        } else {
+            st.mu.Lock()
+            st.deliveries = append(st.deliveries, d)
+            st.mu.Unlock()
+        }
+        time.Sleep(*interval)
+    }
+    time.Sleep(*lead + *acceptLate + *latency + *jitter + time.Second)
+    st.mu.Lock()
+    deliveries := append([]*btsync.Delivery(nil), st.deliveries...)
+    st.mu.Unlock()
+    for _, d := range deliveries {
+        select {
+        case <-d.Done():
+        case <-time.After(5 * time.Second):
+        }
+    }
+
+    clocks := map[string]btsync.PeerStatus{}
+    for i, mgr := range nodes {
+        for _, p := range mgr.Status().Peers {
+            clocks[ids[i]] = p
+        }
+    }
+    printSimReport(st, nw.Stats(), parent.Status(), ids, *events, skews, clocks)
+}
+
+func printSimReport(st *simStats, ns btsync.LoopbackStats, ps btsync.Status, ids []string, events int, skews map[string]time.Duration, clocks map[string]btsync.PeerStatus) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+
+    statusCount := map[string]int{}
+    perChild := map[string]int{}
+    for _, byChild := range st.acks {
+        for id, s := range byChild {
+            statusCount[s]++
+            if s == string(btsync.AckOK) {
+                perChild[id]++
+            }
+        }
+    }
+    expected := events * len(ids)
+    answered := 0
+    for _, n := range statusCount {
+        answered += n
+    }
+
+    fmt.Printf("シミュレーション結果: 親機 1 + 子機 %d / シーン切替 %d 回\n", len(ids), events)
+    fmt.Printf("  ネットワーク: 送信 %d / 配送 %d / 損失 %d\n", ns.Sent, ns.Delivered, ns.Dropped)
+    if ps.LeadTimeAuto {
+        fmt.Printf("  同期待ち時間: %dms（測った遅延から自動）\n", ps.LeadTimeMs)
+    } else {
+        fmt.Printf("  同期待ち時間: %dms（固定）\n", ps.LeadTimeMs)
+    }
+    fmt.Printf("  ACK: ok %d / late_drop %d / not_found %d / error %d / 無応答 %d（期待 %d）\n",
+        statusCount[string(btsync.AckOK)], statusCount[string(btsync.AckLateDrop)],
+        statusCount[string(btsync.AckNotFound)], statusCount[string(btsync.AckError)], expected-answered, expected)
+    var sends, resent, received, settled int
+    for _, d := range st.deliveries {
+        for _, o := range d.Outcomes() {
+            sends += o.Attempts
+            if o.Attempts > 1 {
+                resent++
+            }
+            if o.Received {
+                received++
+            }
+            if o.Status == btsync.AckOK {
+                settled++
+            }
+        }
+    }
+    fmt.Printf("  配送（DispatchScene の結果）: 成功 %d / 受信確認 %d / 再送した宛先 %d（送信 %d 回）\n", settled, received, resent, sends)
+    if len(st.ackLatency) > 0 {
+        lat := append([]time.Duration(nil), st.ackLatency...)
+        sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+        var sum time.Duration
+        for _, d := range lat {
+            sum += d
+        }
+        pct := func(p float64) time.Duration { return lat[int(p*float64(len(lat)-1))] }
+        fmt.Printf("  ACK 遅延（送信→受信）: 平均 %s / p50 %s / p95 %s / 最大 %s\n",
+            (sum / time.Duration(len(lat))).Round(time.Microsecond), pct(0.5).Round(time.Microsecond),
+            pct(0.95).Round(time.Microsecond), lat[len(lat)-1].Round(time.Microsecond))
+    }
+
+    var skewSum, skewMax time.Duration
+    skewN := 0
+    for _, id := range ids {
+        for scene, at := range st.applied[id] {
+            base, ok := st.applied["parent"][scene]
+            if !ok {
+                continue
+            }
+            d := at.Sub(base)
+            if d < 0 {
+                d = -d
+            }
+            skewSum += d
+            skewN++
+            if d > skewMax {
+                skewMax = d
+            }
+        }
+    }
+    if skewN > 0 {
+        fmt.Printf("  切替ズレ（子機と親機の差）: 平均 %s / 最大 %s\n", (skewSum / time.Duration(skewN)).Round(time.Microsecond), skewMax.Round(time.Microsecond))
+    }
+    for _, id := range ids {
+        line := fmt.Sprintf("  %-10s 切替 %d/%d  ok ACK %d", id, len(st.applied[id]), events, perChild[id])
+        if n := st.caughtUp[id]; n > 0 {
+            line += fmt.Sprintf("  追いつき %d", n)
+        }
+        if c := clocks[id]; c.ClockSynced {
+            // 子機から見た offset は「親機 - 子機」なので、実際のずれ（子機 - 親機）と符号が逆になる
+            line += fmt.Sprintf("  時計のずれ 実際 %+.1fms / 推定 %+.1fms (±%.1fms)", float64(skews[id])/float64(time.Millisecond), -c.ClockOffsetMs, c.ClockUncertaintyMs)
+        }
+        fmt.Println(line)
+    }
+}
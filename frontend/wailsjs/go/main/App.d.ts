@@ -2,6 +2,9 @@
 // This file is automatically generated. DO NOT EDIT
 import {config} from '../models';
 import {btsync} from '../models';
+import {main} from '../models';
+
+export function BtBlockPeer(arg1:string):Promise<void>;
 
 export function BtGeneratePairingCode():Promise<string>;
 
@@ -11,12 +14,20 @@ export function BtGetStatus():Promise<btsync.Status>;
 
 export function BtJoinByCode(arg1:string):Promise<void>;
 
+export function BtRenamePeer(arg1:string,arg2:string):Promise<void>;
+
+export function BtRevokePeer(arg1:string):Promise<void>;
+
+export function BtRotatePeerSecret(arg1:string):Promise<void>;
+
 export function BtSaveConfig(arg1:config.BluetoothSyncConfig):Promise<void>;
 
 export function BtStart():Promise<void>;
 
 export function BtStop():Promise<void>;
 
+export function BtUnblockPeer(arg1:string):Promise<void>;
+
 export function GetConfig():Promise<config.Config>;
 
 export function ImportFromDir(arg1:string,arg2:string,arg3:boolean,arg4:boolean,arg5:string,arg6:string,arg7:boolean):Promise<void>;
@@ -29,8 +40,14 @@ export function MidiCurrentDevice():Promise<string>;
 
 export function MidiGetConfig():Promise<config.MidiConfig>;
 
+export function MidiGetStatus():Promise<main.MidiStatus>;
+
 export function MidiIsRunning():Promise<boolean>;
 
+export function MidiLearn(arg1:string):Promise<string>;
+
+export function MidiLearnCancel():Promise<void>;
+
 export function MidiListDevices():Promise<Array<string>>;
 
 export function MidiSaveConfig(arg1:config.MidiConfig):Promise<void>;
@@ -43,6 +60,8 @@ export function OpenDirectoryDialog(arg1:string,arg2:string):Promise<string>;
 
 export function OpenExternalURL(arg1:string):Promise<void>;
 
+export function RunCommand(arg1:btsync.Command):Promise<void>;
+
 export function SaveConfig(arg1:config.Config):Promise<void>;
 
 export function TestConnections():Promise<Record<string, string>>;
@@ -2,6 +2,10 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function BtBlockPeer(arg1) {
+  return window['go']['main']['App']['BtBlockPeer'](arg1);
+}
+
 export function BtGeneratePairingCode() {
   return window['go']['main']['App']['BtGeneratePairingCode']();
 }
@@ -18,6 +22,18 @@ export function BtJoinByCode(arg1) {
   return window['go']['main']['App']['BtJoinByCode'](arg1);
 }
 
+export function BtRenamePeer(arg1, arg2) {
+  return window['go']['main']['App']['BtRenamePeer'](arg1, arg2);
+}
+
+export function BtRevokePeer(arg1) {
+  return window['go']['main']['App']['BtRevokePeer'](arg1);
+}
+
+export function BtRotatePeerSecret(arg1) {
+  return window['go']['main']['App']['BtRotatePeerSecret'](arg1);
+}
+
 export function BtSaveConfig(arg1) {
   return window['go']['main']['App']['BtSaveConfig'](arg1);
 }
@@ -30,6 +46,10 @@ export function BtStop() {
   return window['go']['main']['App']['BtStop']();
 }
 
+export function BtUnblockPeer(arg1) {
+  return window['go']['main']['App']['BtUnblockPeer'](arg1);
+}
+
 export function GetConfig() {
   return window['go']['main']['App']['GetConfig']();
 }
@@ -54,10 +74,22 @@ export function MidiGetConfig() {
   return window['go']['main']['App']['MidiGetConfig']();
 }
 
+export function MidiGetStatus() {
+  return window['go']['main']['App']['MidiGetStatus']();
+}
+
 export function MidiIsRunning() {
   return window['go']['main']['App']['MidiIsRunning']();
 }
 
+export function MidiLearn(arg1) {
+  return window['go']['main']['App']['MidiLearn'](arg1);
+}
+
+export function MidiLearnCancel() {
+  return window['go']['main']['App']['MidiLearnCancel']();
+}
+
 export function MidiListDevices() {
   return window['go']['main']['App']['MidiListDevices']();
 }
@@ -82,6 +114,10 @@ export function OpenExternalURL(arg1) {
   return window['go']['main']['App']['OpenExternalURL'](arg1);
 }
 
+export function RunCommand(arg1) {
+  return window['go']['main']['App']['RunCommand'](arg1);
+}
+
 export function SaveConfig(arg1) {
   return window['go']['main']['App']['SaveConfig'](arg1);
 }
@@ -1,14 +1,47 @@
 export namespace btsync {
 	
+	export class Command {
+	    kind: string;
+	    scene?: string;
+	    transition?: string;
+	    input?: string;
+	    action?: string;
+	    source?: string;
+	    visible?: boolean;
+	    hotkey?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Command(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.kind = source["kind"];
+	        this.scene = source["scene"];
+	        this.transition = source["transition"];
+	        this.input = source["input"];
+	        this.action = source["action"];
+	        this.source = source["source"];
+	        this.visible = source["visible"];
+	        this.hotkey = source["hotkey"];
+	    }
+	}
 	export class PeerStatus {
 	    peer_id: string;
 	    name: string;
+	    alias?: string;
 	    platform: string;
 	    connected: boolean;
+	    paired: boolean;
+	    blocked: boolean;
 	    last_seen_unix_ms?: number;
 	    last_ack_unix_ms?: number;
 	    last_ack_status?: string;
 	    last_latency_ms?: number;
+	    rtt_ms?: number;
+	    clock_synced: boolean;
+	    clock_offset_ms?: number;
+	    clock_uncertainty_ms?: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new PeerStatus(source);
@@ -18,12 +51,19 @@ export namespace btsync {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.peer_id = source["peer_id"];
 	        this.name = source["name"];
+	        this.alias = source["alias"];
 	        this.platform = source["platform"];
 	        this.connected = source["connected"];
+	        this.paired = source["paired"];
+	        this.blocked = source["blocked"];
 	        this.last_seen_unix_ms = source["last_seen_unix_ms"];
 	        this.last_ack_unix_ms = source["last_ack_unix_ms"];
 	        this.last_ack_status = source["last_ack_status"];
 	        this.last_latency_ms = source["last_latency_ms"];
+	        this.rtt_ms = source["rtt_ms"];
+	        this.clock_synced = source["clock_synced"];
+	        this.clock_offset_ms = source["clock_offset_ms"];
+	        this.clock_uncertainty_ms = source["clock_uncertainty_ms"];
 	    }
 	}
 	export class Status {
@@ -34,12 +74,15 @@ export namespace btsync {
 	    running: boolean;
 	    enabled: boolean;
 	    role: string;
+	    transport: string;
 	    device_name: string;
 	    connected_peers: number;
 	    parent_connected: boolean;
 	    pairing_code_active: boolean;
 	    pairing_code_expires_unix_ms?: number;
 	    last_error?: string;
+	    lead_time_ms: number;
+	    lead_time_auto: boolean;
 	    peers: PeerStatus[];
 	
 	    static createFrom(source: any = {}) {
@@ -55,12 +98,15 @@ export namespace btsync {
 	        this.running = source["running"];
 	        this.enabled = source["enabled"];
 	        this.role = source["role"];
+	        this.transport = source["transport"];
 	        this.device_name = source["device_name"];
 	        this.connected_peers = source["connected_peers"];
 	        this.parent_connected = source["parent_connected"];
 	        this.pairing_code_active = source["pairing_code_active"];
 	        this.pairing_code_expires_unix_ms = source["pairing_code_expires_unix_ms"];
 	        this.last_error = source["last_error"];
+	        this.lead_time_ms = source["lead_time_ms"];
+	        this.lead_time_auto = source["lead_time_auto"];
 	        this.peers = this.convertValues(source["peers"], PeerStatus);
 	    }
 	
@@ -105,6 +151,8 @@ export namespace config {
 	        this.secret = source["secret"];
 	        this.last_seen = source["last_seen"];
 	        this.platform = source["platform"];
+	        this.alias = source["alias"];
+	        this.blocked = source["blocked"];
 	    }
 	}
 	export class BluetoothSyncConfig {
@@ -112,11 +160,15 @@ export namespace config {
 	    role: string;
 	    device_name: string;
 	    lead_time_ms: number;
+	    auto_lead_time: boolean;
 	    pairing_code_ttl_sec: number;
 	    accept_late_ms: number;
 	    max_nodes: number;
 	    auto_reconnect: boolean;
 	    drop_missed_events: boolean;
+	    transport: string;
+	    lan_port: number;
+	    lan_parent_addr: string;
 	    trusted_peers: TrustedPeer[];
 	
 	    static createFrom(source: any = {}) {
@@ -129,11 +181,15 @@ export namespace config {
 	        this.role = source["role"];
 	        this.device_name = source["device_name"];
 	        this.lead_time_ms = source["lead_time_ms"];
+	        this.auto_lead_time = source["auto_lead_time"];
 	        this.pairing_code_ttl_sec = source["pairing_code_ttl_sec"];
 	        this.accept_late_ms = source["accept_late_ms"];
 	        this.max_nodes = source["max_nodes"];
 	        this.auto_reconnect = source["auto_reconnect"];
 	        this.drop_missed_events = source["drop_missed_events"];
+	        this.transport = source["transport"];
+	        this.lan_port = source["lan_port"];
+	        this.lan_parent_addr = source["lan_parent_addr"];
 	        this.trusted_peers = this.convertValues(source["trusted_peers"], TrustedPeer);
 	    }
 	
@@ -155,6 +211,40 @@ export namespace config {
 		    return a;
 		}
 	}
+	export class MidiBankConfig {
+	    name: string;
+	    select?: string;
+	    shift?: string;
+	    mappings: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new MidiBankConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.select = source["select"];
+	        this.shift = source["shift"];
+	        this.mappings = source["mappings"];
+	    }
+	}
+	export class MidiInputConfig {
+	    device: string;
+	    channel: string;
+	    mappings: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new MidiInputConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.device = source["device"];
+	        this.channel = source["channel"];
+	        this.mappings = source["mappings"];
+	    }
+	}
 	export class MidiConfig {
 	    enabled: boolean;
 	    device: string;
@@ -162,6 +252,9 @@ export namespace config {
 	    debounce: string;
 	    rate_limit: string;
 	    mappings: string[];
+	    inputs?: MidiInputConfig[];
+	    banks?: MidiBankConfig[];
+	    feedback_device?: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new MidiConfig(source);
@@ -175,7 +268,28 @@ export namespace config {
 	        this.debounce = source["debounce"];
 	        this.rate_limit = source["rate_limit"];
 	        this.mappings = source["mappings"];
+	        this.inputs = this.convertValues(source["inputs"], MidiInputConfig);
+	        this.banks = this.convertValues(source["banks"], MidiBankConfig);
+	        this.feedback_device = source["feedback_device"];
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
 	export class ImportDefaults {
 	    loop: boolean;
@@ -257,3 +371,67 @@ export namespace config {
 
 }
 
+export namespace main {
+	
+	export class MidiDeviceStatus {
+	    device: string;
+	    connected: boolean;
+	    port?: string;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MidiDeviceStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.device = source["device"];
+	        this.connected = source["connected"];
+	        this.port = source["port"];
+	        this.error = source["error"];
+	    }
+	}
+	export class MidiStatus {
+	    running: boolean;
+	    device: string;
+	    connected: boolean;
+	    port?: string;
+	    error?: string;
+	    devices?: MidiDeviceStatus[];
+	    bank?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MidiStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.running = source["running"];
+	        this.device = source["device"];
+	        this.connected = source["connected"];
+	        this.port = source["port"];
+	        this.error = source["error"];
+	        this.devices = this.convertValues(source["devices"], MidiDeviceStatus);
+	        this.bank = source["bank"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
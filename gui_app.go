@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,22 +18,61 @@ import (
 	"awesomeProject/internal/btsync"
 	"awesomeProject/internal/gui/config"
 	"awesomeProject/internal/midi"
+	"awesomeProject/internal/midimap"
 	"awesomeProject/internal/obsws"
 
 	"github.com/andreykaipov/goobs"
+	"github.com/andreykaipov/goobs/api/requests/general"
+	"github.com/andreykaipov/goobs/api/requests/mediainputs"
+	"github.com/andreykaipov/goobs/api/requests/record"
+	"github.com/andreykaipov/goobs/api/requests/sceneitems"
 	"github.com/andreykaipov/goobs/api/requests/scenes"
+	"github.com/andreykaipov/goobs/api/requests/transitions"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 type BluetoothStatus = btsync.Status
 
+// MidiStatus は GUI のステータス表示用の MIDI 入力状態です。
+// Device / Port は 1 台目、Connected は全デバイスが接続中のとき true、Devices はデバイスごとの状態です。
+// Bank は 1 台目の有効なバンク名です（バンク未設定なら空）。
+type MidiStatus struct {
+	Running   bool               `json:"running"`
+	Device    string             `json:"device"`
+	Connected bool               `json:"connected"`
+	Port      string             `json:"port,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Devices   []MidiDeviceStatus `json:"devices,omitempty"`
+	Bank      string             `json:"bank,omitempty"`
+}
+
+// MidiDeviceStatus は開いている MIDI 入力デバイス 1 台の状態です。
+type MidiDeviceStatus struct {
+	Device    string `json:"device"`
+	Connected bool   `json:"connected"`
+	Port      string `json:"port,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
 type App struct {
 	ctx context.Context
 	cfg *config.Config
 
 	// MIDI runtime
-	midiCancel context.CancelFunc
-	midiDrv    midi.Input
+	midiCancel   context.CancelFunc
+	midiDrv      midi.Input
+	midiEvals    map[string]*midimap.Evaluator // デバイス名 → 評価器（1 台目は cfg.MIDI.Device）
+	midiFeedback midi.Output                   // バンク表示用の MIDI 出力（feedback_device 指定時）
+
+	// saveMu は設定ファイルへの書き込みを順に行います（midiMu の外で保存するので、古い内容で上書きしないように）
+	saveMu sync.Mutex
+
+	// cfg（全体）・MIDI の実行状態・MIDI Learn（実行中のセッションからイベントを横取りする）・接続状態を守る
+	// （設定ファイルの監視からの再読込、同期マネージャからの信頼済み peer の保存、画面からの操作が並行する）
+	midiMu      sync.Mutex
+	learnCh     chan midi.Event
+	learnCancel context.CancelFunc
+	midiDevices []MidiDeviceStatus
 
 	// OBS connection cache
 	cacheMu      sync.Mutex
@@ -58,16 +101,25 @@ func NewApp() *App {
 	}
 
 	a := &App{cfg: cfg, cacheClients: map[string]*goobs.Client{}}
-	a.bt = btsync.NewManager(btsync.NewNativeTransport(), btsync.ManagerOptions{
+	a.bt = btsync.NewManager(nil, btsync.ManagerOptions{
 		ApplyScene: func(scene string, source btsync.Source) error {
 			return a.applySceneToEnabledConnections(scene)
 		},
 		SceneExists: func(scene string) (bool, error) {
 			return a.sceneExistsOnEnabledConnections(scene)
 		},
+		PreviewScene:     a.obsPreviewScene,
+		TakePreview:      a.obsTakePreview,
+		MediaAction:      a.obsMediaAction,
+		Record:           a.obsRecord,
+		SetSourceVisible: a.obsSetSourceVisible,
+		TriggerHotkey:    a.obsTriggerHotkey,
+		CurrentScene:     a.obsCurrentScene,
 		PersistTrustedPeers: func(peers []btsync.TrustedPeer) error {
+			a.midiMu.Lock()
 			a.cfg.Bluetooth.TrustedPeers = toConfigTrustedPeers(peers)
-			return config.Save(a.cfg)
+			a.midiMu.Unlock()
+			return a.saveConfig()
 		},
 		Logf: func(level, msg string) {
 			_ = a.emitLog(level, "[BT] "+msg)
@@ -96,10 +148,17 @@ func (a *App) shutdown(ctx context.Context) {
 // --- 設定API ---
 
 func (a *App) GetConfig() (*config.Config, error) {
+	return a.configSnapshot(), nil
+}
+
+// configSnapshot は cfg の複製を返します。cfg を読むときは midiMu を取るか、この複製を使います。
+func (a *App) configSnapshot() *config.Config {
+	a.midiMu.Lock()
+	defer a.midiMu.Unlock()
 	if a.cfg == nil {
-		return config.Default(), nil
+		return config.Default()
 	}
-	return a.cfg, nil
+	return a.cfg.Clone()
 }
 
 func (a *App) SaveConfig(c *config.Config) error {
@@ -107,14 +166,25 @@ func (a *App) SaveConfig(c *config.Config) error {
 		return errors.New("config is nil")
 	}
 	normalizeUniqueConnectionNames(c)
+	c = c.Clone()
+	a.midiMu.Lock()
 	a.cfg = c
-	if err := config.Save(c); err != nil {
+	bc := c.Bluetooth
+	a.midiMu.Unlock()
+	if err := a.saveConfig(); err != nil {
 		return err
 	}
-	a.bt.SetConfig(btConfigFromGUI(a.cfg.Bluetooth))
+	a.bt.SetConfig(btConfigFromGUI(bc))
 	return a.emitLog("info", "設定を保存しました")
 }
 
+// saveConfig は設定を保存します。cfg は midiMu を取って複製し、ファイルへの書き込みはロックの外で行います。
+func (a *App) saveConfig() error {
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+	return config.Save(a.configSnapshot())
+}
+
 func normalizeUniqueConnectionNames(c *config.Config) {
 	if c == nil || len(c.Connections) == 0 {
 		return
@@ -142,11 +212,12 @@ func normalizeUniqueConnectionNames(c *config.Config) {
 }
 
 func (a *App) TestConnections() (map[string]string, error) {
-	if len(a.cfg.Connections) == 0 {
+	conns := a.configSnapshot().Connections
+	if len(conns) == 0 {
 		return nil, errors.New("接続先がありません")
 	}
 	out := map[string]string{}
-	for _, c := range a.cfg.Connections {
+	for _, c := range conns {
 		if !c.Enabled {
 			out[c.Name] = "SKIP: disabled"
 			continue
@@ -168,12 +239,13 @@ func (a *App) TestConnections() (map[string]string, error) {
 }
 
 func (a *App) ListScenes() ([]string, error) {
-	if len(a.cfg.Connections) == 0 {
+	conns := a.configSnapshot().Connections
+	if len(conns) == 0 {
 		return nil, errors.New("接続先がありません")
 	}
 	var inter map[string]struct{}
 	first := true
-	for _, c := range a.cfg.Connections {
+	for _, c := range conns {
 		if !c.Enabled {
 			continue
 		}
@@ -218,7 +290,7 @@ func (a *App) ListScenesFor(connectionName string) ([]string, error) {
 	if strings.TrimSpace(connectionName) == "" {
 		return nil, errors.New("接続名を指定してください")
 	}
-	for _, c := range a.cfg.Connections {
+	for _, c := range a.configSnapshot().Connections {
 		if !c.Enabled {
 			continue
 		}
@@ -259,11 +331,27 @@ func (a *App) TriggerScene(scene string) error {
 	return nil
 }
 
+// RunCommand はシーン切替以外の OBS 操作を実行します。親機として同期中なら子機にも同じ時刻で実行させます。
+func (a *App) RunCommand(cmd btsync.Command) error {
+	cmd = cmd.Normalize()
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := a.dispatchCommand(cmd, btsync.SourceGUI); err != nil {
+			_ = a.emitLog("error", fmt.Sprintf("操作失敗 [%s]: %v", cmd, err))
+		}
+	}()
+	return nil
+}
+
 func (a *App) ImportFromDir(connectionName, dir string, loop bool, activate bool, transition string, monitoring string, debug bool) error {
 	var target *config.Connection
-	for i := range a.cfg.Connections {
-		if a.cfg.Connections[i].Name == connectionName && a.cfg.Connections[i].Enabled {
-			target = &a.cfg.Connections[i]
+	conns := a.configSnapshot().Connections
+	for i := range conns {
+		if conns[i].Name == connectionName && conns[i].Enabled {
+			target = &conns[i]
 			break
 		}
 	}
@@ -402,9 +490,166 @@ func (a *App) setSceneCachedPairs(pairs []struct{ addr, pw string }, scene strin
 	return nil
 }
 
+// doOnEnabledConnections は有効な全接続で fn を実行し、失敗した接続のエラーをまとめて返します。
+// 接続が切れていた（要求が OBS に届いていない）ときはキャッシュを捨て、retry なら 1 回だけ接続し直して実行し直します。
+// 録画のトグルやホットキーなど、2 回実行すると結果が変わる操作は retry=false で呼びます。
+func (a *App) doOnEnabledConnections(retry bool, fn func(c *goobs.Client) error) error {
+	return a.doOnEnabledAddrs(retry, func(_ string, c *goobs.Client) error { return fn(c) })
+}
+
+// doOnEnabledAddrs は doOnEnabledConnections と同じですが、fn に接続先のアドレスも渡します。
+func (a *App) doOnEnabledAddrs(retry bool, fn func(addr string, c *goobs.Client) error) error {
+	pairs := a.enabledPairs()
+	if len(pairs) == 0 {
+		return errors.New("有効な接続がありません")
+	}
+	var errs []error
+	for _, p := range pairs {
+		addr := obsws.NormalizeObsAddr(strings.TrimSpace(p.addr))
+		if addr == "" {
+			continue
+		}
+		cli, err := a.getClientCached(addr, p.pw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s 接続失敗: %w", addr, err))
+			continue
+		}
+		err = fn(addr, cli)
+		if err == nil {
+			continue
+		}
+		if !isObsConnError(err) {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		a.dropCachedClient(addr, p.pw)
+		if !retry {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		cli2, err2 := a.getClientCached(addr, p.pw)
+		if err2 != nil {
+			errs = append(errs, fmt.Errorf("%s 再接続失敗: %w", addr, err2))
+			continue
+		}
+		if err3 := fn(addr, cli2); err3 != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err3))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dropCachedClient はキャッシュした接続を閉じて捨てます（次の getClientCached で接続し直す）。
+func (a *App) dropCachedClient(addr, pw string) {
+	key := addr + "\x00" + strings.TrimSpace(pw)
+	a.cacheMu.Lock()
+	if old, ok := a.cacheClients[key]; ok {
+		_ = old.Disconnect()
+		delete(a.cacheClients, key)
+	}
+	a.cacheMu.Unlock()
+}
+
+// isObsConnError は接続が切れていて要求を送れなかったエラーかを返します。
+// OBS が返したエラーや応答待ちのタイムアウト（OBS では実行済みかもしれない）は含めません。
+func isObsConnError(err error) bool {
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+		return true
+	}
+	return strings.Contains(err.Error(), "client already disconnected")
+}
+
+// obsCurrentScene は有効な全接続のプログラムシーンを返します。接続ごとに違うときは空を返します。
+func (a *App) obsCurrentScene() (string, error) {
+	current, first := "", true
+	err := a.doOnEnabledConnections(true, func(c *goobs.Client) error {
+		resp, err := c.Scenes.GetCurrentProgramScene(&scenes.GetCurrentProgramSceneParams{})
+		if err != nil {
+			return err
+		}
+		scene := resp.SceneName
+		if scene == "" {
+			scene = resp.CurrentProgramSceneName
+		}
+		if first {
+			current, first = scene, false
+		} else if scene != current {
+			current = ""
+		}
+		return nil
+	})
+	return current, err
+}
+
+func (a *App) obsPreviewScene(scene string) error {
+	return a.doOnEnabledConnections(true, func(c *goobs.Client) error {
+		_, err := c.Scenes.SetCurrentPreviewScene(&scenes.SetCurrentPreviewSceneParams{SceneName: &scene})
+		return err
+	})
+}
+
+func (a *App) obsTakePreview(transition string) error {
+	return a.doOnEnabledAddrs(false, func(addr string, c *goobs.Client) error {
+		if transition != "" {
+			restore, err := obsws.UseTransition(c, addr, transition)
+			if err != nil {
+				return err
+			}
+			// トランジションが終わってから戻すので、テイクの完了を待たせない
+			defer func() { go restore() }()
+		}
+		_, err := c.Transitions.TriggerStudioModeTransition(&transitions.TriggerStudioModeTransitionParams{})
+		return err
+	})
+}
+
+func (a *App) obsMediaAction(input, action string) error {
+	mediaAction := "OBS_WEBSOCKET_MEDIA_INPUT_ACTION_" + strings.ToUpper(action)
+	// restart 以外は 2 回実行しても同じ状態になる（restart は頭出しをやり直してしまう）
+	retry := action != "restart"
+	return a.doOnEnabledConnections(retry, func(c *goobs.Client) error {
+		_, err := c.MediaInputs.TriggerMediaInputAction(&mediainputs.TriggerMediaInputActionParams{InputName: &input, MediaAction: &mediaAction})
+		return err
+	})
+}
+
+func (a *App) obsRecord(action string) error {
+	// トグルは 2 回実行すると元に戻るので接続し直して実行し直さない
+	return a.doOnEnabledConnections(action == "start" || action == "stop", func(c *goobs.Client) error {
+		var err error
+		switch action {
+		case "start":
+			_, err = c.Record.StartRecord(&record.StartRecordParams{})
+		case "stop":
+			_, err = c.Record.StopRecord(&record.StopRecordParams{})
+		default:
+			_, err = c.Record.ToggleRecord(&record.ToggleRecordParams{})
+		}
+		return err
+	})
+}
+
+func (a *App) obsSetSourceVisible(scene, source string, visible bool) error {
+	return a.doOnEnabledConnections(true, func(c *goobs.Client) error {
+		item, err := c.SceneItems.GetSceneItemId(&sceneitems.GetSceneItemIdParams{SceneName: &scene, SourceName: &source})
+		if err != nil {
+			return err
+		}
+		_, err = c.SceneItems.SetSceneItemEnabled(&sceneitems.SetSceneItemEnabledParams{SceneName: &scene, SceneItemId: &item.SceneItemId, SceneItemEnabled: &visible})
+		return err
+	})
+}
+
+func (a *App) obsTriggerHotkey(name string) error {
+	return a.doOnEnabledConnections(false, func(c *goobs.Client) error {
+		_, err := c.General.TriggerHotkeyByName(&general.TriggerHotkeyByNameParams{HotkeyName: &name})
+		return err
+	})
+}
+
 func (a *App) enabledPairs() []struct{ addr, pw string } {
 	var pairs []struct{ addr, pw string }
-	for _, c := range a.cfg.Connections {
+	for _, c := range a.configSnapshot().Connections {
 		if c.Enabled {
 			pairs = append(pairs, struct{ addr, pw string }{addr: c.Addr, pw: strings.TrimSpace(c.Password)})
 		}
@@ -457,63 +702,226 @@ func (a *App) dispatchScene(scene string, source btsync.Source) error {
 	if scene == "" {
 		return errors.New("シーン名が空です")
 	}
+	a.noteSceneChange(scene, source)
 
 	st := a.bt.Status()
-	if st.Running && st.Role == btsync.RoleParent && a.cfg.Bluetooth.Enabled {
+	if st.Running && st.Role == btsync.RoleParent && a.btEnabled() {
 		_ = a.emitLog("info", fmt.Sprintf("同期シーン切替を送信: %s (%s)", scene, source))
-		return a.bt.DispatchScene(scene, source)
+		d, err := a.bt.DispatchScene(scene, source)
+		if err != nil {
+			return err
+		}
+		go a.logDelivery(scene, d)
+		return nil
 	}
 
 	_ = a.emitLog("info", fmt.Sprintf("ローカルシーン切替: %s (%s)", scene, source))
 	return a.applySceneToEnabledConnections(scene)
 }
 
+func (a *App) dispatchCommand(cmd btsync.Command, source btsync.Source) error {
+	st := a.bt.Status()
+	if st.Running && st.Role == btsync.RoleParent && a.btEnabled() {
+		_ = a.emitLog("info", fmt.Sprintf("同期操作を送信: %s (%s)", cmd, source))
+		d, err := a.bt.DispatchCommand(cmd, source)
+		if err != nil {
+			return err
+		}
+		go a.logDelivery(cmd.String(), d)
+		return nil
+	}
+
+	_ = a.emitLog("info", fmt.Sprintf("ローカル操作: %s (%s)", cmd, source))
+	switch cmd.Kind {
+	case btsync.CmdPreview:
+		return a.obsPreviewScene(cmd.Scene)
+	case btsync.CmdTake:
+		return a.obsTakePreview(cmd.Transition)
+	case btsync.CmdMedia:
+		return a.obsMediaAction(cmd.Input, cmd.Action)
+	case btsync.CmdRecord:
+		return a.obsRecord(cmd.Action)
+	case btsync.CmdSourceVisibility:
+		return a.obsSetSourceVisible(cmd.Scene, cmd.Source, cmd.Visible)
+	case btsync.CmdHotkey:
+		return a.obsTriggerHotkey(cmd.Hotkey)
+	}
+	return fmt.Errorf("未対応の操作です: %s", cmd.Kind)
+}
+
+// logDelivery は配送が終わるのを待ち、子機ごとの結果を 1 行にまとめてログに出します。
+func (a *App) logDelivery(label string, d *btsync.Delivery) {
+	out := d.Wait()
+	if len(out) == 0 {
+		return
+	}
+	ok := 0
+	var parts []string
+	for _, o := range out {
+		if o.Status == btsync.AckOK {
+			ok++
+		}
+		part := fmt.Sprintf("%s: %s", o.Name, o.Status)
+		if o.Attempts > 1 {
+			part += fmt.Sprintf(" (送信%d回)", o.Attempts)
+		}
+		parts = append(parts, part)
+	}
+	level := "info"
+	if ok < len(out) {
+		level = "error"
+	}
+	_ = a.emitLog(level, fmt.Sprintf("同期結果 %s: %d/%d 台成功 [%s]", label, ok, len(out), strings.Join(parts, ", ")))
+}
+
+// noteSceneChange は MIDI 以外で切り替えたシーンを MIDI の評価器に伝えます（momentary / toggle の戻り先）。
+func (a *App) noteSceneChange(scene string, source btsync.Source) {
+	if source == btsync.SourceMIDI {
+		return
+	}
+	a.midiMu.Lock()
+	evals := a.midiEvals
+	a.midiMu.Unlock()
+	for _, eval := range evals {
+		eval.SetCurrent(scene)
+	}
+}
+
+// seedMidiScene は起動直後の戻り先として、最初の有効な接続の現在のシーンを評価器に設定します。
+func (a *App) seedMidiScene(evals map[string]*midimap.Evaluator) {
+	for _, p := range a.enabledPairs() {
+		addr := obsws.NormalizeObsAddr(strings.TrimSpace(p.addr))
+		if addr == "" {
+			continue
+		}
+		cli, err := a.getClientCached(addr, p.pw)
+		if err != nil {
+			continue
+		}
+		resp, err := cli.Scenes.GetCurrentProgramScene(&scenes.GetCurrentProgramSceneParams{})
+		if err != nil {
+			continue
+		}
+		scene := resp.SceneName
+		if scene == "" {
+			scene = resp.CurrentProgramSceneName
+		}
+		for _, eval := range evals {
+			if scene != "" && eval.Current() == "" {
+				eval.SetCurrent(scene)
+			}
+		}
+		return
+	}
+}
+
 // --- MIDI Support ---
 
 func (a *App) MidiListDevices() ([]string, error) { return midi.ListInputs() }
 
-func (a *App) MidiGetConfig() (config.MidiConfig, error) { return a.cfg.MIDI, nil }
+func (a *App) MidiGetConfig() (config.MidiConfig, error) {
+	a.midiMu.Lock()
+	defer a.midiMu.Unlock()
+	return a.cfg.MIDI, nil
+}
 
-func (a *App) MidiIsRunning() bool { return a.midiDrv != nil }
+func (a *App) MidiIsRunning() bool {
+	a.midiMu.Lock()
+	defer a.midiMu.Unlock()
+	return a.midiDrv != nil
+}
 
 func (a *App) MidiCurrentDevice() string {
-	if a == nil || a.cfg == nil {
+	if a == nil {
+		return ""
+	}
+	a.midiMu.Lock()
+	defer a.midiMu.Unlock()
+	if a.cfg == nil {
 		return ""
 	}
 	return a.cfg.MIDI.Device
 }
 
 func (a *App) MidiSaveConfig(mc config.MidiConfig) error {
+	a.midiMu.Lock()
 	a.cfg.MIDI = mc
-	if err := config.Save(a.cfg); err != nil {
+	var verr error
+	if a.midiEvals != nil {
+		var rules map[string]*midimap.Rules
+		rules, _, verr = midiRulesByDevice(mc)
+		a.swapMidiRulesLocked(rules)
+	}
+	a.midiMu.Unlock()
+	if err := a.saveConfig(); err != nil {
 		return err
 	}
+	if verr != nil {
+		_ = a.emitLog("error", fmt.Sprintf("MIDI設定に無視した項目があります: %v", verr))
+	}
 	return a.emitLog("info", "MIDI設定を保存しました")
 }
 
 func (a *App) MidiStart() error {
+	a.midiMu.Lock()
 	mc := a.cfg.MIDI
+	a.midiMu.Unlock()
 	if strings.TrimSpace(mc.Device) == "" {
 		return errors.New("MIDIデバイスを選択してください")
 	}
 	_ = a.MidiStop()
 
-	chs := parseChannels(mc.Channel)
-	debounce := mustParseDurationDefault(mc.Debounce, 30*time.Millisecond)
-	ratelimit := mustParseDurationDefault(mc.RateLimit, 50*time.Millisecond)
-	noteMap := parseNoteMaps(mc.Mappings)
+	rules, devices, verr := midiRulesByDevice(mc)
+	if verr != nil {
+		_ = a.emitLog("error", fmt.Sprintf("MIDI設定に無視した項目があります: %v", verr))
+	}
+	evals := map[string]*midimap.Evaluator{}
+	for dev, r := range rules {
+		evals[dev] = midimap.NewEvaluator(r)
+	}
 
-	drv, events, err := midi.OpenInput(mc.Device)
+	a.midiMu.Lock()
+	a.midiDevices = make([]MidiDeviceStatus, len(devices))
+	for i, dev := range devices {
+		a.midiDevices[i] = MidiDeviceStatus{Device: dev}
+	}
+	a.midiMu.Unlock()
+	drv, events, err := midi.OpenInputsAuto(devices, midi.ReconnectOptions{}, a.setMidiState)
 	if err != nil {
+		a.midiMu.Lock()
+		a.midiDevices = nil
+		a.midiMu.Unlock()
 		return err
 	}
-	a.midiDrv = drv
+	// バンクの LED フィードバック。開けなくても MIDI 入力は続ける
+	var feedback midi.Output
+	if fd := strings.TrimSpace(mc.FeedbackDevice); fd != "" {
+		if out, err := midi.OpenOutput(fd); err != nil {
+			_ = a.emitLog("error", fmt.Sprintf("MIDIフィードバック出力を開けません（バンク表示なしで継続）: %v", err))
+		} else {
+			feedback = out
+		}
+	}
 	ctx, cancel := context.WithCancel(context.Background())
+	a.midiMu.Lock()
+	a.midiDrv = drv
+	a.midiEvals = evals
+	a.midiFeedback = feedback
 	a.midiCancel = cancel
-	_ = a.emitLog("info", fmt.Sprintf("MIDI開始: device=%s ch=%s", mc.Device, mc.Channel))
+	a.midiMu.Unlock()
+	primary := evals[mc.Device]
+	if _, bank := primary.Bank(); bank != "" {
+		_ = a.emitLog("info", "MIDIバンク: "+bank)
+		sendBankFeedback(feedback, primary)
+	}
+	_ = a.emitLog("info", fmt.Sprintf("MIDI開始: device=%s ch=%s", strings.Join(devices, ", "), mc.Channel))
 
-	lastAt := map[string]time.Time{}
-	var lastEvent time.Time
+	go a.seedMidiScene(evals)
+
+	// 設定ファイルが外部で編集されたらマッピング等を差し替える
+	if p, err := config.Path(); err == nil {
+		go midimap.WatchFile(ctx, p, time.Second, a.reloadMidiConfig)
+	}
 
 	go func() {
 		defer func() { _ = a.emitLog("info", "MIDI停止") }()
@@ -525,31 +933,45 @@ func (a *App) MidiStart() error {
 				if !ok {
 					return
 				}
-				if !lastEvent.IsZero() && time.Since(lastEvent) < debounce {
+				if a.learnTap(ev) {
 					continue
 				}
-				lastEvent = time.Now()
-				if ev.Type != midi.NoteOn {
+				eval := evals[ev.Device]
+				if eval == nil {
 					continue
 				}
-				if len(chs) > 0 && !containsChannel(chs, int(ev.Channel)) {
+				res := eval.Evaluate(ev, time.Now())
+				if res.Bank != "" {
+					if res.Release {
+						_ = a.emitLog("info", fmt.Sprintf("MIDIバンク: %s (%s を離した)", res.Bank, res.Key))
+					} else {
+						_ = a.emitLog("info", fmt.Sprintf("MIDIバンク: %s (%s)", res.Bank, res.Key))
+					}
+					if eval == primary {
+						sendBankFeedback(feedback, eval)
+					}
 					continue
 				}
-				key := fmt.Sprintf("%d:%d", ev.Channel, ev.Data1)
-				scene, ok := noteMap[key]
-				if !ok {
+				if res.Skip == "norevert" {
+					_ = a.emitLog("error", fmt.Sprintf("MIDI: %s (%s) の戻り先シーンが不明です。戻り先を設定してください", res.Key, res.Mode))
+				}
+				if !res.Fire() {
+					continue
+				}
+				if err := a.dispatchScene(res.Scene, btsync.SourceMIDI); err != nil {
+					_ = a.emitLog("error", fmt.Sprintf("MIDI切替失敗: %v", err))
 					continue
 				}
-				if t, ok2 := lastAt[key]; ok2 {
-					if since := time.Since(t); since < ratelimit {
-						continue
+				// 他のデバイスの戻り先も揃える
+				for _, other := range evals {
+					if other != eval {
+						other.SetCurrent(res.Scene)
 					}
 				}
-				lastAt[key] = time.Now()
-				if err := a.dispatchScene(scene, btsync.SourceMIDI); err != nil {
-					_ = a.emitLog("error", fmt.Sprintf("MIDI切替失敗: %v", err))
+				if res.Release {
+					_ = a.emitLog("info", fmt.Sprintf("MIDI切替: %s (%s を離した)", res.Scene, res.Key))
 				} else {
-					_ = a.emitLog("info", fmt.Sprintf("MIDI切替: %s (CH%d Note%d)", scene, ev.Channel, ev.Data1))
+					_ = a.emitLog("info", fmt.Sprintf("MIDI切替: %s (%s)", res.Scene, res.Key))
 				}
 			}
 		}
@@ -558,34 +980,263 @@ func (a *App) MidiStart() error {
 }
 
 func (a *App) MidiStop() error {
-	if a.midiCancel != nil {
-		a.midiCancel()
-		a.midiCancel = nil
+	a.midiMu.Lock()
+	cancel, drv, feedback := a.midiCancel, a.midiDrv, a.midiFeedback
+	a.midiCancel, a.midiDrv, a.midiFeedback = nil, nil, nil
+	a.midiEvals = nil
+	a.midiDevices = nil
+	a.midiMu.Unlock()
+	// 入力を閉じる間も接続状態の通知（setMidiState）が midiMu を取るので、ロックの外で閉じる
+	if cancel != nil {
+		cancel()
+	}
+	if drv != nil {
+		_ = drv.Close()
 	}
-	if a.midiDrv != nil {
-		_ = a.midiDrv.Close()
-		a.midiDrv = nil
+	if feedback != nil {
+		_ = feedback.Close()
 	}
 	return nil
 }
 
+// MidiGetStatus は MIDI 入力の実行/接続状態を返します（抜き差し中は Connected=false）。
+func (a *App) MidiGetStatus() (MidiStatus, error) {
+	a.midiMu.Lock()
+	devices := append([]MidiDeviceStatus(nil), a.midiDevices...)
+	st := MidiStatus{Running: a.midiDrv != nil, Devices: devices}
+	evals := a.midiEvals
+	a.midiMu.Unlock()
+	if len(devices) == 0 {
+		st.Device = a.MidiCurrentDevice()
+		return st, nil
+	}
+	st.Device, st.Port = devices[0].Device, devices[0].Port
+	if eval := evals[st.Device]; eval != nil {
+		_, st.Bank = eval.Bank()
+	}
+	st.Connected = true
+	for _, d := range devices {
+		st.Connected = st.Connected && d.Connected
+		if st.Error == "" && d.Error != "" {
+			st.Error = d.Device + ": " + d.Error
+		}
+	}
+	return st, nil
+}
+
+func (a *App) setMidiState(device string, state midi.State, port string, err error) {
+	a.midiMu.Lock()
+	for i := range a.midiDevices {
+		if a.midiDevices[i].Device != device {
+			continue
+		}
+		a.midiDevices[i] = MidiDeviceStatus{Device: device, Connected: state == midi.StateConnected, Port: port}
+		if err != nil {
+			a.midiDevices[i].Error = err.Error()
+		}
+	}
+	a.midiMu.Unlock()
+	switch state {
+	case midi.StateConnected:
+		_ = a.emitLog("info", "MIDI接続: "+port)
+	case midi.StateDisconnected:
+		_ = a.emitLog("error", fmt.Sprintf("MIDI切断: %s (%v) 再接続を待機します", port, err))
+	}
+}
+
+// swapMidiRulesLocked は実行中の評価器のルールをデバイスごとに差し替えます（midiMu を取ってから呼ぶ）。
+// 新しく追加したデバイスは再開始まで反映しません。
+func (a *App) swapMidiRulesLocked(rules map[string]*midimap.Rules) {
+	for dev, r := range rules {
+		if eval, ok := a.midiEvals[dev]; ok {
+			eval.Swap(r)
+		}
+	}
+	primary := ""
+	if len(a.midiDevices) > 0 {
+		primary = a.midiDevices[0].Device
+	}
+	sendBankFeedback(a.midiFeedback, a.midiEvals[primary])
+}
+
+// sendBankFeedback は有効なバンクの切替ボタンを点灯（他は消灯）するメッセージを out に送ります（out か eval が nil なら何もしません）。
+func sendBankFeedback(out midi.Output, eval *midimap.Evaluator) {
+	if out == nil || eval == nil {
+		return
+	}
+	i, _ := eval.Bank()
+	for _, msg := range eval.Rules().BankFeedback(i) {
+		if err := out.Send(msg); err != nil {
+			return
+		}
+	}
+}
+
+// reloadMidiConfig は設定ファイルを読み直し、MIDI 設定が変わっていれば実行中のルールを差し替えます。
+// 検証エラーのときは現在のマッピングを維持します。デバイスの変更は再開始まで反映しません。
+func (a *App) reloadMidiConfig() {
+	c, err := config.Load()
+	if err != nil {
+		_ = a.emitLog("error", fmt.Sprintf("MIDI設定の再読込に失敗（現在のマッピングを継続）: %v", err))
+		return
+	}
+	a.midiMu.Lock()
+	if reflect.DeepEqual(c.MIDI, a.cfg.MIDI) {
+		a.midiMu.Unlock()
+		return
+	}
+	rules, devices, err := midiRulesByDevice(c.MIDI)
+	if err != nil {
+		a.midiMu.Unlock()
+		_ = a.emitLog("error", fmt.Sprintf("MIDI設定の再読込に失敗（現在のマッピングを継続）: %v", err))
+		return
+	}
+	newDevice := false
+	for _, dev := range devices {
+		if _, ok := a.midiEvals[dev]; !ok {
+			newDevice = true
+			break
+		}
+	}
+	a.cfg.MIDI = c.MIDI
+	a.swapMidiRulesLocked(rules)
+	a.midiMu.Unlock()
+	if newDevice {
+		_ = a.emitLog("info", "MIDIデバイスの変更は再開始後に反映されます")
+	}
+	n := 0
+	for _, r := range rules {
+		n += len(r.Scenes)
+	}
+	_ = a.emitLog("info", fmt.Sprintf("MIDI設定を再読込しました（マッピング %d 件）", n))
+}
+
+// MidiLearn は次に受信した MIDI トリガ（NoteOn/CC/PC）を scene に割り当て、設定へ保存します。
+// MIDI 実行中はそのセッションの入力を学習が終わるまで横取りし（シーン切替は行わない）、
+// 停止中は設定のデバイスを一時的に開きます。戻り値は追加したマッピング行（例: "1:36=Scene"）です。
+func (a *App) MidiLearn(scene string) (string, error) {
+	scene = strings.TrimSpace(scene)
+	if scene == "" {
+		return "", errors.New("割り当てるシーンを選択してください")
+	}
+
+	a.midiMu.Lock()
+	mc := a.cfg.MIDI
+	if a.learnCancel != nil {
+		a.midiMu.Unlock()
+		return "", errors.New("MIDI Learn は実行中です")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	a.learnCancel = cancel
+	running := a.midiDrv != nil
+	var events <-chan midi.Event
+	if running {
+		a.learnCh = make(chan midi.Event, 16)
+		events = a.learnCh
+	}
+	a.midiMu.Unlock()
+
+	defer func() {
+		a.midiMu.Lock()
+		a.learnCh = nil
+		a.learnCancel = nil
+		a.midiMu.Unlock()
+		cancel()
+	}()
+
+	if !running {
+		if strings.TrimSpace(mc.Device) == "" {
+			return "", errors.New("MIDIデバイスを選択してください")
+		}
+		drv, ev, err := midi.OpenInput(mc.Device)
+		if err != nil {
+			return "", err
+		}
+		defer drv.Close()
+		events = ev
+	}
+
+	_ = a.emitLog("info", fmt.Sprintf("MIDI Learn: 「%s」に割り当てるボタン/パッドを押してください", scene))
+	m, err := midimap.Learn(ctx, events, time.Now(), parseChannels(mc.Channel))
+	a.midiMu.Lock()
+	a.learnCh = nil
+	a.midiMu.Unlock()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "", errors.New("MIDI Learn がタイムアウトしました")
+	case errors.Is(err, context.Canceled):
+		return "", errors.New("MIDI Learn をキャンセルしました")
+	case err != nil:
+		return "", err
+	}
+	m.Scene = scene
+	a.midiMu.Lock()
+	a.cfg.MIDI.Mappings = midimap.BindLines(a.cfg.MIDI.Mappings, m)
+	if a.midiEvals != nil {
+		// 実行中のセッションに新しいマッピングを反映する
+		rules, _, _ := midiRulesByDevice(a.cfg.MIDI)
+		a.swapMidiRulesLocked(rules)
+	}
+	a.midiMu.Unlock()
+	if err := a.saveConfig(); err != nil {
+		return "", err
+	}
+	_ = a.emitLog("info", "MIDI Learn: "+m.Line())
+	return m.Line(), nil
+}
+
+// MidiLearnCancel は実行中の MidiLearn を中断します。
+func (a *App) MidiLearnCancel() error {
+	a.midiMu.Lock()
+	defer a.midiMu.Unlock()
+	if a.learnCancel != nil {
+		a.learnCancel()
+	}
+	return nil
+}
+
+// learnTap は MIDI Learn 中なら ev を学習側へ渡して true を返します。学習の対象は 1 台目のデバイスです。
+func (a *App) learnTap(ev midi.Event) bool {
+	a.midiMu.Lock()
+	defer a.midiMu.Unlock()
+	if a.learnCh == nil || ev.Device != a.cfg.MIDI.Device {
+		return false
+	}
+	select {
+	case a.learnCh <- ev:
+	default:
+	}
+	return true
+}
+
 // --- Bluetooth Sync API ---
 
 func (a *App) BtGetConfig() (config.BluetoothSyncConfig, error) {
-	return a.cfg.Bluetooth, nil
+	return a.configSnapshot().Bluetooth, nil
 }
 
 func (a *App) BtSaveConfig(bc config.BluetoothSyncConfig) error {
+	a.midiMu.Lock()
+	// 信頼済み peer は同期マネージャが管理する（画面が持っている古い一覧で上書きしない）
+	bc.TrustedPeers = a.cfg.Bluetooth.TrustedPeers
 	a.cfg.Bluetooth = bc
-	if err := config.Save(a.cfg); err != nil {
+	a.midiMu.Unlock()
+	if err := a.saveConfig(); err != nil {
 		return err
 	}
-	a.bt.SetConfig(btConfigFromGUI(a.cfg.Bluetooth))
+	a.bt.SetConfig(btConfigFromGUI(bc))
 	return a.emitLog("info", "Bluetooth同期設定を保存しました")
 }
 
+// btEnabled は Bluetooth 同期が設定で有効かを返します。
+func (a *App) btEnabled() bool {
+	a.midiMu.Lock()
+	defer a.midiMu.Unlock()
+	return a.cfg.Bluetooth.Enabled
+}
+
 func (a *App) BtStart() error {
-	a.bt.SetConfig(btConfigFromGUI(a.cfg.Bluetooth))
+	a.bt.SetConfig(btConfigFromGUI(a.configSnapshot().Bluetooth))
 	return a.bt.Start()
 }
 
@@ -605,6 +1256,29 @@ func (a *App) BtJoinByCode(code string) error {
 	return a.bt.JoinByCode(code)
 }
 
+// BtRevokePeer はペアリングを解除します（接続中なら相手にも通知）。
+func (a *App) BtRevokePeer(peerID string) error {
+	return a.bt.RevokePeer(peerID)
+}
+
+// BtRenamePeer は peer の表示名を変えます（空なら元の名前に戻す）。
+func (a *App) BtRenamePeer(peerID, alias string) error {
+	return a.bt.RenamePeer(peerID, alias)
+}
+
+// BtRotatePeerSecret は接続中の peer と共有鍵を更新します。
+func (a *App) BtRotatePeerSecret(peerID string) error {
+	return a.bt.RotatePeerSecret(peerID)
+}
+
+func (a *App) BtBlockPeer(peerID string) error {
+	return a.bt.BlockPeer(peerID)
+}
+
+func (a *App) BtUnblockPeer(peerID string) error {
+	return a.bt.UnblockPeer(peerID)
+}
+
 // --- Helpers ---
 
 func btConfigFromGUI(c config.BluetoothSyncConfig) btsync.Config {
@@ -614,11 +1288,15 @@ func btConfigFromGUI(c config.BluetoothSyncConfig) btsync.Config {
 		Role:              role,
 		DeviceName:        strings.TrimSpace(c.DeviceName),
 		LeadTimeMs:        c.LeadTimeMs,
+		AutoLeadTime:      c.AutoLeadTime,
 		PairingCodeTTLSec: c.PairingCodeTTLSec,
 		AcceptLateMs:      c.AcceptLateMs,
 		MaxNodes:          c.MaxNodes,
 		AutoReconnect:     c.AutoReconnect,
 		DropMissedEvents:  c.DropMissedEvents,
+		Transport:         btsync.TransportKind(strings.ToLower(strings.TrimSpace(c.Transport))),
+		LANPort:           c.LANPort,
+		LANParentAddr:     strings.TrimSpace(c.LANParentAddr),
 		TrustedPeers:      toBtTrustedPeers(c.TrustedPeers),
 	}
 }
@@ -632,6 +1310,8 @@ func toBtTrustedPeers(peers []config.TrustedPeer) []btsync.TrustedPeer {
 			Secret:   p.Secret,
 			LastSeen: p.LastSeen,
 			Platform: p.Platform,
+			Alias:    p.Alias,
+			Blocked:  p.Blocked,
 		})
 	}
 	return out
@@ -646,6 +1326,8 @@ func toConfigTrustedPeers(peers []btsync.TrustedPeer) []config.TrustedPeer {
 			Secret:   p.Secret,
 			LastSeen: p.LastSeen,
 			Platform: p.Platform,
+			Alias:    p.Alias,
+			Blocked:  p.Blocked,
 		})
 	}
 	return out
@@ -677,49 +1359,99 @@ func parseChannels(s string) []int {
 	return out
 }
 
-func parseNoteMaps(values []string) map[string]string {
-	out := map[string]string{}
-	for _, v := range values {
-		v = strings.TrimSpace(v)
-		if v == "" {
+// midiRulesFromConfig は GUI の MIDI 設定から照合ルールを作ります。
+// 不正な項目は読み捨てたうえで、その内容をエラーとして返します（ルールは常に非 nil）。
+func midiRulesFromConfig(mc config.MidiConfig) (*midimap.Rules, error) {
+	var errs []error
+	for _, p := range strings.Split(mc.Channel, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
 			continue
 		}
-		parts := strings.SplitN(v, "=", 2)
-		if len(parts) != 2 {
-			continue
+		if v, err := strconv.Atoi(p); err != nil || v < 1 || v > 16 {
+			errs = append(errs, fmt.Errorf("チャネルが不正です: %q", p))
 		}
-		left := strings.TrimSpace(parts[0])
-		right := strings.TrimSpace(parts[1])
-		if right == "" {
-			continue
+	}
+	if d := strings.TrimSpace(mc.Debounce); d != "" {
+		if _, err := time.ParseDuration(d); err != nil {
+			errs = append(errs, fmt.Errorf("デバウンスが不正です: %q", mc.Debounce))
 		}
-		ln := strings.Split(left, ":")
-		if len(ln) != 2 {
-			continue
+	}
+	if r := strings.TrimSpace(mc.RateLimit); r != "" {
+		if _, err := time.ParseDuration(r); err != nil {
+			errs = append(errs, fmt.Errorf("レート制限が不正です: %q", mc.RateLimit))
 		}
-		ch := strings.TrimSpace(ln[0])
-		note := strings.TrimSpace(ln[1])
-		var chv, nv int
-		if _, err := fmt.Sscanf(ch, "%d", &chv); err != nil || chv < 1 || chv > 16 {
+	}
+	for i, ln := range mc.Mappings {
+		if strings.TrimSpace(ln) == "" {
 			continue
 		}
-		if _, err := fmt.Sscanf(note, "%d", &nv); err != nil || nv < 0 || nv > 127 {
-			continue
+		if _, err := midimap.ParseLine(ln); err != nil {
+			errs = append(errs, fmt.Errorf("マッピング%d行目: %w", i+1, err))
 		}
-		key := fmt.Sprintf("%d:%d", chv, nv)
-		out[key] = right
 	}
-	return out
+	rules := midimap.NewRules(
+		parseChannels(mc.Channel),
+		mustParseDurationDefault(mc.Debounce, 30*time.Millisecond),
+		mustParseDurationDefault(mc.RateLimit, 50*time.Millisecond),
+		mc.Mappings,
+	)
+	if len(mc.Banks) > 0 {
+		// バンクは JSON 設定と同じ規則（切替トリガの重複・マッピングとの衝突）で検証する
+		// 行形式の重複は後勝ち（NewRules と同じ）なので、ここでもキーごとに 1 つにまとめる
+		f := &midimap.File{}
+		seen := map[string]int{}
+		for _, ln := range mc.Mappings {
+			m, err := midimap.ParseLine(ln)
+			if err != nil {
+				continue
+			}
+			key, _ := m.Key()
+			if i, dup := seen[key]; dup {
+				f.Mappings[i] = m
+				continue
+			}
+			seen[key] = len(f.Mappings)
+			f.Mappings = append(f.Mappings, m)
+		}
+		for i, b := range mc.Banks {
+			bank, err := midimap.ParseBank(b.Name, b.Select, b.Shift, b.Mappings)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("バンク%d: %w", i+1, err))
+			}
+			f.Banks = append(f.Banks, bank)
+		}
+		if err := f.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		f.ApplyBanks(rules)
+	}
+	return rules, errors.Join(errs...)
 }
 
-func containsChannel(list []int, v int) bool {
-	if len(list) == 0 {
-		return true
-	}
-	for _, x := range list {
-		if x == v {
-			return true
+// midiRulesByDevice は 1 台目と inputs のデバイスごとの照合ルールと、開くデバイスの一覧（1 台目が先頭）を返します。
+// デバウンス・レート制限は全デバイス共通です。不正な項目は midiRulesFromConfig と同じく読み捨ててエラーで返します。
+func midiRulesByDevice(mc config.MidiConfig) (map[string]*midimap.Rules, []string, error) {
+	primary, err := midiRulesFromConfig(mc)
+	errs := []error{err}
+	rules := map[string]*midimap.Rules{mc.Device: primary}
+	devices := []string{mc.Device}
+	for i, in := range mc.Inputs {
+		dev := strings.TrimSpace(in.Device)
+		if dev == "" {
+			errs = append(errs, fmt.Errorf("追加入力%d: デバイスが未選択です", i+1))
+			continue
+		}
+		if _, dup := rules[dev]; dup {
+			errs = append(errs, fmt.Errorf("追加入力%d: デバイス %q が重複しています", i+1, dev))
+			continue
+		}
+		r, err := midiRulesFromConfig(config.MidiConfig{Device: dev, Channel: in.Channel, Debounce: mc.Debounce, RateLimit: mc.RateLimit, Mappings: in.Mappings})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("追加入力%d (%s): %w", i+1, dev, err))
 		}
+		rules[dev] = r
+		devices = append(devices, dev)
 	}
-	return false
+	return rules, devices, errors.Join(errs...)
 }
@@ -0,0 +1,274 @@
+package btsync
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// メッセージ認証（プロトコル v2）: ペアリング後のメッセージは全て、peer ごとの共有鍵から向き別に導出した鍵で
+// メッセージ全体に HMAC を付けます。送信側は起動ごとのセッション ID と peer ごとに 1 から増える seq を付け、
+// 受信側は seq の窓（直近 replayWindow 件）で再送・リプレイを、送信時刻で古いフレームを拒否します。
+// 送信時刻は時計差の推定ができてから比べます（それまでと、時計合わせ・ペアリング完了のフレームはセッションと seq だけで守る）。
+//
+// セッション ID の先頭は開始時刻なので、受信側は今のセッションより古いセッションのフレームを拒否します
+// （盗聴したフレームをリプレイして、今のセッションを終わらせることはできない）。新しいセッションを始めるフレームは
+// 種類によらず送信時刻を確かめます。時計差がまだ分からなければ補正せずに maxSessionSkew まで許します
+// （受信側が再起動した直後に、古いセッションのフレームをリプレイされても受け付けない）。
+
+const (
+	replayWindow   = 64
+	maxMessageAge  = 60 * time.Second // 送信時刻（時計差補正後）がこれ以上ずれていたら古いフレームとして拒否
+	maxSessionSkew = 5 * time.Minute  // 時計差が分からないとき、新しいセッションを始めるフレームに許す送信時刻のずれ
+)
+
+// errProtocolVersion はプロトコルのバージョンが違う peer からのメッセージです。
+var errProtocolVersion = errors.New("unsupported_protocol")
+
+// authState は peer ごとの送受信の状態です。
+type authState struct {
+	sendSeq uint64
+
+	recvSession string
+	recvMax     uint64
+	recvWindow  uint64 // bit i が立っていれば recvMax-i を受信済み
+
+	warnedVersion bool
+}
+
+// needsAuth はペアリングの途中（鍵がまだ無い段階）のメッセージ以外を認証対象にします。
+func needsAuth(msg Message) bool {
+	switch msg.Type {
+	case MsgPairRequest, MsgPairConfirm:
+		return false
+	case MsgPairAccept:
+		return msg.Status == "paired"
+	default:
+		return true
+	}
+}
+
+// macKey は共有鍵から送信側の役割ごとの鍵を導出します（反射攻撃対策に向きで鍵を分ける）。
+func macKey(secret string, sender Role) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("obsctl-sync mac v2|" + string(sender)))
+	return mac.Sum(nil)
+}
+
+// messageMAC は HMAC 欄を空にしたメッセージ全体の JSON に対する HMAC です。
+func messageMAC(key []byte, m Message) string {
+	m.HMAC = ""
+	b, _ := m.Marshal()
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func opposite(role Role) Role {
+	if role == RoleParent {
+		return RoleChild
+	}
+	return RoleParent
+}
+
+// sendSealed はセッション ID・seq・HMAC を付けて peer へ送ります（ペアリング済みの peer のみ）。
+func (m *Manager) sendSealed(peerID string, msg Message) error {
+	m.mu.Lock()
+	p, ok := m.peers[peerID]
+	if !ok || strings.TrimSpace(p.Secret) == "" {
+		m.mu.Unlock()
+		return fmt.Errorf("peer %s はペアリングされていません", peerID)
+	}
+	p.auth.sendSeq++
+	msg.ProtocolVersion = ProtocolVersion
+	msg.Session = m.session
+	msg.Seq = p.auth.sendSeq
+	if msg.SentAtUnixMs == 0 {
+		msg.SentAtUnixMs = m.now().UnixMilli()
+	}
+	msg.HMAC = messageMAC(macKey(p.Secret, m.role), msg)
+	m.mu.Unlock()
+
+	payload, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	return m.transport.Send(peerID, payload)
+}
+
+// authenticate は受信メッセージの HMAC・鮮度・seq を検証します。通ったメッセージだけ処理します。
+func (m *Manager) authenticate(from PeerRef, msg Message) error {
+	if msg.ProtocolVersion != ProtocolVersion {
+		m.mu.Lock()
+		p := m.ensurePeerLocked(from)
+		warned := p.auth.warnedVersion
+		p.auth.warnedVersion = true
+		m.mu.Unlock()
+		if !warned {
+			m.log("error", fmt.Sprintf("プロトコル v%d の peer です [%s]。v%d に更新するまでメッセージを破棄します", msg.ProtocolVersion, from.Name, ProtocolVersion))
+		}
+		return fmt.Errorf("%w: v%d", errProtocolVersion, msg.ProtocolVersion)
+	}
+
+	m.mu.Lock()
+	promoted, err := m.authenticateLocked(from, msg)
+	var peers []TrustedPeer
+	if promoted {
+		peers = m.trustedPeersLocked()
+	}
+	m.mu.Unlock()
+
+	if promoted {
+		m.persistTrustedPeers(peers)
+		m.log("info", fmt.Sprintf("鍵を更新しました: %s (%s)", from.Name, from.PeerID))
+	}
+	return err
+}
+
+// authenticateLocked は authenticate の本体です。鍵の更新中は新旧の鍵も試し、相手が新しい鍵に切り替えたのを
+// 確認したら保留中の鍵を正式な鍵にして promoted=true を返します（peers.go）。
+func (m *Manager) authenticateLocked(from PeerRef, msg Message) (promoted bool, err error) {
+	p := m.ensurePeerLocked(from)
+	secrets := []string{p.Secret, p.nextSecret, p.prevSecret}
+	if msg.Type == MsgPairAccept {
+		// paired は子機がまだ保存していない、交換したばかりの鍵で検証する
+		secrets = []string{m.joinKeys[from.PeerID].Secret}
+	}
+	paired := false
+	matched := -1
+	for i, secret := range secrets {
+		if strings.TrimSpace(secret) == "" {
+			continue
+		}
+		paired = true
+		if msg.HMAC != "" && hmac.Equal([]byte(strings.ToLower(msg.HMAC)), []byte(messageMAC(macKey(secret, opposite(m.role)), msg))) {
+			matched = i
+			break
+		}
+	}
+	if !paired {
+		return false, errors.New("ペアリングされていない peer です")
+	}
+	if matched < 0 {
+		return false, errors.New("invalid_hmac")
+	}
+	if msg.Session == "" || msg.Seq == 0 {
+		return false, errors.New("セッション / seq がありません")
+	}
+	opens := msg.Session != p.auth.recvSession
+	if p.clock.ok && (opens || checksAge(msg)) {
+		sent := time.UnixMilli(msg.SentAtUnixMs).Add(-p.clock.best.offset)
+		if d := m.now().Sub(sent); d > maxMessageAge || d < -maxMessageAge {
+			return false, fmt.Errorf("古いメッセージです (%s)", d.Round(time.Second))
+		}
+	} else if opens {
+		if d := m.now().Sub(time.UnixMilli(msg.SentAtUnixMs)); d > maxSessionSkew || d < -maxSessionSkew {
+			return false, fmt.Errorf("新しいセッションのメッセージが古すぎます (%s)", d.Round(time.Second))
+		}
+	}
+	if err := p.auth.accept(msg.Session, msg.Seq); err != nil {
+		return false, err
+	}
+	if msg.Type == MsgPairAccept {
+		return false, nil
+	}
+	switch matched {
+	case 0:
+		// 相手も新しい鍵を使い始めたので古い鍵はもう受け付けない
+		p.prevSecret = ""
+	case 1:
+		p.Secret = p.nextSecret
+		p.nextSecret = ""
+		p.prevSecret = ""
+		return true, nil
+	}
+	return false, nil
+}
+
+// checksAge は送信時刻で古さを確かめるメッセージかを返します。時計合わせとペアリング完了は、
+// 時計が大きくずれた端末どうしでも通す（時計差はこれで測る）。
+func checksAge(msg Message) bool {
+	switch msg.Type {
+	case MsgPairAccept, MsgHeartbeat, MsgHeartbeatReply, MsgPing, MsgPong:
+		return false
+	default:
+		return true
+	}
+}
+
+// newSessionID は開始時刻（UnixMicro の 16 桁の hex）と乱数からセッション ID を作ります。
+func newSessionID(now time.Time) string {
+	return fmt.Sprintf("%016x-%s", now.UnixMicro(), randomHex(8))
+}
+
+// sessionStart はセッション ID の開始時刻を返します。
+func sessionStart(session string) (int64, bool) {
+	head, _, ok := strings.Cut(session, "-")
+	if !ok || len(head) != 16 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(head, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+// accept はセッションと seq を記録し、リプレイや今のセッションより古いセッションのフレームを拒否します。
+func (a *authState) accept(session string, seq uint64) error {
+	if session != a.recvSession {
+		start, ok := sessionStart(session)
+		if !ok {
+			return errors.New("セッション ID が不正です")
+		}
+		if cur, _ := sessionStart(a.recvSession); a.recvSession != "" && start <= cur {
+			return errors.New("終了したセッションのメッセージです")
+		}
+		a.recvSession = session
+		a.recvMax = seq
+		a.recvWindow = 1
+		return nil
+	}
+	switch {
+	case seq > a.recvMax:
+		shift := seq - a.recvMax
+		if shift >= replayWindow {
+			a.recvWindow = 0
+		} else {
+			a.recvWindow <<= shift
+		}
+		a.recvWindow |= 1
+		a.recvMax = seq
+	case a.recvMax-seq >= replayWindow:
+		return errors.New("古すぎる seq です")
+	default:
+		bit := uint64(1) << (a.recvMax - seq)
+		if a.recvWindow&bit != 0 {
+			return errors.New("受信済みの seq です（リプレイ）")
+		}
+		a.recvWindow |= bit
+	}
+	return nil
+}
+
+// sendLegacyAck は旧プロトコルの scene_command に、そのバージョンの形式でエラー ACK を返します。
+func (m *Manager) sendLegacyAck(peerID string, cmd Message) {
+	ack := Message{
+		Type:            MsgSceneAck,
+		ProtocolVersion: cmd.ProtocolVersion,
+		EventID:         cmd.EventID,
+		Status:          string(AckError),
+		Error:           errProtocolVersion.Error(),
+		SentAtUnixMs:    m.now().UnixMilli(),
+	}
+	payload, err := ack.Marshal()
+	if err != nil {
+		return
+	}
+	_ = m.transport.Send(peerID, payload)
+}
@@ -0,0 +1,153 @@
+package btsync
+
+import (
+	"fmt"
+	"time"
+
+	"awesomeProject/internal/obsws"
+)
+
+// 時計合わせ: 子機はハートビートに送信時刻 T1 を載せ、親機は受信時刻 T2・返信時刻 T3 を付けて heartbeat_reply を返します。
+// 子機は受信時刻 T4 から NTP と同じ式で offset（親機の時計 - 子機の時計）と RTT を求め、
+// 直近の標本のうち RTT が最小のものを採用します（往復の経路が対称に近いほど誤差が小さいため）。
+
+const clockSamples = 8
+
+type clockSample struct {
+	offset time.Duration
+	rtt    time.Duration
+}
+
+// clockFilter は peer ごとの offset 推定です。offset は「peer の時計 - 自分の時計」。
+type clockFilter struct {
+	samples []clockSample
+	best    clockSample
+	ok      bool
+}
+
+func (f *clockFilter) add(s clockSample) {
+	if s.rtt < 0 {
+		s.rtt = 0
+	}
+	f.samples = append(f.samples, s)
+	if len(f.samples) > clockSamples {
+		f.samples = f.samples[len(f.samples)-clockSamples:]
+	}
+	f.best = f.samples[0]
+	for _, c := range f.samples[1:] {
+		if c.rtt < f.best.rtt {
+			f.best = c
+		}
+	}
+	f.ok = true
+}
+
+// set は相手が報告した推定値をそのまま使うときに使います（親機が子機の報告を表示する場合）。
+func (f *clockFilter) set(offset, uncertainty time.Duration) {
+	f.samples = nil
+	f.best = clockSample{offset: offset, rtt: 2 * uncertainty}
+	f.ok = true
+}
+
+// uncertainty は offset の誤差の上限（RTT の半分）です。
+func (f *clockFilter) uncertainty() time.Duration {
+	return f.best.rtt / 2
+}
+
+// clockOffset は NTP の式で offset と RTT を求めます。
+func clockOffset(t1, t2, t3, t4 int64) clockSample {
+	return clockSample{
+		offset: time.Duration(((t2-t1)+(t3-t4))/2) * time.Microsecond,
+		rtt:    time.Duration((t4-t1)-(t3-t2)) * time.Microsecond,
+	}
+}
+
+func (m *Manager) now() time.Time {
+	if m.opts.Clock != nil {
+		return m.opts.Clock()
+	}
+	return time.Now()
+}
+
+// waitUntil は自分の時計（m.now）で t になるまで待ちます。
+func (m *Manager) waitUntil(t time.Time) {
+	obsws.WaitUntil(time.Now().Add(t.Sub(m.now())), 2*time.Millisecond)
+}
+
+// localTime は peer の時計の時刻 t を自分の時計に直します（推定が無ければそのまま）。
+func (m *Manager) localTime(peerID string, t time.Time) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.peers[peerID]; ok && p.clock.ok {
+		return t.Add(-p.clock.best.offset)
+	}
+	return t
+}
+
+// sendHeartbeat は子機から親機へ時計合わせ付きのハートビートを送ります（現在の推定値も載せて親機に表示させる）。
+func (m *Manager) sendHeartbeat(peerID string) error {
+	hb := Message{Type: MsgHeartbeat}
+	m.mu.Lock()
+	if p, ok := m.peers[peerID]; ok && p.clock.ok {
+		hb.ClockOffsetUs = p.clock.best.offset.Microseconds()
+		hb.ClockUncertaintyUs = p.clock.uncertainty().Microseconds()
+	}
+	m.mu.Unlock()
+	now := m.now()
+	hb.SentAtUnixMs = now.UnixMilli()
+	hb.ClockT1Us = now.UnixMicro()
+	return m.sendSealed(peerID, hb)
+}
+
+// handleHeartbeat は親機側: T2/T3 を付けて返信し、子機が報告した推定値を記録します。
+func (m *Manager) handleHeartbeat(from PeerRef, msg Message, receivedAt time.Time) {
+	if msg.ClockT1Us == 0 {
+		return
+	}
+	if msg.ClockUncertaintyUs > 0 || msg.ClockOffsetUs != 0 {
+		m.mu.Lock()
+		// 子機の推定は「親機 - 子機」なので、親機から見た offset は符号を反転する
+		m.ensurePeerLocked(from).clock.set(-time.Duration(msg.ClockOffsetUs)*time.Microsecond, time.Duration(msg.ClockUncertaintyUs)*time.Microsecond)
+		m.mu.Unlock()
+	}
+	reply := Message{
+		Type:      MsgHeartbeatReply,
+		ClockT1Us: msg.ClockT1Us,
+		ClockT2Us: receivedAt.UnixMicro(),
+	}
+	now := m.now()
+	reply.SentAtUnixMs = now.UnixMilli()
+	reply.ClockT3Us = now.UnixMicro()
+	_ = m.sendSealed(from.PeerID, reply)
+}
+
+// handleHeartbeatReply は子機側: 標本を追加して offset を更新します。
+func (m *Manager) handleHeartbeatReply(from PeerRef, msg Message, receivedAt time.Time) {
+	if msg.ClockT1Us == 0 || msg.ClockT2Us == 0 || msg.ClockT3Us == 0 {
+		return
+	}
+	s := clockOffset(msg.ClockT1Us, msg.ClockT2Us, msg.ClockT3Us, receivedAt.UnixMicro())
+	m.mu.Lock()
+	p := m.ensurePeerLocked(from)
+	first := !p.clock.ok
+	p.clock.add(s)
+	best := p.clock.best
+	m.mu.Unlock()
+	if first {
+		m.log("info", fmt.Sprintf("時計差を推定しました [%s]: %+.1fms (±%.1fms)", from.Name, ms(best.offset), ms(best.rtt/2)))
+	}
+}
+
+// probeClock は接続直後に数回ハートビートを送り、最初のシーン切替までに推定を用意します。
+func (m *Manager) probeClock(peerID string) {
+	for i := 0; i < 3; i++ {
+		if err := m.sendHeartbeat(peerID); err != nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
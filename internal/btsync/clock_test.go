@@ -0,0 +1,95 @@
+package btsync
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClockOffsetAndFilter(t *testing.T) {
+	// 子機 T1=1000, 親機 T2=1600 / T3=1700, 子機 T4=1300（µs）: 親機が 500µs 進んでいて、RTT は 200µs
+	s := clockOffset(1000, 1600, 1700, 1300)
+	if s.offset != 500*time.Microsecond || s.rtt != 200*time.Microsecond {
+		t.Fatalf("sample: %+v", s)
+	}
+
+	var f clockFilter
+	f.add(clockSample{offset: 10 * time.Millisecond, rtt: 40 * time.Millisecond})
+	f.add(clockSample{offset: 3 * time.Millisecond, rtt: 4 * time.Millisecond})
+	f.add(clockSample{offset: -8 * time.Millisecond, rtt: 30 * time.Millisecond})
+	if f.best.offset != 3*time.Millisecond || f.uncertainty() != 2*time.Millisecond {
+		t.Fatalf("filter should pick the minimum-RTT sample: %+v", f.best)
+	}
+	for i := 0; i < clockSamples; i++ {
+		f.add(clockSample{offset: time.Millisecond, rtt: 10 * time.Millisecond})
+	}
+	if len(f.samples) != clockSamples || f.best.offset != time.Millisecond {
+		t.Fatalf("old samples should age out: %+v", f)
+	}
+}
+
+func TestChildCorrectsFireTimeForClockSkew(t *testing.T) {
+	// 子機の時計が 200ms 進んでいる場合と、maxMessageAge を大きく超えて遅れている場合
+	for _, skew := range []time.Duration{200 * time.Millisecond, -3 * maxMessageAge} {
+		t.Run(skew.String(), func(t *testing.T) { testClockSkew(t, skew) })
+	}
+}
+
+func testClockSkew(t *testing.T, skew time.Duration) {
+	nw := NewLoopbackNetwork(LoopbackOptions{Latency: 3 * time.Millisecond, Seed: 1})
+
+	var mu sync.Mutex
+	applied := map[string]time.Time{}
+	start := func(id string, role Role, clock func() time.Time) *Manager {
+		return startLoopbackManager(t, nw, id, role, ManagerOptions{
+			ApplyScene: func(string, Source) error {
+				mu.Lock()
+				applied[id] = time.Now()
+				mu.Unlock()
+				return nil
+			},
+			Clock: clock,
+		}, Config{LeadTimeMs: 100, AcceptLateMs: 50})
+	}
+	parent := start("parent", RoleParent, nil)
+	child := start("child", RoleChild, func() time.Time { return time.Now().Add(skew) })
+
+	code, _ := parent.GeneratePairingCode()
+	if err := child.JoinByCode(code); err != nil {
+		t.Fatal(err)
+	}
+	var peer PeerStatus
+	waitFor(t, "clock sync", func() bool {
+		st := child.Status()
+		if len(st.Peers) != 1 || !st.Peers[0].ClockSynced {
+			return false
+		}
+		peer = st.Peers[0]
+		child.mu.Lock()
+		defer child.mu.Unlock()
+		return child.peers["parent"].Secret != ""
+	})
+	if math.Abs(peer.ClockOffsetMs+ms(skew)) > peer.ClockUncertaintyMs+2 {
+		t.Fatalf("offset: want about %v, got %.2fms ±%.2fms", -skew, peer.ClockOffsetMs, peer.ClockUncertaintyMs)
+	}
+
+	if _, err := parent.DispatchScene("SceneA", SourceGUI); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "applied", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return !applied["parent"].IsZero() && !applied["child"].IsZero()
+	})
+	mu.Lock()
+	diff := applied["child"].Sub(applied["parent"])
+	mu.Unlock()
+	if diff < -20*time.Millisecond || diff > 20*time.Millisecond {
+		t.Fatalf("child fired %v away from parent despite clock correction", diff)
+	}
+	waitFor(t, "parent sees child offset", func() bool {
+		st := parent.Status()
+		return len(st.Peers) == 1 && st.Peers[0].ClockSynced && math.Abs(st.Peers[0].ClockOffsetMs-ms(skew)) < 10
+	})
+}
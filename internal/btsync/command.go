@@ -0,0 +1,233 @@
+package btsync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CommandKind はシーン切替以外に同期できる操作の種類です。
+type CommandKind string
+
+const (
+	CmdPreview          CommandKind = "preview"           // スタジオモードのプレビューを Scene にする
+	CmdTake             CommandKind = "take"              // プレビューをプログラムへ（Transition が空なら現在のトランジション）
+	CmdMedia            CommandKind = "media"             // メディア入力 Input に Action（play / pause / stop / restart / resume）
+	CmdRecord           CommandKind = "record"            // 録画を Action（start / stop / toggle）
+	CmdSourceVisibility CommandKind = "source_visibility" // Scene 内のソース Source の表示を Visible にする
+	CmdHotkey           CommandKind = "hotkey"            // OBS のホットキー名 Hotkey を実行する
+)
+
+// Command は command メッセージで送る操作とそのパラメータです。scene_command と同じく発火時刻に全台で実行し、
+// 子機は command_ack で結果を返します。
+type Command struct {
+	Kind       CommandKind `json:"kind"`
+	Scene      string      `json:"scene,omitempty"`
+	Transition string      `json:"transition,omitempty"`
+	Input      string      `json:"input,omitempty"`
+	Action     string      `json:"action,omitempty"`
+	Source     string      `json:"source,omitempty"`
+	Visible    bool        `json:"visible,omitempty"`
+	Hotkey     string      `json:"hotkey,omitempty"`
+}
+
+// Normalize は前後の空白を除き、種類と Action を小文字にそろえます。
+func (c Command) Normalize() Command {
+	c.Kind = CommandKind(strings.ToLower(strings.TrimSpace(string(c.Kind))))
+	c.Scene = strings.TrimSpace(c.Scene)
+	c.Transition = strings.TrimSpace(c.Transition)
+	c.Input = strings.TrimSpace(c.Input)
+	c.Action = strings.ToLower(strings.TrimSpace(c.Action))
+	c.Source = strings.TrimSpace(c.Source)
+	c.Hotkey = strings.TrimSpace(c.Hotkey)
+	return c
+}
+
+// Validate は種類ごとに必要なパラメータがそろっているかを確かめます。
+func (c Command) Validate() error {
+	switch c.Kind {
+	case CmdPreview:
+		if c.Scene == "" {
+			return errors.New("preview: scene が空です")
+		}
+	case CmdTake:
+	case CmdMedia:
+		if c.Input == "" {
+			return errors.New("media: input が空です")
+		}
+		switch c.Action {
+		case "play", "pause", "stop", "restart", "resume":
+		default:
+			return fmt.Errorf("media: 未対応の action です: %q（play / pause / stop / restart / resume）", c.Action)
+		}
+	case CmdRecord:
+		switch c.Action {
+		case "start", "stop", "toggle":
+		default:
+			return fmt.Errorf("record: 未対応の action です: %q（start / stop / toggle）", c.Action)
+		}
+	case CmdSourceVisibility:
+		if c.Scene == "" || c.Source == "" {
+			return errors.New("source_visibility: scene と source が必要です")
+		}
+	case CmdHotkey:
+		if c.Hotkey == "" {
+			return errors.New("hotkey: hotkey が空です")
+		}
+	default:
+		return fmt.Errorf("未対応のコマンドです: %q", c.Kind)
+	}
+	return nil
+}
+
+// String はログ用の短い表記です。
+func (c Command) String() string {
+	switch c.Kind {
+	case CmdPreview:
+		return fmt.Sprintf("preview %s", c.Scene)
+	case CmdTake:
+		if c.Transition != "" {
+			return fmt.Sprintf("take (%s)", c.Transition)
+		}
+		return "take"
+	case CmdMedia:
+		return fmt.Sprintf("media %s %s", c.Input, c.Action)
+	case CmdRecord:
+		return fmt.Sprintf("record %s", c.Action)
+	case CmdSourceVisibility:
+		state := "hide"
+		if c.Visible {
+			state = "show"
+		}
+		return fmt.Sprintf("source_visibility %s/%s %s", c.Scene, c.Source, state)
+	case CmdHotkey:
+		return fmt.Sprintf("hotkey %s", c.Hotkey)
+	}
+	return string(c.Kind)
+}
+
+// DispatchCommand は親機から全台へコマンドを送り、発火時刻に親機でも実行します（配送は DispatchScene と同じ）。
+func (m *Manager) DispatchCommand(cmd Command, source Source) (*Delivery, error) {
+	cmd = cmd.Normalize()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	cfg := m.cfg.Normalize()
+	if !m.running || cfg.Role != RoleParent {
+		m.mu.Unlock()
+		return nil, errors.New("親機モードで起動中ではありません")
+	}
+	now := m.now()
+	lead, _ := m.leadTimeLocked()
+	fireAt := now.Add(lead)
+	m.mu.Unlock()
+
+	d := m.sendEvent(Message{
+		Type:         MsgCommand,
+		EventID:      randomHex(16),
+		Command:      &cmd,
+		Source:       string(source),
+		FireAtUnixMs: fireAt.UnixMilli(),
+		SentAtUnixMs: now.UnixMilli(),
+	}, fireAt.Add(time.Duration(cfg.AcceptLateMs)*time.Millisecond))
+
+	go func() {
+		m.waitUntil(fireAt)
+		if _, err := m.runCommand(cmd); err != nil {
+			m.log("error", fmt.Sprintf("親機ローカル実行失敗 [%s]: %v", cmd, err))
+			return
+		}
+		m.log("info", fmt.Sprintf("親機ローカル実行: %s", cmd))
+	}()
+	return d, nil
+}
+
+func (m *Manager) handleCommand(from PeerRef, msg Message) {
+	if msg.Command == nil {
+		_ = m.sendCommandAck(from.PeerID, msg.EventID, "", AckError, "command がありません", 0)
+		return
+	}
+	cmd := msg.Command.Normalize()
+	first := m.firstSeen(msg.EventID)
+	_ = m.sendCommandAck(from.PeerID, msg.EventID, cmd.Kind, AckReceived, "", 0)
+	if !first {
+		return
+	}
+	if err := cmd.Validate(); err != nil {
+		_ = m.sendCommandAck(from.PeerID, msg.EventID, cmd.Kind, AckUnsupported, err.Error(), 0)
+		return
+	}
+
+	fireAt, inTime := m.fireTime(from, msg)
+	if !inTime {
+		_ = m.sendCommandAck(from.PeerID, msg.EventID, cmd.Kind, AckLateDrop, "late_drop", 0)
+		return
+	}
+
+	m.waitUntil(fireAt)
+	if status, err := m.runCommand(cmd); err != nil {
+		_ = m.sendCommandAck(from.PeerID, msg.EventID, cmd.Kind, status, err.Error(), 0)
+		return
+	}
+
+	latency := m.now().Sub(fireAt).Milliseconds()
+	if latency < 0 {
+		latency = 0
+	}
+	_ = m.sendCommandAck(from.PeerID, msg.EventID, cmd.Kind, AckOK, "", latency)
+}
+
+// runCommand は ManagerOptions のコールバックでコマンドを実行します。コールバックが無ければ AckUnsupported です。
+func (m *Manager) runCommand(cmd Command) (AckStatus, error) {
+	var err error
+	unsupported := false
+	switch cmd.Kind {
+	case CmdPreview:
+		if unsupported = m.opts.PreviewScene == nil; !unsupported {
+			err = m.opts.PreviewScene(cmd.Scene)
+		}
+	case CmdTake:
+		if unsupported = m.opts.TakePreview == nil; !unsupported {
+			err = m.opts.TakePreview(cmd.Transition)
+		}
+	case CmdMedia:
+		if unsupported = m.opts.MediaAction == nil; !unsupported {
+			err = m.opts.MediaAction(cmd.Input, cmd.Action)
+		}
+	case CmdRecord:
+		if unsupported = m.opts.Record == nil; !unsupported {
+			err = m.opts.Record(cmd.Action)
+		}
+	case CmdSourceVisibility:
+		if unsupported = m.opts.SetSourceVisible == nil; !unsupported {
+			err = m.opts.SetSourceVisible(cmd.Scene, cmd.Source, cmd.Visible)
+		}
+	case CmdHotkey:
+		if unsupported = m.opts.TriggerHotkey == nil; !unsupported {
+			err = m.opts.TriggerHotkey(cmd.Hotkey)
+		}
+	default:
+		unsupported = true
+	}
+	if unsupported {
+		return AckUnsupported, fmt.Errorf("%s は実行できません（未対応）", cmd.Kind)
+	}
+	if err != nil {
+		return AckError, err
+	}
+	return AckOK, nil
+}
+
+func (m *Manager) sendCommandAck(peerID, eventID string, kind CommandKind, status AckStatus, errText string, latencyMs int64) error {
+	return m.sendSealed(peerID, Message{
+		Type:      MsgCommandAck,
+		EventID:   eventID,
+		Command:   &Command{Kind: kind},
+		Status:    string(status),
+		Error:     errText,
+		LatencyMs: latencyMs,
+	})
+}
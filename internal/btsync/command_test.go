@@ -0,0 +1,144 @@
+package btsync
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCommandValidate(t *testing.T) {
+	cases := []struct {
+		cmd  Command
+		want string
+	}{
+		{Command{Kind: " Preview ", Scene: "Cam"}, ""},
+		{Command{Kind: CmdPreview}, "scene"},
+		{Command{Kind: CmdTake}, ""},
+		{Command{Kind: CmdMedia, Input: "Clip", Action: "PLAY"}, ""},
+		{Command{Kind: CmdMedia, Input: "Clip", Action: "rewind"}, "action"},
+		{Command{Kind: CmdRecord, Action: "start"}, ""},
+		{Command{Kind: CmdRecord}, "action"},
+		{Command{Kind: CmdSourceVisibility, Scene: "Main", Source: "Logo"}, ""},
+		{Command{Kind: CmdSourceVisibility, Scene: "Main"}, "source"},
+		{Command{Kind: CmdHotkey, Hotkey: "OBSBasic.Screenshot"}, ""},
+		{Command{Kind: "stream"}, "未対応"},
+	}
+	for _, c := range cases {
+		err := c.cmd.Normalize().Validate()
+		if c.want == "" && err != nil {
+			t.Errorf("%+v: unexpected error %v", c.cmd, err)
+		}
+		if c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)) {
+			t.Errorf("%+v: want error containing %q, got %v", c.cmd, c.want, err)
+		}
+	}
+}
+
+func TestDispatchCommandRunsOnParentAndChild(t *testing.T) {
+	nw := NewLoopbackNetwork(LoopbackOptions{Latency: 2 * time.Millisecond, Seed: 1})
+
+	var mu sync.Mutex
+	ran := map[string][]string{}
+	record := func(id, what string) {
+		mu.Lock()
+		ran[id] = append(ran[id], what)
+		mu.Unlock()
+	}
+	start := func(id string, role Role, opts ManagerOptions) *Manager {
+		opts.ApplyScene = func(string, Source) error { return nil }
+		return startLoopbackManager(t, nw, id, role, opts, Config{LeadTimeMs: 50, AcceptLateMs: 100})
+	}
+	optsFor := func(id string) ManagerOptions {
+		return ManagerOptions{
+			MediaAction: func(input, action string) error {
+				record(id, "media "+input+" "+action)
+				return nil
+			},
+			SetSourceVisible: func(scene, source string, visible bool) error {
+				if visible {
+					record(id, "show "+scene+"/"+source)
+				} else {
+					record(id, "hide "+scene+"/"+source)
+				}
+				return nil
+			},
+		}
+	}
+	parent := start("parent", RoleParent, optsFor("parent"))
+	child := start("child", RoleChild, optsFor("child"))
+
+	code, _ := parent.GeneratePairingCode()
+	if err := child.JoinByCode(code); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "paired", func() bool {
+		child.mu.Lock()
+		defer child.mu.Unlock()
+		p, ok := child.peers["parent"]
+		return ok && p.Secret != ""
+	})
+
+	if _, err := parent.DispatchCommand(Command{Kind: CmdMedia, Input: "Clip", Action: "Restart"}, SourceGUI); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parent.DispatchCommand(Command{Kind: CmdSourceVisibility, Scene: "Main", Source: "Logo"}, SourceGUI); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "commands ran", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ran["parent"]) == 2 && len(ran["child"]) == 2
+	})
+	mu.Lock()
+	got := strings.Join(ran["child"], ",")
+	mu.Unlock()
+	if !strings.Contains(got, "media Clip restart") || !strings.Contains(got, "hide Main/Logo") {
+		t.Fatalf("child ran %q", got)
+	}
+	waitFor(t, "ok ack", func() bool {
+		st := parent.Status()
+		return len(st.Peers) == 1 && st.Peers[0].LastAckStatus == string(AckOK)
+	})
+
+	// 子機にコールバックが無い種類は unsupported で返る
+	if _, err := parent.DispatchCommand(Command{Kind: CmdHotkey, Hotkey: "OBSBasic.Screenshot"}, SourceGUI); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "unsupported ack", func() bool {
+		st := parent.Status()
+		return len(st.Peers) == 1 && st.Peers[0].LastAckStatus == string(AckUnsupported)
+	})
+
+	if _, err := child.DispatchCommand(Command{Kind: CmdTake}, SourceGUI); err == nil {
+		t.Fatalf("children must not dispatch commands")
+	}
+}
+
+func TestChildRejectsTamperedCommandParams(t *testing.T) {
+	mgr, tr, _, _ := setupChildManager(t)
+	var got []string
+	mgr.opts.MediaAction = func(input, action string) error {
+		got = append(got, input+" "+action)
+		return nil
+	}
+
+	cmd := Command{Kind: CmdMedia, Input: "Clip", Action: "play"}
+	msg := Message{Type: MsgCommand, EventID: "evt-cmd", Command: &cmd, FireAtUnixMs: time.Now().UnixMilli()}
+	tampered, _ := UnmarshalMessage(sealFromParent(t, msg, 1))
+	tampered.Command.Action = "stop"
+	b, _ := tampered.Marshal()
+	mgr.onTransportMessage(parentRef, b)
+	if len(got) != 0 {
+		t.Fatalf("tampered params must not run: %v", got)
+	}
+
+	mgr.onTransportMessage(parentRef, sealFromParent(t, msg, 2))
+	if len(got) != 1 || got[0] != "Clip play" {
+		t.Fatalf("authentic command should run once: %v", got)
+	}
+	ack, ok := tr.last("parent")
+	if !ok || ack.Type != MsgCommandAck || ack.Status != string(AckOK) || ack.Command == nil || ack.Command.Kind != CmdMedia {
+		t.Fatalf("unexpected ack: %+v", ack)
+	}
+}
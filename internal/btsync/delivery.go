@@ -0,0 +1,270 @@
+package btsync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 再送: 子機は command を受けたらすぐ received の ACK を返し、発火後に結果の ACK を返します。
+// 親機は received が来ない peer へ、発火期限（FireAt + AcceptLateMs）までの間だけ間隔を倍にしながら再送します。
+// 子機は EventID で重複を捨てる（再送には received だけ返し直す）ので、届いたものが二重に実行されることはありません。
+
+const (
+	retransmitMin    = 50 * time.Millisecond // 最初の再送までの最短時間（RTT が分かっていればその 2 倍）
+	maxSendAttempts  = 5                     // 初回を含めた送信回数の上限
+	ackWaitAfterFire = 3 * time.Second       // 発火期限の後、結果の ACK を待つ時間
+)
+
+// PeerOutcome は 1 台の peer への配送結果です。Status は結果が出るまで空です。
+type PeerOutcome struct {
+	PeerID    string    `json:"peer_id"`
+	Name      string    `json:"name"`
+	Status    AckStatus `json:"status,omitempty"`
+	Received  bool      `json:"received"`
+	Attempts  int       `json:"attempts"`
+	LatencyMs int64     `json:"latency_ms,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Delivery は DispatchScene / DispatchCommand で送ったイベントの peer ごとの配送状況です。
+// 全 peer の結果（ACK か AckTimeout）がそろうと Done が閉じます。
+type Delivery struct {
+	EventID string
+	FireAt  time.Time
+
+	mu       sync.Mutex
+	outcomes map[string]*PeerOutcome
+	done     chan struct{}
+	closed   bool
+}
+
+func newDelivery(eventID string, fireAt time.Time) *Delivery {
+	return &Delivery{EventID: eventID, FireAt: fireAt, outcomes: map[string]*PeerOutcome{}, done: make(chan struct{})}
+}
+
+// Done は全 peer の結果がそろうと閉じるチャネルです。
+func (d *Delivery) Done() <-chan struct{} { return d.done }
+
+// Wait は全 peer の結果がそろうまで待って返します。
+func (d *Delivery) Wait() []PeerOutcome {
+	<-d.done
+	return d.Outcomes()
+}
+
+// Outcomes は現在の peer ごとの状況を名前順で返します。
+func (d *Delivery) Outcomes() []PeerOutcome {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]PeerOutcome, 0, len(d.outcomes))
+	for _, o := range d.outcomes {
+		out = append(out, *o)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Name+out[i].PeerID) < strings.ToLower(out[j].Name+out[j].PeerID)
+	})
+	return out
+}
+
+func (d *Delivery) update(peerID string, fn func(o *PeerOutcome)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if o, ok := d.outcomes[peerID]; ok {
+		fn(o)
+	}
+}
+
+// settle は結果の出ていない peer を status にして Done を閉じ、その peer を返します。
+func (d *Delivery) settle(status AckStatus, errText string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ids []string
+	for id, o := range d.outcomes {
+		if o.Status == "" {
+			o.Status = status
+			o.Error = errText
+			ids = append(ids, id)
+		}
+	}
+	d.closeLocked()
+	return ids
+}
+
+func (d *Delivery) closeLocked() {
+	if !d.closed {
+		d.closed = true
+		close(d.done)
+	}
+}
+
+// pendingEvent は親機が結果を待っているイベントです。
+type pendingEvent struct {
+	msg       Message
+	delivery  *Delivery
+	sendUntil time.Time // これを過ぎたら再送しない（m.now() 基準）
+	ackUntil  time.Time // これを過ぎても結果の無い peer は AckTimeout
+	next      map[string]time.Time
+	delay     map[string]time.Duration
+}
+
+// sendEvent は接続中のペアリング済み peer へ evt を送り、届くまで再送する配送を始めます。
+func (m *Manager) sendEvent(evt Message, sendUntil time.Time) *Delivery {
+	d := newDelivery(evt.EventID, time.UnixMilli(evt.FireAtUnixMs))
+
+	m.mu.Lock()
+	now := m.now()
+	pe := &pendingEvent{
+		msg:       evt,
+		delivery:  d,
+		sendUntil: sendUntil,
+		ackUntil:  sendUntil.Add(ackWaitAfterFire),
+		next:      map[string]time.Time{},
+		delay:     map[string]time.Duration{},
+	}
+	var targets []string
+	for _, p := range m.peers {
+		if !p.Connected || strings.TrimSpace(p.Secret) == "" {
+			continue
+		}
+		targets = append(targets, p.PeerID)
+		d.outcomes[p.PeerID] = &PeerOutcome{PeerID: p.PeerID, Name: p.Name}
+		delay := retransmitMin
+		if p.clock.ok && 2*p.clock.best.rtt > delay {
+			delay = 2 * p.clock.best.rtt
+		}
+		pe.delay[p.PeerID] = delay
+		pe.next[p.PeerID] = now.Add(delay)
+	}
+	if len(targets) == 0 {
+		m.mu.Unlock()
+		d.settle(AckTimeout, "")
+		return d
+	}
+	m.pendingAcks[evt.EventID] = pe
+	m.mu.Unlock()
+
+	for _, id := range targets {
+		m.transmit(pe, id)
+	}
+	go m.retransmitLoop(evt.EventID)
+	return d
+}
+
+// transmit は pe.msg を 1 回送ります（seq と HMAC は sendSealed が送るたびに付け直す）。
+func (m *Manager) transmit(pe *pendingEvent, peerID string) {
+	attempt := 0
+	pe.delivery.update(peerID, func(o *PeerOutcome) {
+		o.Attempts++
+		attempt = o.Attempts
+	})
+	err := m.sendSealed(peerID, pe.msg)
+	pe.delivery.update(peerID, func(o *PeerOutcome) {
+		if err != nil {
+			o.Error = err.Error()
+		} else {
+			o.Error = ""
+		}
+	})
+	if err != nil {
+		m.log("error", fmt.Sprintf("%s 送信失敗 [%s] (%d回目): %v", pe.msg.Type, peerID, attempt, err))
+	} else if attempt > 1 {
+		m.log("info", fmt.Sprintf("%s 再送 [%s] event=%s (%d回目)", pe.msg.Type, peerID, pe.msg.EventID, attempt))
+	}
+}
+
+// retransmitLoop は received の来ない peer への再送と、結果の ACK の期限切れを扱います。
+func (m *Manager) retransmitLoop(eventID string) {
+	for {
+		m.mu.Lock()
+		pe, ok := m.pendingAcks[eventID]
+		if !ok {
+			m.mu.Unlock()
+			return
+		}
+		now := m.now()
+		if !now.Before(pe.ackUntil) {
+			delete(m.pendingAcks, eventID)
+			m.mu.Unlock()
+			for _, id := range pe.delivery.settle(AckTimeout, "ACK がありません") {
+				m.log("error", fmt.Sprintf("ACKタイムアウト: event=%s peer=%s", eventID, id))
+			}
+			return
+		}
+		wake := pe.ackUntil
+		var resend []string
+		for id, at := range pe.next {
+			if now.After(pe.sendUntil) {
+				delete(pe.next, id)
+				continue
+			}
+			if !at.After(now) {
+				attempts := 0
+				pe.delivery.update(id, func(o *PeerOutcome) { attempts = o.Attempts })
+				if attempts >= maxSendAttempts {
+					delete(pe.next, id)
+					continue
+				}
+				resend = append(resend, id)
+				pe.delay[id] *= 2
+				at = now.Add(pe.delay[id])
+				pe.next[id] = at
+			}
+			if at.Before(wake) {
+				wake = at
+			}
+		}
+		m.mu.Unlock()
+
+		for _, id := range resend {
+			m.transmit(pe, id)
+		}
+		if d := wake.Sub(m.now()); d > 0 {
+			time.Sleep(d)
+		}
+	}
+}
+
+// recordAck は ACK を配送状況に反映します。received なら再送だけ止め、それ以外は結果として確定します。
+func (m *Manager) recordAck(from PeerRef, msg Message) {
+	m.mu.Lock()
+	pe, ok := m.pendingAcks[msg.EventID]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	delete(pe.next, from.PeerID)
+	status := AckStatus(msg.Status)
+	pe.delivery.mu.Lock()
+	if o, ok := pe.delivery.outcomes[from.PeerID]; ok {
+		o.Received = true
+		if status != AckReceived && o.Status == "" {
+			o.Status = status
+			o.Error = msg.Error
+			o.LatencyMs = msg.LatencyMs
+		}
+	}
+	done := true
+	for _, o := range pe.delivery.outcomes {
+		if o.Status == "" {
+			done = false
+		}
+	}
+	if done {
+		pe.delivery.closeLocked()
+		delete(m.pendingAcks, msg.EventID)
+	}
+	pe.delivery.mu.Unlock()
+	m.mu.Unlock()
+}
+
+// abortPendingLocked は停止時に待っている配送を全て終わらせます（呼び出し後に settle する）。
+func (m *Manager) abortPendingLocked() []*Delivery {
+	var out []*Delivery
+	for _, pe := range m.pendingAcks {
+		out = append(out, pe.delivery)
+	}
+	m.pendingAcks = map[string]*pendingEvent{}
+	return out
+}
@@ -0,0 +1,138 @@
+package btsync
+
+import (
+	"testing"
+	"time"
+)
+
+var childRef = PeerRef{PeerID: "child", Name: "child", Platform: "test"}
+
+func setupParentManager(t *testing.T, leadMs, acceptLateMs int) (*Manager, *captureTransport) {
+	t.Helper()
+	tr := newCaptureTransport("parent")
+	mgr := NewManager(tr, ManagerOptions{ApplyScene: func(string, Source) error { return nil }})
+	mgr.mu.Lock()
+	mgr.cfg = Config{Enabled: true, Role: RoleParent, LeadTimeMs: leadMs, AcceptLateMs: acceptLateMs}.Normalize()
+	mgr.running = true
+	mgr.role = RoleParent
+	mgr.peers = map[string]*peerState{
+		"child": {TrustedPeer: TrustedPeer{PeerID: "child", Name: "child", Secret: "secret"}, Connected: true},
+	}
+	mgr.mu.Unlock()
+	t.Cleanup(func() { _ = mgr.Stop() })
+	return mgr, tr
+}
+
+// testChildSession は ackFromChild が使う子機のセッション ID です。
+var testChildSession = newSessionID(time.Now())
+
+func ackFromChild(t *testing.T, eventID string, status AckStatus, seq uint64) []byte {
+	t.Helper()
+	msg := Message{
+		Type:            MsgSceneAck,
+		ProtocolVersion: ProtocolVersion,
+		EventID:         eventID,
+		Status:          string(status),
+		Session:         testChildSession,
+		Seq:             seq,
+		SentAtUnixMs:    time.Now().UnixMilli(),
+	}
+	msg.HMAC = messageMAC(macKey("secret", RoleChild), msg)
+	b, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (t *captureTransport) count(peerID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sent[peerID])
+}
+
+func TestParentRetransmitsUntilReceived(t *testing.T) {
+	mgr, tr := setupParentManager(t, 1000, 100)
+
+	d, err := mgr.DispatchScene("SceneA", SourceGUI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "retransmission", func() bool { return tr.count("child") >= 2 })
+
+	mgr.onTransportMessage(childRef, ackFromChild(t, d.EventID, AckReceived, 1))
+	sent := tr.count("child")
+	time.Sleep(300 * time.Millisecond)
+	if n := tr.count("child"); n != sent {
+		t.Fatalf("received ACK must stop retransmission: %d -> %d", sent, n)
+	}
+	select {
+	case <-d.Done():
+		t.Fatalf("received is not a final outcome")
+	default:
+	}
+
+	mgr.onTransportMessage(childRef, ackFromChild(t, d.EventID, AckOK, 2))
+	out := d.Wait()
+	if len(out) != 1 || out[0].Status != AckOK || !out[0].Received || out[0].Attempts != sent {
+		t.Fatalf("unexpected outcome: %+v (sent %d)", out, sent)
+	}
+}
+
+func TestRetransmissionIsBounded(t *testing.T) {
+	mgr, tr := setupParentManager(t, 5000, 100)
+
+	d, err := mgr.DispatchScene("SceneA", SourceGUI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 50 + 100 + 200 + 400ms で上限に達する
+	time.Sleep(1200 * time.Millisecond)
+	if n := tr.count("child"); n != maxSendAttempts {
+		t.Fatalf("want %d sends, got %d", maxSendAttempts, n)
+	}
+
+	_ = mgr.Stop()
+	out := d.Wait()
+	if len(out) != 1 || out[0].Status != AckTimeout || out[0].Received || out[0].Attempts != maxSendAttempts {
+		t.Fatalf("unexpected outcome: %+v", out)
+	}
+}
+
+func TestNoRetransmissionAfterFireDeadline(t *testing.T) {
+	mgr, tr := setupParentManager(t, 20, 20)
+
+	d, err := mgr.DispatchScene("SceneA", SourceGUI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := tr.count("child"); n != 1 {
+		t.Fatalf("nothing should be resent after FireAt+AcceptLate, got %d sends", n)
+	}
+	// 期限後の結果 ACK も受け付ける
+	mgr.onTransportMessage(childRef, ackFromChild(t, d.EventID, AckLateDrop, 1))
+	if out := d.Wait(); out[0].Status != AckLateDrop {
+		t.Fatalf("unexpected outcome: %+v", out)
+	}
+}
+
+func TestDispatchWithoutPeersSettlesImmediately(t *testing.T) {
+	mgr, _ := setupParentManager(t, 100, 100)
+	mgr.mu.Lock()
+	mgr.peers["child"].Connected = false
+	mgr.mu.Unlock()
+
+	d, err := mgr.DispatchScene("SceneA", SourceGUI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-d.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("delivery without peers should be done")
+	}
+	if out := d.Outcomes(); len(out) != 0 {
+		t.Fatalf("unexpected outcomes: %+v", out)
+	}
+}
@@ -0,0 +1,17 @@
+package btsync
+
+import "testing"
+
+// startLoopbackManager は nw 上に id の Manager を作って開始し、テストの終わりに止めます。
+// cfg の Enabled・Role・DeviceName はここで埋めます。
+func startLoopbackManager(t *testing.T, nw *LoopbackNetwork, id string, role Role, opts ManagerOptions, cfg Config) *Manager {
+	t.Helper()
+	mgr := NewManager(nw.NewTransport(id), opts)
+	cfg.Enabled, cfg.Role, cfg.DeviceName = true, role, id
+	mgr.SetConfig(cfg)
+	if err := mgr.Start(); err != nil {
+		t.Fatalf("%s start: %v", id, err)
+	}
+	t.Cleanup(func() { _ = mgr.Stop() })
+	return mgr
+}
@@ -0,0 +1,530 @@
+package btsync
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LAN トランスポート: 親機は TCP で待ち受け、同じ番号の UDP ポートへ一定間隔でビーコンをブロードキャストします。
+// 子機はビーコン（または lan_parent_addr）で見つけた親機へ TCP で接続します。
+// ペアリング・HMAC・ACK は Manager がそのまま扱い、ここではメッセージを運ぶだけです。
+
+const (
+	DefaultLANPort = 47810
+
+	lanService        = "obsctl-sync"
+	lanBeaconInterval = 1 * time.Second
+	lanRedialInterval = 2 * time.Second
+	lanWriteTimeout   = 2 * time.Second
+	lanMaxFrame       = 64 * 1024
+)
+
+// lanBeacon は親機が UDP でブロードキャストする告知です。
+type lanBeacon struct {
+	Service  string `json:"service"`
+	Version  int    `json:"version"`
+	PeerID   string `json:"peer_id"`
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	Port     int    `json:"port"`
+}
+
+// lanHello は TCP 接続直後に互いに送る自己紹介です。
+type lanHello struct {
+	Service  string `json:"service"`
+	PeerID   string `json:"peer_id"`
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+}
+
+type lanConn struct {
+	ref  PeerRef
+	conn net.Conn
+	wmu  sync.Mutex
+}
+
+func (c *lanConn) write(payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(lanWriteTimeout))
+	return writeLANFrame(c.conn, payload)
+}
+
+type lanTransport struct {
+	mu sync.Mutex
+
+	port       int
+	parentAddr string
+
+	role        Role
+	localPeer   PeerRef
+	running     bool
+	stop        chan struct{}
+	onMessage   func(from PeerRef, payload []byte)
+	onPeerEvent func(PeerEvent)
+
+	listener net.Listener
+	udp      net.PacketConn
+	conns    map[string]*lanConn
+	dialing  map[string]bool // 子機: 接続中・接続試行中の親機のアドレス → 信頼済みの親機か（preferredParentLocked）
+
+	// 子機から親機への再接続の可否と間隔（キーは親機のアドレスと peer ID の両方。reconnect.go）
+	reconnect reconnectState
+}
+
+// NewLANTransport は LAN（UDP ブロードキャストで発見 + TCP で送受信）のトランスポートを返します。
+// port は親機の TCP 待ち受けと UDP ビーコンの番号（0 以下なら DefaultLANPort）。
+// parentAddr（host:port）を指定した子機はビーコンを待たずにその親機へ接続します。
+func NewLANTransport(port int, parentAddr string) Transport {
+	if port <= 0 {
+		port = DefaultLANPort
+	}
+	return &lanTransport{
+		port:       port,
+		parentAddr: strings.TrimSpace(parentAddr),
+		conns:      map[string]*lanConn{},
+		dialing:    map[string]bool{},
+	}
+}
+
+// SetReconnect は子機が切れた親機へつなぎ直すかどうかと、その間隔を設定します。
+func (t *lanTransport) SetReconnect(policy ReconnectPolicy) {
+	t.reconnect.set(policy)
+}
+
+func (t *lanTransport) SupportsRole(role Role) error {
+	switch role {
+	case RoleParent, RoleChild, RoleOff:
+		return nil
+	default:
+		return fmt.Errorf("unsupported role: %s", role)
+	}
+}
+
+func (t *lanTransport) LocalPeer() PeerRef {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.localPeer.PeerID == "" {
+		return lanLocalPeer(defaultDeviceName())
+	}
+	return t.localPeer
+}
+
+// lanLocalPeer はホスト名とデバイス名から再起動しても変わらない PeerID を作ります（TrustedPeers に残すため）。
+func lanLocalPeer(deviceName string) PeerRef {
+	name := strings.TrimSpace(deviceName)
+	if name == "" {
+		name = defaultDeviceName()
+	}
+	host, _ := os.Hostname()
+	sum := sha256.Sum256([]byte(host + "|" + name))
+	return PeerRef{PeerID: "lan-" + hex.EncodeToString(sum[:6]), Name: name, Platform: runtime.GOOS}
+}
+
+func (t *lanTransport) Start(ctx Context, role Role, deviceName string, onMessage func(from PeerRef, payload []byte), onPeerEvent func(PeerEvent)) error {
+	if err := t.SupportsRole(role); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if t.running {
+		t.mu.Unlock()
+		return nil
+	}
+	t.role = role
+	t.onMessage = onMessage
+	t.onPeerEvent = onPeerEvent
+	t.localPeer = lanLocalPeer(deviceName)
+	t.conns = map[string]*lanConn{}
+	t.dialing = map[string]bool{}
+	t.reconnect.reset()
+	stop := make(chan struct{})
+	t.stop = stop
+	t.mu.Unlock()
+
+	switch role {
+	case RoleParent:
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", t.port))
+		if err != nil {
+			return fmt.Errorf("LAN同期の待ち受けに失敗 (tcp :%d): %w", t.port, err)
+		}
+		udp, err := net.ListenPacket("udp4", ":0")
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("LAN同期のビーコン送信準備に失敗: %w", err)
+		}
+		t.mu.Lock()
+		t.listener = ln
+		t.udp = udp
+		t.running = true
+		t.mu.Unlock()
+		go t.acceptLoop(ln)
+		go t.beaconLoop(udp, ln.Addr().(*net.TCPAddr).Port, stop)
+	case RoleChild:
+		udp, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", t.port))
+		if err != nil && t.parentAddr == "" {
+			return fmt.Errorf("LAN同期のビーコン受信に失敗 (udp :%d): %w", t.port, err)
+		}
+		t.mu.Lock()
+		if err == nil {
+			t.udp = udp
+		}
+		t.running = true
+		t.mu.Unlock()
+		if err == nil {
+			go t.discoverLoop(udp)
+		}
+		if t.parentAddr != "" {
+			go t.redialLoop(t.parentAddr, stop)
+		}
+	default:
+		t.mu.Lock()
+		t.running = true
+		t.mu.Unlock()
+	}
+
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = t.Stop()
+			case <-stop:
+			}
+		}()
+	}
+	return nil
+}
+
+func (t *lanTransport) Stop() error {
+	t.mu.Lock()
+	if !t.running {
+		t.mu.Unlock()
+		return nil
+	}
+	t.running = false
+	close(t.stop)
+	ln, udp := t.listener, t.udp
+	t.listener, t.udp = nil, nil
+	conns := make([]*lanConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.conns = map[string]*lanConn{}
+	t.mu.Unlock()
+
+	if ln != nil {
+		_ = ln.Close()
+	}
+	if udp != nil {
+		_ = udp.Close()
+	}
+	for _, c := range conns {
+		_ = c.conn.Close()
+	}
+	return nil
+}
+
+func (t *lanTransport) Send(peerID string, payload []byte) error {
+	t.mu.Lock()
+	c, ok := t.conns[peerID]
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("peer %s は接続されていません", peerID)
+	}
+	if err := c.write(payload); err != nil {
+		_ = c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (t *lanTransport) Broadcast(payload []byte) error {
+	t.mu.Lock()
+	ids := make([]string, 0, len(t.conns))
+	for id := range t.conns {
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+
+	if len(ids) == 0 {
+		return errors.New("接続中のpeerがありません")
+	}
+	var errs []error
+	for _, id := range ids {
+		if err := t.Send(id, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *lanTransport) acceptLoop(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go t.serve(conn, "")
+	}
+}
+
+func (t *lanTransport) beaconLoop(udp net.PacketConn, port int, stop chan struct{}) {
+	dst := &net.UDPAddr{IP: net.IPv4bcast, Port: t.port}
+	ticker := time.NewTicker(lanBeaconInterval)
+	defer ticker.Stop()
+	for {
+		t.mu.Lock()
+		local := t.localPeer
+		t.mu.Unlock()
+		b, _ := json.Marshal(lanBeacon{Service: lanService, Version: ProtocolVersion, PeerID: local.PeerID, Name: local.Name, Platform: local.Platform, Port: port})
+		_, _ = udp.WriteTo(b, dst)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *lanTransport) discoverLoop(udp net.PacketConn) {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		addr, peerID, ok := parseLANBeacon(buf[:n], from)
+		if !ok || (peerID != "" && !t.reconnect.allow(peerID, time.Now())) {
+			// 再接続しない設定や待ち時間は、接続してから peer ID で確かめる前にビーコンの peer ID にも当てはめる
+			continue
+		}
+		if t.claimDial(addr, t.preferredParent(addr, peerID)) {
+			go t.dial(addr)
+		}
+	}
+}
+
+// preferredParent は addr の親機（peer ID が peerID）を優先してつなぐかを返します。
+// 信頼済みの peer か、lan_parent_addr で指定した親機を優先します。
+func (t *lanTransport) preferredParent(addr, peerID string) bool {
+	return (peerID != "" && t.reconnect.isKnown(peerID)) || (t.parentAddr != "" && addr == t.parentAddr)
+}
+
+// claimDial は親機への接続を始めてよければ dialing に addr を登録して true を返します。
+// 子機がつなぐ親機は 1 台なので、別のアドレス（ビーコンと lan_parent_addr など）から同じ親機へ二重につながないよう、
+// 接続中・接続試行中のものがあれば始めません。ただし preferred の親機なら、優先しない親機との接続を切ってつなぎ直します
+// （ペアリング済みの子機が、先に聞こえた別の親機につながったままにならないようにする）。
+func (t *lanTransport) claimDial(addr string, preferred bool) bool {
+	if !t.reconnect.allow(addr, time.Now()) {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.dialing) > 0 {
+		if !preferred || t.preferredParentLocked() {
+			return false
+		}
+		for _, c := range t.conns {
+			_ = c.conn.Close()
+		}
+	}
+	t.dialing[addr] = preferred
+	return true
+}
+
+// preferredParentLocked は優先する親機に接続中・接続試行中かを返します。
+func (t *lanTransport) preferredParentLocked() bool {
+	for _, preferred := range t.dialing {
+		if preferred {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLANBeacon はビーコンから親機の TCP アドレス（送信元の IP + 告知されたポート）と peer ID を返します。
+func parseLANBeacon(b []byte, from net.Addr) (string, string, bool) {
+	var bc lanBeacon
+	if err := json.Unmarshal(b, &bc); err != nil || bc.Service != lanService || bc.Port <= 0 {
+		return "", "", false
+	}
+	ua, ok := from.(*net.UDPAddr)
+	if !ok {
+		return "", "", false
+	}
+	return net.JoinHostPort(ua.IP.String(), strconv.Itoa(bc.Port)), strings.TrimSpace(bc.PeerID), true
+}
+
+func (t *lanTransport) redialLoop(addr string, stop chan struct{}) {
+	ticker := time.NewTicker(lanRedialInterval)
+	defer ticker.Stop()
+	for {
+		if t.claimDial(addr, true) {
+			go t.dial(addr)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dial は親機へ接続し、切断されるまで受信します。終わったら dialing を外して再接続できるようにします。
+func (t *lanTransport) dial(addr string) {
+	defer func() {
+		t.mu.Lock()
+		delete(t.dialing, addr)
+		t.mu.Unlock()
+	}()
+	conn, err := net.DialTimeout("tcp", addr, lanWriteTimeout)
+	if err != nil {
+		t.reconnect.failed(addr, time.Now())
+		return
+	}
+	t.serve(conn, addr)
+}
+
+// serve は hello を交換して接続を登録し、フレームを onMessage へ渡します。addr は子機が接続した親機のアドレスです。
+func (t *lanTransport) serve(conn net.Conn, addr string) {
+	defer conn.Close()
+
+	t.mu.Lock()
+	local := t.localPeer
+	running := t.running
+	t.mu.Unlock()
+	if !running {
+		return
+	}
+
+	hello, _ := json.Marshal(lanHello{Service: lanService, PeerID: local.PeerID, Name: local.Name, Platform: local.Platform})
+	_ = conn.SetWriteDeadline(time.Now().Add(lanWriteTimeout))
+	if err := writeLANFrame(conn, hello); err != nil {
+		t.handshakeFailed(addr)
+		return
+	}
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	first, err := readLANFrame(r)
+	if err != nil {
+		t.handshakeFailed(addr)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	var h lanHello
+	if err := json.Unmarshal(first, &h); err != nil || h.Service != lanService || strings.TrimSpace(h.PeerID) == "" || h.PeerID == local.PeerID {
+		t.handshakeFailed(addr)
+		return
+	}
+	peer := PeerRef{PeerID: h.PeerID, Name: h.Name, Platform: h.Platform}
+	if peer.Name == "" {
+		peer.Name = addr
+	}
+	if addr != "" && !t.reconnect.allow(peer.PeerID, time.Now()) {
+		// 別のアドレスから見つけた同じ親機にも、再接続しない設定や待ち時間を当てはめる
+		t.reconnect.failed(addr, time.Now())
+		return
+	}
+
+	c := &lanConn{ref: peer, conn: conn}
+	t.mu.Lock()
+	if !t.running {
+		t.mu.Unlock()
+		return
+	}
+	if addr != "" {
+		// ビーコンの peer ID ではなく、hello で名乗った peer ID で優先するかを決め直す
+		preferred := t.preferredParent(addr, peer.PeerID)
+		delete(t.dialing, addr)
+		if !preferred && t.preferredParentLocked() {
+			t.dialing[addr] = false
+			t.mu.Unlock()
+			return
+		}
+		t.dialing[addr] = preferred
+	}
+	if old, ok := t.conns[peer.PeerID]; ok {
+		_ = old.conn.Close()
+	}
+	t.conns[peer.PeerID] = c
+	onMessage, onPeerEvent := t.onMessage, t.onPeerEvent
+	t.mu.Unlock()
+	if addr != "" {
+		t.reconnect.succeeded(addr, time.Now())
+		t.reconnect.succeeded(peer.PeerID, time.Now())
+	}
+
+	if onPeerEvent != nil {
+		onPeerEvent(PeerEvent{Peer: peer, Connected: true, At: time.Now()})
+	}
+	for {
+		payload, err := readLANFrame(r)
+		if err != nil {
+			break
+		}
+		if onMessage != nil {
+			onMessage(peer, payload)
+		}
+	}
+
+	t.mu.Lock()
+	current := t.conns[peer.PeerID] == c
+	if current {
+		delete(t.conns, peer.PeerID)
+	}
+	t.mu.Unlock()
+	if current && addr != "" {
+		t.reconnect.dropped(addr, peer.PeerID, time.Now())
+		t.reconnect.dropped(peer.PeerID, peer.PeerID, time.Now())
+	}
+	if current && onPeerEvent != nil {
+		onPeerEvent(PeerEvent{Peer: peer, Connected: false, At: time.Now()})
+	}
+}
+
+func (t *lanTransport) handshakeFailed(addr string) {
+	if addr != "" {
+		t.reconnect.failed(addr, time.Now())
+	}
+}
+
+// writeLANFrame / readLANFrame は 4 バイト（ビッグエンディアン）の長さ + 本文で 1 メッセージを送受信します。
+func writeLANFrame(w io.Writer, payload []byte) error {
+	if len(payload) > lanMaxFrame {
+		return fmt.Errorf("メッセージが大きすぎます (%d bytes)", len(payload))
+	}
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[4:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readLANFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > lanMaxFrame {
+		return nil, fmt.Errorf("メッセージが大きすぎます (%d bytes)", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
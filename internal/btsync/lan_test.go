@@ -0,0 +1,200 @@
+package btsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freeLANPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close()
+	return port
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestLANFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	for _, p := range []string{`{"type":"heartbeat"}`, ""} {
+		if err := writeLANFrame(&buf, []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{`{"type":"heartbeat"}`, ""} {
+		got, err := readLANFrame(&buf)
+		if err != nil || string(got) != want {
+			t.Fatalf("want %q, got %q (%v)", want, got, err)
+		}
+	}
+	if err := writeLANFrame(&buf, make([]byte, lanMaxFrame+1)); err == nil {
+		t.Fatal("oversized frame should fail")
+	}
+}
+
+func TestParseLANBeacon(t *testing.T) {
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 10), Port: 50000}
+	addr, peerID, ok := parseLANBeacon([]byte(`{"service":"obsctl-sync","version":1,"peer_id":"lan-1","port":47810}`), from)
+	if !ok || addr != "192.168.0.10:47810" || peerID != "lan-1" {
+		t.Fatalf("beacon: %q %q %v", addr, peerID, ok)
+	}
+	if _, _, ok := parseLANBeacon([]byte(`{"service":"other","port":47810}`), from); ok {
+		t.Fatal("foreign beacon should be ignored")
+	}
+}
+
+func TestLANPairAndDispatch(t *testing.T) {
+	port := freeLANPort(t)
+
+	var mu sync.Mutex
+	applied := map[string]string{}
+	newMgr := func(name string, role Role) *Manager {
+		mgr := NewManager(nil, ManagerOptions{
+			ApplyScene: func(scene string, _ Source) error {
+				mu.Lock()
+				applied[name] = scene
+				mu.Unlock()
+				return nil
+			},
+		})
+		mgr.SetConfig(Config{
+			Enabled:       true,
+			Role:          role,
+			DeviceName:    name,
+			LeadTimeMs:    100,
+			Transport:     TransportLAN,
+			LANPort:       port,
+			LANParentAddr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		})
+		return mgr
+	}
+	parent := newMgr("lan-test-parent", RoleParent)
+	child := newMgr("lan-test-child", RoleChild)
+	if err := parent.Start(); err != nil {
+		t.Fatalf("parent start: %v", err)
+	}
+	defer parent.Stop()
+	if err := child.Start(); err != nil {
+		t.Fatalf("child start: %v", err)
+	}
+	defer child.Stop()
+
+	if st := parent.Status(); st.Transport != TransportLAN || !st.SupportedRoleChild {
+		t.Fatalf("status: %+v", st)
+	}
+	waitFor(t, "child connected", func() bool { return child.Status().ParentConnected })
+
+	code, err := parent.GeneratePairingCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.JoinByCode(code); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "pairing", func() bool {
+		child.mu.Lock()
+		defer child.mu.Unlock()
+		for _, p := range child.peers {
+			if p.Secret != "" {
+				return true
+			}
+		}
+		return false
+	})
+
+	if _, err := parent.DispatchScene("SceneA", SourceGUI); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "ack", func() bool {
+		for _, p := range parent.Status().Peers {
+			if p.LastAckStatus == string(AckOK) {
+				return true
+			}
+		}
+		return false
+	})
+	waitFor(t, "applied on both", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return applied["lan-test-child"] == "SceneA" && applied["lan-test-parent"] == "SceneA"
+	})
+}
+
+// TestLANChildPrefersTrustedParent は先に聞こえた別の親機につながった子機が、信頼済みの親機のビーコンで乗り換えることを確かめます。
+func TestLANChildPrefersTrustedParent(t *testing.T) {
+	tr := NewLANTransport(freeLANPort(t), "").(*lanTransport)
+	tr.reconnect.set(ReconnectPolicy{KnownPeers: []string{"trusted"}})
+	tr.reconnect.reset()
+	tr.running = true
+	tr.localPeer = PeerRef{PeerID: "child"}
+
+	foreignAddr, trustedAddr := "192.168.0.20:47810", "192.168.0.10:47810"
+	if tr.preferredParent(foreignAddr, "foreign") || !tr.preferredParent(trustedAddr, "trusted") {
+		t.Fatal("only trusted parents are preferred")
+	}
+	if !tr.claimDial(foreignAddr, false) {
+		t.Fatal("an unknown parent is used while no trusted parent is around")
+	}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	tr.conns["foreign"] = &lanConn{ref: PeerRef{PeerID: "foreign"}, conn: local}
+
+	if tr.claimDial("192.168.0.30:47810", false) {
+		t.Fatal("a second unknown parent must not be dialed")
+	}
+	if !tr.claimDial(trustedAddr, true) {
+		t.Fatal("a trusted parent should replace the unknown one")
+	}
+	if _, err := remote.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection to the unknown parent should be closed")
+	}
+	if tr.claimDial("192.168.0.30:47810", false) {
+		t.Fatal("unknown parents must not be dialed while the trusted one is in use")
+	}
+
+	// 信頼済みの親機へ接続中に、別の親機への接続が先に確立しても登録しない
+	delete(tr.conns, "foreign")
+	tr.dialing[foreignAddr] = false
+	local, remote = net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		tr.serve(local, foreignAddr)
+		close(done)
+	}()
+	r := bufio.NewReader(remote)
+	if _, err := readLANFrame(r); err != nil {
+		t.Fatal(err)
+	}
+	hello, _ := json.Marshal(lanHello{Service: lanService, PeerID: "foreign"})
+	if err := writeLANFrame(remote, hello); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	_ = remote.Close()
+	tr.mu.Lock()
+	_, registered := tr.conns["foreign"]
+	tr.mu.Unlock()
+	if registered {
+		t.Fatal("unknown parent must not be registered while a trusted one is dialed")
+	}
+}
@@ -0,0 +1,125 @@
+package btsync
+
+import (
+	"strings"
+	"time"
+)
+
+// 同期待ち時間の自動調整（Config.AutoLeadTime）。
+//
+// 親機は子機がつながったときと heartbeat ごとに ping を送り、子機は T1 をそのまま pong で返します。
+// 親機は自分の時計だけで RTT を求め、子機ごとに直近の最大値を片道の上限とみなします。
+// 同期待ち時間は、接続中の子機のうち最も遅いものの RTT に leadMargin を足して leadStep 単位に切り上げ、
+// leadMin..leadMax に収めた値です。まだ測れていない子機がいる間は、その子機に Config.LeadTimeMs を見込みます。
+
+const (
+	latencySamples = 8
+	leadMargin     = 50 * time.Millisecond
+	leadMin        = 50 * time.Millisecond
+	leadMax        = 2 * time.Second
+	leadStep       = 10 * time.Millisecond
+)
+
+// latencyFilter は peer ごとの RTT の直近の標本です。
+type latencyFilter struct {
+	samples []time.Duration
+}
+
+func (f *latencyFilter) add(rtt time.Duration) {
+	if rtt < 0 {
+		rtt = 0
+	}
+	f.samples = append(f.samples, rtt)
+	if len(f.samples) > latencySamples {
+		f.samples = f.samples[len(f.samples)-latencySamples:]
+	}
+}
+
+func (f *latencyFilter) reset() {
+	f.samples = nil
+}
+
+// max は直近の最大の RTT と、標本があるかを返します。
+func (f *latencyFilter) max() (time.Duration, bool) {
+	if len(f.samples) == 0 {
+		return 0, false
+	}
+	out := f.samples[0]
+	for _, s := range f.samples[1:] {
+		if s > out {
+			out = s
+		}
+	}
+	return out, true
+}
+
+// leadTimeLocked は今の同期待ち時間と、測定から決めたかを返します。
+func (m *Manager) leadTimeLocked() (time.Duration, bool) {
+	cfg := m.cfg.Normalize()
+	fixed := time.Duration(cfg.LeadTimeMs) * time.Millisecond
+	if !cfg.AutoLeadTime || m.role != RoleParent {
+		return fixed, false
+	}
+	var need time.Duration
+	measured := 0
+	for _, p := range m.peers {
+		if !p.Connected || p.Blocked || strings.TrimSpace(p.Secret) == "" {
+			continue
+		}
+		rtt, ok := p.rtt.max()
+		if !ok {
+			rtt = fixed // まだ測れていない子機には設定値を見込む
+		} else {
+			measured++
+		}
+		if rtt > need {
+			need = rtt
+		}
+	}
+	if measured == 0 {
+		return fixed, false
+	}
+	lead := (need + leadMargin + leadStep - 1) / leadStep * leadStep
+	if lead < leadMin {
+		lead = leadMin
+	}
+	if lead > leadMax {
+		lead = leadMax
+	}
+	return lead, true
+}
+
+// sendPing は親機から子機へ ping を送ります。
+func (m *Manager) sendPing(peerID string) error {
+	now := m.now()
+	return m.sendSealed(peerID, Message{Type: MsgPing, SentAtUnixMs: now.UnixMilli(), ClockT1Us: now.UnixMicro()})
+}
+
+// handlePing は子機側: T1 をそのまま返します。
+func (m *Manager) handlePing(from PeerRef, msg Message) {
+	if msg.ClockT1Us == 0 {
+		return
+	}
+	_ = m.sendSealed(from.PeerID, Message{Type: MsgPong, ClockT1Us: msg.ClockT1Us})
+}
+
+// handlePong は親機側: RTT の標本を追加します。
+func (m *Manager) handlePong(from PeerRef, msg Message, receivedAt time.Time) {
+	if msg.ClockT1Us == 0 {
+		return
+	}
+	rtt := receivedAt.Sub(time.UnixMicro(msg.ClockT1Us))
+	m.mu.Lock()
+	m.ensurePeerLocked(from).rtt.add(rtt)
+	m.mu.Unlock()
+}
+
+// probeLatency は接続直後に数回 ping を送り、最初のシーン切替までに遅延を測っておきます。
+func (m *Manager) probeLatency(peerID string) {
+	for i := 0; i < 3; i++ {
+		if err := m.sendPing(peerID); err != nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
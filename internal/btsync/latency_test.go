@@ -0,0 +1,64 @@
+package btsync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeadTimeFollowsSlowestPeer(t *testing.T) {
+	mgr, _ := setupParentManager(t, 300, 100)
+	lead := func() (int, bool) {
+		st := mgr.Status()
+		return st.LeadTimeMs, st.LeadTimeAuto
+	}
+	pong := func(peer PeerRef, rtt time.Duration) {
+		now := time.Now()
+		mgr.handlePong(peer, Message{Type: MsgPong, ClockT1Us: now.Add(-rtt).UnixMicro()}, now)
+	}
+
+	pong(childRef, 115*time.Millisecond)
+	if ms, auto := lead(); ms != 300 || auto {
+		t.Fatalf("auto lead time is off by default: %dms auto=%v", ms, auto)
+	}
+
+	mgr.mu.Lock()
+	mgr.cfg.AutoLeadTime = true
+	mgr.mu.Unlock()
+	if ms, auto := lead(); ms != 170 || !auto {
+		t.Fatalf("want slowest RTT + margin = 170ms, got %dms auto=%v", ms, auto)
+	}
+	d, err := mgr.DispatchScene("SceneA", SourceGUI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Until(d.FireAt); got > 170*time.Millisecond || got < 100*time.Millisecond {
+		t.Fatalf("dispatch should use the measured lead time: %v", got)
+	}
+
+	// まだ測れていない子機には設定値を見込む
+	mgr.mu.Lock()
+	mgr.peers["slow"] = &peerState{TrustedPeer: TrustedPeer{PeerID: "slow", Secret: "secret"}, Connected: true}
+	mgr.mu.Unlock()
+	if ms, _ := lead(); ms != 350 {
+		t.Fatalf("unmeasured peer should count as the configured lead: %dms", ms)
+	}
+	pong(PeerRef{PeerID: "slow"}, 3*time.Second)
+	if ms, _ := lead(); ms != int(leadMax/time.Millisecond) {
+		t.Fatalf("lead time should be capped: %dms", ms)
+	}
+	mgr.onTransportPeerEvent(PeerEvent{Peer: PeerRef{PeerID: "slow"}, Connected: false})
+	if ms, _ := lead(); ms != 170 {
+		t.Fatalf("disconnected peers must not count: %dms", ms)
+	}
+}
+
+func TestPingMeasuresRTT(t *testing.T) {
+	parent, _, _, _ := startPairedLoopback(t)
+	if err := parent.sendPing("child"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "pong", func() bool {
+		st := parent.Status()
+		return len(st.Peers) == 1 && st.Peers[0].RTTMs >= 4
+	})
+}
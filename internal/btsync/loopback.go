@@ -0,0 +1,295 @@
+package btsync
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoopbackOptions はメモリ内ネットワークの回線品質です。
+type LoopbackOptions struct {
+	Latency time.Duration // 片道の遅延
+	Jitter  time.Duration // 0..Jitter の遅延を追加（Latency より大きいとメッセージの順序が入れ替わる）
+	Loss    float64       // 0..1 のメッセージ損失率
+	Seed    int64         // 乱数の種（0 なら現在時刻）
+	// Tap は配送（または損失）のたびに呼ばれます（シミュレータの計測用）。
+	Tap func(from, to PeerRef, payload []byte, dropped bool)
+}
+
+// LoopbackStats はネットワーク全体の送信数・配送数・損失数です。
+type LoopbackStats struct {
+	Sent      int
+	Delivered int
+	Dropped   int
+}
+
+// LoopbackNetwork は同じプロセス内の LoopbackTransport をつなぐ仮想ネットワークです。
+// 実行中の親機と子機は（Disconnect していなければ）全て接続されます。
+type LoopbackNetwork struct {
+	mu    sync.Mutex
+	opts  LoopbackOptions
+	rng   *rand.Rand
+	nodes map[string]*LoopbackTransport
+	down  map[string]bool
+	links map[string]bool
+	stats LoopbackStats
+}
+
+func NewLoopbackNetwork(opts LoopbackOptions) *LoopbackNetwork {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &LoopbackNetwork{
+		opts:  opts,
+		rng:   rand.New(rand.NewSource(seed)),
+		nodes: map[string]*LoopbackTransport{},
+		down:  map[string]bool{},
+		links: map[string]bool{},
+	}
+}
+
+// SetOptions は回線品質を変更します（Seed は無視します）。
+func (n *LoopbackNetwork) SetOptions(opts LoopbackOptions) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.opts = opts
+}
+
+func (n *LoopbackNetwork) Stats() LoopbackStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// NewTransport は peerID のノードをネットワークに加えます。
+func (n *LoopbackNetwork) NewTransport(peerID string) *LoopbackTransport {
+	peerID = strings.TrimSpace(peerID)
+	t := &LoopbackTransport{net: n, local: PeerRef{PeerID: peerID, Name: peerID, Platform: "loopback"}}
+	n.mu.Lock()
+	n.nodes[peerID] = t
+	n.mu.Unlock()
+	return t
+}
+
+// Disconnect は peerID の全ての接続を切ります（Connect まで再接続しません）。
+func (n *LoopbackNetwork) Disconnect(peerID string) {
+	n.mu.Lock()
+	n.down[peerID] = true
+	n.mu.Unlock()
+	n.relink()
+}
+
+// Connect は Disconnect したノードを戻します。
+func (n *LoopbackNetwork) Connect(peerID string) {
+	n.mu.Lock()
+	delete(n.down, peerID)
+	n.mu.Unlock()
+	n.relink()
+}
+
+type loopbackEvent struct {
+	to *LoopbackTransport
+	ev PeerEvent
+}
+
+// relink は接続状態を計算し直し、変わった組にだけ PeerEvent を送ります。
+func (n *LoopbackNetwork) relink() {
+	now := time.Now()
+	var events []loopbackEvent
+	n.mu.Lock()
+	for _, a := range n.nodes {
+		for _, b := range n.nodes {
+			if a.role != RoleParent || b.role != RoleChild {
+				continue
+			}
+			key := a.local.PeerID + "|" + b.local.PeerID
+			up := a.running && b.running && !n.down[a.local.PeerID] && !n.down[b.local.PeerID]
+			if up == n.links[key] {
+				continue
+			}
+			if up {
+				n.links[key] = true
+			} else {
+				delete(n.links, key)
+			}
+			events = append(events,
+				loopbackEvent{to: a, ev: PeerEvent{Peer: b.local, Connected: up, At: now}},
+				loopbackEvent{to: b, ev: PeerEvent{Peer: a.local, Connected: up, At: now}},
+			)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, e := range events {
+		if cb := e.to.peerEventHandler(); cb != nil {
+			cb(e.ev)
+		}
+	}
+}
+
+func (n *LoopbackNetwork) linkedLocked(a, b string) bool {
+	return n.links[a+"|"+b] || n.links[b+"|"+a]
+}
+
+func (n *LoopbackNetwork) send(from *LoopbackTransport, toID string, payload []byte) error {
+	n.mu.Lock()
+	to, ok := n.nodes[toID]
+	if !ok || !n.linkedLocked(from.local.PeerID, toID) {
+		n.mu.Unlock()
+		return fmt.Errorf("peer %s は接続されていません", toID)
+	}
+	n.stats.Sent++
+	opts := n.opts
+	lost := opts.Loss > 0 && n.rng.Float64() < opts.Loss
+	delay := opts.Latency
+	if opts.Jitter > 0 {
+		delay += time.Duration(n.rng.Int63n(int64(opts.Jitter) + 1))
+	}
+	if lost {
+		n.stats.Dropped++
+	}
+	n.mu.Unlock()
+
+	cp := make([]byte, len(payload))
+	copy(cp, payload)
+	fromRef := from.local
+	if lost {
+		if opts.Tap != nil {
+			opts.Tap(fromRef, to.local, cp, true)
+		}
+		return nil
+	}
+	time.AfterFunc(delay, func() {
+		n.mu.Lock()
+		alive := n.linkedLocked(fromRef.PeerID, toID)
+		if alive {
+			n.stats.Delivered++
+		} else {
+			n.stats.Dropped++
+		}
+		tap := n.opts.Tap
+		n.mu.Unlock()
+
+		if tap != nil {
+			tap(fromRef, to.local, cp, !alive)
+		}
+		if !alive {
+			return
+		}
+		if cb := to.messageHandler(); cb != nil {
+			cb(fromRef, cp)
+		}
+	})
+	return nil
+}
+
+// LoopbackTransport は LoopbackNetwork 上の Transport です（テスト・シミュレーション用）。
+type LoopbackTransport struct {
+	net *LoopbackNetwork
+
+	// 以下は net.mu で保護します
+	local       PeerRef
+	role        Role
+	running     bool
+	onMessage   func(from PeerRef, payload []byte)
+	onPeerEvent func(PeerEvent)
+}
+
+func (t *LoopbackTransport) messageHandler() func(PeerRef, []byte) {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	if !t.running {
+		return nil
+	}
+	return t.onMessage
+}
+
+func (t *LoopbackTransport) peerEventHandler() func(PeerEvent) {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	return t.onPeerEvent
+}
+
+func (t *LoopbackTransport) Start(ctx Context, role Role, deviceName string, onMessage func(from PeerRef, payload []byte), onPeerEvent func(PeerEvent)) error {
+	if err := t.SupportsRole(role); err != nil {
+		return err
+	}
+	t.net.mu.Lock()
+	if t.running {
+		t.net.mu.Unlock()
+		return nil
+	}
+	t.role = role
+	t.running = true
+	t.onMessage = onMessage
+	t.onPeerEvent = onPeerEvent
+	if name := strings.TrimSpace(deviceName); name != "" {
+		t.local.Name = name
+	}
+	t.net.mu.Unlock()
+	t.net.relink()
+
+	if ctx != nil {
+		go func() {
+			<-ctx.Done()
+			_ = t.Stop()
+		}()
+	}
+	return nil
+}
+
+func (t *LoopbackTransport) Stop() error {
+	t.net.mu.Lock()
+	running := t.running
+	t.running = false
+	t.net.mu.Unlock()
+	if running {
+		t.net.relink()
+	}
+	return nil
+}
+
+func (t *LoopbackTransport) Send(peerID string, payload []byte) error {
+	return t.net.send(t, peerID, payload)
+}
+
+func (t *LoopbackTransport) Broadcast(payload []byte) error {
+	t.net.mu.Lock()
+	var ids []string
+	for id := range t.net.nodes {
+		if id != t.local.PeerID && t.net.linkedLocked(t.local.PeerID, id) {
+			ids = append(ids, id)
+		}
+	}
+	t.net.mu.Unlock()
+
+	if len(ids) == 0 {
+		return errors.New("接続中のpeerがありません")
+	}
+	var errs []error
+	for _, id := range ids {
+		if err := t.Send(id, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *LoopbackTransport) SupportsRole(role Role) error {
+	switch role {
+	case RoleParent, RoleChild, RoleOff:
+		return nil
+	default:
+		return fmt.Errorf("unsupported role: %s", role)
+	}
+}
+
+func (t *LoopbackTransport) LocalPeer() PeerRef {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	return t.local
+}
@@ -0,0 +1,96 @@
+package btsync
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoopbackPairDispatchAndDisconnect(t *testing.T) {
+	nw := NewLoopbackNetwork(LoopbackOptions{Latency: 2 * time.Millisecond, Jitter: 3 * time.Millisecond, Seed: 1})
+
+	var mu sync.Mutex
+	applied := map[string]int{}
+	start := func(id string, role Role) *Manager {
+		return startLoopbackManager(t, nw, id, role, ManagerOptions{
+			ApplyScene: func(scene string, _ Source) error {
+				mu.Lock()
+				applied[id]++
+				mu.Unlock()
+				return nil
+			},
+		}, Config{LeadTimeMs: 50})
+	}
+	parent := start("parent", RoleParent)
+	children := []*Manager{start("c1", RoleChild), start("c2", RoleChild)}
+
+	if st := parent.Status(); st.ConnectedPeers != 2 {
+		t.Fatalf("parent should see 2 children: %+v", st)
+	}
+	code, err := parent.GeneratePairingCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range children {
+		if err := c.JoinByCode(code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "pairing", func() bool {
+		for _, p := range parent.Status().Peers {
+			parent.mu.Lock()
+			secret := parent.peers[p.PeerID].Secret
+			parent.mu.Unlock()
+			if secret == "" {
+				return false
+			}
+		}
+		return true
+	})
+
+	if _, err := parent.DispatchScene("SceneA", SourceGUI); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "applied", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return applied["parent"] == 1 && applied["c1"] == 1 && applied["c2"] == 1
+	})
+
+	nw.Disconnect("c2")
+	if st := children[1].Status(); st.ParentConnected {
+		t.Fatalf("c2 should be disconnected: %+v", st)
+	}
+	if st := parent.Status(); st.ConnectedPeers != 1 {
+		t.Fatalf("parent should see 1 child: %+v", st)
+	}
+	nw.Connect("c2")
+	if st := children[1].Status(); !st.ParentConnected {
+		t.Fatalf("c2 should be reconnected: %+v", st)
+	}
+	if s := nw.Stats(); s.Sent == 0 || s.Dropped != 0 {
+		t.Fatalf("stats: %+v", s)
+	}
+}
+
+func TestLoopbackLoss(t *testing.T) {
+	nw := NewLoopbackNetwork(LoopbackOptions{Loss: 1, Seed: 1})
+	got := make(chan []byte, 1)
+	a, b := nw.NewTransport("a"), nw.NewTransport("b")
+	_ = a.Start(nil, RoleParent, "a", nil, nil)
+	_ = b.Start(nil, RoleChild, "b", func(_ PeerRef, p []byte) { got <- p }, nil)
+	if err := a.Send("b", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+		t.Fatal("message should be lost")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if s := nw.Stats(); s.Sent != 1 || s.Dropped != 1 {
+		t.Fatalf("stats: %+v", s)
+	}
+	if err := a.Send("nobody", []byte("x")); err == nil {
+		t.Fatal("send to unknown peer should fail")
+	}
+}
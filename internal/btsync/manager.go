@@ -2,6 +2,7 @@ package btsync
 
 import (
 	"context"
+	"crypto/ecdh"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
@@ -10,8 +11,6 @@ import (
 	"strings"
 	"sync"
 	"time"
-
-	"awesomeProject/internal/obsws"
 )
 
 type ManagerOptions struct {
@@ -19,6 +18,18 @@ type ManagerOptions struct {
 	SceneExists         func(scene string) (bool, error)
 	PersistTrustedPeers func(peers []TrustedPeer) error
 	Logf                func(level, msg string)
+	// シーン以外のコマンド（command）の実行先です。nil の種類を受けた子機は unsupported の ACK を返します
+	PreviewScene     func(scene string) error
+	TakePreview      func(transition string) error
+	MediaAction      func(input, action string) error
+	Record           func(action string) error
+	SetSourceVisible func(scene, source string, visible bool) error
+	TriggerHotkey    func(name string) error
+	// Clock は同期に使う時計です（nil なら time.Now。シミュレーションで時計のずれを再現するときに使う）
+	Clock func() time.Time
+	// CurrentScene は OBS の今のプログラムシーンを返します（状態同期で使う。nil なら同期で最後に切り替えたシーン、
+	// 空なら子機は親機のシーンへ合わせ直す）
+	CurrentScene func() (string, error)
 }
 
 type peerState struct {
@@ -28,6 +39,14 @@ type peerState struct {
 	LastAckAt     time.Time
 	LastAckStatus string
 	LastLatencyMs int64
+	clock         clockFilter
+	rtt           latencyFilter // 親機が ping で測った往復時間（latency.go）
+	auth          authState
+
+	// 鍵の更新（peers.go）: 要求側は切り替え後しばらく古い鍵も受け付け、応答側は相手が新しい鍵を使うまで保留する
+	prevSecret string
+	nextSecret string
+	rekey      *ecdh.PrivateKey
 }
 
 type Manager struct {
@@ -36,6 +55,10 @@ type Manager struct {
 	transport Transport
 	opts      ManagerOptions
 
+	// autoTransport は Config.Transport からトランスポートを選ぶとき true（NewManager に nil を渡した場合）
+	autoTransport bool
+	transportKey  string
+
 	running bool
 	role    Role
 	cancel  context.CancelFunc
@@ -43,29 +66,90 @@ type Manager struct {
 	pairingCode    string
 	pairingExpires time.Time
 
+	// ペアリング（pairing.go）: 親機は進行中のセッションと失敗回数、子機は自分の SPAKE2 の状態
+	pairSessions map[string]*pairSession
+	pairFailures map[string]*pairAttempts
+	codeFailures int
+	joinPake     *pakeSide
+	joinCode     string // joinPake のコード（同じコードでの再送は同じ値を送り、親機に新しい推測と数えさせない）
+	joinKeys     map[string]pakeKeys
+	session      string // 起動ごとのセッション ID（送信メッセージに付ける）
+
 	seenEvents  map[string]time.Time
 	peers       map[string]*peerState
-	pendingAcks map[string]map[string]time.Time
+	pendingAcks map[string]*pendingEvent // 結果を待っている配送（delivery.go）
+	lastScene   Message                  // 親機が最後に送ったシーン切替（状態同期で送る。state.go）
+
+	// 子機の状態同期（state.go）: 同期で最後に切り替えたシーン、発火待ちのシーン切替の数、合わせられなかったシーン
+	appliedScene string
+	firing       int
+	stateMissing string
 
 	lastError string
 }
 
+// NewManager は同期マネージャを作ります。transport が nil なら Config.Transport（bluetooth / lan）から選びます。
 func NewManager(transport Transport, opts ManagerOptions) *Manager {
 	m := &Manager{
-		transport:   transport,
-		opts:        opts,
-		cfg:         Config{}.Normalize(),
-		seenEvents:  map[string]time.Time{},
-		peers:       map[string]*peerState{},
-		pendingAcks: map[string]map[string]time.Time{},
+		transport:    transport,
+		opts:         opts,
+		cfg:          Config{}.Normalize(),
+		seenEvents:   map[string]time.Time{},
+		peers:        map[string]*peerState{},
+		pendingAcks:  map[string]*pendingEvent{},
+		pairSessions: map[string]*pairSession{},
+		pairFailures: map[string]*pairAttempts{},
+	}
+	if transport == nil {
+		m.autoTransport = true
+		m.selectTransportLocked()
 	}
 	return m
 }
 
+// NewTransport は cfg.Transport に応じたトランスポートを返します。
+func NewTransport(cfg Config) Transport {
+	cfg = cfg.Normalize()
+	if cfg.Transport == TransportLAN {
+		return NewLANTransport(cfg.LANPort, cfg.LANParentAddr)
+	}
+	return NewNativeTransport()
+}
+
 func (m *Manager) SetConfig(cfg Config) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	m.cfg = cfg.Normalize()
+	running := m.running
+	if !running {
+		m.selectTransportLocked()
+		m.loadTrustedPeersLocked()
+	}
+	m.mu.Unlock()
+	if running {
+		m.applyReconnectPolicy()
+	}
+}
+
+// loadTrustedPeersLocked は設定の TrustedPeers から peer の一覧を作り直します（停止中でも管理できるように）。
+func (m *Manager) loadTrustedPeersLocked() {
+	m.peers = map[string]*peerState{}
+	for _, p := range m.cfg.TrustedPeers {
+		cp := p
+		m.peers[p.PeerID] = &peerState{TrustedPeer: cp}
+	}
+}
+
+// selectTransportLocked は停止中に transport / LAN の設定が変わっていればトランスポートを作り直します。
+func (m *Manager) selectTransportLocked() {
+	if !m.autoTransport {
+		return
+	}
+	key := fmt.Sprintf("%s|%d|%s", m.cfg.Transport, m.cfg.LANPort, m.cfg.LANParentAddr)
+	if m.transport != nil && key == m.transportKey {
+		return
+	}
+	m.transport = NewTransport(m.cfg)
+	m.transportKey = key
 }
 
 func (m *Manager) Start() error {
@@ -76,19 +160,20 @@ func (m *Manager) Start() error {
 	}
 	cfg := m.cfg.Normalize()
 	m.cfg = cfg
-	m.peers = map[string]*peerState{}
-	for _, p := range cfg.TrustedPeers {
-		cp := p
-		m.peers[p.PeerID] = &peerState{TrustedPeer: cp}
-	}
+	m.selectTransportLocked()
+	transport := m.transport
+	m.loadTrustedPeersLocked()
 	m.seenEvents = map[string]time.Time{}
-	m.pendingAcks = map[string]map[string]time.Time{}
+	m.pendingAcks = map[string]*pendingEvent{}
+	m.lastScene = Message{}
+	m.appliedScene = ""
+	m.stateMissing = ""
 
 	if !cfg.Enabled || cfg.Role == RoleOff {
 		m.mu.Unlock()
-		return errors.New("Bluetooth同期は無効です")
+		return errors.New("同期は無効です")
 	}
-	if err := m.transport.SupportsRole(cfg.Role); err != nil {
+	if err := transport.SupportsRole(cfg.Role); err != nil {
 		m.lastError = err.Error()
 		m.mu.Unlock()
 		return err
@@ -98,10 +183,12 @@ func (m *Manager) Start() error {
 	m.cancel = cancel
 	m.running = true
 	m.role = cfg.Role
+	m.session = newSessionID(m.now())
 	m.lastError = ""
 	m.mu.Unlock()
 
-	if err := m.transport.Start(ctx, cfg.Role, cfg.DeviceName, m.onTransportMessage, m.onTransportPeerEvent); err != nil {
+	m.applyReconnectPolicy()
+	if err := transport.Start(ctx, cfg.Role, cfg.DeviceName, m.onTransportMessage, m.onTransportPeerEvent); err != nil {
 		m.mu.Lock()
 		m.running = false
 		m.role = RoleOff
@@ -112,7 +199,7 @@ func (m *Manager) Start() error {
 		return err
 	}
 
-	m.log("info", fmt.Sprintf("Bluetooth同期を開始しました (role=%s)", cfg.Role))
+	m.log("info", fmt.Sprintf("同期を開始しました (role=%s, transport=%s)", cfg.Role, cfg.Transport))
 	go m.houseKeepingLoop(ctx)
 	go m.heartbeatLoop(ctx)
 	return nil
@@ -122,82 +209,65 @@ func (m *Manager) Stop() error {
 	m.mu.Lock()
 	cancel := m.cancel
 	wasRunning := m.running
+	transport := m.transport
 	m.running = false
 	m.role = RoleOff
 	m.cancel = nil
-	m.pendingAcks = map[string]map[string]time.Time{}
+	aborted := m.abortPendingLocked()
 	m.pairingCode = ""
 	m.pairingExpires = time.Time{}
+	m.pairSessions = map[string]*pairSession{}
+	m.joinPake = nil
+	m.joinCode = ""
+	m.joinKeys = nil
 	m.mu.Unlock()
 
 	if cancel != nil {
 		cancel()
 	}
-	err := m.transport.Stop()
+	for _, d := range aborted {
+		d.settle(AckTimeout, "同期を停止しました")
+	}
+	err := transport.Stop()
 	if wasRunning {
-		m.log("info", "Bluetooth同期を停止しました")
+		m.log("info", "同期を停止しました")
 	}
 	return err
 }
 
-func (m *Manager) DispatchScene(scene string, source Source) error {
+// DispatchScene は親機から全台へシーン切替を送り、発火時刻に親機でも切り替えます。
+// 返す Delivery で peer ごとの配送結果（再送回数と ACK）を確認できます。
+func (m *Manager) DispatchScene(scene string, source Source) (*Delivery, error) {
 	scene = strings.TrimSpace(scene)
 	if scene == "" {
-		return errors.New("シーン名が空です")
+		return nil, errors.New("シーン名が空です")
 	}
 
 	m.mu.Lock()
 	cfg := m.cfg.Normalize()
 	if !m.running || cfg.Role != RoleParent {
 		m.mu.Unlock()
-		return errors.New("親機モードで起動中ではありません")
+		return nil, errors.New("親機モードで起動中ではありません")
 	}
 
-	fireAt := time.Now().Add(time.Duration(cfg.LeadTimeMs) * time.Millisecond)
+	now := m.now()
+	lead, _ := m.leadTimeLocked()
+	fireAt := now.Add(lead)
 	evt := Message{
-		Type:            MsgSceneCommand,
-		ProtocolVersion: ProtocolVersion,
-		EventID:         randomHex(16),
-		SceneName:       scene,
-		Source:          string(source),
-		FireAtUnixMs:    fireAt.UnixMilli(),
-		SentAtUnixMs:    time.Now().UnixMilli(),
-	}
-
-	peers := make([]*peerState, 0, len(m.peers))
-	for _, p := range m.peers {
-		cp := *p
-		peers = append(peers, &cp)
-	}
-
-	m.pendingAcks[evt.EventID] = map[string]time.Time{}
-	for _, p := range peers {
-		if p.Connected {
-			m.pendingAcks[evt.EventID][p.PeerID] = time.Now().Add(3 * time.Second)
-		}
-	}
+		Type:         MsgSceneCommand,
+		EventID:      randomHex(16),
+		SceneName:    scene,
+		Source:       string(source),
+		FireAtUnixMs: fireAt.UnixMilli(),
+		SentAtUnixMs: now.UnixMilli(),
+	}
+	m.lastScene = evt
 	m.mu.Unlock()
 
-	for _, p := range peers {
-		if !p.Connected || strings.TrimSpace(p.Secret) == "" {
-			continue
-		}
-		msg := evt
-		msg.HMAC = sceneHMAC(p.Secret, msg)
-		payload, err := msg.Marshal()
-		if err != nil {
-			m.log("error", fmt.Sprintf("scene_command シリアライズ失敗 [%s]: %v", p.PeerID, err))
-			continue
-		}
-		if err := m.transport.Send(p.PeerID, payload); err != nil {
-			m.log("error", fmt.Sprintf("scene_command 送信失敗 [%s]: %v", p.PeerID, err))
-			m.dropPendingAck(evt.EventID, p.PeerID)
-			continue
-		}
-	}
+	d := m.sendEvent(evt, fireAt.Add(time.Duration(cfg.AcceptLateMs)*time.Millisecond))
 
 	go func(sceneName string, src Source, fire time.Time) {
-		obsws.WaitUntil(fire, 2*time.Millisecond)
+		m.waitUntil(fire)
 		if err := m.applyScene(sceneName, src); err != nil {
 			m.log("error", fmt.Sprintf("親機ローカル切替失敗: %v", err))
 			return
@@ -205,7 +275,7 @@ func (m *Manager) DispatchScene(scene string, source Source) error {
 		m.log("info", fmt.Sprintf("親機ローカル切替: %s", sceneName))
 	}(scene, source, fireAt)
 
-	return nil
+	return d, nil
 }
 
 func (m *Manager) GeneratePairingCode() (string, error) {
@@ -218,6 +288,8 @@ func (m *Manager) GeneratePairingCode() (string, error) {
 	ttl := time.Duration(m.cfg.PairingCodeTTLSec) * time.Second
 	m.pairingCode = code
 	m.pairingExpires = time.Now().Add(ttl)
+	m.pairSessions = map[string]*pairSession{}
+	m.codeFailures = 0
 	return code, nil
 }
 
@@ -237,13 +309,28 @@ func (m *Manager) JoinByCode(code string) error {
 		return errors.New("子機モードで起動中ではありません")
 	}
 
+	m.mu.Lock()
+	side := m.joinPake
+	if side == nil || m.joinCode != code {
+		var err error
+		if side, err = newPakeSide(code, true); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		m.joinPake = side
+		m.joinCode = code
+		m.joinKeys = map[string]pakeKeys{}
+	}
+	m.mu.Unlock()
+
 	msg := Message{
-		Type:         MsgPairRequest,
-		PairingCode:  code,
-		PeerID:       peer.PeerID,
-		PeerName:     peer.Name,
-		Platform:     peer.Platform,
-		SentAtUnixMs: time.Now().UnixMilli(),
+		Type:            MsgPairRequest,
+		ProtocolVersion: ProtocolVersion,
+		Pake:            side.Public(),
+		PeerID:          peer.PeerID,
+		PeerName:        peer.Name,
+		Platform:        peer.Platform,
+		SentAtUnixMs:    m.now().UnixMilli(),
 	}
 	payload, err := msg.Marshal()
 	if err != nil {
@@ -267,11 +354,15 @@ func (m *Manager) Status() Status {
 		Running:             m.running,
 		Enabled:             m.cfg.Enabled,
 		Role:                m.cfg.Role,
+		Transport:           m.cfg.Transport,
 		DeviceName:          m.cfg.DeviceName,
 		LastError:           m.lastError,
 		PairingCodeActive:   m.pairingCode != "" && time.Now().Before(m.pairingExpires),
 		Peers:               []PeerStatus{},
 	}
+	lead, auto := m.leadTimeLocked()
+	st.LeadTimeMs = int(lead / time.Millisecond)
+	st.LeadTimeAuto = auto
 	if !st.SupportedRoleParent && !st.SupportedRoleChild {
 		st.Supported = false
 		st.UnsupportedReason = "このビルドではBluetooth同期が利用できません"
@@ -285,11 +376,22 @@ func (m *Manager) Status() Status {
 		ps := PeerStatus{
 			PeerID:        p.PeerID,
 			Name:          p.Name,
+			Alias:         p.Alias,
 			Platform:      p.Platform,
 			Connected:     p.Connected,
+			Paired:        strings.TrimSpace(p.Secret) != "",
+			Blocked:       p.Blocked,
 			LastAckStatus: p.LastAckStatus,
 			LastLatencyMs: p.LastLatencyMs,
 		}
+		if rtt, ok := p.rtt.max(); ok {
+			ps.RTTMs = ms(rtt)
+		}
+		if p.clock.ok {
+			ps.ClockSynced = true
+			ps.ClockOffsetMs = ms(p.clock.best.offset)
+			ps.ClockUncertaintyMs = ms(p.clock.uncertainty())
+		}
 		if !p.LastSeenAt.IsZero() {
 			ps.LastSeenUnixMs = p.LastSeenAt.UnixMilli()
 		}
@@ -297,12 +399,14 @@ func (m *Manager) Status() Status {
 			ps.LastAckUnixMs = p.LastAckAt.UnixMilli()
 		}
 		peers = append(peers, ps)
-		if p.Connected {
+		if p.Connected && !p.Blocked {
 			st.ConnectedPeers++
 		}
 	}
 	sort.Slice(peers, func(i, j int) bool {
-		return strings.ToLower(peers[i].Name+peers[i].PeerID) < strings.ToLower(peers[j].Name+peers[j].PeerID)
+		a, b := peers[i], peers[j]
+		return strings.ToLower(TrustedPeer{Name: a.Name, Alias: a.Alias}.DisplayName()+a.PeerID) <
+			strings.ToLower(TrustedPeer{Name: b.Name, Alias: b.Alias}.DisplayName()+b.PeerID)
 	})
 	st.Peers = peers
 
@@ -315,15 +419,39 @@ func (m *Manager) Status() Status {
 
 func (m *Manager) onTransportPeerEvent(ev PeerEvent) {
 	m.mu.Lock()
+	if m.blockedLocked(ev.Peer.PeerID) {
+		m.peers[ev.Peer.PeerID].Connected = ev.Connected
+		m.mu.Unlock()
+		return
+	}
 	p := m.ensurePeerLocked(ev.Peer)
+	was := p.Connected
 	p.Connected = ev.Connected
+	if !ev.Connected {
+		p.rtt.reset() // つなぎ直したら測り直す
+	}
 	if !ev.At.IsZero() {
 		p.LastSeenAt = ev.At
 	} else {
 		p.LastSeenAt = time.Now()
 	}
+	probe := ev.Connected && m.running
+	role := m.role
 	m.mu.Unlock()
 
+	if probe && role == RoleChild {
+		go m.probeClock(ev.Peer.PeerID)
+	}
+	if probe && role == RoleParent {
+		go m.probeLatency(ev.Peer.PeerID)
+	}
+	switch {
+	case ev.Connected && !was:
+		go m.onPeerReconnected(ev.Peer.PeerID)
+	case !ev.Connected && was:
+		m.onPeerLost(ev.Peer.PeerID)
+	}
+
 	if ev.Connected {
 		m.log("info", fmt.Sprintf("BT peer connected: %s (%s)", ev.Peer.Name, ev.Peer.PeerID))
 	} else {
@@ -332,6 +460,7 @@ func (m *Manager) onTransportPeerEvent(ev PeerEvent) {
 }
 
 func (m *Manager) onTransportMessage(from PeerRef, payload []byte) {
+	receivedAt := m.now()
 	msg, err := UnmarshalMessage(payload)
 	if err != nil {
 		m.log("error", fmt.Sprintf("BT受信メッセージの解析失敗: %v", err))
@@ -339,6 +468,10 @@ func (m *Manager) onTransportMessage(from PeerRef, payload []byte) {
 	}
 
 	m.mu.Lock()
+	if m.blockedLocked(from.PeerID) {
+		m.mu.Unlock()
+		return
+	}
 	running := m.running
 	role := m.role
 	p := m.ensurePeerLocked(from)
@@ -348,6 +481,19 @@ func (m *Manager) onTransportMessage(from PeerRef, payload []byte) {
 	if !running {
 		return
 	}
+	if needsAuth(msg) {
+		if err := m.authenticate(from, msg); err != nil {
+			if errors.Is(err, errProtocolVersion) {
+				// 旧バージョンの親機にも分かるよう、認証なしの ACK で更新が必要なことだけ返す
+				if role == RoleChild && msg.Type == MsgSceneCommand {
+					m.sendLegacyAck(from.PeerID, msg)
+				}
+				return
+			}
+			m.log("error", fmt.Sprintf("メッセージを破棄しました [%s] type=%s: %v", from.PeerID, msg.Type, err))
+			return
+		}
+	}
 
 	switch msg.Type {
 	case MsgPairRequest:
@@ -358,111 +504,83 @@ func (m *Manager) onTransportMessage(from PeerRef, payload []byte) {
 		if role == RoleChild {
 			m.handlePairAccept(from, msg)
 		}
+	case MsgPairConfirm:
+		if role == RoleParent {
+			m.handlePairConfirm(from, msg)
+		}
 	case MsgSceneCommand:
 		if role == RoleChild {
 			m.handleSceneCommand(from, msg)
 		}
-	case MsgSceneAck:
+	case MsgCommand:
+		if role == RoleChild {
+			m.handleCommand(from, msg)
+		}
+	case MsgSceneAck, MsgCommandAck:
 		if role == RoleParent {
-			m.handleSceneAck(from, msg)
+			m.handleAck(from, msg)
 		}
 	case MsgHeartbeat:
-		// peer alive
+		if role == RoleParent {
+			m.handleHeartbeat(from, msg, receivedAt)
+		}
+	case MsgHeartbeatReply:
+		if role == RoleChild {
+			m.handleHeartbeatReply(from, msg, receivedAt)
+		}
+	case MsgPing:
+		if role == RoleChild {
+			m.handlePing(from, msg)
+		}
+	case MsgPong:
+		if role == RoleParent {
+			m.handlePong(from, msg, receivedAt)
+		}
+	case MsgState:
+		if role == RoleChild {
+			m.handleState(from, msg)
+		}
+	case MsgPeerRevoke:
+		m.handlePeerRevoke(from)
+	case MsgRekey:
+		m.handleRekey(from, msg)
 	default:
 		m.log("info", fmt.Sprintf("未定義メッセージ type=%s from=%s", msg.Type, from.PeerID))
 	}
 }
 
-func (m *Manager) handlePairRequest(from PeerRef, msg Message) {
+// firstSeen は EventID を初めて受けたときだけ true を返します（親機の再送を重複して実行しない）。
+func (m *Manager) firstSeen(eventID string) bool {
 	m.mu.Lock()
-	cfg := m.cfg.Normalize()
-	now := time.Now()
-	valid := m.pairingCode != "" && now.Before(m.pairingExpires) && subtleEqual(m.pairingCode, strings.TrimSpace(msg.PairingCode))
-	if !valid {
-		m.mu.Unlock()
-		_ = m.sendPairAccept(from.PeerID, "denied", "ペアリングコードが無効です", "")
-		return
-	}
-
-	if _, ok := m.peers[from.PeerID]; !ok {
-		if cfg.MaxNodes > 0 && len(m.peers) >= cfg.MaxNodes-1 {
-			m.mu.Unlock()
-			_ = m.sendPairAccept(from.PeerID, "denied", "最大接続台数に達しました", "")
-			return
-		}
-	}
-
-	secret := randomHex(32)
-	p := m.ensurePeerLocked(from)
-	p.Secret = secret
-	p.Platform = from.Platform
-	p.Name = from.Name
-	p.LastSeenAt = now
-	p.Connected = true
-	peers := m.trustedPeersLocked()
-	m.mu.Unlock()
-
-	m.persistTrustedPeers(peers)
-	if err := m.sendPairAccept(from.PeerID, "ok", "", secret); err != nil {
-		m.log("error", fmt.Sprintf("pair_accept送信失敗: %v", err))
-		return
+	defer m.mu.Unlock()
+	m.gcSeenLocked(time.Now())
+	if t, ok := m.seenEvents[eventID]; ok && time.Since(t) < 30*time.Second {
+		return false
 	}
-	m.log("info", fmt.Sprintf("ペアリング成功: %s (%s)", from.Name, from.PeerID))
+	m.seenEvents[eventID] = time.Now()
+	m.trimSeenLocked()
+	return true
 }
 
-func (m *Manager) handlePairAccept(from PeerRef, msg Message) {
-	if msg.Status != "ok" {
-		if strings.TrimSpace(msg.Error) == "" {
-			m.log("error", "ペアリング拒否: unknown")
-		} else {
-			m.log("error", fmt.Sprintf("ペアリング拒否: %s", msg.Error))
-		}
-		return
-	}
-	if strings.TrimSpace(msg.Secret) == "" {
-		m.log("error", "pair_accept に secret がありません")
-		return
-	}
-
+// fireTime は親機の時計の発火時刻を自分の時計に直し、遅れすぎていないかを返します。
+func (m *Manager) fireTime(from PeerRef, msg Message) (time.Time, bool) {
 	m.mu.Lock()
-	p := m.ensurePeerLocked(from)
-	p.Secret = msg.Secret
-	p.Connected = true
-	p.LastSeenAt = time.Now()
-	peers := m.trustedPeersLocked()
+	cfg := m.cfg.Normalize()
 	m.mu.Unlock()
-
-	m.persistTrustedPeers(peers)
-	m.log("info", fmt.Sprintf("親機とのペアリング完了: %s", from.Name))
+	fireAt := m.localTime(from.PeerID, time.UnixMilli(msg.FireAtUnixMs))
+	return fireAt, !m.now().After(fireAt.Add(time.Duration(cfg.AcceptLateMs) * time.Millisecond))
 }
 
 func (m *Manager) handleSceneCommand(from PeerRef, msg Message) {
-	if msg.ProtocolVersion != ProtocolVersion {
-		_ = m.sendSceneAck(from.PeerID, msg.EventID, AckError, "unsupported protocol", 0)
-		return
-	}
-
-	m.mu.Lock()
-	p := m.ensurePeerLocked(from)
-	secret := p.Secret
-	cfg := m.cfg.Normalize()
-
-	m.gcSeenLocked(time.Now())
-	if t, ok := m.seenEvents[msg.EventID]; ok && time.Since(t) < 30*time.Second {
-		m.mu.Unlock()
-		return
-	}
-	m.seenEvents[msg.EventID] = time.Now()
-	m.trimSeenLocked()
-	m.mu.Unlock()
-
-	if strings.TrimSpace(secret) == "" || !verifySceneHMAC(secret, msg) {
-		_ = m.sendSceneAck(from.PeerID, msg.EventID, AckError, "invalid_hmac", 0)
+	// 受け取ったことをすぐ返して親機の再送を止める（再送で重複して届いた分にも返し直す）
+	first := m.firstSeen(msg.EventID)
+	_ = m.sendSceneAck(from.PeerID, msg.EventID, AckReceived, "", 0)
+	if !first {
 		return
 	}
 
-	fireAt := time.UnixMilli(msg.FireAtUnixMs)
-	if time.Now().After(fireAt.Add(time.Duration(cfg.AcceptLateMs) * time.Millisecond)) {
+	fireAt, inTime := m.fireTime(from, msg)
+	if !inTime {
 		_ = m.sendSceneAck(from.PeerID, msg.EventID, AckLateDrop, "late_drop", 0)
 		return
 	}
@@ -477,71 +595,61 @@ func (m *Manager) handleSceneCommand(from PeerRef, msg Message) {
 		return
 	}
 
-	obsws.WaitUntil(fireAt, 2*time.Millisecond)
-	if err := m.applyScene(msg.SceneName, Source(msg.Source)); err != nil {
+	m.mu.Lock()
+	m.firing++
+	m.mu.Unlock()
+	m.waitUntil(fireAt)
+	err = m.applyScene(msg.SceneName, Source(msg.Source))
+	m.mu.Lock()
+	m.firing--
+	if err == nil {
+		m.appliedScene = msg.SceneName
+	}
+	m.mu.Unlock()
+	if err != nil {
 		_ = m.sendSceneAck(from.PeerID, msg.EventID, AckError, err.Error(), 0)
 		return
 	}
 
-	latency := time.Since(fireAt).Milliseconds()
+	latency := m.now().Sub(fireAt).Milliseconds()
 	if latency < 0 {
 		latency = 0
 	}
 	_ = m.sendSceneAck(from.PeerID, msg.EventID, AckOK, "", latency)
 }
 
-func (m *Manager) handleSceneAck(from PeerRef, msg Message) {
+func (m *Manager) handleAck(from PeerRef, msg Message) {
+	m.recordAck(from, msg)
+	if msg.Status == string(AckReceived) {
+		return
+	}
+
 	m.mu.Lock()
 	p := m.ensurePeerLocked(from)
 	p.LastAckAt = time.Now()
 	p.LastAckStatus = msg.Status
 	p.LastLatencyMs = msg.LatencyMs
-	if pending, ok := m.pendingAcks[msg.EventID]; ok {
-		delete(pending, from.PeerID)
-		if len(pending) == 0 {
-			delete(m.pendingAcks, msg.EventID)
-		}
-	}
 	m.mu.Unlock()
 
+	event := msg.EventID
+	if msg.Command != nil {
+		event += " command=" + string(msg.Command.Kind)
+	}
 	if msg.Status != string(AckOK) {
-		m.log("error", fmt.Sprintf("ACK失敗 [%s] event=%s status=%s err=%s", from.Name, msg.EventID, msg.Status, msg.Error))
+		m.log("error", fmt.Sprintf("ACK失敗 [%s] event=%s status=%s err=%s", from.Name, event, msg.Status, msg.Error))
 		return
 	}
-	m.log("info", fmt.Sprintf("ACK受信 [%s] event=%s latency=%dms", from.Name, msg.EventID, msg.LatencyMs))
-}
-
-func (m *Manager) sendPairAccept(peerID, status, errText, secret string) error {
-	msg := Message{
-		Type:            MsgPairAccept,
-		Status:          status,
-		Error:           errText,
-		Secret:          secret,
-		ProtocolVersion: ProtocolVersion,
-		SentAtUnixMs:    time.Now().UnixMilli(),
-	}
-	payload, err := msg.Marshal()
-	if err != nil {
-		return err
-	}
-	return m.transport.Send(peerID, payload)
+	m.log("info", fmt.Sprintf("ACK受信 [%s] event=%s latency=%dms", from.Name, event, msg.LatencyMs))
 }
 
 func (m *Manager) sendSceneAck(peerID, eventID string, status AckStatus, errText string, latencyMs int64) error {
-	msg := Message{
-		Type:            MsgSceneAck,
-		ProtocolVersion: ProtocolVersion,
-		EventID:         eventID,
-		Status:          string(status),
-		Error:           errText,
-		LatencyMs:       latencyMs,
-		SentAtUnixMs:    time.Now().UnixMilli(),
-	}
-	payload, err := msg.Marshal()
-	if err != nil {
-		return err
-	}
-	return m.transport.Send(peerID, payload)
+	return m.sendSealed(peerID, Message{
+		Type:      MsgSceneAck,
+		EventID:   eventID,
+		Status:    string(status),
+		Error:     errText,
+		LatencyMs: latencyMs,
+	})
 }
 
 func (m *Manager) houseKeepingLoop(ctx context.Context) {
@@ -552,7 +660,7 @@ func (m *Manager) houseKeepingLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			m.checkAckTimeouts()
+			m.expirePairSessions()
 			m.mu.Lock()
 			m.gcSeenLocked(time.Now())
 			m.mu.Unlock()
@@ -578,52 +686,20 @@ func (m *Manager) heartbeatLoop(ctx context.Context) {
 				continue
 			}
 
-			hb := Message{Type: MsgHeartbeat, ProtocolVersion: ProtocolVersion, SentAtUnixMs: time.Now().UnixMilli()}
-			payload, _ := hb.Marshal()
-
-			if role == RoleParent {
-				_ = m.transport.Broadcast(payload)
-				continue
-			}
-			if role == RoleChild {
-				for _, p := range peers {
-					_ = m.transport.Send(p.PeerID, payload)
+			var paired []string
+			for _, p := range peers {
+				if p.Secret == "" {
+					continue
+				}
+				if role == RoleParent {
+					_ = m.sendPing(p.PeerID) // 生存確認を兼ねて遅延を測る（latency.go）
+					paired = append(paired, p.PeerID)
+				} else if role == RoleChild {
+					_ = m.sendHeartbeat(p.PeerID)
 				}
 			}
-		}
-	}
-}
-
-func (m *Manager) checkAckTimeouts() {
-	now := time.Now()
-	timedOut := []string{}
-
-	m.mu.Lock()
-	for evtID, peers := range m.pendingAcks {
-		for peerID, dl := range peers {
-			if now.After(dl) {
-				timedOut = append(timedOut, fmt.Sprintf("event=%s peer=%s", evtID, peerID))
-				delete(peers, peerID)
-			}
-		}
-		if len(peers) == 0 {
-			delete(m.pendingAcks, evtID)
-		}
-	}
-	m.mu.Unlock()
-
-	for _, s := range timedOut {
-		m.log("error", "ACKタイムアウト: "+s)
-	}
-}
-
-func (m *Manager) dropPendingAck(eventID, peerID string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	if peers, ok := m.pendingAcks[eventID]; ok {
-		delete(peers, peerID)
-		if len(peers) == 0 {
-			delete(m.pendingAcks, eventID)
+			// 親機は今のシーンも送り、切断中に逃した子機をそろえる（state.go）
+			m.sendState(paired...)
 		}
 	}
 }
@@ -643,9 +719,13 @@ func (m *Manager) ensurePeerLocked(peer PeerRef) *peerState {
 	return p
 }
 
+// trustedPeersLocked は保存する peer（ペアリング済み・ブロック中・表示名を付けたもの）を返します。
 func (m *Manager) trustedPeersLocked() []TrustedPeer {
 	out := make([]TrustedPeer, 0, len(m.peers))
 	for _, p := range m.peers {
+		if strings.TrimSpace(p.Secret) == "" && !p.Blocked && p.Alias == "" {
+			continue
+		}
 		cp := p.TrustedPeer
 		if !p.LastSeenAt.IsZero() {
 			cp.LastSeen = p.LastSeenAt.UTC().Format(time.RFC3339)
@@ -653,11 +733,16 @@ func (m *Manager) trustedPeersLocked() []TrustedPeer {
 		out = append(out, cp)
 	}
 	sort.Slice(out, func(i, j int) bool {
-		return strings.ToLower(out[i].Name+out[i].PeerID) < strings.ToLower(out[j].Name+out[j].PeerID)
+		return strings.ToLower(out[i].DisplayName()+out[i].PeerID) < strings.ToLower(out[j].DisplayName()+out[j].PeerID)
 	})
 	return out
 }
 
+func (m *Manager) blockedLocked(peerID string) bool {
+	p, ok := m.peers[peerID]
+	return ok && p.Blocked
+}
+
 func (m *Manager) gcSeenLocked(now time.Time) {
 	for k, t := range m.seenEvents {
 		if now.Sub(t) > 30*time.Second {
@@ -700,6 +785,11 @@ func (m *Manager) sceneExists(scene string) (bool, error) {
 }
 
 func (m *Manager) persistTrustedPeers(peers []TrustedPeer) {
+	// 停止中の管理操作や次の Start でも使えるよう、手元の設定にも反映しておく
+	m.mu.Lock()
+	m.cfg.TrustedPeers = append([]TrustedPeer(nil), peers...)
+	m.mu.Unlock()
+	m.applyReconnectPolicy()
 	if m.opts.PersistTrustedPeers == nil {
 		return
 	}
@@ -740,14 +830,3 @@ func randomDigits(n int) string {
 	}
 	return string(out)
 }
-
-func subtleEqual(a, b string) bool {
-	if len(a) != len(b) {
-		return false
-	}
-	var v byte
-	for i := 0; i < len(a); i++ {
-		v |= a[i] ^ b[i]
-	}
-	return v == 0
-}
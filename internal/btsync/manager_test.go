@@ -2,6 +2,7 @@ package btsync
 
 import (
 	"encoding/json"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -46,6 +47,19 @@ func (t *captureTransport) last(peerID string) (Message, bool) {
 	return m, true
 }
 
+// statuses は peerID へ送った ACK の status を順に返します。
+func (t *captureTransport) statuses(peerID string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []string
+	for _, b := range t.sent[peerID] {
+		if m, err := UnmarshalMessage(b); err == nil {
+			out = append(out, m.Status)
+		}
+	}
+	return out
+}
+
 func setupChildManager(t *testing.T) (*Manager, *captureTransport, *int, *time.Time) {
 	t.Helper()
 
@@ -80,23 +94,62 @@ func setupChildManager(t *testing.T) (*Manager, *captureTransport, *int, *time.T
 	return mgr, tr, &applyCount, &appliedAt
 }
 
-func TestSceneHMACVerify(t *testing.T) {
+var parentRef = PeerRef{PeerID: "parent", Name: "parent", Platform: "test"}
+
+// testParentSession は sealFromParent が使う親機のセッション ID です。
+var testParentSession = newSessionID(time.Now())
+
+// sealFromParent は親機が sendSealed で送るのと同じ形で認証情報を付けます。
+func sealFromParent(t *testing.T, msg Message, seq uint64) []byte {
+	t.Helper()
+	return sealFromParentSession(t, msg, testParentSession, seq)
+}
+
+func sealFromParentSession(t *testing.T, msg Message, session string, seq uint64) []byte {
+	t.Helper()
+	msg.ProtocolVersion = ProtocolVersion
+	msg.Session = session
+	msg.Seq = seq
+	if msg.SentAtUnixMs == 0 {
+		msg.SentAtUnixMs = time.Now().UnixMilli()
+	}
+	msg.HMAC = messageMAC(macKey("secret", RoleParent), msg)
+	payload, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestMessageMAC(t *testing.T) {
 	msg := Message{
-		Type:            MsgSceneCommand,
-		ProtocolVersion: ProtocolVersion,
-		EventID:         "evt-1",
-		SceneName:       "SceneA",
-		Source:          string(SourceGUI),
-		FireAtUnixMs:    time.Now().Add(100 * time.Millisecond).UnixMilli(),
-		SentAtUnixMs:    time.Now().UnixMilli(),
+		Type:         MsgSceneAck,
+		EventID:      "evt-1",
+		Status:       string(AckOK),
+		Session:      "s",
+		Seq:          1,
+		SentAtUnixMs: time.Now().UnixMilli(),
+	}
+	key := macKey("secret", RoleChild)
+	mac := messageMAC(key, msg)
+	msg.HMAC = mac
+	if messageMAC(key, msg) != mac {
+		t.Fatalf("MAC must not depend on the HMAC field")
 	}
-	msg.HMAC = sceneHMAC("secret", msg)
-	if !verifySceneHMAC("secret", msg) {
-		t.Fatalf("HMAC should verify")
+	for _, tamper := range []func(*Message){
+		func(m *Message) { m.Status = string(AckError) },
+		func(m *Message) { m.Seq = 2 },
+		func(m *Message) { m.Session = "other" },
+		func(m *Message) { m.LatencyMs = 1 },
+	} {
+		cp := msg
+		tamper(&cp)
+		if messageMAC(key, cp) == mac {
+			t.Fatalf("tampered message must change the MAC: %+v", cp)
+		}
 	}
-	msg.SceneName = "tampered"
-	if verifySceneHMAC("secret", msg) {
-		t.Fatalf("tampered message must fail HMAC verification")
+	if messageMAC(macKey("secret", RoleParent), msg) == mac {
+		t.Fatalf("keys must differ per direction so messages cannot be reflected")
 	}
 }
 
@@ -112,9 +165,7 @@ func TestChildDropsLateSceneCommand(t *testing.T) {
 		FireAtUnixMs:    time.Now().Add(-2 * time.Second).UnixMilli(),
 		SentAtUnixMs:    time.Now().Add(-2 * time.Second).UnixMilli(),
 	}
-	msg.HMAC = sceneHMAC("secret", msg)
-
-	mgr.handleSceneCommand(PeerRef{PeerID: "parent", Name: "parent", Platform: "test"}, msg)
+	mgr.onTransportMessage(parentRef, sealFromParent(t, msg, 1))
 
 	if *applyCount != 0 {
 		t.Fatalf("late command should not apply scene")
@@ -141,10 +192,10 @@ func TestChildDeduplicatesSceneCommand(t *testing.T) {
 		FireAtUnixMs:    fireAt.UnixMilli(),
 		SentAtUnixMs:    time.Now().UnixMilli(),
 	}
-	msg.HMAC = sceneHMAC("secret", msg)
-
-	mgr.handleSceneCommand(PeerRef{PeerID: "parent", Name: "parent", Platform: "test"}, msg)
-	mgr.handleSceneCommand(PeerRef{PeerID: "parent", Name: "parent", Platform: "test"}, msg)
+	// 同じフレームのリプレイは認証で、別 seq での再送は EventID で弾く
+	mgr.onTransportMessage(parentRef, sealFromParent(t, msg, 1))
+	mgr.onTransportMessage(parentRef, sealFromParent(t, msg, 1))
+	mgr.onTransportMessage(parentRef, sealFromParent(t, msg, 2))
 
 	time.Sleep(160 * time.Millisecond)
 
@@ -158,12 +209,9 @@ func TestChildDeduplicatesSceneCommand(t *testing.T) {
 		t.Fatalf("applied too early: fireAt=%v appliedAt=%v", fireAt, *appliedAt)
 	}
 
-	ack, ok := tr.last("parent")
-	if !ok {
-		t.Fatalf("expected ACK")
-	}
-	if ack.Status != string(AckOK) {
-		t.Fatalf("expected %s, got %s", AckOK, ack.Status)
+	// 受信確認 → 結果、再送分には受信確認だけを返し直す
+	if got := strings.Join(tr.statuses("parent"), ","); got != "received,ok,received" {
+		t.Fatalf("unexpected ACKs: %s", got)
 	}
 }
 
@@ -171,26 +219,161 @@ func TestChildRejectsTamperedHMAC(t *testing.T) {
 	mgr, tr, applyCount, _ := setupChildManager(t)
 
 	msg := Message{
+		Type:         MsgSceneCommand,
+		EventID:      "evt-bad-hmac",
+		SceneName:    "SceneA",
+		Source:       string(SourceGUI),
+		FireAtUnixMs: time.Now().Add(50 * time.Millisecond).UnixMilli(),
+	}
+	payload := sealFromParent(t, msg, 1)
+	tampered, _ := UnmarshalMessage(payload)
+	tampered.SceneName = "SceneB"
+	b, _ := tampered.Marshal()
+	mgr.onTransportMessage(parentRef, b)
+
+	unsigned := msg
+	unsigned.ProtocolVersion = ProtocolVersion
+	unsigned.Session = testParentSession
+	unsigned.Seq = 2
+	unsigned.SentAtUnixMs = time.Now().UnixMilli()
+	b, _ = unsigned.Marshal()
+	mgr.onTransportMessage(parentRef, b)
+
+	time.Sleep(100 * time.Millisecond)
+	if *applyCount != 0 {
+		t.Fatalf("tampered command should not apply scene")
+	}
+	if _, ok := tr.last("parent"); ok {
+		t.Fatalf("unauthenticated frames must be dropped without an ACK")
+	}
+}
+
+func TestChildRejectsReplayAndStaleFrames(t *testing.T) {
+	mgr, tr, applyCount, _ := setupChildManager(t)
+
+	hb := Message{Type: MsgHeartbeat}
+	for _, seq := range []uint64{5, 3, 4} {
+		mgr.onTransportMessage(parentRef, sealFromParent(t, hb, seq))
+	}
+	mgr.mu.Lock()
+	a := mgr.peers["parent"].auth
+	mgr.mu.Unlock()
+	if a.recvMax != 5 || a.recvWindow != 0b111 {
+		t.Fatalf("out-of-order frames within the window should be accepted: max=%d window=%b", a.recvMax, a.recvWindow)
+	}
+
+	cmd := func(id string) Message {
+		return Message{Type: MsgSceneCommand, EventID: id, SceneName: "SceneA", FireAtUnixMs: time.Now().Add(30 * time.Millisecond).UnixMilli()}
+	}
+	mgr.onTransportMessage(parentRef, sealFromParent(t, cmd("evt-replay"), 4))
+
+	mgr.mu.Lock()
+	mgr.peers["parent"].clock.set(0, time.Millisecond) // 送信時刻は時計差が分かってから比べる
+	mgr.mu.Unlock()
+	stale := cmd("evt-stale")
+	stale.SentAtUnixMs = time.Now().Add(-2 * maxMessageAge).UnixMilli()
+	mgr.onTransportMessage(parentRef, sealFromParent(t, stale, 6))
+
+	mgr.onTransportMessage(parentRef, sealFromParent(t, cmd("evt-old-seq"), 5+replayWindow+1))
+	mgr.onTransportMessage(parentRef, sealFromParent(t, cmd("evt-too-old"), 5))
+
+	time.Sleep(100 * time.Millisecond)
+	if *applyCount != 1 {
+		t.Fatalf("only the fresh command should apply, got %d", *applyCount)
+	}
+	if ack, ok := tr.last("parent"); !ok || ack.EventID != "evt-old-seq" || ack.Status != string(AckOK) {
+		t.Fatalf("unexpected ACK: %+v", ack)
+	}
+}
+
+func TestAuthStateRejectsOlderSession(t *testing.T) {
+	var a authState
+	now := time.Now()
+	s1, s2 := newSessionID(now), newSessionID(now.Add(time.Second))
+	if err := a.accept(s1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.accept(s2, 1); err != nil {
+		t.Fatalf("new session (peer restarted) should be accepted: %v", err)
+	}
+	if err := a.accept(s1, 11); err == nil {
+		t.Fatalf("frames from a replaced session must be rejected")
+	}
+	if err := a.accept(s2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.accept(newSessionID(now.Add(-time.Hour)), 1); err == nil {
+		t.Fatalf("sessions older than the current one are rejected however long ago they ended")
+	}
+	if err := a.accept("parent-session", 1); err == nil {
+		t.Fatalf("session IDs without a start time are rejected")
+	}
+}
+
+// TestReplayedHeartbeatCannotEndSession は盗聴した古いセッションの heartbeat をリプレイしても、
+// 今のセッションが終わらないことを確かめます（受信側の再起動直後も含む）。
+func TestReplayedHeartbeatCannotEndSession(t *testing.T) {
+	captured := time.Now().Add(-10 * time.Minute)
+	old := Message{Type: MsgHeartbeat, SentAtUnixMs: captured.UnixMilli()}
+	replay := sealFromParentSession(t, old, newSessionID(captured), 1)
+	live := func(seq uint64, id string) []byte {
+		return sealFromParent(t, Message{Type: MsgSceneCommand, EventID: id, SceneName: "SceneA", FireAtUnixMs: time.Now().Add(30 * time.Millisecond).UnixMilli()}, seq)
+	}
+
+	// 受信中のセッションより古いセッションは、時間が経っても受け付けない
+	mgr, tr, applyCount, _ := setupChildManager(t)
+	mgr.onTransportMessage(parentRef, sealFromParent(t, Message{Type: MsgHeartbeat}, 1))
+	mgr.onTransportMessage(parentRef, replay)
+	mgr.onTransportMessage(parentRef, live(2, "evt-after-replay"))
+	waitFor(t, "command after replay", func() bool {
+		ack, ok := tr.last("parent")
+		return ok && ack.EventID == "evt-after-replay" && ack.Status == string(AckOK)
+	})
+	time.Sleep(50 * time.Millisecond)
+	if *applyCount != 1 {
+		t.Fatalf("live session should still apply, got %d", *applyCount)
+	}
+
+	// 再起動した受信側は、古い送信時刻で新しいセッションを始めるフレームを受け付けない
+	mgr, tr, _, _ = setupChildManager(t)
+	mgr.onTransportMessage(parentRef, replay)
+	mgr.mu.Lock()
+	opened := mgr.peers["parent"].auth.recvSession
+	mgr.mu.Unlock()
+	if opened != "" {
+		t.Fatalf("stale frame must not open a session: %s", opened)
+	}
+	// 時計差の範囲内の古いセッションを先に受けても、今のセッションのほうが新しいので置き換わる
+	recent := time.Now().Add(-time.Minute)
+	mgr.onTransportMessage(parentRef, sealFromParentSession(t, Message{Type: MsgHeartbeat, SentAtUnixMs: recent.UnixMilli()}, newSessionID(recent), 1))
+	mgr.onTransportMessage(parentRef, live(3, "evt-after-restart"))
+	waitFor(t, "command after restart", func() bool {
+		ack, ok := tr.last("parent")
+		return ok && ack.EventID == "evt-after-restart" && ack.Status == string(AckOK)
+	})
+}
+
+func TestChildAnswersLegacyProtocol(t *testing.T) {
+	mgr, tr, applyCount, _ := setupChildManager(t)
+
+	legacy := Message{
 		Type:            MsgSceneCommand,
-		ProtocolVersion: ProtocolVersion,
-		EventID:         "evt-bad-hmac",
+		ProtocolVersion: 1,
+		EventID:         "evt-v1",
 		SceneName:       "SceneA",
-		Source:          string(SourceGUI),
-		FireAtUnixMs:    time.Now().Add(50 * time.Millisecond).UnixMilli(),
+		FireAtUnixMs:    time.Now().Add(30 * time.Millisecond).UnixMilli(),
 		SentAtUnixMs:    time.Now().UnixMilli(),
-		HMAC:            "deadbeef",
+		HMAC:            "00",
 	}
+	b, _ := legacy.Marshal()
+	mgr.onTransportMessage(parentRef, b)
 
-	mgr.handleSceneCommand(PeerRef{PeerID: "parent", Name: "parent", Platform: "test"}, msg)
-
+	time.Sleep(60 * time.Millisecond)
 	if *applyCount != 0 {
-		t.Fatalf("tampered command should not apply scene")
+		t.Fatalf("v1 command must not apply")
 	}
 	ack, ok := tr.last("parent")
-	if !ok {
-		t.Fatalf("expected ACK")
-	}
-	if ack.Status != string(AckError) {
-		t.Fatalf("expected %s, got %s", AckError, ack.Status)
+	if !ok || ack.ProtocolVersion != 1 || ack.Status != string(AckError) || ack.Error != errProtocolVersion.Error() {
+		t.Fatalf("expected a v1 error ACK, got %+v", ack)
 	}
 }
@@ -40,6 +40,8 @@ type tinyGoTransport struct {
 
 	peers      map[string]*parentPeerConn
 	connecting map[string]struct{}
+	// 親機から子機への再接続の可否と間隔（キーは子機のアドレス。reconnect.go）
+	reconnect reconnectState
 
 	childParent      PeerRef
 	childConnected   bool
@@ -58,6 +60,11 @@ func NewNativeTransport() Transport {
 	}
 }
 
+// SetReconnect は親機が切れた子機へつなぎ直すかどうかと、その間隔を設定します。
+func (t *tinyGoTransport) SetReconnect(policy ReconnectPolicy) {
+	t.reconnect.set(policy)
+}
+
 func (t *tinyGoTransport) SupportsRole(role Role) error {
 	switch role {
 	case RoleParent:
@@ -108,6 +115,7 @@ func (t *tinyGoTransport) Start(ctx Context, role Role, deviceName string, onMes
 	t.childInputBuffer = nil
 	t.childAdvStopper = nil
 	t.mu.Unlock()
+	t.reconnect.reset()
 
 	if err := t.adapter.Enable(); err != nil {
 		t.mu.Lock()
@@ -127,6 +135,7 @@ func (t *tinyGoTransport) Start(ctx Context, role Role, deviceName string, onMes
 				if pc, ok := t.peers[peerID]; ok {
 					peer = pc.ref
 					delete(t.peers, peerID)
+					t.reconnect.dropped(peerID, peerID, time.Now())
 				}
 				delete(t.connecting, peerID)
 			}
@@ -252,7 +261,7 @@ func (t *tinyGoTransport) parentScanLoop(ctx Context) {
 			t.mu.Lock()
 			_, connected := t.peers[peerID]
 			_, connecting := t.connecting[peerID]
-			if connected || connecting {
+			if connected || connecting || !t.reconnect.allow(peerID, time.Now()) {
 				t.mu.Unlock()
 				return
 			}
@@ -280,10 +289,14 @@ func (t *tinyGoTransport) parentScanLoop(ctx Context) {
 func (t *tinyGoTransport) connectParentPeer(result bluetooth.ScanResult) {
 	peerID := normalizePeerID(result.Address.String())
 
+	ok := false
 	defer func() {
 		t.mu.Lock()
 		delete(t.connecting, peerID)
 		t.mu.Unlock()
+		if !ok {
+			t.reconnect.failed(peerID, time.Now())
+		}
 	}()
 
 	device, err := t.adapter.Connect(result.Address, bluetooth.ConnectionParams{})
@@ -344,6 +357,8 @@ func (t *tinyGoTransport) connectParentPeer(result bluetooth.ScanResult) {
 	}
 	cb := t.onPeerEvent
 	t.mu.Unlock()
+	ok = true
+	t.reconnect.succeeded(peerID, time.Now())
 
 	if cb != nil {
 		cb(PeerEvent{Peer: PeerRef{PeerID: peerID, Name: peerName, Platform: "unknown"}, Connected: true, At: time.Now()})
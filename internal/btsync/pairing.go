@@ -0,0 +1,269 @@
+package btsync
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	pairMinInterval     = 1 * time.Second  // 同じ peer からの pair_request の最小間隔
+	pairSessionTimeout  = 10 * time.Second // pair_confirm が来なければ失敗として数える
+	pairMaxPeerFailures = 3                // この回数失敗した peer は pairLockout の間拒否する
+	pairLockout         = 60 * time.Second
+	pairMaxCodeFailures = 5 // 1つのコードで失敗がこの回数に達したらコードを無効にする
+)
+
+// 推測の回数を抑えているのは pairMaxCodeFailures です。peer ごとの失敗回数とロックアウトは送信元が名乗る peer ID で
+// 数えるので、ID を変えれば避けられます（正規の子機が続けて間違えたときに、少し待たせるためのものです）。
+// 6 桁のコード 1 つにつき、攻撃者が試せるのは pairMaxCodeFailures 回までです。
+
+// pairSession は親機側で pair_confirm を待っている SPAKE2 のセッションです。
+type pairSession struct {
+	keys    pakeKeys
+	started time.Time
+	pake    string  // 子機の値（同じ値の pair_request の再送には reply をそのまま返す）
+	reply   Message // 送った pair_accept
+}
+
+// pairAttempts は peer ごとの失敗回数とロックアウトです（コードを発行し直しても残ります）。
+type pairAttempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func (m *Manager) handlePairRequest(from PeerRef, msg Message) {
+	if msg.Pake == "" {
+		_ = m.sendPairAccept(from.PeerID, Message{Status: "denied", Error: "子機のバージョンが古いためペアリングできません（更新してください）"})
+		return
+	}
+
+	m.mu.Lock()
+	if sess, ok := m.pairSessions[from.PeerID]; ok && sess.pake == msg.Pake {
+		// 同じ値の再送（pair_accept が届かなかった）は新しい推測ではないので、失敗として数えずに同じ応答を返す
+		reply := sess.reply
+		m.mu.Unlock()
+		_ = m.sendPairAccept(from.PeerID, reply)
+		return
+	}
+	cfg := m.cfg.Normalize()
+	now := time.Now()
+	a := m.pairFailures[from.PeerID]
+	if a == nil {
+		a = &pairAttempts{}
+		m.pairFailures[from.PeerID] = a
+	}
+	deny := ""
+	switch {
+	case now.Before(a.lockedUntil):
+		deny = fmt.Sprintf("失敗が続いたため %d 秒間ペアリングできません", int(time.Until(a.lockedUntil).Seconds())+1)
+	case now.Sub(a.last) < pairMinInterval:
+		deny = "ペアリング要求が多すぎます"
+	default:
+		a.last = now
+		if m.pairingCode == "" || !now.Before(m.pairingExpires) {
+			deny = "ペアリングコードが無効です"
+		} else if _, ok := m.peers[from.PeerID]; !ok && cfg.MaxNodes > 0 && len(m.peers) >= cfg.MaxNodes-1 {
+			deny = "最大接続台数に達しました"
+		}
+	}
+	var failed string
+	if deny == "" {
+		// 前のセッションが確認されないまま別の値で要求が来たら、前の推測は外れたとみなす
+		// （子機は confirm_b で 1 回分の推測を確かめられるので、値を変えた要求は新しい推測として数える）
+		if _, pending := m.pairSessions[from.PeerID]; pending {
+			delete(m.pairSessions, from.PeerID)
+			failed = m.recordPairFailureLocked(from.PeerID, now)
+			if m.pairingCode == "" || now.Before(a.lockedUntil) {
+				deny = "ペアリングコードが無効です"
+			}
+		}
+	}
+	var side *pakeSide
+	if deny == "" {
+		var err error
+		if side, err = newPakeSide(m.pairingCode, false); err != nil {
+			deny = err.Error()
+		}
+	}
+	var reply Message
+	if deny == "" {
+		if keys, err := side.Finish(msg.Pake); err != nil {
+			deny = err.Error()
+		} else {
+			reply = Message{Status: "ok", Pake: side.Public(), Confirm: keys.ConfirmB}
+			m.pairSessions[from.PeerID] = &pairSession{keys: keys, started: now, pake: msg.Pake, reply: reply}
+		}
+	}
+	m.mu.Unlock()
+
+	if failed != "" {
+		m.log("error", failed)
+	}
+	if deny != "" {
+		_ = m.sendPairAccept(from.PeerID, Message{Status: "denied", Error: deny})
+		return
+	}
+	if err := m.sendPairAccept(from.PeerID, reply); err != nil {
+		m.log("error", fmt.Sprintf("pair_accept送信失敗: %v", err))
+	}
+}
+
+// handlePairConfirm は親機側: 子機の確認値が合えば共有鍵を保存します。
+func (m *Manager) handlePairConfirm(from PeerRef, msg Message) {
+	m.mu.Lock()
+	sess, ok := m.pairSessions[from.PeerID]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.pairSessions, from.PeerID)
+	now := time.Now()
+	if msg.Status != "ok" || !confirmEqual(sess.keys.ConfirmA, msg.Confirm) {
+		failed := m.recordPairFailureLocked(from.PeerID, now)
+		m.mu.Unlock()
+		m.log("error", failed)
+		_ = m.sendPairAccept(from.PeerID, Message{Status: "denied", Error: "ペアリングコードが違います"})
+		return
+	}
+	delete(m.pairFailures, from.PeerID)
+	p := m.ensurePeerLocked(from)
+	p.Secret = sess.keys.Secret
+	p.Platform = from.Platform
+	p.Name = from.Name
+	p.LastSeenAt = now
+	p.Connected = true
+	peers := m.trustedPeersLocked()
+	m.mu.Unlock()
+
+	m.persistTrustedPeers(peers)
+	// 完了通知は交換したばかりの鍵で認証する（子機はこれで親機が同じ鍵を持つことを確かめる）
+	if err := m.sendSealed(from.PeerID, Message{Type: MsgPairAccept, Status: "paired"}); err != nil {
+		m.log("error", fmt.Sprintf("pair_accept送信失敗: %v", err))
+		return
+	}
+	m.log("info", fmt.Sprintf("ペアリング成功: %s (%s)", from.Name, from.PeerID))
+	go m.probeLatency(from.PeerID) // 接続時には鍵が無く測れなかった（latency.go）
+}
+
+// handlePairAccept は子機側: 親機の値で鍵を求めて確認値を返し、paired を受けたら鍵を保存します。
+func (m *Manager) handlePairAccept(from PeerRef, msg Message) {
+	switch msg.Status {
+	case "ok":
+	case "paired":
+		m.mu.Lock()
+		keys, ok := m.joinKeys[from.PeerID]
+		if !ok {
+			m.mu.Unlock()
+			return
+		}
+		delete(m.joinKeys, from.PeerID)
+		p := m.ensurePeerLocked(from)
+		p.Secret = keys.Secret
+		p.Connected = true
+		p.LastSeenAt = time.Now()
+		peers := m.trustedPeersLocked()
+		m.mu.Unlock()
+
+		m.persistTrustedPeers(peers)
+		m.log("info", fmt.Sprintf("親機とのペアリング完了: %s", from.Name))
+		// 時計合わせは認証付きなので、鍵が揃ってから始める
+		go m.probeClock(from.PeerID)
+		return
+	default:
+		if msg.Error == "" {
+			m.log("error", "ペアリング拒否: unknown")
+		} else {
+			m.log("error", fmt.Sprintf("ペアリング拒否: %s", msg.Error))
+		}
+		return
+	}
+
+	m.mu.Lock()
+	side := m.joinPake
+	m.mu.Unlock()
+	if side == nil {
+		return
+	}
+	keys, err := side.Finish(msg.Pake)
+	if err == nil && !confirmEqual(keys.ConfirmB, msg.Confirm) {
+		err = fmt.Errorf("ペアリングコードが違います")
+	}
+	if err != nil {
+		m.log("error", fmt.Sprintf("ペアリング失敗: %v", err))
+		_ = m.sendPairConfirm(from.PeerID, Message{Status: "mismatch"})
+		return
+	}
+	m.mu.Lock()
+	if m.joinKeys == nil {
+		m.joinKeys = map[string]pakeKeys{}
+	}
+	m.joinKeys[from.PeerID] = keys
+	m.mu.Unlock()
+	if err := m.sendPairConfirm(from.PeerID, Message{Status: "ok", Confirm: keys.ConfirmA}); err != nil {
+		m.log("error", fmt.Sprintf("pair_confirm送信失敗: %v", err))
+	}
+}
+
+// recordPairFailureLocked は失敗を数え、peer のロックアウトやコードの無効化を行ってログ用の文を返します。
+func (m *Manager) recordPairFailureLocked(peerID string, now time.Time) string {
+	a := m.pairFailures[peerID]
+	if a == nil {
+		a = &pairAttempts{}
+		m.pairFailures[peerID] = a
+	}
+	a.failures++
+	msg := fmt.Sprintf("ペアリング失敗 [%s] (%d回目)", peerID, a.failures)
+	if a.failures >= pairMaxPeerFailures {
+		a.failures = 0
+		a.lockedUntil = now.Add(pairLockout)
+		msg += fmt.Sprintf("。%d 秒間この端末からの要求を拒否します", int(pairLockout.Seconds()))
+	}
+	m.codeFailures++
+	if m.pairingCode != "" && m.codeFailures >= pairMaxCodeFailures {
+		m.pairingCode = ""
+		m.pairingExpires = time.Time{}
+		m.pairSessions = map[string]*pairSession{}
+		msg += "。失敗が多いためペアリングコードを無効にしました（再発行してください）"
+	}
+	return msg
+}
+
+// expirePairSessions は pair_confirm が来ないまま時間切れになったセッションを失敗として数えます。
+func (m *Manager) expirePairSessions() {
+	now := time.Now()
+	var logs []string
+	m.mu.Lock()
+	for id, s := range m.pairSessions {
+		if now.Sub(s.started) > pairSessionTimeout {
+			delete(m.pairSessions, id)
+			logs = append(logs, m.recordPairFailureLocked(id, now))
+		}
+	}
+	m.mu.Unlock()
+	for _, l := range logs {
+		m.log("error", l)
+	}
+}
+
+func (m *Manager) sendPairAccept(peerID string, msg Message) error {
+	msg.Type = MsgPairAccept
+	msg.ProtocolVersion = ProtocolVersion
+	msg.SentAtUnixMs = m.now().UnixMilli()
+	payload, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	return m.transport.Send(peerID, payload)
+}
+
+func (m *Manager) sendPairConfirm(peerID string, msg Message) error {
+	msg.Type = MsgPairConfirm
+	msg.ProtocolVersion = ProtocolVersion
+	msg.SentAtUnixMs = m.now().UnixMilli()
+	payload, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	return m.transport.Send(peerID, payload)
+}
@@ -0,0 +1,177 @@
+package btsync
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ペアリングは SPAKE2（P-256）で行います。6桁のコードは鍵交換の認証にだけ使い、コードも共有鍵も無線に流れません。
+//
+//	子機: X = x·G + w·M を pair_request で送る（w はコードから導出）
+//	親機: Y = y·G + w·N と確認値 confirm_b を pair_accept で返す
+//	子機: confirm_b を検証し、confirm_a を pair_confirm で送る
+//	親機: confirm_a を検証して鍵を保存し、pair_accept(status=paired) で完了を通知する
+//
+// 盗聴者はコードを総当たりできず、能動的な攻撃者もセッションごとに1回しか推測できません。
+// その回数は親機がコードごとに pairMaxCodeFailures 回までに制限します（pairing.go）。
+
+// 点の演算には crypto/elliptic の汎用メソッド（ScalarMult / Add）を使います。非推奨ですが、標準ライブラリで
+// 任意の点どうしの加算ができるのはこれだけです（crypto/ecdh は鍵交換のみ）。P-256 では内部で crypto/internal/nistec の
+// 定数時間の実装が使われるので、依存を増やさずにこれを使い、結果は pake_test.go のテストベクタで固定しています。
+var pakeCurve = elliptic.P256()
+
+// pakeM / pakeN は SPAKE2 の固定点です。ハッシュから try-and-increment で導出します（離散対数を誰も知らない点）。
+var (
+	pakeMx, pakeMy = pakePoint("obsctl-sync SPAKE2 M")
+	pakeNx, pakeNy = pakePoint("obsctl-sync SPAKE2 N")
+)
+
+func pakePoint(seed string) (*big.Int, *big.Int) {
+	params := pakeCurve.Params()
+	three := big.NewInt(3)
+	exp := new(big.Int).Add(params.P, big.NewInt(1))
+	exp.Rsh(exp, 2) // P-256 は p ≡ 3 (mod 4) なので平方根は (p+1)/4 乗
+	for i := 0; ; i++ {
+		h := sha256.Sum256([]byte(fmt.Sprintf("%s|%d", seed, i)))
+		x := new(big.Int).SetBytes(h[:])
+		x.Mod(x, params.P)
+		// y² = x³ - 3x + b
+		y2 := new(big.Int).Exp(x, three, params.P)
+		y2.Sub(y2, new(big.Int).Mul(three, x))
+		y2.Add(y2, params.B)
+		y2.Mod(y2, params.P)
+		y := new(big.Int).Exp(y2, exp, params.P)
+		if y.Bit(0) == 1 {
+			y.Sub(params.P, y)
+		}
+		if pakeCurve.IsOnCurve(x, y) {
+			return x, y
+		}
+	}
+}
+
+// pakePassword はペアリングコードからスカラー w を導出します。
+func pakePassword(code string) *big.Int {
+	h := sha256.Sum256([]byte("obsctl-sync pake v1|" + strings.TrimSpace(code)))
+	w := new(big.Int).SetBytes(h[:])
+	return w.Mod(w, pakeCurve.Params().N)
+}
+
+func randomScalar() (*big.Int, error) {
+	n := pakeCurve.Params().N
+	for {
+		k, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() > 0 {
+			return k, nil
+		}
+	}
+}
+
+// pakeSide は SPAKE2 の片側の状態です（child=true なら M、親機なら N で自分の値を隠す）。
+type pakeSide struct {
+	child bool
+	w     *big.Int
+	k     *big.Int
+	mine  []byte
+}
+
+func newPakeSide(code string, child bool) (*pakeSide, error) {
+	k, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	return newPakeSideWithScalar(code, child, k), nil
+}
+
+// newPakeSideWithScalar は乱数 k を指定して pakeSide を作ります（テストベクタ用）。
+func newPakeSideWithScalar(code string, child bool, k *big.Int) *pakeSide {
+	s := &pakeSide{child: child, w: pakePassword(code), k: k}
+	mx, my := pakeMx, pakeMy
+	if !child {
+		mx, my = pakeNx, pakeNy
+	}
+	gx, gy := pakeCurve.ScalarBaseMult(k.Bytes())
+	wx, wy := pakeCurve.ScalarMult(mx, my, s.w.Bytes())
+	px, py := pakeCurve.Add(gx, gy, wx, wy)
+	s.mine = elliptic.Marshal(pakeCurve, px, py)
+	return s
+}
+
+// Public は相手に送る値（hex）です。
+func (s *pakeSide) Public() string {
+	return hex.EncodeToString(s.mine)
+}
+
+// pakeKeys は鍵交換の結果です。Secret は TrustedPeer.Secret に保存する共有鍵。
+type pakeKeys struct {
+	Secret   string
+	ConfirmA string // 子機 → 親機
+	ConfirmB string // 親機 → 子機
+}
+
+// Finish は相手の値から共有鍵と確認値を求めます。
+func (s *pakeSide) Finish(peerHex string) (pakeKeys, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(peerHex))
+	if err != nil {
+		return pakeKeys{}, errors.New("pake: 不正な値です")
+	}
+	px, py := elliptic.Unmarshal(pakeCurve, raw)
+	if px == nil {
+		return pakeKeys{}, errors.New("pake: 曲線上の点ではありません")
+	}
+	// 相手は反対側の固定点で隠しているので、それを引いてから自分の乱数を掛ける
+	mx, my := pakeNx, pakeNy
+	if !s.child {
+		mx, my = pakeMx, pakeMy
+	}
+	wx, wy := pakeCurve.ScalarMult(mx, my, s.w.Bytes())
+	wy.Sub(pakeCurve.Params().P, wy)
+	ux, uy := pakeCurve.Add(px, py, wx, wy)
+	kx, ky := pakeCurve.ScalarMult(ux, uy, s.k.Bytes())
+	if kx.Sign() == 0 && ky.Sign() == 0 {
+		return pakeKeys{}, errors.New("pake: 無効な鍵です")
+	}
+
+	x, y := s.mine, raw
+	if !s.child {
+		x, y = raw, s.mine
+	}
+	tt := sha256.New()
+	for _, part := range [][]byte{[]byte("obsctl-sync pake v1"), x, y, elliptic.Marshal(pakeCurve, kx, ky), s.w.Bytes()} {
+		var l [4]byte
+		l[0], l[1], l[2], l[3] = byte(len(part)>>24), byte(len(part)>>16), byte(len(part)>>8), byte(len(part))
+		tt.Write(l[:])
+		tt.Write(part)
+	}
+	root := tt.Sum(nil)
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, root)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	confirm := func(label string) string {
+		mac := hmac.New(sha256.New, derive(label))
+		mac.Write(x)
+		mac.Write(y)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	return pakeKeys{
+		Secret:   hex.EncodeToString(derive("secret")),
+		ConfirmA: confirm("confirm child"),
+		ConfirmB: confirm("confirm parent"),
+	}, nil
+}
+
+func confirmEqual(a, b string) bool {
+	return hmac.Equal([]byte(strings.ToLower(a)), []byte(strings.ToLower(b)))
+}
@@ -0,0 +1,190 @@
+package btsync
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPakeAgreesOnlyWithSameCode(t *testing.T) {
+	finish := func(childCode, parentCode string) (pakeKeys, pakeKeys) {
+		t.Helper()
+		a, err := newPakeSide(childCode, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := newPakeSide(parentCode, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ka, err := a.Finish(b.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		kb, err := b.Finish(a.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ka, kb
+	}
+
+	ka, kb := finish("123456", "123456")
+	if ka != kb || len(ka.Secret) != 64 {
+		t.Fatalf("same code should agree: %+v / %+v", ka, kb)
+	}
+	if ka.ConfirmA == ka.ConfirmB {
+		t.Fatal("confirmations must differ per direction")
+	}
+	ka, kb = finish("123456", "123457")
+	if ka.Secret == kb.Secret || confirmEqual(ka.ConfirmB, kb.ConfirmB) {
+		t.Fatal("different codes must not agree")
+	}
+
+	side, _ := newPakeSide("123456", true)
+	if _, err := side.Finish("04deadbeef"); err == nil {
+		t.Fatal("invalid point should be rejected")
+	}
+}
+
+// TestPakeVector は crypto/elliptic の非推奨の点演算の結果が変わらないことを固定します（pake.go）。
+func TestPakeVector(t *testing.T) {
+	if got := hex.EncodeToString(pakeMx.Bytes()); got != "aed1cc307b2b77340e907ef5e8adc0af9392a5aff15ed54503538b012c264a74" {
+		t.Fatalf("M.x = %s", got)
+	}
+	if got := hex.EncodeToString(pakeNx.Bytes()); got != "f7ae1a1b10101981990098cca7d3268894aa9e4764a103373cf3afc5b6a3bb2d" {
+		t.Fatalf("N.x = %s", got)
+	}
+	child := newPakeSideWithScalar("123456", true, big.NewInt(12345))
+	parent := newPakeSideWithScalar("123456", false, big.NewInt(67890))
+	if got := child.Public(); got != "0466f763912fc82ad0f064736e02b14e539d1979972b63fec14c8c45e9a952081b253380a34b8725ef498c277d3b45f1d91b3337af3861b58248883ce84c38ac45" {
+		t.Fatalf("X = %s", got)
+	}
+	if got := parent.Public(); got != "04535fde2a977c6725dbabb6af01dc79f26fa302e853a105a4c24856accb10990758fdd72c5e93afa9616d3ba9eacf894c6ef63dc4735d599caf7e8cf6af69ff11" {
+		t.Fatalf("Y = %s", got)
+	}
+	ka, err := child.Finish(parent.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := parent.Finish(child.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pakeKeys{
+		Secret:   "52694bcc0538479839b770226529fccff96609c10f77f2fd05b1521ce5ee3bc4",
+		ConfirmA: "e442e753fd98f41a6985cc88ac25d8c93e334a0a9ec993d495204e72ad990b0d",
+		ConfirmB: "7b4c4da1e5a69fc539736a106824dce005bb148431b52fbae361c6fdf8dd0e6b",
+	}
+	if ka != want || kb != want {
+		t.Fatalf("keys: %+v / %+v", ka, kb)
+	}
+}
+
+// TestPairRequestResendIsNotAFailure は同じ値の pair_request の再送に同じ応答を返し、失敗として数えないことを確かめます。
+func TestPairRequestResendIsNotAFailure(t *testing.T) {
+	mgr, tr := setupParentManager(t, 100, 100)
+	code, err := mgr.GeneratePairingCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := PeerRef{PeerID: "newchild", Name: "newchild"}
+	side, _ := newPakeSide(code, true)
+	mgr.handlePairRequest(from, Message{Type: MsgPairRequest, Pake: side.Public()})
+	first, _ := tr.last("newchild")
+	mgr.handlePairRequest(from, Message{Type: MsgPairRequest, Pake: side.Public()})
+	second, _ := tr.last("newchild")
+	if first.Status != "ok" || second.Status != "ok" || first.Pake != second.Pake || first.Confirm != second.Confirm {
+		t.Fatalf("resend should get the same reply: %+v / %+v", first, second)
+	}
+	mgr.mu.Lock()
+	failures := mgr.codeFailures
+	mgr.mu.Unlock()
+	if failures != 0 {
+		t.Fatalf("a resend is not a guess: %d failures", failures)
+	}
+
+	// 値を変えた要求は新しい推測なので、確認されなかった前のセッションを失敗として数える
+	mgr.mu.Lock()
+	mgr.pairFailures["newchild"].last = time.Time{}
+	mgr.mu.Unlock()
+	other, _ := newPakeSide(code, true)
+	mgr.handlePairRequest(from, Message{Type: MsgPairRequest, Pake: other.Public()})
+	mgr.mu.Lock()
+	failures = mgr.codeFailures
+	mgr.mu.Unlock()
+	if failures != 1 {
+		t.Fatalf("a new value should count the abandoned session: %d failures", failures)
+	}
+}
+
+func TestPairingWrongCodeAndLockout(t *testing.T) {
+	nw := NewLoopbackNetwork(LoopbackOptions{Seed: 1})
+	var mu sync.Mutex
+	var childLogs []string
+	parent := startLoopbackManager(t, nw, "parent", RoleParent, ManagerOptions{}, Config{})
+	child := startLoopbackManager(t, nw, "child", RoleChild, ManagerOptions{Logf: func(_, msg string) {
+		mu.Lock()
+		childLogs = append(childLogs, msg)
+		mu.Unlock()
+	}}, Config{})
+	childLogged := func(sub string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, l := range childLogs {
+			if strings.Contains(l, sub) {
+				return true
+			}
+		}
+		return false
+	}
+	failures := func() int {
+		parent.mu.Lock()
+		defer parent.mu.Unlock()
+		if a := parent.pairFailures["child"]; a != nil {
+			return a.failures
+		}
+		return 0
+	}
+
+	code, _ := parent.GeneratePairingCode()
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if err := child.JoinByCode(wrong); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "parent counts the failure", func() bool { return failures() == 1 })
+	if !childLogged("ペアリングコードが違います") {
+		t.Fatalf("child should report the mismatch: %v", childLogs)
+	}
+
+	// 続けて失敗するとロックアウトされ、正しいコードでも拒否される
+	parent.mu.Lock()
+	parent.recordPairFailureLocked("child", time.Now())
+	parent.recordPairFailureLocked("child", time.Now())
+	parent.pairFailures["child"].last = time.Time{}
+	parent.mu.Unlock()
+	if err := child.JoinByCode(code); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "lockout", func() bool { return childLogged("ペアリングできません") })
+	child.mu.Lock()
+	paired := child.peers["parent"].Secret != ""
+	child.mu.Unlock()
+	if paired {
+		t.Fatal("locked out child must not pair")
+	}
+
+	// 失敗が pairMaxCodeFailures 回に達したらコードを無効にする
+	parent.mu.Lock()
+	parent.recordPairFailureLocked("other", time.Now())
+	parent.recordPairFailureLocked("other", time.Now())
+	parent.mu.Unlock()
+	if st := parent.Status(); st.PairingCodeActive {
+		t.Fatal("code should be invalidated after too many failures")
+	}
+}
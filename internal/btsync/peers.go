@@ -0,0 +1,252 @@
+package btsync
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 信頼済み peer の管理: 解除（相手にも通知して鍵を捨てる）、表示名の変更、鍵の更新、ブロック。
+// 変更は PersistTrustedPeers で保存します。
+//
+// 鍵の更新は今の鍵で認証した rekey メッセージで X25519 の一時鍵を交換し、今の鍵と共有値から新しい鍵を導出します
+// （新しい鍵は通信路に流れません）。
+//
+//	A: rekey(request, A の公開鍵)                    … 今の鍵で署名
+//	B: 新しい鍵を保留して rekey(accept, B の公開鍵)   … 今の鍵で署名
+//	A: 新しい鍵に切り替え、rekey(done)               … 新しい鍵で署名（古い鍵で届く B のメッセージもしばらく受け付ける）
+//	B: 新しい鍵で署名されたメッセージを受けた時点で切り替える
+
+// RevokePeer はペアリングを解除します。接続中なら相手にも通知し、相手も鍵を捨てます。
+func (m *Manager) RevokePeer(peerID string) error {
+	peerID = strings.TrimSpace(peerID)
+	m.mu.Lock()
+	p, ok := m.peers[peerID]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("peer が見つかりません: %s", peerID)
+	}
+	notify := m.running && p.Connected && p.Secret != ""
+	name := p.DisplayName()
+	m.mu.Unlock()
+
+	if notify {
+		if err := m.sendSealed(peerID, Message{Type: MsgPeerRevoke}); err != nil {
+			m.log("error", fmt.Sprintf("解除の通知に失敗 [%s]: %v", peerID, err))
+		}
+	}
+	m.forgetPeer(peerID)
+	if notify {
+		m.log("info", fmt.Sprintf("ペアリングを解除しました: %s (%s)", name, peerID))
+	} else {
+		m.log("info", fmt.Sprintf("ペアリングを解除しました: %s (%s)。相手には接続時に通知できないため、相手側でも解除してください", name, peerID))
+	}
+	return nil
+}
+
+// RenamePeer は peer の表示名を変えます（空なら相手の名乗る名前に戻す）。
+func (m *Manager) RenamePeer(peerID, alias string) error {
+	peerID = strings.TrimSpace(peerID)
+	m.mu.Lock()
+	p, ok := m.peers[peerID]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("peer が見つかりません: %s", peerID)
+	}
+	p.Alias = strings.TrimSpace(alias)
+	peers := m.trustedPeersLocked()
+	m.mu.Unlock()
+
+	m.persistTrustedPeers(peers)
+	return nil
+}
+
+// BlockPeer はペアリングを解除し、以後その peer ID からのメッセージを全て無視します（ペアリング要求も含む）。
+func (m *Manager) BlockPeer(peerID string) error {
+	peerID = strings.TrimSpace(peerID)
+	if peerID == "" {
+		return errors.New("peer ID が空です")
+	}
+	m.mu.Lock()
+	ref := PeerRef{PeerID: peerID}
+	prev, known := m.peers[peerID]
+	if known {
+		ref.Name, ref.Platform = prev.Name, prev.Platform
+	}
+	m.mu.Unlock()
+	if known {
+		if err := m.RevokePeer(peerID); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	p := m.ensurePeerLocked(ref)
+	p.Blocked = true
+	delete(m.pairSessions, peerID)
+	peers := m.trustedPeersLocked()
+	m.mu.Unlock()
+
+	m.persistTrustedPeers(peers)
+	m.log("info", fmt.Sprintf("peer をブロックしました: %s", peerID))
+	return nil
+}
+
+// UnblockPeer はブロックを解除します（もう一度ペアリングが必要です）。
+func (m *Manager) UnblockPeer(peerID string) error {
+	peerID = strings.TrimSpace(peerID)
+	m.mu.Lock()
+	p, ok := m.peers[peerID]
+	if !ok || !p.Blocked {
+		m.mu.Unlock()
+		return fmt.Errorf("ブロックしていない peer です: %s", peerID)
+	}
+	p.Blocked = false
+	if !p.Connected && p.Alias == "" {
+		delete(m.peers, peerID)
+	}
+	peers := m.trustedPeersLocked()
+	m.mu.Unlock()
+
+	m.persistTrustedPeers(peers)
+	m.log("info", fmt.Sprintf("peer のブロックを解除しました: %s", peerID))
+	return nil
+}
+
+// RotatePeerSecret は接続中の peer と鍵を更新します。結果はログに出ます。
+func (m *Manager) RotatePeerSecret(peerID string) error {
+	peerID = strings.TrimSpace(peerID)
+	m.mu.Lock()
+	p, ok := m.peers[peerID]
+	switch {
+	case !m.running:
+		m.mu.Unlock()
+		return errors.New("同期を開始していません")
+	case !ok || p.Secret == "":
+		m.mu.Unlock()
+		return fmt.Errorf("ペアリングしていない peer です: %s", peerID)
+	case !p.Connected:
+		m.mu.Unlock()
+		return fmt.Errorf("peer が接続されていません: %s", peerID)
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	p.rekey = priv
+	m.mu.Unlock()
+
+	return m.sendSealed(peerID, Message{Type: MsgRekey, Status: "request", RekeyKey: hex.EncodeToString(priv.PublicKey().Bytes())})
+}
+
+// forgetPeer は鍵・表示名と認証・更新の状態を捨てます。接続中やブロック中の peer は一覧に残します。
+func (m *Manager) forgetPeer(peerID string) {
+	m.mu.Lock()
+	p, ok := m.peers[peerID]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	p.Secret = ""
+	p.nextSecret = ""
+	p.prevSecret = ""
+	p.rekey = nil
+	// 送信の seq は続きから使う（相手が解除に気付いていなくても、再ペアリング後に古い seq と見なされないように）
+	p.auth = authState{sendSeq: p.auth.sendSeq}
+	p.Alias = ""
+	if !p.Connected && !p.Blocked && p.Alias == "" {
+		delete(m.peers, peerID)
+	}
+	peers := m.trustedPeersLocked()
+	m.mu.Unlock()
+
+	m.persistTrustedPeers(peers)
+}
+
+// handlePeerRevoke は相手からの解除通知です（認証済み）。
+func (m *Manager) handlePeerRevoke(from PeerRef) {
+	m.forgetPeer(from.PeerID)
+	m.log("info", fmt.Sprintf("%s (%s) からペアリングを解除されました", from.Name, from.PeerID))
+}
+
+func (m *Manager) handleRekey(from PeerRef, msg Message) {
+	switch msg.Status {
+	case "request":
+		priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return
+		}
+		pubA, err := hex.DecodeString(msg.RekeyKey)
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		p, ok := m.peers[from.PeerID]
+		if !ok || p.Secret == "" {
+			m.mu.Unlock()
+			return
+		}
+		next, err := rekeySecret(p.Secret, priv, pubA, pubA, priv.PublicKey().Bytes())
+		if err != nil {
+			m.mu.Unlock()
+			m.log("error", fmt.Sprintf("鍵の更新に失敗 [%s]: %v", from.PeerID, err))
+			return
+		}
+		p.nextSecret = next
+		m.mu.Unlock()
+		_ = m.sendSealed(from.PeerID, Message{Type: MsgRekey, Status: "accept", RekeyKey: hex.EncodeToString(priv.PublicKey().Bytes())})
+	case "accept":
+		pubB, err := hex.DecodeString(msg.RekeyKey)
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		p, ok := m.peers[from.PeerID]
+		if !ok || p.rekey == nil {
+			m.mu.Unlock()
+			return
+		}
+		priv := p.rekey
+		p.rekey = nil
+		next, err := rekeySecret(p.Secret, priv, pubB, priv.PublicKey().Bytes(), pubB)
+		if err != nil {
+			m.mu.Unlock()
+			m.log("error", fmt.Sprintf("鍵の更新に失敗 [%s]: %v", from.PeerID, err))
+			return
+		}
+		p.prevSecret = p.Secret
+		p.Secret = next
+		peers := m.trustedPeersLocked()
+		m.mu.Unlock()
+
+		m.persistTrustedPeers(peers)
+		_ = m.sendSealed(from.PeerID, Message{Type: MsgRekey, Status: "done"})
+		m.log("info", fmt.Sprintf("鍵を更新しました: %s (%s)", from.Name, from.PeerID))
+	case "done":
+		// 新しい鍵への切り替えは authenticate が行う
+	}
+}
+
+// rekeySecret は今の鍵と X25519 の共有値から新しい鍵を導出します（pubA が要求側、pubB が応答側）。
+func rekeySecret(secret string, priv *ecdh.PrivateKey, peerPub, pubA, pubB []byte) (string, error) {
+	pub, err := ecdh.X25519().NewPublicKey(peerPub)
+	if err != nil {
+		return "", err
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("obsctl-sync rekey v1"))
+	for _, part := range [][]byte{shared, pubA, pubB} {
+		mac.Write(part)
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
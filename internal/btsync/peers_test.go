@@ -0,0 +1,191 @@
+package btsync
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// startPairedLoopback は親機と子機 1 台を loopback でペアリングし、保存内容と子機の適用回数を返す関数を添えて返します。
+func startPairedLoopback(t *testing.T) (parent, child *Manager, persisted func(id string) []TrustedPeer, applied func() int) {
+	t.Helper()
+	nw := NewLoopbackNetwork(LoopbackOptions{Latency: 2 * time.Millisecond, Seed: 1})
+
+	var mu sync.Mutex
+	saved := map[string][]TrustedPeer{}
+	childApplied := 0
+	start := func(id string, role Role) *Manager {
+		return startLoopbackManager(t, nw, id, role, ManagerOptions{
+			ApplyScene: func(string, Source) error {
+				mu.Lock()
+				if id == "child" {
+					childApplied++
+				}
+				mu.Unlock()
+				return nil
+			},
+			PersistTrustedPeers: func(peers []TrustedPeer) error {
+				mu.Lock()
+				saved[id] = peers
+				mu.Unlock()
+				return nil
+			},
+		}, Config{LeadTimeMs: 50, AcceptLateMs: 100})
+	}
+	parent = start("parent", RoleParent)
+	child = start("child", RoleChild)
+
+	code, _ := parent.GeneratePairingCode()
+	if err := child.JoinByCode(code); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "paired", func() bool { return secretOf(child, "parent") != "" && secretOf(parent, "child") != "" })
+
+	persisted = func(id string) []TrustedPeer {
+		mu.Lock()
+		defer mu.Unlock()
+		return saved[id]
+	}
+	applied = func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return childApplied
+	}
+	return parent, child, persisted, applied
+}
+
+func secretOf(m *Manager, peerID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.peers[peerID]; ok {
+		return p.Secret
+	}
+	return ""
+}
+
+func TestRevokePeerNotifiesOtherSide(t *testing.T) {
+	parent, child, persisted, _ := startPairedLoopback(t)
+
+	if err := parent.RevokePeer("child"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "child forgets parent", func() bool { return secretOf(child, "parent") == "" })
+	if secretOf(parent, "child") != "" {
+		t.Fatalf("parent should drop the secret")
+	}
+	if got := persisted("parent"); len(got) != 0 {
+		t.Fatalf("parent persisted %+v", got)
+	}
+	if got := persisted("child"); len(got) != 0 {
+		t.Fatalf("child persisted %+v", got)
+	}
+	if st := parent.Status(); len(st.Peers) != 1 || st.Peers[0].Paired || !st.Peers[0].Connected {
+		t.Fatalf("revoked peer should stay connected but unpaired: %+v", st.Peers)
+	}
+}
+
+func TestRotatePeerSecretKeepsSyncWorking(t *testing.T) {
+	parent, child, persisted, applied := startPairedLoopback(t)
+	old := secretOf(parent, "child")
+
+	if err := parent.RotatePeerSecret("child"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "both sides switch", func() bool {
+		s := secretOf(parent, "child")
+		return s != old && s == secretOf(child, "parent")
+	})
+	next := secretOf(parent, "child")
+	for _, id := range []string{"parent", "child"} {
+		if got := persisted(id); len(got) != 1 || got[0].Secret != next {
+			t.Fatalf("%s persisted %+v", id, got)
+		}
+	}
+
+	if _, err := parent.DispatchScene("SceneA", SourceGUI); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "applied with new key", func() bool { return applied() == 1 })
+	parent.mu.Lock()
+	prev := parent.peers["child"].prevSecret
+	parent.mu.Unlock()
+	if prev != "" {
+		t.Fatalf("old key should be dropped once the child uses the new one")
+	}
+
+	if err := child.RotatePeerSecret("nobody"); err == nil {
+		t.Fatalf("rotating an unknown peer must fail")
+	}
+}
+
+func TestBlockedPeerIsIgnored(t *testing.T) {
+	mgr, tr, applyCount, _ := setupChildManager(t)
+	var saved []TrustedPeer
+	mgr.opts.PersistTrustedPeers = func(peers []TrustedPeer) error {
+		saved = peers
+		return nil
+	}
+
+	if err := mgr.BlockPeer("parent"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := tr.last("parent"); !ok || msg.Type != MsgPeerRevoke {
+		t.Fatalf("block should notify the peer first: %+v", msg)
+	}
+	if len(saved) != 1 || !saved[0].Blocked || saved[0].Secret != "" || saved[0].Name != "parent" {
+		t.Fatalf("unexpected persisted peers: %+v", saved)
+	}
+
+	sent := tr.count("parent")
+	mgr.onTransportMessage(parentRef, sealFromParent(t, Message{Type: MsgSceneCommand, EventID: "evt-blocked", SceneName: "SceneA", FireAtUnixMs: time.Now().UnixMilli()}, 1))
+	mgr.onTransportMessage(parentRef, mustMarshal(t, Message{Type: MsgPairAccept, Status: "ok"}))
+	if *applyCount != 0 || tr.count("parent") != sent {
+		t.Fatalf("blocked peer must be ignored: applied=%d sent=%d->%d", *applyCount, sent, tr.count("parent"))
+	}
+	if st := mgr.Status(); st.ParentConnected || len(st.Peers) != 1 || !st.Peers[0].Blocked {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	if err := mgr.UnblockPeer("parent"); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 0 {
+		t.Fatalf("unblocked peer without key should not be persisted: %+v", saved)
+	}
+}
+
+func TestRenamePeerWhileStopped(t *testing.T) {
+	var saved []TrustedPeer
+	mgr := NewManager(newCaptureTransport("parent"), ManagerOptions{
+		PersistTrustedPeers: func(peers []TrustedPeer) error {
+			saved = peers
+			return nil
+		},
+	})
+	mgr.SetConfig(Config{Role: RoleParent, TrustedPeers: []TrustedPeer{
+		{PeerID: "a", Name: "zeta", Secret: "s1"},
+		{PeerID: "b", Name: "yota", Secret: "s2"},
+	}})
+
+	if err := mgr.RenamePeer("a", " Camera 1 "); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 || saved[0].PeerID != "a" || saved[0].Alias != "Camera 1" || saved[0].Secret != "s1" {
+		t.Fatalf("unexpected persisted peers: %+v", saved)
+	}
+	if st := mgr.Status(); st.Peers[0].Alias != "Camera 1" || !st.Peers[0].Paired {
+		t.Fatalf("status should sort by alias: %+v", st.Peers)
+	}
+	if err := mgr.RenamePeer("missing", "x"); err == nil {
+		t.Fatalf("renaming an unknown peer must fail")
+	}
+}
+
+func mustMarshal(t *testing.T, msg Message) []byte {
+	t.Helper()
+	b, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
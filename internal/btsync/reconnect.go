@@ -0,0 +1,238 @@
+package btsync
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 再接続と切断中のイベント（Config.AutoReconnect / Config.DropMissedEvents）。
+//
+// 再接続は接続する側のトランスポート（LAN の子機、Bluetooth の親機）が行います。AutoReconnect が有効なら、
+// 切れた信頼済み peer へ間隔を倍にしながら（reconnectMinBackoff..reconnectMaxBackoff）つなぎ直し、無効なら
+// 同期を開始し直すまでつなぎ直しません。ペアリング前の peer は従来どおり見つかれば接続します。
+//
+// 切断中のイベントは親機が扱います。DropMissedEvents が有効なら切れた子機への配送をその場で打ち切り、
+// 無効なら再接続した子機へ、まだ発火期限内の配送をすぐ再送します（子機は EventID で重複を捨てる）。
+// どちらでも再接続した子機には今の状態（state.go）を送るので、シーンは親機にそろいます。
+
+const (
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+	reconnectStableFor  = 10 * time.Second // これより長くつながっていた peer は次の切断で間隔を最短に戻す
+)
+
+// SourceCatchUp は子機が状態同期で親機のシーンに合わせた切替です（state.go）。
+const SourceCatchUp Source = "catch_up"
+
+// ReconnectPolicy はトランスポートの再接続の設定です。
+type ReconnectPolicy struct {
+	Enabled    bool
+	KnownPeers []string // 信頼済み peer の ID（切断後の扱いはこの peer にだけ適用する）
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Reconnector は再接続の設定を受け付けるトランスポートです。Manager が Start の前と信頼済み peer が変わるたびに呼びます。
+type Reconnector interface {
+	SetReconnect(policy ReconnectPolicy)
+}
+
+// reconnectState は接続する側のトランスポートが、相手（アドレスや peer ID をキーにする）ごとに
+// 接続してよいかと次に試す時刻を決めるための状態です。
+type reconnectState struct {
+	mu        sync.Mutex
+	policy    ReconnectPolicy
+	known     map[string]bool
+	lost      map[string]bool // 信頼済み peer との接続が切れた（AutoReconnect 無効なら以後つながない）
+	next      map[string]time.Time
+	delay     map[string]time.Duration
+	connected map[string]time.Time
+}
+
+func (r *reconnectState) set(p ReconnectPolicy) {
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = reconnectMinBackoff
+	}
+	if p.MaxBackoff < p.MinBackoff {
+		p.MaxBackoff = reconnectMaxBackoff
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = p
+	r.known = map[string]bool{}
+	for _, id := range p.KnownPeers {
+		r.known[strings.TrimSpace(id)] = true
+	}
+}
+
+// isKnown は peerID が信頼済み peer かを返します。
+func (r *reconnectState) isKnown(peerID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.known[peerID]
+}
+
+// reset は開始時に相手ごとの状態を消します（設定は残す）。
+func (r *reconnectState) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lost = map[string]bool{}
+	r.next = map[string]time.Time{}
+	r.delay = map[string]time.Duration{}
+	r.connected = map[string]time.Time{}
+}
+
+// allow は key へ今接続を試してよいかを返します。
+func (r *reconnectState) allow(key string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lost[key] && !r.policy.Enabled {
+		return false
+	}
+	return !now.Before(r.next[key])
+}
+
+// succeeded は接続できたことを記録します。
+func (r *reconnectState) succeeded(key string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.connected == nil {
+		r.connected = map[string]time.Time{}
+	}
+	r.connected[key] = now
+	delete(r.next, key)
+}
+
+// failed は接続に失敗したので、次に試すまでの間隔を倍にします。
+func (r *reconnectState) failed(key string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backoffLocked(key, now)
+}
+
+// dropped は接続が切れたことを記録します。peerID が信頼済みなら lost とし、短時間で切れた接続ほど間隔を延ばします。
+func (r *reconnectState) dropped(key, peerID string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.known[peerID] {
+		if r.lost == nil {
+			r.lost = map[string]bool{}
+		}
+		r.lost[key] = true
+	}
+	if since, ok := r.connected[key]; ok && now.Sub(since) >= reconnectStableFor {
+		delete(r.delay, key)
+	}
+	delete(r.connected, key)
+	r.backoffLocked(key, now)
+}
+
+func (r *reconnectState) backoffLocked(key string, now time.Time) {
+	if r.next == nil {
+		r.next = map[string]time.Time{}
+		r.delay = map[string]time.Duration{}
+	}
+	min, max := r.policy.MinBackoff, r.policy.MaxBackoff
+	if min <= 0 {
+		min, max = reconnectMinBackoff, reconnectMaxBackoff
+	}
+	d := r.delay[key] * 2
+	if d < min {
+		d = min
+	}
+	if d > max {
+		d = max
+	}
+	r.delay[key] = d
+	r.next[key] = now.Add(d)
+}
+
+// reconnectPolicyLocked は設定と信頼済み peer からトランスポートへ渡す再接続の設定を作ります。
+func (m *Manager) reconnectPolicyLocked() ReconnectPolicy {
+	p := ReconnectPolicy{Enabled: m.cfg.AutoReconnect, MinBackoff: reconnectMinBackoff, MaxBackoff: reconnectMaxBackoff}
+	for _, tp := range m.trustedPeersLocked() {
+		if strings.TrimSpace(tp.Secret) != "" && !tp.Blocked {
+			p.KnownPeers = append(p.KnownPeers, tp.PeerID)
+		}
+	}
+	return p
+}
+
+// applyReconnectPolicy は対応しているトランスポートへ再接続の設定を渡します。
+func (m *Manager) applyReconnectPolicy() {
+	m.mu.Lock()
+	rc, ok := m.transport.(Reconnector)
+	policy := m.reconnectPolicyLocked()
+	m.mu.Unlock()
+	if ok {
+		rc.SetReconnect(policy)
+	}
+}
+
+// onPeerLost は親機で子機が切れたときに呼びます。DropMissedEvents ならその子機への配送を打ち切ります。
+func (m *Manager) onPeerLost(peerID string) {
+	m.mu.Lock()
+	if m.role != RoleParent || !m.cfg.DropMissedEvents {
+		m.mu.Unlock()
+		return
+	}
+	var dropped []string
+	for id, pe := range m.pendingAcks {
+		delete(pe.next, peerID)
+		pe.delivery.mu.Lock()
+		if o, ok := pe.delivery.outcomes[peerID]; ok && o.Status == "" {
+			o.Status = AckTimeout
+			o.Error = "切断されたため破棄しました"
+			dropped = append(dropped, id)
+		}
+		done := true
+		for _, o := range pe.delivery.outcomes {
+			if o.Status == "" {
+				done = false
+			}
+		}
+		if done {
+			pe.delivery.closeLocked()
+			delete(m.pendingAcks, id)
+		}
+		pe.delivery.mu.Unlock()
+	}
+	m.mu.Unlock()
+
+	if len(dropped) > 0 {
+		m.log("info", fmt.Sprintf("切断された peer %s への配送を %d 件破棄しました", peerID, len(dropped)))
+	}
+}
+
+// onPeerReconnected は親機でペアリング済みの子機がつながり直したときに呼びます。
+// 今の状態を送り（state.go）、DropMissedEvents でなければ期限内の配送もすぐ再送します。
+func (m *Manager) onPeerReconnected(peerID string) {
+	m.mu.Lock()
+	p, ok := m.peers[peerID]
+	if m.role != RoleParent || !ok || strings.TrimSpace(p.Secret) == "" {
+		m.mu.Unlock()
+		return
+	}
+	now := m.now()
+	var resend []*pendingEvent
+	for _, pe := range m.pendingAcks {
+		if m.cfg.DropMissedEvents || now.After(pe.sendUntil) {
+			continue
+		}
+		received := false
+		pe.delivery.update(peerID, func(o *PeerOutcome) { received = o.Received })
+		if _, target := pe.delay[peerID]; !target || received {
+			continue
+		}
+		pe.next[peerID] = now.Add(pe.delay[peerID])
+		resend = append(resend, pe)
+	}
+	m.mu.Unlock()
+
+	for _, pe := range resend {
+		m.transmit(pe, peerID)
+	}
+	m.sendState(peerID)
+}
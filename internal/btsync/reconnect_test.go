@@ -0,0 +1,166 @@
+package btsync
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnectStateBackoff(t *testing.T) {
+	var r reconnectState
+	r.set(ReconnectPolicy{Enabled: true, KnownPeers: []string{"p1"}, MinBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond})
+	r.reset()
+	now := time.Unix(1000, 0)
+
+	if !r.allow("p1", now) {
+		t.Fatal("first attempt should be allowed")
+	}
+	for i, want := range []time.Duration{100, 200, 300, 300} {
+		r.failed("p1", now)
+		if r.allow("p1", now.Add(want*time.Millisecond-time.Millisecond)) || !r.allow("p1", now.Add(want*time.Millisecond)) {
+			t.Fatalf("attempt %d: want backoff %dms", i, want)
+		}
+	}
+
+	// 長くつながっていた接続が切れたら最短の間隔に戻す
+	r.succeeded("p1", now)
+	r.dropped("p1", "p1", now.Add(reconnectStableFor))
+	if !r.allow("p1", now.Add(reconnectStableFor+100*time.Millisecond)) {
+		t.Fatal("stable connection should reset the backoff")
+	}
+
+	// 無効なら切れた信頼済み peer にはつなぎ直さない（ペアリング前の peer は見つかればつなぐ）
+	r.set(ReconnectPolicy{Enabled: false, KnownPeers: []string{"p1"}})
+	r.reset()
+	r.dropped("p1", "p1", now)
+	r.dropped("addr", "stranger", now)
+	if r.allow("p1", now.Add(time.Hour)) {
+		t.Fatal("lost trusted peer must not be reconnected when disabled")
+	}
+	if !r.allow("addr", now.Add(time.Second)) {
+		t.Fatal("unpaired peers are still discovered")
+	}
+}
+
+// TestLANChildReconnect は親機が再起動したとき、AutoReconnect の子機だけがつなぎ直すことを確かめます。
+func TestLANChildReconnect(t *testing.T) {
+	for _, auto := range []bool{true, false} {
+		port := freeLANPort(t)
+		parentName, childName := "lan-rc-parent", "lan-rc-child"
+		newMgr := func(name, peer string, role Role) *Manager {
+			mgr := NewManager(nil, ManagerOptions{ApplyScene: func(string, Source) error { return nil }})
+			mgr.SetConfig(Config{
+				Enabled:       true,
+				Role:          role,
+				DeviceName:    name,
+				AutoReconnect: auto,
+				Transport:     TransportLAN,
+				LANPort:       port,
+				LANParentAddr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+				TrustedPeers:  []TrustedPeer{{PeerID: lanLocalPeer(peer).PeerID, Name: peer, Secret: "secret"}},
+			})
+			return mgr
+		}
+		parent := newMgr(parentName, childName, RoleParent)
+		child := newMgr(childName, parentName, RoleChild)
+		if err := parent.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if err := child.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "child connected", func() bool { return child.Status().ParentConnected })
+
+		_ = parent.Stop()
+		waitFor(t, "child disconnected", func() bool { return !child.Status().ParentConnected })
+		if err := parent.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if auto {
+			waitFor(t, "child reconnected", func() bool { return child.Status().ParentConnected })
+		} else {
+			time.Sleep(2 * lanRedialInterval)
+			if child.Status().ParentConnected {
+				t.Fatal("child must not reconnect when AutoReconnect is off")
+			}
+		}
+		_ = child.Stop()
+		_ = parent.Stop()
+	}
+}
+
+func TestReconnectedChildCatchesUp(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		nw := NewLoopbackNetwork(LoopbackOptions{Latency: 2 * time.Millisecond, Seed: 1})
+		var mu sync.Mutex
+		var scenes []string
+		start := func(id string, role Role) *Manager {
+			return startLoopbackManager(t, nw, id, role, ManagerOptions{
+				ApplyScene: func(scene string, _ Source) error {
+					if id == "child" {
+						mu.Lock()
+						scenes = append(scenes, scene)
+						mu.Unlock()
+					}
+					return nil
+				},
+			}, Config{LeadTimeMs: 30, AcceptLateMs: 100, DropMissedEvents: drop})
+		}
+		parent := start("parent", RoleParent)
+		child := start("child", RoleChild)
+		code, _ := parent.GeneratePairingCode()
+		if err := child.JoinByCode(code); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "paired", func() bool { return secretOf(child, "parent") != "" && secretOf(parent, "child") != "" })
+
+		if _, err := parent.DispatchScene("SceneA", SourceGUI); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "SceneA", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(scenes) == 1
+		})
+
+		nw.Disconnect("child")
+		d, err := parent.DispatchScene("SceneB", SourceGUI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := d.Wait(); len(out) != 0 {
+			t.Fatalf("disconnected child should not be a target: %+v", out)
+		}
+		nw.Connect("child")
+
+		// DropMissedEvents でも、つながり直したときの状態同期でシーンはそろう
+		waitFor(t, "caught up", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(scenes) == 2 && scenes[1] == "SceneB"
+		})
+	}
+}
+
+func TestDropMissedEventsAbandonsDelivery(t *testing.T) {
+	mgr, _ := setupParentManager(t, 5000, 100)
+	mgr.mu.Lock()
+	mgr.cfg.DropMissedEvents = true
+	mgr.mu.Unlock()
+
+	d, err := mgr.DispatchScene("SceneA", SourceGUI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.onTransportPeerEvent(PeerEvent{Peer: childRef, Connected: false})
+	select {
+	case <-d.Done():
+	case <-time.After(time.Second):
+		t.Fatal("delivery to a lost peer should be abandoned")
+	}
+	if out := d.Outcomes(); len(out) != 1 || out[0].Status != AckTimeout || out[0].Received {
+		t.Fatalf("unexpected outcome: %+v", out)
+	}
+}
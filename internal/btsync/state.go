@@ -0,0 +1,113 @@
+package btsync
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 状態同期（state）。
+//
+// 親機は子機がつながったときと heartbeat ごとに、今のプログラムシーンと最後のシーン切替の EventID を送ります。
+// 子機は自分のシーンと違えばそのシーンへ切り替えるので、切断中に逃したシーン切替があっても全台が親機にそろいます。
+// 最後のシーン切替がまだ発火期限内なら、そのシーンを発火時刻付きで送り（配送中の子機には送らない）、
+// 子機は発火時刻を待ってから切り替えます。子機は自分のシーン切替の発火待ちの間は状態を適用しません。
+
+// sendState は親機の今の状態を peerIDs へ送ります（ペアリングしていない peer には送れないので無視する）。
+func (m *Manager) sendState(peerIDs ...string) {
+	m.mu.Lock()
+	if !m.running || m.role != RoleParent || len(peerIDs) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	cfg := m.cfg.Normalize()
+	last := m.lastScene
+	now := m.now()
+	fireAt := time.UnixMilli(last.FireAtUnixMs)
+	inFlight := last.EventID != "" && now.Before(fireAt.Add(time.Duration(cfg.AcceptLateMs)*time.Millisecond))
+	skip := map[string]bool{}
+	if pe, ok := m.pendingAcks[last.EventID]; ok && inFlight {
+		for id := range pe.delay {
+			skip[id] = true // 配送中のシーン切替がそのまま届く
+		}
+	}
+	m.mu.Unlock()
+
+	st := Message{Type: MsgState, EventID: last.EventID, SceneName: last.SceneName}
+	if inFlight {
+		st.FireAtUnixMs = last.FireAtUnixMs
+	} else if m.opts.CurrentScene != nil {
+		// 発火後は OBS の実際のシーン（同期を通さない切替も含める）を送る
+		if scene, err := m.opts.CurrentScene(); err == nil && strings.TrimSpace(scene) != "" {
+			st.SceneName = strings.TrimSpace(scene)
+		}
+	}
+	if st.SceneName == "" {
+		return
+	}
+	for _, id := range peerIDs {
+		if !skip[id] {
+			_ = m.sendSealed(id, st)
+		}
+	}
+}
+
+// handleState は子機で親機の状態を受け、自分のシーンと違えば切り替えます。
+func (m *Manager) handleState(from PeerRef, msg Message) {
+	scene := strings.TrimSpace(msg.SceneName)
+	if scene == "" {
+		return
+	}
+	m.mu.Lock()
+	busy := m.firing > 0
+	current := m.appliedScene
+	m.mu.Unlock()
+	if busy {
+		return
+	}
+	if m.opts.CurrentScene != nil {
+		if s, err := m.opts.CurrentScene(); err == nil {
+			current = strings.TrimSpace(s) // 空（OBS ごとにシーンが違うなど）なら合わせ直す
+		}
+	}
+	if current == scene {
+		return
+	}
+
+	ok, err := m.sceneExists(scene)
+	if err != nil || !ok {
+		m.mu.Lock()
+		repeated := m.stateMissing == scene
+		m.stateMissing = scene
+		m.mu.Unlock()
+		if !repeated {
+			m.log("error", fmt.Sprintf("状態同期: 親機のシーン %s に合わせられません (exists=%v err=%v)", scene, ok, err))
+		}
+		return
+	}
+
+	m.mu.Lock()
+	m.firing++
+	m.stateMissing = ""
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.firing--
+		m.mu.Unlock()
+	}()
+	if msg.EventID != "" {
+		// 同じシーン切替が後から届いても、もう一度は切り替えない
+		m.firstSeen(msg.EventID)
+	}
+	if msg.FireAtUnixMs != 0 {
+		m.waitUntil(m.localTime(from.PeerID, time.UnixMilli(msg.FireAtUnixMs)))
+	}
+	if err := m.applyScene(scene, SourceCatchUp); err != nil {
+		m.log("error", fmt.Sprintf("状態同期: シーン %s への切替に失敗: %v", scene, err))
+		return
+	}
+	m.mu.Lock()
+	m.appliedScene = scene
+	m.mu.Unlock()
+	m.log("info", fmt.Sprintf("状態同期: 親機のシーン %s に合わせました", scene))
+}
@@ -0,0 +1,62 @@
+package btsync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChildAppliesStateWhenDifferent(t *testing.T) {
+	mgr, _, applyCount, _ := setupChildManager(t)
+
+	state := Message{Type: MsgState, EventID: "evt-state", SceneName: "SceneA"}
+	mgr.onTransportMessage(parentRef, sealFromParent(t, state, 1))
+	if *applyCount != 1 {
+		t.Fatalf("child should switch to the parent's scene: applied=%d", *applyCount)
+	}
+	mgr.onTransportMessage(parentRef, sealFromParent(t, state, 2))
+	if *applyCount != 1 {
+		t.Fatalf("same scene must not be applied again: applied=%d", *applyCount)
+	}
+	// 状態で合わせたシーン切替が後から届いても切り替え直さない
+	mgr.onTransportMessage(parentRef, sealFromParent(t, Message{Type: MsgSceneCommand, EventID: "evt-state", SceneName: "SceneA", FireAtUnixMs: time.Now().UnixMilli()}, 3))
+	if *applyCount != 1 {
+		t.Fatalf("scene command already covered by state: applied=%d", *applyCount)
+	}
+
+	// OBS 側が親機と同じなら何もしない
+	mgr.opts.CurrentScene = func() (string, error) { return "SceneB", nil }
+	mgr.onTransportMessage(parentRef, sealFromParent(t, Message{Type: MsgState, SceneName: "SceneB"}, 4))
+	if *applyCount != 1 {
+		t.Fatalf("scene already on program: applied=%d", *applyCount)
+	}
+}
+
+func TestParentStateSkipsPendingTargets(t *testing.T) {
+	mgr, tr := setupParentManager(t, 5000, 100)
+	mgr.opts.CurrentScene = func() (string, error) { return "Manual", nil }
+	mgr.mu.Lock()
+	mgr.peers["late"] = &peerState{TrustedPeer: TrustedPeer{PeerID: "late", Secret: "secret"}}
+	mgr.mu.Unlock()
+
+	if _, err := mgr.DispatchScene("SceneA", SourceGUI); err != nil {
+		t.Fatal(err)
+	}
+	sent := tr.count("child")
+	mgr.sendState("child", "late") // late はシーン切替の後にペアリングした子機
+	if tr.count("child") != sent {
+		t.Fatal("child receiving the scene command must not get a state")
+	}
+	msg, ok := tr.last("late")
+	if !ok || msg.Type != MsgState || msg.SceneName != "SceneA" || msg.FireAtUnixMs == 0 {
+		t.Fatalf("in-flight scene should be sent with its fire time: %+v", msg)
+	}
+
+	// 発火後は OBS の実際のシーンを送る
+	mgr.mu.Lock()
+	mgr.lastScene.FireAtUnixMs = time.Now().Add(-time.Second).UnixMilli()
+	mgr.mu.Unlock()
+	mgr.sendState("late")
+	if msg, _ := tr.last("late"); msg.SceneName != "Manual" || msg.FireAtUnixMs != 0 {
+		t.Fatalf("settled state should follow the program scene: %+v", msg)
+	}
+}
@@ -1,9 +1,6 @@
 package btsync
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -13,13 +10,24 @@ import (
 )
 
 const (
-	ProtocolVersion = 1
+	// ProtocolVersion 2 でペアリング後の全メッセージに HMAC・セッション・seq を付けた（v1 とは通信できない）
+	ProtocolVersion = 2
 
 	MsgPairRequest  = "pair_request"
 	MsgPairAccept   = "pair_accept"
+	MsgPairConfirm  = "pair_confirm"
 	MsgSceneCommand = "scene_command"
 	MsgSceneAck     = "scene_ack"
 	MsgHeartbeat    = "heartbeat"
+
+	MsgHeartbeatReply = "heartbeat_reply"
+	MsgCommand        = "command"
+	MsgCommandAck     = "command_ack"
+	MsgPeerRevoke     = "peer_revoke" // 送信側がペアリングを解除した（受信側も鍵を捨てる）
+	MsgRekey          = "rekey"       // 鍵の更新（Status: request / accept / done）
+	MsgState          = "state"       // 親機の今のシーンと最後のシーン切替の EventID（子機は違えば合わせる）
+	MsgPing           = "ping"        // 親機が遅延を測る（子機は ClockT1Us をそのまま pong で返す）
+	MsgPong           = "pong"
 )
 
 type Role string
@@ -44,6 +52,19 @@ const (
 	AckNotFound AckStatus = "not_found"
 	AckError    AckStatus = "error"
 	AckLateDrop AckStatus = "late_drop"
+	// AckUnsupported は子機がそのコマンドを実行できない（コールバック未設定）ことを表す
+	AckUnsupported AckStatus = "unsupported"
+	// AckReceived は子機が実行前に受信だけを知らせる ACK（親機はこれで再送をやめる）
+	AckReceived AckStatus = "received"
+	// AckTimeout は期限までに ACK が無かったことを表す（親機の Delivery でだけ使う）
+	AckTimeout AckStatus = "timeout"
+)
+
+type TransportKind string
+
+const (
+	TransportBluetooth TransportKind = "bluetooth"
+	TransportLAN       TransportKind = "lan"
 )
 
 type TrustedPeer struct {
@@ -52,6 +73,17 @@ type TrustedPeer struct {
 	Secret   string `json:"secret"`
 	LastSeen string `json:"last_seen"`
 	Platform string `json:"platform"`
+	// Alias はユーザーが付けた表示名（空なら Name）、Blocked はこの peer ID からのメッセージを全て無視する
+	Alias   string `json:"alias,omitempty"`
+	Blocked bool   `json:"blocked,omitempty"`
+}
+
+// DisplayName は Alias があればそれを、無ければ相手の名乗る Name を返します。
+func (p TrustedPeer) DisplayName() string {
+	if p.Alias != "" {
+		return p.Alias
+	}
+	return p.Name
 }
 
 type Config struct {
@@ -59,11 +91,15 @@ type Config struct {
 	Role              Role          `json:"role"`
 	DeviceName        string        `json:"device_name"`
 	LeadTimeMs        int           `json:"lead_time_ms"`
+	AutoLeadTime      bool          `json:"auto_lead_time"` // 親機が測った遅延から同期待ち時間を決める（LeadTimeMs は測れるまでの値）
 	PairingCodeTTLSec int           `json:"pairing_code_ttl_sec"`
 	AcceptLateMs      int           `json:"accept_late_ms"`
 	MaxNodes          int           `json:"max_nodes"`
 	AutoReconnect     bool          `json:"auto_reconnect"`
 	DropMissedEvents  bool          `json:"drop_missed_events"`
+	Transport         TransportKind `json:"transport"`       // bluetooth|lan（空なら bluetooth）
+	LANPort           int           `json:"lan_port"`        // LAN: 親機の TCP 待ち受け / UDP ビーコンの番号
+	LANParentAddr     string        `json:"lan_parent_addr"` // LAN 子機: ブロードキャストが届かないときの親機 host:port
 	TrustedPeers      []TrustedPeer `json:"trusted_peers"`
 }
 
@@ -86,6 +122,15 @@ func (c Config) Normalize() Config {
 	if out.MaxNodes <= 0 {
 		out.MaxNodes = 4
 	}
+	switch out.Transport {
+	case TransportBluetooth, TransportLAN:
+	default:
+		out.Transport = TransportBluetooth
+	}
+	if out.LANPort <= 0 || out.LANPort > 65535 {
+		out.LANPort = DefaultLANPort
+	}
+	out.LANParentAddr = strings.TrimSpace(out.LANParentAddr)
 	legacyUnset := !out.Enabled &&
 		out.Role == RoleOff &&
 		strings.TrimSpace(out.DeviceName) == "" &&
@@ -95,6 +140,10 @@ func (c Config) Normalize() Config {
 		out.MaxNodes == 4 &&
 		!out.AutoReconnect &&
 		!out.DropMissedEvents &&
+		!out.AutoLeadTime &&
+		out.Transport == TransportBluetooth &&
+		out.LANPort == DefaultLANPort &&
+		out.LANParentAddr == "" &&
 		len(out.TrustedPeers) == 0
 	if strings.TrimSpace(out.DeviceName) == "" {
 		out.DeviceName = defaultDeviceName()
@@ -119,31 +168,45 @@ func defaultDeviceName() string {
 }
 
 type Status struct {
-	Supported                bool         `json:"supported"`
-	SupportedRoleParent      bool         `json:"supported_role_parent"`
-	SupportedRoleChild       bool         `json:"supported_role_child"`
-	UnsupportedReason        string       `json:"unsupported_reason,omitempty"`
-	Running                  bool         `json:"running"`
-	Enabled                  bool         `json:"enabled"`
-	Role                     Role         `json:"role"`
-	DeviceName               string       `json:"device_name"`
-	ConnectedPeers           int          `json:"connected_peers"`
-	ParentConnected          bool         `json:"parent_connected"`
-	PairingCodeActive        bool         `json:"pairing_code_active"`
-	PairingCodeExpiresUnixMs int64        `json:"pairing_code_expires_unix_ms,omitempty"`
-	LastError                string       `json:"last_error,omitempty"`
-	Peers                    []PeerStatus `json:"peers"`
+	Supported                bool          `json:"supported"`
+	SupportedRoleParent      bool          `json:"supported_role_parent"`
+	SupportedRoleChild       bool          `json:"supported_role_child"`
+	UnsupportedReason        string        `json:"unsupported_reason,omitempty"`
+	Running                  bool          `json:"running"`
+	Enabled                  bool          `json:"enabled"`
+	Role                     Role          `json:"role"`
+	Transport                TransportKind `json:"transport"`
+	DeviceName               string        `json:"device_name"`
+	ConnectedPeers           int           `json:"connected_peers"`
+	ParentConnected          bool          `json:"parent_connected"`
+	PairingCodeActive        bool          `json:"pairing_code_active"`
+	PairingCodeExpiresUnixMs int64         `json:"pairing_code_expires_unix_ms,omitempty"`
+	LastError                string        `json:"last_error,omitempty"`
+	// LeadTimeMs は今使う同期待ち時間、LeadTimeAuto は親機が測った遅延から決めたとき true
+	LeadTimeMs   int          `json:"lead_time_ms"`
+	LeadTimeAuto bool         `json:"lead_time_auto"`
+	Peers        []PeerStatus `json:"peers"`
 }
 
 type PeerStatus struct {
 	PeerID         string `json:"peer_id"`
 	Name           string `json:"name"`
+	Alias          string `json:"alias,omitempty"`
 	Platform       string `json:"platform"`
 	Connected      bool   `json:"connected"`
+	Paired         bool   `json:"paired"`
+	Blocked        bool   `json:"blocked"`
 	LastSeenUnixMs int64  `json:"last_seen_unix_ms,omitempty"`
 	LastAckUnixMs  int64  `json:"last_ack_unix_ms,omitempty"`
 	LastAckStatus  string `json:"last_ack_status,omitempty"`
 	LastLatencyMs  int64  `json:"last_latency_ms,omitempty"`
+	// RTTMs は親機が ping で測った直近の最大の往復時間
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+
+	// ClockOffsetMs は「peer の時計 - 自分の時計」の推定値、ClockUncertaintyMs はその誤差の上限（RTT/2）
+	ClockSynced        bool    `json:"clock_synced"`
+	ClockOffsetMs      float64 `json:"clock_offset_ms,omitempty"`
+	ClockUncertaintyMs float64 `json:"clock_uncertainty_ms,omitempty"`
 }
 
 type Message struct {
@@ -155,17 +218,34 @@ type Message struct {
 	Source       string `json:"source,omitempty"`
 	FireAtUnixMs int64  `json:"fire_at_unix_ms,omitempty"`
 	SentAtUnixMs int64  `json:"sent_at_unix_ms,omitempty"`
-	HMAC         string `json:"hmac,omitempty"`
+	// Command は command / command_ack の中身（ACK では Kind だけ）。HMAC はメッセージ全体に掛かるのでパラメータも保護される
+	Command *Command `json:"command,omitempty"`
+	HMAC    string   `json:"hmac,omitempty"`
+
+	// 送信側の起動ごとのセッション ID と peer ごとの通し番号（リプレイ検出用）
+	Session string `json:"session,omitempty"`
+	Seq     uint64 `json:"seq,omitempty"`
 
-	PairingCode string `json:"pairing_code,omitempty"`
-	PeerID      string `json:"peer_id,omitempty"`
-	PeerName    string `json:"peer_name,omitempty"`
-	Platform    string `json:"platform,omitempty"`
-	Secret      string `json:"secret,omitempty"`
+	// 鍵の更新（rekey）で交換する X25519 の一時公開鍵（hex）
+	RekeyKey string `json:"rekey_key,omitempty"`
+
+	// ペアリング（SPAKE2）。コードと共有鍵そのものは送らない
+	Pake     string `json:"pake,omitempty"`
+	Confirm  string `json:"confirm,omitempty"`
+	PeerID   string `json:"peer_id,omitempty"`
+	PeerName string `json:"peer_name,omitempty"`
+	Platform string `json:"platform,omitempty"`
 
 	Status    string `json:"status,omitempty"`
 	Error     string `json:"error,omitempty"`
 	LatencyMs int64  `json:"latency_ms,omitempty"`
+
+	// 時計合わせ（heartbeat / heartbeat_reply）。時刻は Unix マイクロ秒
+	ClockT1Us          int64 `json:"clock_t1_us,omitempty"`
+	ClockT2Us          int64 `json:"clock_t2_us,omitempty"`
+	ClockT3Us          int64 `json:"clock_t3_us,omitempty"`
+	ClockOffsetUs      int64 `json:"clock_offset_us,omitempty"`
+	ClockUncertaintyUs int64 `json:"clock_uncertainty_us,omitempty"`
 }
 
 func (m Message) Marshal() ([]byte, error) {
@@ -178,17 +258,6 @@ func UnmarshalMessage(payload []byte) (Message, error) {
 	return m, err
 }
 
-func sceneHMAC(secret string, m Message) string {
-	mac := hmac.New(sha256.New, []byte(secret))
-	_, _ = mac.Write([]byte(fmt.Sprintf("%d|%s|%s|%s|%d|%d", ProtocolVersion, m.EventID, m.SceneName, m.Source, m.FireAtUnixMs, m.SentAtUnixMs)))
-	return hex.EncodeToString(mac.Sum(nil))
-}
-
-func verifySceneHMAC(secret string, m Message) bool {
-	got := sceneHMAC(secret, m)
-	return hmac.Equal([]byte(strings.ToLower(got)), []byte(strings.ToLower(m.HMAC)))
-}
-
 type PeerRef struct {
 	PeerID   string
 	Name     string
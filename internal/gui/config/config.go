@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -46,6 +47,27 @@ type MidiConfig struct {
 	Debounce  string   `json:"debounce"`   // 例: "30ms"
 	RateLimit string   `json:"rate_limit"` // 例: "50ms"
 	Mappings  []string `json:"mappings"`
+	// Inputs は同時に開く追加の入力デバイスです（デバウンス・レート制限は上の値を使います）。
+	Inputs []MidiInputConfig `json:"inputs,omitempty"`
+	// Banks は切り替えて使うマッピングのページです（1 台目のデバイスのみ、先頭が起動時のバンク）。
+	// FeedbackDevice を指定すると、有効なバンクの切替ボタンを MIDI 出力で点灯します。
+	Banks          []MidiBankConfig `json:"banks,omitempty"`
+	FeedbackDevice string           `json:"feedback_device,omitempty"`
+}
+
+// MidiBankConfig はマッピングのバンク 1 つ分の設定です。Select / Shift は "ch:note"（"ch:cc64" 等）形式のトリガです。
+type MidiBankConfig struct {
+	Name     string   `json:"name"`
+	Select   string   `json:"select,omitempty"` // 押すとこのバンクに切り替える
+	Shift    string   `json:"shift,omitempty"`  // 押している間だけこのバンクにする
+	Mappings []string `json:"mappings"`
+}
+
+// MidiInputConfig は追加の MIDI 入力デバイス 1 台分の設定です。
+type MidiInputConfig struct {
+	Device   string   `json:"device"`
+	Channel  string   `json:"channel"`
+	Mappings []string `json:"mappings"`
 }
 
 // BluetoothSyncConfig はGUI用 Bluetooth 同期設定。
@@ -54,11 +76,15 @@ type BluetoothSyncConfig struct {
 	Role              string        `json:"role"` // off|parent|child
 	DeviceName        string        `json:"device_name"`
 	LeadTimeMs        int           `json:"lead_time_ms"`
+	AutoLeadTime      bool          `json:"auto_lead_time"` // 親機: 測った遅延から同期待ち時間を決める（LeadTimeMs は測れるまでの値）
 	PairingCodeTTLSec int           `json:"pairing_code_ttl_sec"`
 	AcceptLateMs      int           `json:"accept_late_ms"`
 	MaxNodes          int           `json:"max_nodes"`
 	AutoReconnect     bool          `json:"auto_reconnect"`
 	DropMissedEvents  bool          `json:"drop_missed_events"`
+	Transport         string        `json:"transport"`       // bluetooth|lan
+	LANPort           int           `json:"lan_port"`        // LAN 同期のポート（TCP / UDP ビーコン）
+	LANParentAddr     string        `json:"lan_parent_addr"` // 子機: 親機の host:port（空ならブロードキャストで探索）
 	TrustedPeers      []TrustedPeer `json:"trusted_peers"`
 }
 
@@ -68,6 +94,8 @@ type TrustedPeer struct {
 	Secret   string `json:"secret"`
 	LastSeen string `json:"last_seen"`
 	Platform string `json:"platform"`
+	Alias    string `json:"alias,omitempty"`
+	Blocked  bool   `json:"blocked,omitempty"`
 }
 
 func Default() *Config {
@@ -81,16 +109,39 @@ func Default() *Config {
 			Role:              "off",
 			DeviceName:        "",
 			LeadTimeMs:        300,
+			AutoLeadTime:      true,
 			PairingCodeTTLSec: 60,
 			AcceptLateMs:      500,
 			MaxNodes:          4,
 			AutoReconnect:     true,
 			DropMissedEvents:  true,
+			Transport:         "bluetooth",
+			LANPort:           47810,
 			TrustedPeers:      []TrustedPeer{},
 		},
 	}
 }
 
+// Clone は c の複製を返します（スライスも複製するので、複製を書き換えても c は変わりません）。
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return nil
+	}
+	out := *c
+	out.Connections = slices.Clone(c.Connections)
+	out.MIDI.Mappings = slices.Clone(c.MIDI.Mappings)
+	out.MIDI.Inputs = slices.Clone(c.MIDI.Inputs)
+	for i := range out.MIDI.Inputs {
+		out.MIDI.Inputs[i].Mappings = slices.Clone(out.MIDI.Inputs[i].Mappings)
+	}
+	out.MIDI.Banks = slices.Clone(c.MIDI.Banks)
+	for i := range out.MIDI.Banks {
+		out.MIDI.Banks[i].Mappings = slices.Clone(out.MIDI.Banks[i].Mappings)
+	}
+	out.Bluetooth.TrustedPeers = slices.Clone(c.Bluetooth.TrustedPeers)
+	return &out
+}
+
 // 保存先パス（OS毎の規定の設定ディレクトリ配下）
 func path() (string, error) {
 	dir, err := os.UserConfigDir()
@@ -104,6 +155,9 @@ func path() (string, error) {
 	return filepath.Join(d, "config.json"), nil
 }
 
+// Path は設定ファイルの保存先パスを返します（変更監視用）。
+func Path() (string, error) { return path() }
+
 // Load は設定を読み込みます。無い場合は (nil, os.ErrNotExist) を返します。
 func Load() (*Config, error) {
 	p, err := path()
@@ -162,6 +216,7 @@ func normalizeConfig(c *Config) {
 		b.MaxNodes == 0 &&
 		!b.AutoReconnect &&
 		!b.DropMissedEvents &&
+		!b.AutoLeadTime &&
 		len(b.TrustedPeers) == 0
 	switch b.Role {
 	case "off", "parent", "child":
@@ -180,9 +235,18 @@ func normalizeConfig(c *Config) {
 	if b.MaxNodes <= 0 {
 		b.MaxNodes = 4
 	}
+	switch b.Transport {
+	case "bluetooth", "lan":
+	default:
+		b.Transport = "bluetooth"
+	}
+	if b.LANPort <= 0 || b.LANPort > 65535 {
+		b.LANPort = 47810
+	}
 	if legacyUnset {
 		b.AutoReconnect = true
 		b.DropMissedEvents = true
+		b.AutoLeadTime = true
 	}
 	if b.TrustedPeers == nil {
 		b.TrustedPeers = []TrustedPeer{}
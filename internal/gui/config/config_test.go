@@ -91,7 +91,34 @@ func TestLoadLegacyConfigWithoutBluetooth(t *testing.T) {
 	if got.Bluetooth.MaxNodes != 4 {
 		t.Fatalf("expected default max_nodes=4, got %d", got.Bluetooth.MaxNodes)
 	}
-	if !got.Bluetooth.AutoReconnect || !got.Bluetooth.DropMissedEvents {
-		t.Fatalf("expected default reconnect/drop flags true")
+	if got.Bluetooth.Transport != "bluetooth" || got.Bluetooth.LANPort != 47810 {
+		t.Fatalf("expected default transport bluetooth/47810, got %q/%d", got.Bluetooth.Transport, got.Bluetooth.LANPort)
+	}
+	if !got.Bluetooth.AutoReconnect || !got.Bluetooth.DropMissedEvents || !got.Bluetooth.AutoLeadTime {
+		t.Fatalf("expected default reconnect/drop/auto lead flags true")
+	}
+}
+
+func TestCloneCopiesSlices(t *testing.T) {
+	c := Default()
+	c.Connections = []Connection{{Name: "OBS 1", Addr: "127.0.0.1:4455", Enabled: true}}
+	c.MIDI.Mappings = []string{"1:36=A"}
+	c.MIDI.Banks = []MidiBankConfig{{Name: "B", Mappings: []string{"1:37=B"}}}
+	c.MIDI.Inputs = []MidiInputConfig{{Device: "Foot", Mappings: []string{"2:1=C"}}}
+	c.Bluetooth.TrustedPeers = []TrustedPeer{{PeerID: "p1"}}
+
+	d := c.Clone()
+	d.Connections[0].Enabled = false
+	d.MIDI.Mappings[0] = "1:36=X"
+	d.MIDI.Banks[0].Mappings[0] = "1:37=X"
+	d.MIDI.Inputs[0].Mappings[0] = "2:1=X"
+	d.Bluetooth.TrustedPeers[0].Blocked = true
+
+	if !c.Connections[0].Enabled || c.MIDI.Mappings[0] != "1:36=A" || c.MIDI.Banks[0].Mappings[0] != "1:37=B" ||
+		c.MIDI.Inputs[0].Mappings[0] != "2:1=C" || c.Bluetooth.TrustedPeers[0].Blocked {
+		t.Fatalf("Clone shares slices with the original: %+v", c)
+	}
+	if e := Default().Clone(); e.Connections == nil || e.MIDI.Mappings == nil {
+		t.Fatalf("Clone should keep empty slices non-nil: %+v", e)
 	}
 }
@@ -0,0 +1,23 @@
+package midi
+
+import "strings"
+
+// OpenInput は指定デバイスを開き、イベントチャネルを返す。
+// "rtpmidi://" で始まる名前はネットワーク MIDI（RTP-MIDI / AppleMIDI）のセッションとして待ち受け、
+// "replay://" で始まる名前は記録ファイル（obsctl midi record の出力）を再生する。
+// それ以外はネイティブの入力ポート（デフォルトビルドでは未対応。midi_native タグが必要）。
+func OpenInput(deviceName string) (Input, <-chan Event, error) {
+    if strings.HasPrefix(deviceName, RTPMIDIScheme) {
+        return OpenRTPMIDI(deviceName)
+    }
+    if strings.HasPrefix(deviceName, ReplayScheme) {
+        return OpenReplay(deviceName)
+    }
+    return openNative(deviceName)
+}
+
+// IsURLDevice はデバイス名が URL 形式（ポート一覧に現れない入力）かを返す。
+// URL 形式の入力は抜き差しの監視・再接続を行わない。
+func IsURLDevice(deviceName string) bool {
+    return strings.HasPrefix(deviceName, RTPMIDIScheme) || strings.HasPrefix(deviceName, ReplayScheme)
+}
@@ -4,7 +4,6 @@ package midi
 
 import (
     "fmt"
-    "strings"
     "sync"
     "time"
 
@@ -13,15 +12,20 @@ import (
 )
 
 // inputWrap は rtmididrv のポート/ドライバをまとめて Close する薄いラッパです。
+// Close 後はイベントチャネルも閉じます（リスナからの送信とは mu で排他）。
 type inputWrap struct{
     drv *rtmididrv.Driver
     in  midi.In
     once sync.Once
+
+    mu     sync.RWMutex
+    closed bool
+    evCh   chan Event
 }
 
-// OpenInput は指定名の入力ポートを開き、イベントをチャネルで返す。
-// デバイス名は完全一致。合致しない場合はエラー。
-func OpenInput(deviceName string) (Input, <-chan Event, error) {
+// openNative は指定名の入力ポートを開き、イベントをチャネルで返す。
+// デバイス名は完全一致を優先し、無ければ部分一致。合致しない場合はエラー。
+func openNative(deviceName string) (Input, <-chan Event, error) {
     drv, err := rtmididrv.New()
     if err != nil {
         return nil, nil, fmt.Errorf("rtmididrv.New: %w", err)
@@ -32,16 +36,14 @@ func OpenInput(deviceName string) (Input, <-chan Event, error) {
         return nil, nil, fmt.Errorf("MIDI入力列挙に失敗: %w", err)
     }
     var in midi.In
-    // 優先: 完全一致 → 部分一致
+    names := make([]string, 0, len(ins))
     for _, p := range ins {
-        if p.String() == deviceName {
-            in = p
-            break
-        }
+        names = append(names, p.String())
     }
-    if in == nil {
+    // 優先: 完全一致 → 部分一致
+    if port, ok := MatchPort(names, deviceName); ok {
         for _, p := range ins {
-            if strings.Contains(p.String(), deviceName) {
+            if p.String() == port {
                 in = p
                 break
             }
@@ -57,49 +59,13 @@ func OpenInput(deviceName string) (Input, <-chan Event, error) {
     }
 
     evCh := make(chan Event, 128)
+    w := &inputWrap{drv: drv, in: in, evCh: evCh}
 
     // 生バイトを受け取り、代表的なチャンネルメッセージのみ正規化して流す
     if err := in.SetListener(func(bt []byte, _ int64) {
-        if len(bt) == 0 {
-            return
-        }
-        status := bt[0]
-        // Realtime/System Common は無視
-        if status >= 0xF0 {
-            return
-        }
-        typ := status >> 4
-        ch := (status & 0x0F) + 1 // 1-16
-        now := time.Now()
-
-        switch typ {
-        case 0x08: // NoteOff
-            if len(bt) >= 3 {
-                e := Event{Type: NoteOff, Channel: ch, Data1: bt[1] & 0x7F, Data2: bt[2] & 0x7F, Time: now}
-                select { case evCh <- e: default: }
-            }
-        case 0x09: // NoteOn（Vel==0 は NoteOff）
-            if len(bt) >= 3 {
-                vel := bt[2] & 0x7F
-                t := NoteOn
-                if vel == 0 {
-                    t = NoteOff
-                }
-                e := Event{Type: t, Channel: ch, Data1: bt[1] & 0x7F, Data2: vel, Time: now}
-                select { case evCh <- e: default: }
-            }
-        case 0x0B: // ControlChange
-            if len(bt) >= 3 {
-                e := Event{Type: ControlChange, Channel: ch, Data1: bt[1] & 0x7F, Data2: bt[2] & 0x7F, Time: now}
-                select { case evCh <- e: default: }
-            }
-        case 0x0C: // ProgramChange
-            if len(bt) >= 2 {
-                e := Event{Type: ProgramChange, Channel: ch, Data1: bt[1] & 0x7F, Time: now}
-                select { case evCh <- e: default: }
-            }
-        default:
-            // ignore other channel messages
+        if e, ok := ParseMessage(bt); ok {
+            e.Time = time.Now()
+            w.send(e)
         }
     }); err != nil {
         _ = in.Close()
@@ -108,7 +74,17 @@ func OpenInput(deviceName string) (Input, <-chan Event, error) {
     }
 
     // ラッパを返す
-    return &inputWrap{drv: drv, in: in}, evCh, nil
+    return w, evCh, nil
+}
+
+// send はバッファが一杯なら読み捨てる（Close 後は何もしない）。
+func (w *inputWrap) send(e Event) {
+    w.mu.RLock()
+    defer w.mu.RUnlock()
+    if w.closed {
+        return
+    }
+    select { case w.evCh <- e: default: }
 }
 
 func (w *inputWrap) Close() error {
@@ -117,6 +93,10 @@ func (w *inputWrap) Close() error {
         // best-effort 停止
         _ = w.in.Close()
         err = w.drv.Close()
+        w.mu.Lock()
+        w.closed = true
+        close(w.evCh)
+        w.mu.Unlock()
     })
     return err
 }
@@ -138,3 +118,62 @@ func ListInputs() ([]string, error) {
     }
     return names, nil
 }
+
+// outputWrap は rtmididrv の出力ポートとドライバをまとめて Close する薄いラッパです。
+type outputWrap struct{
+    drv  *rtmididrv.Driver
+    out  midi.Out
+    mu   sync.Mutex
+    once sync.Once
+}
+
+// OpenOutput は指定名の出力ポートを開く。名前の照合は入力と同じ（完全一致 → 部分一致）。
+func OpenOutput(deviceName string) (Output, error) {
+    drv, err := rtmididrv.New()
+    if err != nil {
+        return nil, fmt.Errorf("rtmididrv.New: %w", err)
+    }
+    outs, err := drv.Outs()
+    if err != nil {
+        _ = drv.Close()
+        return nil, fmt.Errorf("MIDI出力列挙に失敗: %w", err)
+    }
+    names := make([]string, 0, len(outs))
+    for _, p := range outs {
+        names = append(names, p.String())
+    }
+    var out midi.Out
+    if port, ok := MatchPort(names, deviceName); ok {
+        for _, p := range outs {
+            if p.String() == port {
+                out = p
+                break
+            }
+        }
+    }
+    if out == nil {
+        _ = drv.Close()
+        return nil, fmt.Errorf("MIDI出力デバイスが見つかりません: %s", deviceName)
+    }
+    if err := out.Open(); err != nil {
+        _ = drv.Close()
+        return nil, fmt.Errorf("出力オープン失敗: %w", err)
+    }
+    return &outputWrap{drv: drv, out: out}, nil
+}
+
+func (w *outputWrap) Send(msg []byte) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    _, err := w.out.Write(msg)
+    return err
+}
+
+func (w *outputWrap) Close() error {
+    var err error
+    w.once.Do(func(){
+        _ = w.out.Close()
+        err = w.drv.Close()
+    })
+    return err
+}
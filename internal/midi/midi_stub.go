@@ -4,9 +4,9 @@ package midi
 
 import "errors"
 
-// OpenInput は指定デバイスを開き、イベントチャネルを返す。
+// openNative は指定デバイスを開き、イベントチャネルを返す。
 // デフォルトビルド（midi_nativeタグなし）では未対応。
-func OpenInput(deviceName string) (Input, <-chan Event, error) {
+func openNative(deviceName string) (Input, <-chan Event, error) {
     return nil, nil, errors.New("native MIDI driver is not included in this build (build with -tags midi_native)")
 }
 
@@ -15,3 +15,9 @@ func OpenInput(deviceName string) (Input, <-chan Event, error) {
 func ListInputs() ([]string, error) {
     return nil, errors.New("native MIDI driver is not included in this build (build with -tags midi_native)")
 }
+
+// OpenOutput は指定名の MIDI 出力ポートを開く。
+// デフォルトビルド（midi_nativeタグなし）では未対応。
+func OpenOutput(deviceName string) (Output, error) {
+    return nil, errors.New("native MIDI driver is not included in this build (build with -tags midi_native)")
+}
@@ -0,0 +1,96 @@
+package midi
+
+import "strings"
+
+// MSCCommand は MIDI Show Control のコマンド。
+type MSCCommand string
+
+const (
+    MSCGo     MSCCommand = "go"
+    MSCStop   MSCCommand = "stop"
+    MSCResume MSCCommand = "resume"
+    MSCLoad   MSCCommand = "load"
+    MSCAllOff MSCCommand = "all_off"
+    MSCReset  MSCCommand = "reset"
+)
+
+// MSCAllCall は全デバイス宛ての device ID。
+const MSCAllCall uint8 = 0x7F
+
+// MSC は解析済みの MIDI Show Control メッセージ。
+// Cue/List/Path は "1.5" のような ASCII の番号で、省略時は空文字。
+type MSC struct {
+    DeviceID uint8      `json:"device_id"` // 0x00-0x6F: 個別、0x70-0x7E: グループ、0x7F: 全体
+    Format   uint8      `json:"format"`    // command_format（0x01: Lighting、0x30: Video など）
+    Command  MSCCommand `json:"command"`
+    Cue      string     `json:"cue,omitempty"`
+    List     string     `json:"list,omitempty"`
+    Path     string     `json:"path,omitempty"`
+}
+
+var mscCommands = map[byte]MSCCommand{
+    0x01: MSCGo,
+    0x02: MSCStop,
+    0x03: MSCResume,
+    0x04: MSCGo, // TIMED_GO は時刻を無視して GO として扱う
+    0x05: MSCLoad,
+    0x08: MSCAllOff,
+    0x0A: MSCReset,
+}
+
+// ParseMSC は SysEx（F0 7F <device> 02 <format> <command> <data> F7）を MSC として解析する。
+// 対応外のコマンドや MSC 以外の SysEx は false。
+func ParseMSC(bt []byte) (MSC, bool) {
+    if len(bt) < 6 || bt[0] != 0xF0 || bt[1] != 0x7F || bt[3] != 0x02 {
+        return MSC{}, false
+    }
+    body := bt[6:]
+    if n := len(body); n > 0 && body[n-1] == 0xF7 {
+        body = body[:n-1]
+    }
+    cmd, ok := mscCommands[bt[5]]
+    if !ok {
+        return MSC{}, false
+    }
+    m := MSC{DeviceID: bt[2] & 0x7F, Format: bt[4], Command: cmd}
+    if bt[5] == 0x04 {
+        // TIMED_GO: hr mn sc fr ff の 5 バイトを読み飛ばす
+        if len(body) < 5 {
+            return m, true
+        }
+        body = body[5:]
+    }
+    switch cmd {
+    case MSCGo, MSCStop, MSCResume, MSCLoad:
+        fields := strings.Split(string(body), "\x00")
+        for i, f := range fields {
+            if !validCueNumber(f) {
+                return MSC{}, false
+            }
+            switch i {
+            case 0:
+                m.Cue = f
+            case 1:
+                m.List = f
+            case 2:
+                m.Path = f
+            }
+        }
+    }
+    return m, true
+}
+
+// validCueNumber は ASCII の数字と '.' だけから成るか（空は可）を返す。
+func validCueNumber(s string) bool {
+    for _, c := range s {
+        if (c < '0' || c > '9') && c != '.' {
+            return false
+        }
+    }
+    return true
+}
+
+// Matches は device（0x00-0x7E）宛てのメッセージかを返す。全体宛て（0x7F）は常に true。
+func (m MSC) Matches(device uint8) bool {
+    return m.DeviceID == MSCAllCall || m.DeviceID == device
+}
@@ -0,0 +1,47 @@
+package midi
+
+import "testing"
+
+func TestParseMSC(t *testing.T) {
+    // GO cue 12.5 list 3（device 1、Video）
+    msg := []byte{0xF0, 0x7F, 0x01, 0x02, 0x30, 0x01, '1', '2', '.', '5', 0x00, '3', 0xF7}
+    ev, ok := ParseMessage(msg)
+    if !ok || ev.Type != ShowControl || ev.MSC == nil {
+        t.Fatalf("GO should parse: %+v %v", ev, ok)
+    }
+    m := *ev.MSC
+    if m.Command != MSCGo || m.Cue != "12.5" || m.List != "3" || m.DeviceID != 1 || m.Format != 0x30 {
+        t.Fatalf("unexpected MSC: %+v", m)
+    }
+    if !m.Matches(1) || m.Matches(2) {
+        t.Fatalf("device match: %+v", m)
+    }
+
+    cases := []struct {
+        in   []byte
+        cmd  MSCCommand
+        cue  string
+    }{
+        {[]byte{0xF0, 0x7F, 0x7F, 0x02, 0x01, 0x02, 0xF7}, MSCStop, ""},
+        {[]byte{0xF0, 0x7F, 0x7F, 0x02, 0x01, 0x03, '7', 0xF7}, MSCResume, "7"},
+        {[]byte{0xF0, 0x7F, 0x7F, 0x02, 0x01, 0x05, '4', 0xF7}, MSCLoad, "4"},
+        {[]byte{0xF0, 0x7F, 0x7F, 0x02, 0x01, 0x04, 1, 2, 3, 4, 0, '9', 0xF7}, MSCGo, "9"}, // TIMED_GO
+    }
+    for _, c := range cases {
+        m, ok := ParseMSC(c.in)
+        if !ok || m.Command != c.cmd || m.Cue != c.cue || !m.Matches(5) {
+            t.Fatalf("ParseMSC(% X)=%+v %v", c.in, m, ok)
+        }
+    }
+
+    bad := [][]byte{
+        {0xF0, 0x7E, 0x01, 0x06, 0x01, 0xF7},            // MSC 以外の Universal SysEx
+        {0xF0, 0x7F, 0x01, 0x02, 0x01, 0x01, 'x', 0xF7}, // 不正なキュー番号
+        {0xF0, 0x7F, 0x01, 0x02, 0x01, 0x06, 0xF7},      // SET は未対応
+    }
+    for _, in := range bad {
+        if _, ok := ParseMSC(in); ok {
+            t.Fatalf("ParseMSC(% X) should fail", in)
+        }
+    }
+}
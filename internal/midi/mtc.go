@@ -0,0 +1,287 @@
+package midi
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// FrameRate は MIDI タイムコードのフレームレート。
+type FrameRate string
+
+const (
+    FPS24     FrameRate = "24"
+    FPS25     FrameRate = "25"
+    FPS2997DF FrameRate = "29.97df" // 30 フレーム/秒のドロップフレーム（実時間 29.97fps）
+    FPS30     FrameRate = "30"
+)
+
+// mtcRates は MTC の rate ビット（0-3）とフレームレートの対応。
+var mtcRates = [4]FrameRate{FPS24, FPS25, FPS2997DF, FPS30}
+
+// ParseFrameRate は "24" / "25" / "29.97df"（"29.97"、"30df" も可）/ "30" を解釈する。
+func ParseFrameRate(s string) (FrameRate, error) {
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "24":
+        return FPS24, nil
+    case "25":
+        return FPS25, nil
+    case "29.97df", "29.97", "30df", "2997":
+        return FPS2997DF, nil
+    case "30":
+        return FPS30, nil
+    }
+    return "", fmt.Errorf("未対応のフレームレートです: %q（24/25/29.97df/30）", s)
+}
+
+// nominal は 1 秒あたりのフレーム番号の数（29.97df は 30）。
+func (r FrameRate) nominal() int {
+    switch r {
+    case FPS24:
+        return 24
+    case FPS25:
+        return 25
+    default:
+        return 30
+    }
+}
+
+// FrameDuration は 1 フレームの実時間。
+func (r FrameRate) FrameDuration() time.Duration {
+    if r == FPS2997DF {
+        return time.Second * 1001 / 30000
+    }
+    return time.Second / time.Duration(r.nominal())
+}
+
+// Timecode は hh:mm:ss:ff のタイムコード。
+type Timecode struct {
+    Hours, Minutes, Seconds, Frames int
+    Rate                            FrameRate
+}
+
+// String は "hh:mm:ss:ff"（ドロップフレームは "hh:mm:ss;ff"）を返す。
+func (t Timecode) String() string {
+    sep := ":"
+    if t.Rate == FPS2997DF {
+        sep = ";"
+    }
+    return fmt.Sprintf("%02d:%02d:%02d%s%02d", t.Hours, t.Minutes, t.Seconds, sep, t.Frames)
+}
+
+// FrameCount は 00:00:00:00 からのフレーム数（ドロップフレームは欠番を除いた実フレーム数）。
+func (t Timecode) FrameCount() int64 {
+    fps := int64(t.Rate.nominal())
+    total := (int64(t.Hours)*3600+int64(t.Minutes)*60+int64(t.Seconds))*fps + int64(t.Frames)
+    if t.Rate == FPS2997DF {
+        // 10 分ごとの分を除き、毎分 2 フレーム（00, 01）を欠番にする
+        mins := int64(t.Hours)*60 + int64(t.Minutes)
+        total -= 2 * (mins - mins/10)
+    }
+    return total
+}
+
+// Duration は 00:00:00:00 からの実時間。
+func (t Timecode) Duration() time.Duration {
+    return time.Duration(t.FrameCount()) * t.Rate.FrameDuration()
+}
+
+// TimecodeFromFrames は FrameCount の逆変換。
+func TimecodeFromFrames(n int64, rate FrameRate) Timecode {
+    if n < 0 {
+        n = 0
+    }
+    fps := int64(rate.nominal())
+    if rate == FPS2997DF {
+        // 実フレーム数 → 表示上のフレーム番号（欠番を足し戻す）
+        const per10 = 17982 // 10 分間の実フレーム数
+        const perMin = 1798 // 欠番ありの 1 分間の実フレーム数
+        d, m := n/per10, n%per10
+        n += 18 * d
+        if m >= 2 {
+            n += 2 * ((m - 2) / perMin)
+        }
+    }
+    t := Timecode{Rate: rate}
+    t.Frames = int(n % fps)
+    n /= fps
+    t.Seconds = int(n % 60)
+    n /= 60
+    t.Minutes = int(n % 60)
+    t.Hours = int(n / 60)
+    return t
+}
+
+// ParseTimecode は "hh:mm:ss:ff"（区切りは ':' / ';' / '.'）を rate で解釈する。
+func ParseTimecode(s string, rate FrameRate) (Timecode, error) {
+    f := strings.FieldsFunc(strings.TrimSpace(s), func(r rune) bool { return r == ':' || r == ';' || r == '.' })
+    if len(f) != 4 {
+        return Timecode{}, fmt.Errorf("タイムコードは hh:mm:ss:ff 形式で指定してください: %q", s)
+    }
+    var v [4]int
+    for i, p := range f {
+        n, err := strconv.Atoi(p)
+        if err != nil || n < 0 {
+            return Timecode{}, fmt.Errorf("タイムコードが数値ではありません: %q", s)
+        }
+        v[i] = n
+    }
+    t := Timecode{Hours: v[0], Minutes: v[1], Seconds: v[2], Frames: v[3], Rate: rate}
+    if t.Hours > 23 || t.Minutes > 59 || t.Seconds > 59 || t.Frames >= rate.nominal() {
+        return Timecode{}, fmt.Errorf("タイムコードの範囲外です: %q (%s fps)", s, rate)
+    }
+    if rate == FPS2997DF && t.Seconds == 0 && t.Frames < 2 && t.Minutes%10 != 0 {
+        return Timecode{}, fmt.Errorf("ドロップフレームの欠番です: %q", s)
+    }
+    return t, nil
+}
+
+// parseMTCFull は Full Frame（F0 7F <device> 01 01 hr mn sc fr F7）を解析する。
+func parseMTCFull(bt []byte) (Timecode, bool) {
+    if len(bt) < 9 || bt[0] != 0xF0 || bt[1] != 0x7F || bt[3] != 0x01 || bt[4] != 0x01 {
+        return Timecode{}, false
+    }
+    hr := bt[5]
+    return Timecode{
+        Hours:   int(hr & 0x1F),
+        Minutes: int(bt[6] & 0x3F),
+        Seconds: int(bt[7] & 0x3F),
+        Frames:  int(bt[8] & 0x1F),
+        Rate:    mtcRates[(hr>>5)&0x03],
+    }, true
+}
+
+// ClockState は MTCClock の同期状態。
+type ClockState string
+
+const (
+    ClockStopped ClockState = "stopped" // 未受信またはクォーターフレームが途絶えた
+    ClockLocked  ClockState = "locked"  // クォーターフレームを連続受信中
+)
+
+// ClockUpdate は MTCClock.Feed / Check の結果。
+type ClockUpdate struct {
+    State    ClockState
+    Position time.Duration // At 時点のタイムコード位置（実時間換算）
+    Timecode Timecode
+    At       time.Time
+    Jump     bool // 位置が連続していない（ロケート、巻き戻し、ロック直後を含む）
+}
+
+// MTCClock はクォーターフレーム / フルフレームからタイムコードを再構成する。
+// 8 つのクォーターフレームを順方向に揃えるとロックし、LossTimeout 受信が無いと停止扱いにする。
+type MTCClock struct {
+    // Rate を指定すると受信した rate ビットより優先する（空なら受信値）。
+    Rate        FrameRate
+    LossTimeout time.Duration // 既定 250ms
+
+    pieces    [8]byte
+    have      uint8 // 受信済みピースのビット
+    lastPiece int
+    state     ClockState
+    base      time.Duration // 直近の位置
+    baseAt    time.Time
+    rate      FrameRate
+    lastQF    time.Time
+}
+
+// NewMTCClock は rate（空なら受信値）で解釈する MTCClock を作る。
+func NewMTCClock(rate FrameRate, lossTimeout time.Duration) *MTCClock {
+    if lossTimeout <= 0 {
+        lossTimeout = 250 * time.Millisecond
+    }
+    return &MTCClock{Rate: rate, LossTimeout: lossTimeout, lastPiece: -1, state: ClockStopped}
+}
+
+// State は現在の同期状態を返す。
+func (c *MTCClock) State() ClockState { return c.state }
+
+func (c *MTCClock) rateFor(bits FrameRate) FrameRate {
+    if c.Rate != "" {
+        return c.Rate
+    }
+    return bits
+}
+
+// Feed はタイムコードのイベントを取り込み、位置が更新されたら true を返す。
+// クォーターフレームはロック中は 1/4 フレームずつ位置を進め、8 ピースが揃うたびに位置を補正する。
+func (c *MTCClock) Feed(ev Event) (ClockUpdate, bool) {
+    now := ev.Time
+    if now.IsZero() {
+        now = time.Now()
+    }
+    switch ev.Type {
+    case TimecodeFull:
+        if ev.Timecode == nil {
+            return ClockUpdate{}, false
+        }
+        tc := *ev.Timecode
+        tc.Rate = c.rateFor(tc.Rate)
+        c.have, c.lastPiece = 0, -1
+        c.state = ClockStopped
+        c.rate = tc.Rate
+        c.base, c.baseAt = tc.Duration(), now
+        return ClockUpdate{State: c.state, Position: c.base, Timecode: tc, At: now, Jump: true}, true
+    case TimecodeQuarterFrame:
+    default:
+        return ClockUpdate{}, false
+    }
+
+    piece := int(ev.Data1>>4) & 0x07
+    if c.lastPiece >= 0 && piece != (c.lastPiece+1)%8 {
+        // 逆方向や欠落: 揃え直す
+        c.have = 0
+        if c.state == ClockLocked {
+            c.state = ClockStopped
+        }
+    }
+    c.lastPiece = piece
+    c.pieces[piece] = ev.Data1 & 0x0F
+    c.have |= 1 << piece
+    c.lastQF = now
+
+    if c.state == ClockLocked {
+        c.base += c.rate.FrameDuration() / 4
+        c.baseAt = now
+    }
+    if piece != 7 || c.have != 0xFF {
+        if c.state == ClockLocked {
+            return ClockUpdate{State: c.state, Position: c.base, Timecode: TimecodeFromFrames(int64(c.base/c.rate.FrameDuration()), c.rate), At: now}, true
+        }
+        return ClockUpdate{}, false
+    }
+
+    p := c.pieces
+    tc := Timecode{
+        Frames:  int(p[0] | p[1]<<4&0x10),
+        Seconds: int(p[2] | (p[3]&0x03)<<4),
+        Minutes: int(p[4] | (p[5]&0x03)<<4),
+        Hours:   int(p[6] | (p[7]&0x01)<<4),
+        Rate:    c.rateFor(mtcRates[(p[7]>>1)&0x03]),
+    }
+    // ピース 0 の送出時点が tc。ピース 7 の受信時点はそこから 7/4 フレーム進んでいる。
+    pos := tc.Duration() + tc.Rate.FrameDuration()*7/4
+    jump := c.state != ClockLocked || absDur(pos-c.base) > tc.Rate.FrameDuration()
+    c.state = ClockLocked
+    c.rate = tc.Rate
+    c.base, c.baseAt = pos, now
+    return ClockUpdate{State: c.state, Position: pos, Timecode: TimecodeFromFrames(int64(pos/tc.Rate.FrameDuration()), tc.Rate), At: now, Jump: jump}, true
+}
+
+// Check は now 時点でクォーターフレームが途絶えていれば停止に遷移し、その更新を返す。
+func (c *MTCClock) Check(now time.Time) (ClockUpdate, bool) {
+    if c.state != ClockLocked || now.Sub(c.lastQF) < c.LossTimeout {
+        return ClockUpdate{}, false
+    }
+    c.state = ClockStopped
+    c.have, c.lastPiece = 0, -1
+    return ClockUpdate{State: c.state, Position: c.base, Timecode: TimecodeFromFrames(int64(c.base/c.rate.FrameDuration()), c.rate), At: now}, true
+}
+
+func absDur(d time.Duration) time.Duration {
+    if d < 0 {
+        return -d
+    }
+    return d
+}
@@ -0,0 +1,122 @@
+package midi
+
+import (
+    "testing"
+    "time"
+)
+
+// quarterFrames は tc を rate ビット r で送る 8 つのクォーターフレーム（ピース 0..7）を返す。
+func quarterFrames(h, m, s, f int, r byte, at time.Time, step time.Duration) []Event {
+    nib := []int{f & 0xF, f >> 4, s & 0xF, s >> 4, m & 0xF, m >> 4, h & 0xF, h>>4 | int(r)<<1}
+    var out []Event
+    for i, n := range nib {
+        ev, ok := ParseMessage([]byte{0xF1, byte(i<<4 | n)})
+        if !ok || ev.Type != TimecodeQuarterFrame {
+            panic("quarter frame should parse")
+        }
+        ev.Time = at.Add(time.Duration(i) * step)
+        out = append(out, ev)
+    }
+    return out
+}
+
+func TestParseMTCFullFrame(t *testing.T) {
+    // 01:02:03:04 @25fps（rate ビット 01）
+    ev, ok := ParseMessage([]byte{0xF0, 0x7F, 0x7F, 0x01, 0x01, 0x20 | 1, 2, 3, 4, 0xF7})
+    if !ok || ev.Type != TimecodeFull || ev.Timecode == nil {
+        t.Fatalf("full frame should parse: %+v %v", ev, ok)
+    }
+    if got := ev.Timecode.String(); got != "01:02:03:04" || ev.Timecode.Rate != FPS25 {
+        t.Fatalf("unexpected timecode: %s %s", got, ev.Timecode.Rate)
+    }
+    if _, ok := ParseMessage([]byte{0xF8}); ok {
+        t.Fatalf("timing clock should be ignored")
+    }
+}
+
+func TestTimecodeDropFrame(t *testing.T) {
+    tc, err := ParseTimecode("00:01:00;02", FPS2997DF)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := tc.FrameCount(); n != 1800 {
+        t.Fatalf("frame count: %d", n)
+    }
+    if _, err := ParseTimecode("00:01:00:00", FPS2997DF); err == nil {
+        t.Fatalf("dropped frame number should be rejected")
+    }
+    for _, s := range []string{"00:00:59;29", "00:10:00;00", "00:11:00;02", "01:23:45;12"} {
+        tc, err := ParseTimecode(s, FPS2997DF)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if back := TimecodeFromFrames(tc.FrameCount(), FPS2997DF); back != tc {
+            t.Fatalf("round trip %s: got %s", s, back)
+        }
+    }
+    if _, err := ParseTimecode("00:00:00:25", FPS25); err == nil {
+        t.Fatalf("frame 25 should be out of range at 25fps")
+    }
+}
+
+func TestMTCClockLockJumpLoss(t *testing.T) {
+    c := NewMTCClock("", 100*time.Millisecond)
+    frame := FPS25.FrameDuration()
+    qf := frame / 4
+    t0 := time.Unix(1000, 0)
+
+    var last ClockUpdate
+    var got int
+    for _, ev := range quarterFrames(1, 0, 0, 0, 1, t0, qf) {
+        if u, ok := c.Feed(ev); ok {
+            last = u
+            got++
+        }
+    }
+    if got != 1 || last.State != ClockLocked || !last.Jump {
+        t.Fatalf("should lock on piece 7: got=%d %+v", got, last)
+    }
+    if want := time.Hour + frame*7/4; last.Position != want || last.Timecode.Rate != FPS25 {
+        t.Fatalf("position: %s want %s (%s)", last.Position, want, last.Timecode.Rate)
+    }
+
+    // 続きのシーケンス（2 フレーム後）は連続
+    for _, ev := range quarterFrames(1, 0, 0, 2, 1, t0.Add(8*qf), qf) {
+        if u, ok := c.Feed(ev); ok {
+            last = u
+            if u.Jump {
+                t.Fatalf("continuous sequence should not jump: %+v", u)
+            }
+        }
+    }
+    if want := time.Hour + frame*2 + frame*7/4; last.Position != want {
+        t.Fatalf("position after 2 frames: %s want %s", last.Position, want)
+    }
+
+    // ロケート
+    for _, ev := range quarterFrames(2, 0, 0, 0, 1, t0.Add(16*qf), qf) {
+        if u, ok := c.Feed(ev); ok {
+            last = u
+        }
+    }
+    if !last.Jump || last.Timecode.Hours != 2 {
+        t.Fatalf("should detect jump: %+v", last)
+    }
+
+    if _, ok := c.Check(last.At.Add(50 * time.Millisecond)); ok {
+        t.Fatalf("should still be locked")
+    }
+    u, ok := c.Check(last.At.Add(150 * time.Millisecond))
+    if !ok || u.State != ClockStopped || c.State() != ClockStopped {
+        t.Fatalf("should detect loss: %+v %v", u, ok)
+    }
+
+    // 逆方向（7..0）ではロックしない
+    c = NewMTCClock(FPS30, 0)
+    evs := quarterFrames(0, 0, 10, 0, 3, t0, qf)
+    for i := len(evs) - 1; i >= 0; i-- {
+        if _, ok := c.Feed(evs[i]); ok {
+            t.Fatalf("reverse sequence should not lock")
+        }
+    }
+}
@@ -0,0 +1,63 @@
+package midi
+
+import "sync"
+
+// multiInput は複数の入力をまとめたもの。Close で全て閉じる。
+type multiInput struct {
+    inputs []Input
+    stop   chan struct{}
+    once   sync.Once
+}
+
+func (m *multiInput) Close() error {
+    m.once.Do(func() { close(m.stop) })
+    for _, in := range m.inputs {
+        _ = in.Close()
+    }
+    return nil
+}
+
+// OpenInputsAuto は devices をそれぞれ OpenInputAuto で開き、イベントを 1 つのチャネルにまとめる。
+// 各イベントの Device には devices に指定した名前が入る。onState は入力ごとの接続状態の変化を受け取る（nil 可）。
+// どれか 1 つでも初回のオープンに失敗した場合は、開いた入力を閉じてエラーを返す。
+// 返すチャネルは Close で全ての入力が閉じたあとに閉じる。
+func OpenInputsAuto(devices []string, opts ReconnectOptions, onState func(device string, state State, port string, err error)) (Input, <-chan Event, error) {
+    m := &multiInput{stop: make(chan struct{})}
+    var chans []<-chan Event
+    for _, d := range devices {
+        o := opts
+        if onState != nil {
+            d := d
+            o.OnState = func(state State, port string, err error) { onState(d, state, port, err) }
+        }
+        in, events, err := OpenInputAuto(d, o)
+        if err != nil {
+            _ = m.Close()
+            return nil, nil, err
+        }
+        m.inputs = append(m.inputs, in)
+        chans = append(chans, events)
+    }
+
+    out := make(chan Event, 128)
+    var wg sync.WaitGroup
+    for i, events := range chans {
+        wg.Add(1)
+        go func(device string, events <-chan Event) {
+            defer wg.Done()
+            for ev := range events {
+                ev.Device = device
+                select {
+                case out <- ev:
+                case <-m.stop:
+                    // 受け手がいなくても Close で止まれるよう、残りは捨てる
+                }
+            }
+        }(devices[i], events)
+    }
+    go func() {
+        wg.Wait()
+        close(out)
+    }()
+    return m, out, nil
+}
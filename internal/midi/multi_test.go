@@ -0,0 +1,81 @@
+package midi
+
+import (
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestOpenInputsAutoMergesDevices(t *testing.T) {
+    var mu sync.Mutex
+    ports := map[string]chan Event{}
+    opts := ReconnectOptions{
+        PollInterval: 5 * time.Millisecond,
+        list: func() ([]string, error) { return []string{"Foot Switch", "Pad MIDI 1"}, nil },
+        open: func(name string) (Input, <-chan Event, error) {
+            mu.Lock()
+            defer mu.Unlock()
+            ch := make(chan Event, 4)
+            ports[name] = ch
+            return closerFunc(func() error { return nil }), ch, nil
+        },
+    }
+    var stMu sync.Mutex
+    states := map[string]State{}
+    in, events, err := OpenInputsAuto([]string{"Foot", "Pad"}, opts, func(device string, s State, port string, err error) {
+        stMu.Lock()
+        states[device] = s
+        stMu.Unlock()
+    })
+    if err != nil {
+        t.Fatalf("OpenInputsAuto: %v", err)
+    }
+    stMu.Lock()
+    if states["Foot"] != StateConnected || states["Pad"] != StateConnected {
+        t.Fatalf("states = %v", states)
+    }
+    stMu.Unlock()
+
+    mu.Lock()
+    ports["Foot Switch"] <- Event{Type: ControlChange, Channel: 1, Data1: 64, Data2: 127}
+    ports["Pad MIDI 1"] <- Event{Type: NoteOn, Channel: 10, Data1: 36, Data2: 100}
+    mu.Unlock()
+    got := map[string]Event{}
+    for i := 0; i < 2; i++ {
+        select {
+        case ev := <-events:
+            got[ev.Device] = ev
+        case <-time.After(time.Second):
+            t.Fatal("timeout waiting for events")
+        }
+    }
+    if got["Foot"].Data1 != 64 || got["Pad"].Data1 != 36 {
+        t.Fatalf("unexpected events: %+v", got)
+    }
+
+    _ = in.Close()
+    select {
+    case _, ok := <-events:
+        if ok {
+            t.Fatal("expected closed channel")
+        }
+    case <-time.After(time.Second):
+        t.Fatal("channel not closed after Close")
+    }
+}
+
+func TestOpenInputsAutoFailsIfAnyMissing(t *testing.T) {
+    closed := 0
+    opts := ReconnectOptions{
+        list: func() ([]string, error) { return []string{"Foot Switch"}, nil },
+        open: func(name string) (Input, <-chan Event, error) {
+            return closerFunc(func() error { closed++; return nil }), make(chan Event), nil
+        },
+    }
+    if _, _, err := OpenInputsAuto([]string{"Foot", "Pad"}, opts, nil); err == nil {
+        t.Fatal("expected error for missing device")
+    }
+    if closed != 1 {
+        t.Fatalf("already opened input should be closed: %d", closed)
+    }
+}
@@ -0,0 +1,57 @@
+package midi
+
+// ParseMessage は生の MIDI メッセージ（ステータスバイト付き）のうち、
+// NoteOn/NoteOff/ControlChange/ProgramChange と MIDI Show Control（SysEx）、MIDI タイムコードを Event に正規化する（Time はゼロ値）。
+// NoteOn の velocity 0 は NoteOff として扱う。その他のメッセージは false。
+func ParseMessage(bt []byte) (Event, bool) {
+    if len(bt) == 0 {
+        return Event{}, false
+    }
+    status := bt[0]
+    if status == 0xF0 {
+        if m, ok := ParseMSC(bt); ok {
+            return Event{Type: ShowControl, MSC: &m}, true
+        }
+        if tc, ok := parseMTCFull(bt); ok {
+            return Event{Type: TimecodeFull, Timecode: &tc}, true
+        }
+        return Event{}, false
+    }
+    if status == 0xF1 {
+        if len(bt) >= 2 {
+            return Event{Type: TimecodeQuarterFrame, Data1: bt[1] & 0x7F}, true
+        }
+        return Event{}, false
+    }
+    // Realtime/System Common は対象外
+    if status < 0x80 || status >= 0xF0 {
+        return Event{}, false
+    }
+    typ := status >> 4
+    ch := (status & 0x0F) + 1 // 1-16
+
+    switch typ {
+    case 0x08: // NoteOff
+        if len(bt) >= 3 {
+            return Event{Type: NoteOff, Channel: ch, Data1: bt[1] & 0x7F, Data2: bt[2] & 0x7F}, true
+        }
+    case 0x09: // NoteOn（Vel==0 は NoteOff）
+        if len(bt) >= 3 {
+            vel := bt[2] & 0x7F
+            t := NoteOn
+            if vel == 0 {
+                t = NoteOff
+            }
+            return Event{Type: t, Channel: ch, Data1: bt[1] & 0x7F, Data2: vel}, true
+        }
+    case 0x0B: // ControlChange
+        if len(bt) >= 3 {
+            return Event{Type: ControlChange, Channel: ch, Data1: bt[1] & 0x7F, Data2: bt[2] & 0x7F}, true
+        }
+    case 0x0C: // ProgramChange
+        if len(bt) >= 2 {
+            return Event{Type: ProgramChange, Channel: ch, Data1: bt[1] & 0x7F}, true
+        }
+    }
+    return Event{}, false
+}
@@ -0,0 +1,195 @@
+package midi
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+)
+
+// State は自動再接続付き入力の接続状態。
+type State string
+
+const (
+    StateConnected    State = "connected"
+    StateDisconnected State = "disconnected" // 切断を検出し、再接続を試行中
+)
+
+// ReconnectOptions は OpenInputAuto の挙動を指定する。ゼロ値は既定値を使う。
+type ReconnectOptions struct {
+    MinBackoff   time.Duration // 再接続試行の初回待ち（既定 500ms）
+    MaxBackoff   time.Duration // 再接続試行の最大待ち（既定 10s）
+    PollInterval time.Duration // ポート一覧で存在を確認する間隔（既定 1s）
+
+    // OnState は接続状態が変わるたびに呼ばれる。port は接続中（切断時は直前）のポート名。
+    OnState func(state State, port string, err error)
+
+    // テスト用の差し替え（nil なら OpenInput / ListInputs）
+    open func(name string) (Input, <-chan Event, error)
+    list func() ([]string, error)
+}
+
+func (o ReconnectOptions) withDefaults() ReconnectOptions {
+    if o.MinBackoff <= 0 { o.MinBackoff = 500 * time.Millisecond }
+    if o.MaxBackoff <= 0 { o.MaxBackoff = 10 * time.Second }
+    if o.MaxBackoff < o.MinBackoff { o.MaxBackoff = o.MinBackoff }
+    if o.PollInterval <= 0 { o.PollInterval = time.Second }
+    if o.open == nil { o.open = OpenInput }
+    if o.list == nil { o.list = ListInputs }
+    return o
+}
+
+// MatchPort は names から deviceName に一致するポート名を返す（完全一致 → 部分一致の順）。
+func MatchPort(names []string, deviceName string) (string, bool) {
+    for _, n := range names {
+        if n == deviceName {
+            return n, true
+        }
+    }
+    for _, n := range names {
+        if strings.Contains(n, deviceName) {
+            return n, true
+        }
+    }
+    return "", false
+}
+
+// autoInput は切断時に再接続を繰り返す入力。イベントチャネルは Close まで閉じない。
+type autoInput struct {
+    name string
+    opts ReconnectOptions
+    out  chan Event
+    stop chan struct{}
+    done chan struct{}
+    once sync.Once
+}
+
+// OpenInputAuto は OpenInput と同様に入力を開くが、デバイスが消えた（抜かれた）ことを
+// ポート一覧の定期確認とイベントチャネルの終了で検出し、バックオフしながら再接続する（URL 形式のデバイスは除く）。
+// 初回のオープンに失敗した場合はエラーを返す。返すチャネルは Close するまで閉じない。
+func OpenInputAuto(deviceName string, opts ReconnectOptions) (Input, <-chan Event, error) {
+    opts = opts.withDefaults()
+    if IsURLDevice(deviceName) {
+        // ネットワーク等の入力はポート一覧に無いので、そのまま開く（セッションの切断は入力側で扱う）
+        in, events, err := opts.open(deviceName)
+        if err != nil {
+            return nil, nil, err
+        }
+        if opts.OnState != nil {
+            opts.OnState(StateConnected, deviceName, nil)
+        }
+        return in, events, nil
+    }
+    in, events, port, err := opts.connect(deviceName)
+    if err != nil {
+        return nil, nil, err
+    }
+    a := &autoInput{
+        name: deviceName,
+        opts: opts,
+        out:  make(chan Event, 128),
+        stop: make(chan struct{}),
+        done: make(chan struct{}),
+    }
+    a.notify(StateConnected, port, nil)
+    go a.run(in, events, port)
+    return a, a.out, nil
+}
+
+func (o ReconnectOptions) connect(deviceName string) (Input, <-chan Event, string, error) {
+    names, err := o.list()
+    if err != nil {
+        return nil, nil, "", err
+    }
+    port, ok := MatchPort(names, deviceName)
+    if !ok {
+        return nil, nil, "", fmt.Errorf("MIDI入力デバイスが見つかりません: %s", deviceName)
+    }
+    in, events, err := o.open(port)
+    if err != nil {
+        return nil, nil, "", err
+    }
+    return in, events, port, nil
+}
+
+func (a *autoInput) notify(s State, port string, err error) {
+    if a.opts.OnState != nil {
+        a.opts.OnState(s, port, err)
+    }
+}
+
+func (a *autoInput) run(in Input, events <-chan Event, port string) {
+    defer close(a.done)
+    defer close(a.out)
+    for {
+        lostErr := a.pump(events, port)
+        _ = in.Close()
+        if lostErr == nil {
+            return
+        }
+        a.notify(StateDisconnected, port, lostErr)
+
+        backoff := a.opts.MinBackoff
+        for {
+            select {
+            case <-a.stop:
+                return
+            case <-time.After(backoff):
+            }
+            nin, nev, nport, err := a.opts.connect(a.name)
+            if err == nil {
+                in, events, port = nin, nev, nport
+                break
+            }
+            backoff *= 2
+            if backoff > a.opts.MaxBackoff {
+                backoff = a.opts.MaxBackoff
+            }
+        }
+        a.notify(StateConnected, port, nil)
+    }
+}
+
+// pump はイベントを転送し、切断を検出したらその理由を、Close されたら nil を返す。
+func (a *autoInput) pump(events <-chan Event, port string) error {
+    tk := time.NewTicker(a.opts.PollInterval)
+    defer tk.Stop()
+    for {
+        select {
+        case <-a.stop:
+            return nil
+        case ev, ok := <-events:
+            if !ok {
+                return errors.New("MIDI入力が閉じられました")
+            }
+            select {
+            case a.out <- ev:
+            case <-a.stop:
+                return nil
+            }
+        case <-tk.C:
+            names, err := a.opts.list()
+            if err != nil {
+                continue
+            }
+            present := false
+            for _, n := range names {
+                if n == port {
+                    present = true
+                    break
+                }
+            }
+            if !present {
+                return fmt.Errorf("MIDI入力デバイスが見つかりません: %s", port)
+            }
+        }
+    }
+}
+
+// Close は再接続を止め、開いているデバイスを閉じてイベントチャネルを閉じる。
+func (a *autoInput) Close() error {
+    a.once.Do(func() { close(a.stop) })
+    <-a.done
+    return nil
+}
@@ -0,0 +1,122 @@
+package midi
+
+import (
+    "errors"
+    "sync"
+    "testing"
+    "time"
+)
+
+// fakePorts は抜き差しを模擬するポート一覧とオープン処理。
+type fakePorts struct {
+    mu      sync.Mutex
+    names   []string
+    opened  int
+    current chan Event
+}
+
+func (f *fakePorts) setNames(n ...string) {
+    f.mu.Lock()
+    f.names = n
+    f.mu.Unlock()
+}
+
+func (f *fakePorts) list() ([]string, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return append([]string(nil), f.names...), nil
+}
+
+func (f *fakePorts) open(name string) (Input, <-chan Event, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.opened++
+    f.current = make(chan Event, 4)
+    return closerFunc(func() error { return nil }), f.current, nil
+}
+
+func (f *fakePorts) send(ev Event) {
+    f.mu.Lock()
+    ch := f.current
+    f.mu.Unlock()
+    ch <- ev
+}
+
+type closerFunc func() error
+
+func (c closerFunc) Close() error { return c() }
+
+func TestMatchPort(t *testing.T) {
+    names := []string{"USB MIDI 2", "Launchpad Mini MIDI 1", "Launchpad"}
+    if p, ok := MatchPort(names, "Launchpad"); !ok || p != "Launchpad" {
+        t.Fatalf("exact match should win: %q", p)
+    }
+    if p, ok := MatchPort(names, "Mini"); !ok || p != "Launchpad Mini MIDI 1" {
+        t.Fatalf("partial match: %q", p)
+    }
+    if _, ok := MatchPort(names, "nanoKEY"); ok {
+        t.Fatalf("unexpected match")
+    }
+}
+
+func TestOpenInputAutoReconnects(t *testing.T) {
+    fp := &fakePorts{names: []string{"Pad MIDI 1"}}
+    states := make(chan State, 8)
+    in, events, err := OpenInputAuto("Pad", ReconnectOptions{
+        MinBackoff:   5 * time.Millisecond,
+        MaxBackoff:   20 * time.Millisecond,
+        PollInterval: 5 * time.Millisecond,
+        OnState:      func(s State, port string, err error) { states <- s },
+        open:         fp.open,
+        list:         fp.list,
+    })
+    if err != nil {
+        t.Fatalf("OpenInputAuto: %v", err)
+    }
+    expectState(t, states, StateConnected)
+
+    fp.send(Event{Type: NoteOn, Channel: 1, Data1: 36})
+    if ev := <-events; ev.Data1 != 36 {
+        t.Fatalf("unexpected event: %+v", ev)
+    }
+
+    // 抜く → 切断検出、挿し直す（名前の末尾が変わっても部分一致で再接続）
+    fp.setNames()
+    expectState(t, states, StateDisconnected)
+    fp.setNames("Pad MIDI 2")
+    expectState(t, states, StateConnected)
+
+    fp.send(Event{Type: NoteOn, Channel: 1, Data1: 40})
+    if ev := <-events; ev.Data1 != 40 {
+        t.Fatalf("event after reconnect: %+v", ev)
+    }
+
+    _ = in.Close()
+    if _, ok := <-events; ok {
+        t.Fatalf("events should be closed after Close")
+    }
+    if fp.opened != 2 {
+        t.Fatalf("opened=%d; want 2", fp.opened)
+    }
+}
+
+func TestOpenInputAutoInitialFailure(t *testing.T) {
+    _, _, err := OpenInputAuto("Pad", ReconnectOptions{
+        list: func() ([]string, error) { return nil, errors.New("no driver") },
+    })
+    if err == nil {
+        t.Fatalf("expected error")
+    }
+}
+
+func expectState(t *testing.T, ch <-chan State, want State) {
+    t.Helper()
+    select {
+    case s := <-ch:
+        if s != want {
+            t.Fatalf("state=%s; want %s", s, want)
+        }
+    case <-time.After(time.Second):
+        t.Fatalf("timeout waiting for %s", want)
+    }
+}
@@ -0,0 +1,101 @@
+package midi
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strings"
+    "time"
+)
+
+// Record は記録ファイル（JSONL）の 1 行で、受信したイベント 1 件と記録開始からの時刻を表す。
+type Record struct {
+    TMs      float64   `json:"t_ms"` // 記録開始からの経過（ミリ秒）
+    Device   string    `json:"device,omitempty"`
+    Type     Type      `json:"type"`
+    Channel  uint8     `json:"channel,omitempty"`
+    Data1    uint8     `json:"data1"`
+    Data2    uint8     `json:"data2"`
+    MSC      *MSC      `json:"msc,omitempty"`
+    Timecode string    `json:"timecode,omitempty"` // "hh:mm:ss:ff"（ドロップフレームは ';'）
+    Rate     FrameRate `json:"rate,omitempty"`
+}
+
+// NewRecord は start からの経過時刻付きで ev を Record にする。
+func NewRecord(ev Event, start time.Time) Record {
+    r := Record{
+        TMs:     float64(ev.Time.Sub(start)) / float64(time.Millisecond),
+        Device:  ev.Device,
+        Type:    ev.Type,
+        Channel: ev.Channel,
+        Data1:   ev.Data1,
+        Data2:   ev.Data2,
+        MSC:     ev.MSC,
+    }
+    if ev.Timecode != nil {
+        r.Timecode, r.Rate = ev.Timecode.String(), ev.Timecode.Rate
+    }
+    return r
+}
+
+// Offset は記録開始からの経過時間を返す。
+func (r Record) Offset() time.Duration {
+    return time.Duration(r.TMs * float64(time.Millisecond))
+}
+
+// Event は Record をイベントに戻す（Time は at）。
+func (r Record) Event(at time.Time) (Event, error) {
+    ev := Event{Type: r.Type, Channel: r.Channel, Data1: r.Data1, Data2: r.Data2, Time: at, MSC: r.MSC, Device: r.Device}
+    if r.Timecode != "" {
+        tc, err := ParseTimecode(r.Timecode, r.Rate)
+        if err != nil {
+            return Event{}, err
+        }
+        ev.Timecode = &tc
+    }
+    return ev, nil
+}
+
+// Recorder は受信したイベントを JSONL で書き出す。
+type Recorder struct {
+    w     io.Writer
+    start time.Time
+}
+
+// NewRecorder は start を記録開始時刻として w に書き出す Recorder を作る。
+func NewRecorder(w io.Writer, start time.Time) *Recorder {
+    return &Recorder{w: w, start: start}
+}
+
+// Write は ev を 1 行書き出す。途中で中断しても書いた行は読めるよう、1 件ずつ書き込む。
+func (r *Recorder) Write(ev Event) error {
+    bt, err := json.Marshal(NewRecord(ev, r.start))
+    if err != nil {
+        return err
+    }
+    _, err = r.w.Write(append(bt, '\n'))
+    return err
+}
+
+// ReadRecords は JSONL の記録を読み込む。空行と '#' で始まる行は読み飛ばす。
+func ReadRecords(rd io.Reader) ([]Record, error) {
+    var out []Record
+    sc := bufio.NewScanner(rd)
+    sc.Buffer(make([]byte, 64*1024), 1024*1024)
+    for n := 1; sc.Scan(); n++ {
+        line := strings.TrimSpace(sc.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        var r Record
+        if err := json.Unmarshal([]byte(line), &r); err != nil {
+            return nil, fmt.Errorf("%d 行目: %w", n, err)
+        }
+        if r.TMs < 0 {
+            return nil, fmt.Errorf("%d 行目: t_ms が負です: %g", n, r.TMs)
+        }
+        out = append(out, r)
+    }
+    return out, sc.Err()
+}
@@ -0,0 +1,109 @@
+package midi
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+    start := time.Now()
+    tc := Timecode{Hours: 1, Minutes: 2, Seconds: 3, Frames: 4, Rate: FPS2997DF}
+    evs := []Event{
+        {Type: NoteOn, Channel: 1, Data1: 36, Data2: 100, Time: start.Add(10 * time.Millisecond), Device: "Pad"},
+        {Type: ControlChange, Channel: 16, Data1: 64, Data2: 127, Time: start.Add(200 * time.Millisecond), Device: "Foot"},
+        {Type: ShowControl, MSC: &MSC{DeviceID: 1, Command: MSCGo, Cue: "5"}, Time: start.Add(300 * time.Millisecond), Device: "Pad"},
+        {Type: TimecodeFull, Timecode: &tc, Time: start.Add(400 * time.Millisecond), Device: "Pad"},
+    }
+    var buf bytes.Buffer
+    rec := NewRecorder(&buf, start)
+    for _, ev := range evs {
+        if err := rec.Write(ev); err != nil {
+            t.Fatal(err)
+        }
+    }
+    path := filepath.Join(t.TempDir(), "log.jsonl")
+    if err := os.WriteFile(path, append([]byte("# rehearsal\n\n"), buf.Bytes()...), 0o644); err != nil {
+        t.Fatal(err)
+    }
+
+    // 100 倍速で再生（400ms の記録は約 4ms）
+    began := time.Now()
+    in, events, err := OpenInput(ReplayScheme + path + "?speed=100")
+    if err != nil {
+        t.Fatalf("OpenInput: %v", err)
+    }
+    defer in.Close()
+    var got []Event
+    for ev := range events {
+        got = append(got, ev)
+    }
+    if time.Since(began) > 300*time.Millisecond {
+        t.Fatalf("speed factor not applied: %s", time.Since(began))
+    }
+    if len(got) != len(evs) {
+        t.Fatalf("got %d events", len(got))
+    }
+    if got[0].Type != NoteOn || got[0].Data1 != 36 || got[0].Device != "Pad" || got[0].Time.IsZero() {
+        t.Fatalf("unexpected first event: %+v", got[0])
+    }
+    if got[2].MSC == nil || got[2].MSC.Cue != "5" || got[2].MSC.Command != MSCGo {
+        t.Fatalf("MSC not restored: %+v", got[2])
+    }
+    if got[3].Timecode == nil || *got[3].Timecode != tc {
+        t.Fatalf("timecode not restored: %+v", got[3].Timecode)
+    }
+    if got[1].Time.Before(got[0].Time) {
+        t.Fatal("events out of order")
+    }
+
+    // device で 1 台分だけ取り出す
+    _, events, err = OpenInput(ReplayScheme + path + "?speed=100&device=Foot")
+    if err != nil {
+        t.Fatal(err)
+    }
+    n := 0
+    for ev := range events {
+        if ev.Device != "Foot" {
+            t.Fatalf("unexpected device: %+v", ev)
+        }
+        n++
+    }
+    if n != 1 {
+        t.Fatalf("device filter: got %d events", n)
+    }
+}
+
+func TestReplayLoopAndClose(t *testing.T) {
+    in, events, err := ReplayRecords([]Record{{TMs: 1, Type: NoteOn, Channel: 1, Data1: 1, Data2: 1}}, ReplayOptions{Speed: 10, Loop: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 3; i++ {
+        select {
+        case <-events:
+        case <-time.After(time.Second):
+            t.Fatal("loop should repeat")
+        }
+    }
+    _ = in.Close()
+    for range events {
+    }
+}
+
+func TestParseReplayDevice(t *testing.T) {
+    path, opts, err := parseReplayDevice("replay://logs/show 1.jsonl?speed=0.5&loop=1")
+    if err != nil || path != "logs/show 1.jsonl" || opts.Speed != 0.5 || !opts.Loop {
+        t.Fatalf("got %q %+v %v", path, opts, err)
+    }
+    for _, bad := range []string{"replay://", "replay://a.jsonl?speed=0", "replay://a.jsonl?loop=maybe"} {
+        if _, _, err := parseReplayDevice(bad); err == nil {
+            t.Fatalf("%q should fail", bad)
+        }
+    }
+    if _, _, err := OpenInput("replay://does-not-exist.jsonl"); err == nil {
+        t.Fatal("missing file should fail")
+    }
+}
@@ -0,0 +1,143 @@
+package midi
+
+import (
+    "fmt"
+    "net/url"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ReplayScheme は記録ファイルを再生する入力のデバイス名の接頭辞。
+// 例: "replay://log.jsonl"、"replay://log.jsonl?speed=2&loop=1&device=Foot"。
+//   speed:  再生速度の倍率（既定 1。2 で倍速）
+//   loop:   末尾まで再生したら先頭から繰り返す
+//   device: 記録した device がこの名前のイベントだけを再生する（複数入力の記録から 1 台分を取り出す）
+const ReplayScheme = "replay://"
+
+// ReplayOptions は記録ファイルの再生方法。
+type ReplayOptions struct {
+    Speed  float64
+    Loop   bool
+    Device string
+}
+
+// replayInput は記録を時刻どおりに流す入力。
+type replayInput struct {
+    stop chan struct{}
+    done chan struct{}
+    once sync.Once
+}
+
+// OpenReplay は "replay://path?..." の記録ファイルを読み込み、記録時と同じ間隔（speed 倍）でイベントを流す。
+// イベントの Time は流した時刻。末尾まで再生するとチャネルを閉じる（loop 指定時は Close まで繰り返す）。
+func OpenReplay(device string) (Input, <-chan Event, error) {
+    path, opts, err := parseReplayDevice(device)
+    if err != nil {
+        return nil, nil, err
+    }
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, nil, fmt.Errorf("記録ファイルを開けません: %w", err)
+    }
+    recs, err := ReadRecords(f)
+    _ = f.Close()
+    if err != nil {
+        return nil, nil, fmt.Errorf("記録ファイルが不正です (%s): %w", path, err)
+    }
+    return ReplayRecords(recs, opts)
+}
+
+// ReplayRecords は recs を opts に従って流す入力を返す（OpenReplay の本体。テストから直接使える）。
+func ReplayRecords(recs []Record, opts ReplayOptions) (Input, <-chan Event, error) {
+    if opts.Speed <= 0 {
+        opts.Speed = 1
+    }
+    var sel []Record
+    for _, r := range recs {
+        if opts.Device == "" || r.Device == opts.Device {
+            sel = append(sel, r)
+        }
+    }
+    sort.SliceStable(sel, func(i, j int) bool { return sel[i].TMs < sel[j].TMs })
+    if len(sel) == 0 && opts.Loop {
+        return nil, nil, fmt.Errorf("再生するイベントがありません")
+    }
+    for _, r := range sel {
+        if _, err := r.Event(time.Time{}); err != nil {
+            return nil, nil, err
+        }
+    }
+    in := &replayInput{stop: make(chan struct{}), done: make(chan struct{})}
+    out := make(chan Event, 128)
+    go in.run(sel, opts, out)
+    return in, out, nil
+}
+
+func (in *replayInput) run(recs []Record, opts ReplayOptions, out chan<- Event) {
+    defer close(in.done)
+    defer close(out)
+    for {
+        start := time.Now()
+        for _, r := range recs {
+            at := start.Add(time.Duration(float64(r.Offset()) / opts.Speed))
+            if d := time.Until(at); d > 0 {
+                t := time.NewTimer(d)
+                select {
+                case <-in.stop:
+                    t.Stop()
+                    return
+                case <-t.C:
+                }
+            }
+            ev, _ := r.Event(time.Now())
+            select {
+            case out <- ev:
+            case <-in.stop:
+                return
+            }
+        }
+        if !opts.Loop {
+            return
+        }
+    }
+}
+
+// Close は再生を止めてイベントチャネルを閉じる。
+func (in *replayInput) Close() error {
+    in.once.Do(func() { close(in.stop) })
+    <-in.done
+    return nil
+}
+
+// parseReplayDevice はデバイス名からファイルパスと再生オプションを取り出す。
+func parseReplayDevice(device string) (string, ReplayOptions, error) {
+    rest := strings.TrimPrefix(device, ReplayScheme)
+    path, query := rest, ""
+    if i := strings.LastIndex(rest, "?"); i >= 0 {
+        path, query = rest[:i], rest[i+1:]
+    }
+    opts := ReplayOptions{Speed: 1}
+    if strings.TrimSpace(path) == "" {
+        return "", opts, fmt.Errorf("再生する記録ファイルを指定してください（例: replay://log.jsonl）")
+    }
+    q, err := url.ParseQuery(query)
+    if err != nil {
+        return "", opts, fmt.Errorf("replay の指定が不正です: %w", err)
+    }
+    if s := q.Get("speed"); s != "" {
+        if opts.Speed, err = strconv.ParseFloat(s, 64); err != nil || opts.Speed <= 0 {
+            return "", opts, fmt.Errorf("replay の speed が不正です: %q", s)
+        }
+    }
+    if s := q.Get("loop"); s != "" {
+        if opts.Loop, err = strconv.ParseBool(s); err != nil {
+            return "", opts, fmt.Errorf("replay の loop が不正です: %q", s)
+        }
+    }
+    opts.Device = q.Get("device")
+    return path, opts, nil
+}
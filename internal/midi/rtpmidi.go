@@ -0,0 +1,399 @@
+package midi
+
+import (
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// RTPMIDIScheme はネットワーク MIDI（RTP-MIDI / AppleMIDI）のデバイス名の接頭辞。
+// 例: "rtpmidi://:5004"、"rtpmidi://0.0.0.0:5004?name=OBS"（name はセッション相手に見える名前）。
+const RTPMIDIScheme = "rtpmidi://"
+
+// DefaultRTPMIDIPort は RTP-MIDI のコントロールポートの既定値（データポートは +1）。
+const DefaultRTPMIDIPort = 5004
+
+// AppleMIDI のコマンド（パケット先頭の 0xFFFF に続く 2 文字）。
+const (
+    appleMIDISignature   = 0xFFFF
+    appleMIDIInvitation  = "IN"
+    appleMIDIAccept      = "OK"
+    appleMIDIReject      = "NO"
+    appleMIDIEnd         = "BY"
+    appleMIDISync        = "CK"
+    appleMIDIFeedback    = "RS"
+    appleMIDIVersion     = 2
+    rtpMIDIPayloadType   = 0x61
+    rtpFeedbackInterval  = time.Second
+)
+
+// rtpSession は RTP-MIDI のセッション参加者（招待を受ける側）。
+// コントロールポートとデータポートで招待（IN）に応答し、同期（CK）に答え、データポートの MIDI を Event にする。
+type rtpSession struct {
+    name  string
+    ssrc  uint32
+    start time.Time
+    ctrl  *net.UDPConn
+    data  *net.UDPConn
+    evCh  chan Event
+
+    mu     sync.Mutex
+    peers  map[uint32]*rtpPeer
+    closed bool
+    once   sync.Once
+    wg     sync.WaitGroup
+}
+
+// rtpPeer はセッション相手（SSRC ごと）。
+type rtpPeer struct {
+    name       string
+    ctrlAddr   *net.UDPAddr
+    dataAddr   *net.UDPAddr
+    seq        uint16
+    hasSeq     bool
+    feedbackAt time.Time
+    sysex      []byte // 分割された SysEx の組み立て中のバイト列
+}
+
+// OpenRTPMIDI は "rtpmidi://[host]:port[?name=...]" のコントロールポートとデータポート（port+1）で待ち受け、
+// 相手（macOS の Network MIDI、rtpMIDI 等）からの招待を受けて MIDI を受信する。
+// 受信したメッセージは OpenInput と同じく ParseMessage で Event に正規化する。
+func OpenRTPMIDI(device string) (Input, <-chan Event, error) {
+    host, port, name, err := parseRTPMIDIDevice(device)
+    if err != nil {
+        return nil, nil, err
+    }
+    ctrl, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(host), Port: port})
+    if err != nil {
+        return nil, nil, fmt.Errorf("RTP-MIDI コントロールポートを開けません: %w", err)
+    }
+    data, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(host), Port: port + 1})
+    if err != nil {
+        _ = ctrl.Close()
+        return nil, nil, fmt.Errorf("RTP-MIDI データポートを開けません: %w", err)
+    }
+    var b [4]byte
+    _, _ = rand.Read(b[:])
+    s := &rtpSession{
+        name:  name,
+        ssrc:  binary.BigEndian.Uint32(b[:]),
+        start: time.Now(),
+        ctrl:  ctrl,
+        data:  data,
+        evCh:  make(chan Event, 128),
+        peers: map[uint32]*rtpPeer{},
+    }
+    s.wg.Add(2)
+    go s.serve(ctrl, false)
+    go s.serve(data, true)
+    return s, s.evCh, nil
+}
+
+// parseRTPMIDIDevice はデバイス名から待ち受けアドレスとセッション名を取り出す。
+func parseRTPMIDIDevice(device string) (host string, port int, name string, err error) {
+    u, err := url.Parse(device)
+    if err != nil || u.Scheme+"://" != RTPMIDIScheme {
+        return "", 0, "", fmt.Errorf("RTP-MIDI のデバイス指定が不正です: %q（例: rtpmidi://:5004）", device)
+    }
+    host, port = u.Hostname(), DefaultRTPMIDIPort
+    if p := u.Port(); p != "" {
+        if port, err = strconv.Atoi(p); err != nil || port < 0 || port > 65534 {
+            return "", 0, "", fmt.Errorf("RTP-MIDI のポートが不正です: %q", p)
+        }
+    }
+    name = strings.TrimSpace(u.Query().Get("name"))
+    if name == "" {
+        name = "obsctl"
+    }
+    return host, port, name, nil
+}
+
+// serve は conn からパケットを読み、Close されるまで処理する。
+func (s *rtpSession) serve(conn *net.UDPConn, isData bool) {
+    defer s.wg.Done()
+    buf := make([]byte, 65536)
+    for {
+        n, addr, err := conn.ReadFromUDP(buf)
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
+            continue
+        }
+        pkt := buf[:n]
+        if len(pkt) >= 4 && binary.BigEndian.Uint16(pkt) == appleMIDISignature {
+            s.handleCommand(conn, addr, pkt, isData)
+        } else if isData {
+            s.handleRTP(pkt, time.Now())
+        }
+    }
+}
+
+// handleCommand は AppleMIDI のセッションコマンドに応答する。
+func (s *rtpSession) handleCommand(conn *net.UDPConn, addr *net.UDPAddr, pkt []byte, isData bool) {
+    cmd := string(pkt[2:4])
+    switch cmd {
+    case appleMIDIInvitation:
+        if len(pkt) < 16 {
+            return
+        }
+        token := binary.BigEndian.Uint32(pkt[8:12])
+        ssrc := binary.BigEndian.Uint32(pkt[12:16])
+        s.mu.Lock()
+        p := s.peers[ssrc]
+        if p == nil {
+            p = &rtpPeer{}
+            s.peers[ssrc] = p
+        }
+        p.name = cstring(pkt[16:])
+        if isData {
+            p.dataAddr = addr
+        } else {
+            p.ctrlAddr = addr
+        }
+        s.mu.Unlock()
+        _, _ = conn.WriteToUDP(s.invitationPacket(appleMIDIAccept, token), addr)
+    case appleMIDIEnd:
+        if len(pkt) < 16 {
+            return
+        }
+        s.mu.Lock()
+        delete(s.peers, binary.BigEndian.Uint32(pkt[12:16]))
+        s.mu.Unlock()
+    case appleMIDISync:
+        if len(pkt) < 36 || !isData {
+            return
+        }
+        count := pkt[8]
+        if count > 1 {
+            return // 同期の 3 回目（相手が時刻差を計算する）
+        }
+        reply := make([]byte, 36)
+        copy(reply, pkt)
+        binary.BigEndian.PutUint32(reply[4:8], s.ssrc)
+        reply[8] = count + 1
+        binary.BigEndian.PutUint64(reply[12+8*int(count+1):], s.timestamp(time.Now()))
+        _, _ = conn.WriteToUDP(reply, addr)
+    }
+}
+
+// invitationPacket は IN / OK / NO / BY のパケットを作る。
+func (s *rtpSession) invitationPacket(cmd string, token uint32) []byte {
+    pkt := make([]byte, 16, 16+len(s.name)+1)
+    binary.BigEndian.PutUint16(pkt[0:2], appleMIDISignature)
+    copy(pkt[2:4], cmd)
+    binary.BigEndian.PutUint32(pkt[4:8], appleMIDIVersion)
+    binary.BigEndian.PutUint32(pkt[8:12], token)
+    binary.BigEndian.PutUint32(pkt[12:16], s.ssrc)
+    if cmd != appleMIDIEnd {
+        pkt = append(append(pkt, s.name...), 0)
+    }
+    return pkt
+}
+
+// timestamp は同期用の時刻（セッション開始からの 100µs 単位）を返す。
+func (s *rtpSession) timestamp(now time.Time) uint64 {
+    return uint64(now.Sub(s.start) / (100 * time.Microsecond))
+}
+
+// handleRTP は RTP パケットの MIDI コマンドリストを Event にして流す。招待していない相手のパケットは捨てる。
+func (s *rtpSession) handleRTP(pkt []byte, now time.Time) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.closed || len(pkt) < 12 {
+        return
+    }
+    p := s.peers[binary.BigEndian.Uint32(pkt[8:12])]
+    if p == nil {
+        return
+    }
+    seq := binary.BigEndian.Uint16(pkt[2:4])
+    msgs, err := parseRTPMIDI(pkt, &p.sysex)
+    if err != nil {
+        return
+    }
+    p.seq, p.hasSeq = seq, true
+    for _, m := range msgs {
+        if ev, ok := ParseMessage(m); ok {
+            ev.Time = now
+            select { case s.evCh <- ev: default: }
+        }
+    }
+    // 受信済みの番号を知らせ、相手がリカバリジャーナルを縮められるようにする
+    if p.ctrlAddr != nil && now.Sub(p.feedbackAt) >= rtpFeedbackInterval {
+        p.feedbackAt = now
+        fb := make([]byte, 12)
+        binary.BigEndian.PutUint16(fb[0:2], appleMIDISignature)
+        copy(fb[2:4], appleMIDIFeedback)
+        binary.BigEndian.PutUint32(fb[4:8], s.ssrc)
+        binary.BigEndian.PutUint16(fb[8:10], seq)
+        _, _ = s.ctrl.WriteToUDP(fb, p.ctrlAddr)
+    }
+}
+
+// Close は参加中の相手に終了（BY）を送り、ポートを閉じてイベントチャネルを閉じる。
+func (s *rtpSession) Close() error {
+    s.once.Do(func() {
+        s.mu.Lock()
+        bye := s.invitationPacket(appleMIDIEnd, 0)
+        for _, p := range s.peers {
+            if p.ctrlAddr != nil {
+                _, _ = s.ctrl.WriteToUDP(bye, p.ctrlAddr)
+            }
+        }
+        s.closed = true
+        s.mu.Unlock()
+        _ = s.ctrl.Close()
+        _ = s.data.Close()
+        s.wg.Wait()
+        close(s.evCh)
+    })
+    return nil
+}
+
+// parseRTPMIDI は RTP パケット（RFC 6295）の MIDI コマンドリストを、ステータスバイト付きのメッセージ列にする。
+// ランニングステータスとデルタタイムを解釈し、リカバリジャーナルは読み飛ばす。
+// 複数パケットに分割された SysEx は sysex に溜め、終端（F7）を受けたパケットで 1 つのメッセージとして返す。
+func parseRTPMIDI(pkt []byte, sysex *[]byte) ([][]byte, error) {
+    if len(pkt) < 13 || pkt[0]>>6 != 2 || pkt[1]&0x7F != rtpMIDIPayloadType {
+        return nil, errors.New("RTP-MIDI パケットではありません")
+    }
+    // 固定ヘッダ 12 バイト + CSRC（4 バイト × CC）+ 拡張ヘッダ（X ビット。4 バイト + 長さ × 4 バイト）
+    hdr := 12 + 4*int(pkt[0]&0x0F)
+    if pkt[0]&0x10 != 0 {
+        if len(pkt) < hdr+4 {
+            return nil, errors.New("RTP-MIDI のヘッダが短すぎます")
+        }
+        hdr += 4 + 4*int(binary.BigEndian.Uint16(pkt[hdr+2:hdr+4]))
+    }
+    if len(pkt) < hdr+1 {
+        return nil, errors.New("RTP-MIDI のヘッダが短すぎます")
+    }
+    body := pkt[hdr:]
+    flags := body[0]
+    length := int(flags & 0x0F)
+    body = body[1:]
+    if flags&0x80 != 0 { // B: 長さが 12 ビット
+        if len(body) < 1 {
+            return nil, errors.New("RTP-MIDI のヘッダが短すぎます")
+        }
+        length = length<<8 | int(body[0])
+        body = body[1:]
+    }
+    if length > len(body) {
+        return nil, fmt.Errorf("RTP-MIDI のコマンドリストが短すぎます: %d > %d", length, len(body))
+    }
+    list := body[:length]
+    hasDelta := flags&0x20 != 0 // Z: 先頭のコマンドにもデルタタイムがある
+
+    var out [][]byte
+    var running byte
+    for i := 0; len(list) > 0; i++ {
+        if i > 0 || hasDelta {
+            n := deltaLen(list)
+            if n < 0 {
+                return out, errors.New("RTP-MIDI のデルタタイムが不正です")
+            }
+            list = list[n:]
+            if len(list) == 0 {
+                break
+            }
+        }
+        status := list[0]
+        if status >= 0x80 {
+            list = list[1:]
+        } else if running == 0 {
+            return out, errors.New("RTP-MIDI のランニングステータスが不正です")
+        } else {
+            status = running
+        }
+        switch {
+        case status == 0xF0 || status == 0xF7:
+            // SysEx（分割時は F0..F0 / F7..F0 / F7..F7 の区切り、F7 F4 で取り消し）
+            end := 0
+            for end < len(list) && list[end] < 0x80 {
+                end++
+            }
+            if end == len(list) {
+                return out, errors.New("RTP-MIDI の SysEx が終端していません")
+            }
+            data, term := list[:end], list[end]
+            list = list[end+1:]
+            running = 0
+            if status == 0xF0 {
+                *sysex = append([]byte{0xF0}, data...)
+            } else if len(*sysex) > 0 {
+                *sysex = append(*sysex, data...)
+            }
+            switch term {
+            case 0xF7:
+                if len(*sysex) > 0 {
+                    out = append(out, append(*sysex, 0xF7))
+                }
+                *sysex = nil
+            case 0xF4:
+                *sysex = nil
+            }
+        case status >= 0xF8:
+            // リアルタイム（クロック等）はデータなし。ランニングステータスは維持
+            out = append(out, []byte{status})
+        default:
+            n := dataLen(status)
+            if len(list) < n {
+                return out, errors.New("RTP-MIDI のコマンドが短すぎます")
+            }
+            msg := append([]byte{status}, list[:n]...)
+            list = list[n:]
+            out = append(out, msg)
+            if status < 0xF0 {
+                running = status
+            } else {
+                running = 0 // System Common はランニングステータスを解除
+            }
+        }
+    }
+    return out, nil
+}
+
+// deltaLen は可変長のデルタタイム（最大 4 バイト）のバイト数を返す。不正なら -1。
+func deltaLen(b []byte) int {
+    for i := 0; i < 4 && i < len(b); i++ {
+        if b[i]&0x80 == 0 {
+            return i + 1
+        }
+    }
+    return -1
+}
+
+// dataLen はステータスバイトに続くデータバイト数を返す（SysEx とリアルタイムを除く）。
+func dataLen(status byte) int {
+    switch {
+    case status < 0xC0, status >= 0xE0 && status < 0xF0:
+        return 2
+    case status < 0xE0:
+        return 1
+    case status == 0xF1 || status == 0xF3:
+        return 1
+    case status == 0xF2:
+        return 2
+    default:
+        return 0
+    }
+}
+
+// cstring は NUL 終端の文字列を返す。
+func cstring(b []byte) string {
+    for i, c := range b {
+        if c == 0 {
+            return string(b[:i])
+        }
+    }
+    return string(b)
+}
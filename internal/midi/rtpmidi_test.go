@@ -0,0 +1,255 @@
+package midi
+
+import (
+    "bytes"
+    "encoding/binary"
+    "net"
+    "strconv"
+    "testing"
+    "time"
+)
+
+// rtpPacket は ssrc からの RTP-MIDI パケット（ジャーナル付き）を作る。
+func rtpPacket(ssrc uint32, seq uint16, flags byte, list []byte) []byte {
+    pkt := make([]byte, 12)
+    pkt[0], pkt[1] = 0x80, rtpMIDIPayloadType
+    binary.BigEndian.PutUint16(pkt[2:4], seq)
+    binary.BigEndian.PutUint32(pkt[8:12], ssrc)
+    if len(list) > 15 {
+        pkt = append(pkt, 0x80|flags|byte(len(list)>>8), byte(len(list)))
+    } else {
+        pkt = append(pkt, flags|byte(len(list)))
+    }
+    pkt = append(pkt, list...)
+    return append(pkt, 0x20, 0x00, 0x01) // ジャーナル（読み飛ばされる）
+}
+
+func TestParseRTPMIDIRunningStatusAndDelta(t *testing.T) {
+    // NoteOn ch1 36/100、デルタ 0、ランニングステータスの NoteOn 37/0、デルタ 2 バイト、CC ch2 64/127、クロック、PC ch1 5
+    list := []byte{0x90, 36, 100, 0x00, 37, 0, 0x81, 0x00, 0xB1, 64, 127, 0x00, 0xF8, 0x00, 0xC0, 5}
+    var sysex []byte
+    msgs, err := parseRTPMIDI(rtpPacket(1, 1, 0x40, list), &sysex)
+    if err != nil {
+        t.Fatalf("parseRTPMIDI: %v", err)
+    }
+    want := [][]byte{{0x90, 36, 100}, {0x90, 37, 0}, {0xB1, 64, 127}, {0xF8}, {0xC0, 5}}
+    if len(msgs) != len(want) {
+        t.Fatalf("got %d messages: %x", len(msgs), msgs)
+    }
+    for i := range want {
+        if !bytes.Equal(msgs[i], want[i]) {
+            t.Fatalf("msg[%d] = %x, want %x", i, msgs[i], want[i])
+        }
+    }
+
+    // Z フラグ: 先頭にもデルタタイムがある
+    msgs, err = parseRTPMIDI(rtpPacket(1, 2, 0x20, []byte{0x05, 0x80, 36, 0}), &sysex)
+    if err != nil || len(msgs) != 1 || !bytes.Equal(msgs[0], []byte{0x80, 36, 0}) {
+        t.Fatalf("Z flag: %x %v", msgs, err)
+    }
+
+    if _, err := parseRTPMIDI(rtpPacket(1, 3, 0, []byte{36, 100}), &sysex); err == nil {
+        t.Fatal("data byte without status should fail")
+    }
+}
+
+func TestParseRTPMIDISegmentedSysEx(t *testing.T) {
+    var sysex []byte
+    // MSC GO cue 5 を 2 パケットに分割（F0..F0 / F7..F7）
+    first := []byte{0xF0, 0x7F, 0x01, 0x02, 0xF0}
+    last := []byte{0xF7, 0x01, 0x01, '5', 0xF7}
+    msgs, err := parseRTPMIDI(rtpPacket(1, 1, 0, first), &sysex)
+    if err != nil || len(msgs) != 0 {
+        t.Fatalf("first segment: %x %v", msgs, err)
+    }
+    msgs, err = parseRTPMIDI(rtpPacket(1, 2, 0, last), &sysex)
+    if err != nil || len(msgs) != 1 {
+        t.Fatalf("last segment: %x %v", msgs, err)
+    }
+    ev, ok := ParseMessage(msgs[0])
+    if !ok || ev.Type != ShowControl || ev.MSC.Command != MSCGo || ev.MSC.Cue != "5" {
+        t.Fatalf("reassembled sysex should be MSC GO 5: %x %+v", msgs[0], ev)
+    }
+}
+
+func TestParseRTPMIDIShortPackets(t *testing.T) {
+    valid := rtpPacket(1, 1, 0, []byte{0x90, 36, 100})
+    withCSRC := func(cc byte, csrc int) []byte {
+        pkt := append([]byte(nil), valid[:12]...)
+        pkt[0] |= cc
+        pkt = append(pkt, make([]byte, csrc)...)
+        return append(pkt, valid[12:]...)
+    }
+    withExt := func(words uint16, ext int) []byte {
+        pkt := append([]byte(nil), valid[:12]...)
+        pkt[0] |= 0x10
+        pkt = append(pkt, 0xBE, 0xDE, byte(words>>8), byte(words))
+        pkt = append(pkt, make([]byte, ext)...)
+        return append(pkt, valid[12:]...)
+    }
+    cases := []struct {
+        name string
+        pkt  []byte
+        ok   bool
+    }{
+        {"valid", valid, true},
+        {"header only", valid[:12], false},
+        {"csrc count beyond packet", append([]byte{0x8F}, valid[1:13]...), false},
+        {"csrc present", withCSRC(2, 8), true},
+        {"csrc truncated", withCSRC(2, 7)[:19], false},
+        {"extension present", withExt(1, 4), true},
+        {"extension header truncated", append([]byte{0x90}, valid[1:14]...), false},
+        {"extension length beyond packet", withExt(100, 4), false},
+        {"12-bit length missing", append(append([]byte(nil), valid[:12]...), 0x80), false},
+        {"list length beyond packet", append(append([]byte(nil), valid[:12]...), 0x0F, 0x90), false},
+        {"truncated command", append(append([]byte(nil), valid[:12]...), 0x02, 0x90, 36), false},
+    }
+    for _, c := range cases {
+        var sysex []byte
+        msgs, err := parseRTPMIDI(c.pkt, &sysex)
+        if c.ok && (err != nil || len(msgs) != 1) {
+            t.Errorf("%s: want one message, got %x %v", c.name, msgs, err)
+        }
+        if !c.ok && err == nil {
+            t.Errorf("%s: want error, got %x", c.name, msgs)
+        }
+    }
+}
+
+func FuzzParseRTPMIDI(f *testing.F) {
+    f.Add(rtpPacket(1, 1, 0x40, []byte{0x90, 36, 100, 0x00, 37, 0}))
+    f.Add(rtpPacket(1, 1, 0, []byte{0xF0, 0x7F, 0x01, 0xF0}))
+    f.Add(append([]byte{0x8F, rtpMIDIPayloadType}, make([]byte, 11)...))
+    f.Add(append([]byte{0x91, rtpMIDIPayloadType}, make([]byte, 16)...))
+    f.Fuzz(func(t *testing.T, pkt []byte) {
+        var sysex []byte
+        _, _ = parseRTPMIDI(pkt, &sysex)
+    })
+}
+
+func TestParseRTPMIDIDevice(t *testing.T) {
+    host, port, name, err := parseRTPMIDIDevice("rtpmidi://:5004")
+    if err != nil || host != "" || port != 5004 || name != "obsctl" {
+        t.Fatalf("got %q %d %q %v", host, port, name, err)
+    }
+    if _, port, name, _ = parseRTPMIDIDevice("rtpmidi://127.0.0.1?name=OBS"); port != DefaultRTPMIDIPort || name != "OBS" {
+        t.Fatalf("default port / name: %d %q", port, name)
+    }
+    if _, _, _, err := parseRTPMIDIDevice("rtpmidi://:abc"); err == nil {
+        t.Fatal("bad port should fail")
+    }
+}
+
+// openTestSession は空いているポートの組でセッションを開く。
+func openTestSession(t *testing.T) (Input, <-chan Event, int) {
+    t.Helper()
+    for i := 0; i < 20; i++ {
+        l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+        if err != nil {
+            t.Fatal(err)
+        }
+        port := l.LocalAddr().(*net.UDPAddr).Port
+        _ = l.Close()
+        in, events, err := OpenInput("rtpmidi://127.0.0.1:" + strconv.Itoa(port) + "?name=test")
+        if err == nil {
+            return in, events, port
+        }
+    }
+    t.Fatal("no free port pair")
+    return nil, nil, 0
+}
+
+func TestRTPMIDISessionInvitationSyncAndData(t *testing.T) {
+    in, events, port := openTestSession(t)
+    defer in.Close()
+
+    local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+    ctrl, err := net.DialUDP("udp", local, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ctrl.Close()
+    data, err := net.DialUDP("udp", local, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer data.Close()
+
+    const ssrc = 0x11223344
+    invite := func(c *net.UDPConn) {
+        pkt := make([]byte, 16)
+        binary.BigEndian.PutUint16(pkt, appleMIDISignature)
+        copy(pkt[2:4], appleMIDIInvitation)
+        binary.BigEndian.PutUint32(pkt[4:8], appleMIDIVersion)
+        binary.BigEndian.PutUint32(pkt[8:12], 0xCAFE)
+        binary.BigEndian.PutUint32(pkt[12:16], ssrc)
+        pkt = append(pkt, "Playback Mac\x00"...)
+        if _, err := c.Write(pkt); err != nil {
+            t.Fatal(err)
+        }
+        reply := read(t, c)
+        if string(reply[2:4]) != appleMIDIAccept || binary.BigEndian.Uint32(reply[8:12]) != 0xCAFE || cstring(reply[16:]) != "test" {
+            t.Fatalf("unexpected invitation reply: %x", reply)
+        }
+    }
+    invite(ctrl)
+    invite(data)
+
+    ck := make([]byte, 36)
+    binary.BigEndian.PutUint16(ck, appleMIDISignature)
+    copy(ck[2:4], appleMIDISync)
+    binary.BigEndian.PutUint32(ck[4:8], ssrc)
+    binary.BigEndian.PutUint64(ck[12:20], 1234)
+    if _, err := data.Write(ck); err != nil {
+        t.Fatal(err)
+    }
+    reply := read(t, data)
+    if string(reply[2:4]) != appleMIDISync || reply[8] != 1 || binary.BigEndian.Uint64(reply[12:20]) != 1234 {
+        t.Fatalf("unexpected sync reply: %x", reply)
+    }
+
+    if _, err := data.Write(rtpPacket(ssrc, 7, 0, []byte{0x99, 36, 100})); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case ev := <-events:
+        if ev.Type != NoteOn || ev.Channel != 10 || ev.Data1 != 36 || ev.Time.IsZero() {
+            t.Fatalf("unexpected event: %+v", ev)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("timeout waiting for event")
+    }
+    // 受信フィードバック（RS）がコントロールポートに届く
+    if fb := read(t, ctrl); string(fb[2:4]) != appleMIDIFeedback || binary.BigEndian.Uint16(fb[8:10]) != 7 {
+        t.Fatalf("unexpected feedback: %x", fb)
+    }
+
+    // 招待していない SSRC は無視する
+    if _, err := data.Write(rtpPacket(0x99, 1, 0, []byte{0x90, 1, 1})); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case ev := <-events:
+        t.Fatalf("event from unknown peer: %+v", ev)
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    _ = in.Close()
+    if bye := read(t, ctrl); string(bye[2:4]) != appleMIDIEnd {
+        t.Fatalf("expected BY on close: %x", bye)
+    }
+    if _, ok := <-events; ok {
+        t.Fatal("events should be closed")
+    }
+}
+
+func read(t *testing.T, c *net.UDPConn) []byte {
+    t.Helper()
+    buf := make([]byte, 1500)
+    _ = c.SetReadDeadline(time.Now().Add(time.Second))
+    n, err := c.Read(buf)
+    if err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    return buf[:n]
+}
@@ -0,0 +1,267 @@
+package midi
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "time"
+)
+
+// TimedEvent は SMF 内のイベントと、曲頭からの経過時間（テンポマップ適用済み）。
+type TimedEvent struct {
+    At    time.Duration
+    Track int
+    Event Event // Time はゼロ値
+}
+
+// SMF は読み込んだ Standard MIDI File。Events は At の昇順（同時刻はトラック順）。
+type SMF struct {
+    Format   int
+    Division uint16 // ヘッダの division（上位ビットが 0 なら 4 分音符あたりの tick 数）
+    Events   []TimedEvent
+    Length   time.Duration // 最後のイベント（End of Track を含む）までの時間
+}
+
+// ReadSMFFile は path の SMF を読み込む。
+func ReadSMFFile(path string) (*SMF, error) {
+    bt, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return ParseSMF(bt)
+}
+
+type tempoChange struct {
+    tick  uint64
+    usPQN uint32 // 4 分音符あたりのマイクロ秒
+}
+
+type rawEvent struct {
+    tick  uint64
+    track int
+    seq   int
+    ev    Event
+}
+
+// ParseSMF は SMF（format 0/1）を解析し、ノート/CC/プログラムチェンジと MSC を時刻付きで返す。
+// テンポ変更（Set Tempo）は全トラックから集めてテンポマップとして適用する。SMPTE 形式の division にも対応。
+func ParseSMF(data []byte) (*SMF, error) {
+    r := bytes.NewReader(data)
+    id, body, err := readChunk(r)
+    if err != nil {
+        return nil, fmt.Errorf("SMF ヘッダの読み込みに失敗: %w", err)
+    }
+    if id != "MThd" || len(body) < 6 {
+        return nil, errors.New("SMF ではありません（MThd がありません）")
+    }
+    format := int(binary.BigEndian.Uint16(body[0:2]))
+    ntrks := int(binary.BigEndian.Uint16(body[2:4]))
+    division := binary.BigEndian.Uint16(body[4:6])
+    if format > 1 {
+        return nil, fmt.Errorf("未対応の SMF format です: %d（0/1 のみ対応）", format)
+    }
+    if division == 0 {
+        return nil, errors.New("SMF の division が 0 です")
+    }
+    if division&0x8000 != 0 {
+        if fps, tpf := -int(int8(division>>8)), division&0xFF; fps <= 0 || tpf == 0 {
+            return nil, fmt.Errorf("SMF の SMPTE division が不正です: %d fps / %d tick", fps, tpf)
+        }
+    }
+
+    var raws []rawEvent
+    var tempos []tempoChange
+    var lastTick uint64
+    track := 0
+    for track < ntrks {
+        id, body, err := readChunk(r)
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, fmt.Errorf("トラック %d の読み込みに失敗: %w", track, err)
+        }
+        if id != "MTrk" {
+            continue // 未知のチャンクは読み飛ばす
+        }
+        evs, tcs, end, err := parseTrack(body, track)
+        if err != nil {
+            return nil, fmt.Errorf("トラック %d: %w", track, err)
+        }
+        raws = append(raws, evs...)
+        tempos = append(tempos, tcs...)
+        if end > lastTick {
+            lastTick = end
+        }
+        track++
+    }
+
+    toTime := tickConverter(division, tempos)
+    sort.SliceStable(raws, func(i, j int) bool {
+        if raws[i].tick != raws[j].tick {
+            return raws[i].tick < raws[j].tick
+        }
+        if raws[i].track != raws[j].track {
+            return raws[i].track < raws[j].track
+        }
+        return raws[i].seq < raws[j].seq
+    })
+    out := &SMF{Format: format, Division: division, Events: make([]TimedEvent, 0, len(raws))}
+    for _, rv := range raws {
+        out.Events = append(out.Events, TimedEvent{At: toTime(rv.tick), Track: rv.track, Event: rv.ev})
+    }
+    out.Length = toTime(lastTick)
+    return out, nil
+}
+
+func readChunk(r *bytes.Reader) (string, []byte, error) {
+    var hdr [8]byte
+    if _, err := io.ReadFull(r, hdr[:]); err != nil {
+        if err == io.ErrUnexpectedEOF {
+            return "", nil, errors.New("チャンクヘッダが途中で終わっています")
+        }
+        return "", nil, err
+    }
+    n := binary.BigEndian.Uint32(hdr[4:8])
+    if int64(n) > int64(r.Len()) {
+        return "", nil, fmt.Errorf("チャンク %q の長さ %d がファイルを超えています", string(hdr[:4]), n)
+    }
+    body := make([]byte, n)
+    if _, err := io.ReadFull(r, body); err != nil {
+        return "", nil, err
+    }
+    return string(hdr[:4]), body, nil
+}
+
+func readVarLen(b []byte, i int) (uint32, int, error) {
+    var v uint32
+    for k := 0; k < 4; k++ {
+        if i >= len(b) {
+            return 0, i, errors.New("可変長数値が途中で終わっています")
+        }
+        c := b[i]
+        i++
+        v = v<<7 | uint32(c&0x7F)
+        if c&0x80 == 0 {
+            return v, i, nil
+        }
+    }
+    return 0, i, errors.New("可変長数値が長すぎます")
+}
+
+// parseTrack は 1 トラック分を解析し、チャンネルイベント・テンポ変更・トラック終端の tick を返す。
+func parseTrack(b []byte, track int) ([]rawEvent, []tempoChange, uint64, error) {
+    var evs []rawEvent
+    var tempos []tempoChange
+    var tick uint64
+    var running byte
+    i := 0
+    for i < len(b) {
+        delta, ni, err := readVarLen(b, i)
+        if err != nil {
+            return nil, nil, 0, err
+        }
+        i = ni
+        tick += uint64(delta)
+        if i >= len(b) {
+            return nil, nil, 0, errors.New("イベントが途中で終わっています")
+        }
+        status := b[i]
+        switch {
+        case status == 0xFF: // メタイベント
+            if i+2 > len(b) {
+                return nil, nil, 0, errors.New("メタイベントが途中で終わっています")
+            }
+            typ := b[i+1]
+            n, ni, err := readVarLen(b, i+2)
+            if err != nil {
+                return nil, nil, 0, err
+            }
+            if ni+int(n) > len(b) {
+                return nil, nil, 0, errors.New("メタイベントの長さが不正です")
+            }
+            data := b[ni : ni+int(n)]
+            i = ni + int(n)
+            switch typ {
+            case 0x51: // Set Tempo
+                if len(data) == 3 {
+                    tempos = append(tempos, tempoChange{tick: tick, usPQN: uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])})
+                }
+            case 0x2F: // End of Track
+                return evs, tempos, tick, nil
+            }
+        case status == 0xF0 || status == 0xF7: // SysEx
+            n, ni, err := readVarLen(b, i+1)
+            if err != nil {
+                return nil, nil, 0, err
+            }
+            if ni+int(n) > len(b) {
+                return nil, nil, 0, errors.New("SysEx の長さが不正です")
+            }
+            if status == 0xF0 {
+                // MIDI Show Control は受信時と同じく ShowControl イベントにする
+                if ev, ok := ParseMessage(append([]byte{0xF0}, b[ni:ni+int(n)]...)); ok {
+                    evs = append(evs, rawEvent{tick: tick, track: track, seq: len(evs), ev: ev})
+                }
+            }
+            i = ni + int(n)
+        default:
+            if status&0x80 != 0 {
+                running = status
+                i++
+            } else if running == 0 {
+                return nil, nil, 0, fmt.Errorf("ランニングステータスがありません（offset %d）", i)
+            }
+            size := 2
+            if kind := running >> 4; kind == 0x0C || kind == 0x0D {
+                size = 1
+            }
+            if i+size > len(b) {
+                return nil, nil, 0, errors.New("チャンネルメッセージが途中で終わっています")
+            }
+            msg := append([]byte{running}, b[i:i+size]...)
+            i += size
+            if ev, ok := ParseMessage(msg); ok {
+                evs = append(evs, rawEvent{tick: tick, track: track, seq: len(evs), ev: ev})
+            }
+        }
+    }
+    return evs, tempos, tick, nil
+}
+
+// tickConverter は tick → 経過時間の変換関数を返す。
+func tickConverter(division uint16, tempos []tempoChange) func(uint64) time.Duration {
+    if division&0x8000 != 0 {
+        // SMPTE: 上位バイトが -fps（2 の補数）、下位バイトがフレームあたりの tick 数
+        fps := -int(int8(division >> 8))
+        if fps == 29 {
+            fps = 30 // 29.97 drop frame は 30 として近似
+        }
+        tpf := int(division & 0xFF)
+        perTick := time.Second / time.Duration(fps*tpf)
+        return func(t uint64) time.Duration { return time.Duration(t) * perTick }
+    }
+    tpq := uint64(division)
+    sort.SliceStable(tempos, func(i, j int) bool { return tempos[i].tick < tempos[j].tick })
+    return func(t uint64) time.Duration {
+        var at time.Duration
+        var prev uint64
+        us := uint64(500000) // 既定 120BPM
+        for _, tc := range tempos {
+            if tc.tick >= t {
+                break
+            }
+            at += ticksToDur(tc.tick-prev, us, tpq)
+            prev, us = tc.tick, uint64(tc.usPQN)
+        }
+        return at + ticksToDur(t-prev, us, tpq)
+    }
+}
+
+func ticksToDur(ticks, usPQN, tpq uint64) time.Duration {
+    return time.Duration(ticks*usPQN*1000/tpq) * time.Nanosecond
+}
@@ -0,0 +1,86 @@
+package midi
+
+import (
+    "encoding/binary"
+    "testing"
+    "time"
+)
+
+func chunk(id string, body []byte) []byte {
+    out := append([]byte(id), 0, 0, 0, 0)
+    binary.BigEndian.PutUint32(out[4:8], uint32(len(body)))
+    return append(out, body...)
+}
+
+func TestParseSMFTempoMap(t *testing.T) {
+    // division 480、0 tick で 120BPM、960 tick（1 秒）で 240BPM に変更
+    hdr := chunk("MThd", []byte{0, 1, 0, 2, 0x01, 0xE0})
+    tempo := chunk("MTrk", []byte{
+        0x00, 0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20,
+        0x87, 0x40, 0xFF, 0x51, 0x03, 0x03, 0xD0, 0x90,
+        0x00, 0xFF, 0x2F, 0x00,
+    })
+    notes := chunk("MTrk", []byte{
+        0x00, 0x90, 36, 100, // 0s NoteOn
+        0x87, 0x40, 37, 100, // 960 tick = 1s、ランニングステータス
+        0x00, 0xF0, 0x02, 0x7E, 0xF7, // SysEx は読み飛ばす
+        0x83, 0x60, 0xB1, 20, 127, // 1440 tick = 1.25s CH2 CC20
+        0x00, 0xC1, 5, // ProgramChange
+        0x10, 0x90, 36, 0, // velocity 0 は NoteOff
+        0x00, 0xFF, 0x2F, 0x00,
+    })
+    data := append(append(hdr, tempo...), notes...)
+
+    smf, err := ParseSMF(data)
+    if err != nil {
+        t.Fatalf("ParseSMF: %v", err)
+    }
+    want := []struct {
+        at  time.Duration
+        typ Type
+        ch  uint8
+        d1  uint8
+    }{
+        {0, NoteOn, 1, 36},
+        {time.Second, NoteOn, 1, 37},
+        {1250 * time.Millisecond, ControlChange, 2, 20},
+        {1250 * time.Millisecond, ProgramChange, 2, 5},
+        {1250*time.Millisecond + 16*time.Second/(4*480), NoteOff, 1, 36},
+    }
+    if len(smf.Events) != len(want) {
+        t.Fatalf("events=%d; want %d: %+v", len(smf.Events), len(want), smf.Events)
+    }
+    for i, w := range want {
+        got := smf.Events[i]
+        if got.At != w.at || got.Event.Type != w.typ || got.Event.Channel != w.ch || got.Event.Data1 != w.d1 {
+            t.Fatalf("event[%d]=%+v; want %+v", i, got, w)
+        }
+    }
+}
+
+func TestParseSMFRejectsInvalid(t *testing.T) {
+    if _, err := ParseSMF([]byte("RIFF....")); err == nil {
+        t.Fatalf("non-SMF should fail")
+    }
+    hdr := chunk("MThd", []byte{0, 2, 0, 1, 0x01, 0xE0})
+    if _, err := ParseSMF(hdr); err == nil {
+        t.Fatalf("format 2 should fail")
+    }
+    // SMPTE 24fps でフレームあたり 0 tick
+    smpte := append(chunk("MThd", []byte{0, 0, 0, 1, 0xE8, 0x00}), chunk("MTrk", []byte{0x00, 0xFF, 0x2F, 0x00})...)
+    if _, err := ParseSMF(smpte); err == nil {
+        t.Fatalf("SMPTE division with 0 ticks per frame should fail")
+    }
+}
+
+func TestParseMessage(t *testing.T) {
+    if ev, ok := ParseMessage([]byte{0x9F, 60, 0}); !ok || ev.Type != NoteOff || ev.Channel != 16 {
+        t.Fatalf("NoteOn vel 0: %+v %v", ev, ok)
+    }
+    if _, ok := ParseMessage([]byte{0xF8}); ok {
+        t.Fatalf("realtime message should be ignored")
+    }
+    if _, ok := ParseMessage([]byte{0xB0, 7}); ok {
+        t.Fatalf("truncated CC should be ignored")
+    }
+}
@@ -10,15 +10,22 @@ const (
     NoteOff       Type = "note_off"
     ControlChange Type = "control_change"
     ProgramChange Type = "program_change"
+    ShowControl   Type = "msc" // MIDI Show Control（SysEx）。内容は Event.MSC
+
+    TimecodeQuarterFrame Type = "mtc_qf"   // MTC クォーターフレーム（F1）。Data1 にデータバイト
+    TimecodeFull         Type = "mtc_full" // MTC フルフレーム（SysEx）。内容は Event.Timecode
 )
 
 // Event は正規化されたMIDIイベント。
 type Event struct {
-    Type    Type
-    Channel uint8 // 1-16
-    Data1   uint8 // Note番号 / CC番号 / Program番号
-    Data2   uint8 // Velocity / CC値（ProgramChangeでは未使用）
-    Time    time.Time
+    Type     Type
+    Channel  uint8 // 1-16
+    Data1    uint8 // Note番号 / CC番号 / Program番号
+    Data2    uint8 // Velocity / CC値（ProgramChangeでは未使用）
+    Time     time.Time
+    MSC      *MSC      // Type が ShowControl のときのみ（Channel は 0）
+    Timecode *Timecode // Type が TimecodeFull のときのみ（Channel は 0）
+    Device   string    // 受信した入力の名前（OpenInputsAuto でまとめたときのみ）
 }
 
 // Input はオープン済みのMIDI入力デバイスを表す。
@@ -26,3 +33,9 @@ type Input interface {
     Close() error
 }
 
+
+// Output はオープン済みの MIDI 出力デバイスを表す（LED などのフィードバック送信用）。
+type Output interface {
+    Send(msg []byte) error
+    Close() error
+}
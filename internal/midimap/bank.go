@@ -0,0 +1,211 @@
+package midimap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"awesomeProject/internal/midi"
+)
+
+// Bank は banks の要素（切り替えて使うマッピングのページ）です。
+// 有効なバンクの mappings はトップレベルの mappings より優先し、どのバンクでもトップレベルは使えます。
+type Bank struct {
+	Name     string    `json:"name"`
+	Select   *Mapping  `json:"select,omitempty"` // 押すとこのバンクに切り替えるトリガ（scene は不要）
+	Shift    *Mapping  `json:"shift,omitempty"`  // 押している間だけこのバンクにするトリガ（note_on / control_change）
+	Mappings []Mapping `json:"mappings"`
+}
+
+// BankRules は 1 バンク分の照合ルールです。
+type BankRules struct {
+	Name     string
+	Scenes   map[string]string
+	Modes    map[string]ModeSpec
+	Controls map[string]*Control
+	Routes   map[string]Route
+	Select   *Mapping // フィードバック（LED 点灯）に使う切替トリガ
+}
+
+// BankKey はバンク切替トリガの照合キーに対応する動作です。
+type BankKey struct {
+	Bank  int  // Rules.Banks の位置
+	Shift bool // 押している間だけ切り替える
+}
+
+// bankName は空なら "bank N" を返します。
+func bankName(name string, i int) string {
+	if n := strings.TrimSpace(name); n != "" {
+		return n
+	}
+	return fmt.Sprintf("bank %d", i+1)
+}
+
+// validateBanks は banks を検証します（Validate から呼ばれます）。
+// 切替トリガ同士の重複と、切替トリガがトップレベルやバンクのマッピングと重なることもエラーにします。
+func (f *File) validateBanks() []error {
+	var errs []error
+	triggers := map[string]string{}
+	names := map[string]int{}
+	for i, b := range f.Banks {
+		prefix := fmt.Sprintf("banks[%d]", i)
+		name := bankName(b.Name, i)
+		if j, dup := names[name]; dup {
+			errs = append(errs, fmt.Errorf("%s: name %q は banks[%d] と重複しています", prefix, name, j))
+		}
+		names[name] = i
+		if b.Select == nil && b.Shift == nil && i > 0 {
+			errs = append(errs, fmt.Errorf("%s: select か shift が必要です（先頭以外のバンク）", prefix))
+		}
+		for _, t := range []struct {
+			field string
+			m     *Mapping
+		}{{"select", b.Select}, {"shift", b.Shift}} {
+			if t.m == nil {
+				continue
+			}
+			key, err := t.m.Key()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %w", prefix, t.field, err))
+				continue
+			}
+			if t.m.kind() == midi.ShowControl {
+				errs = append(errs, fmt.Errorf("%s.%s (%s): バンク切替に msc は使えません", prefix, t.field, key))
+			}
+			if t.field == "shift" && t.m.kind() == midi.ProgramChange {
+				errs = append(errs, fmt.Errorf("%s.shift (%s): shift は note_on / control_change でのみ使えます", prefix, key))
+			}
+			if prev, dup := triggers[key]; dup {
+				errs = append(errs, fmt.Errorf("%s.%s (%s): %s と同じトリガです", prefix, t.field, key, prev))
+				continue
+			}
+			triggers[key] = prefix + "." + t.field
+		}
+		sub := &File{Mappings: b.Mappings}
+		for _, err := range sub.validateMappings() {
+			errs = append(errs, fmt.Errorf("%s.%w", prefix, err))
+		}
+	}
+	// 切替トリガはマッピングより先に照合するため、同じトリガのマッピングは発火しない
+	check := func(prefix string, ms []Mapping) {
+		for j, m := range ms {
+			if key, err := m.Key(); err == nil {
+				if t, dup := triggers[key]; dup {
+					errs = append(errs, fmt.Errorf("%smappings[%d] (%s): %s と同じトリガです", prefix, j, key, t))
+				}
+			}
+		}
+	}
+	if len(triggers) > 0 {
+		check("", f.Mappings)
+		for i, b := range f.Banks {
+			check(fmt.Sprintf("banks[%d].", i), b.Mappings)
+		}
+	}
+	return errs
+}
+
+// BankRules は banks をバンクごとのルールと切替トリガにまとめます。不正なものは読み捨てます（検証は Validate）。
+func (f *File) BankRules() ([]*BankRules, map[string]BankKey) {
+	var banks []*BankRules
+	keys := map[string]BankKey{}
+	for i, b := range f.Banks {
+		sub := &File{Mappings: b.Mappings, ApplyTransitions: f.ApplyTransitions}
+		br := &BankRules{Name: bankName(b.Name, i), Scenes: map[string]string{}, Modes: sub.Modes(), Controls: sub.Controls(), Routes: sub.Routes(), Select: b.Select}
+		for _, m := range b.Mappings {
+			if key, err := m.Key(); err == nil && strings.TrimSpace(m.Scene) != "" {
+				br.Scenes[key] = m.Scene
+			}
+		}
+		banks = append(banks, br)
+		addBankKey(keys, b.Select, BankKey{Bank: i})
+		addBankKey(keys, b.Shift, BankKey{Bank: i, Shift: true})
+	}
+	return banks, keys
+}
+
+func addBankKey(keys map[string]BankKey, m *Mapping, k BankKey) {
+	if m == nil || m.kind() == midi.ShowControl || (k.Shift && m.kind() == midi.ProgramChange) {
+		return
+	}
+	if key, err := m.Key(); err == nil {
+		if _, dup := keys[key]; !dup {
+			keys[key] = k
+		}
+	}
+}
+
+// ParseBank は GUI の行形式（select / shift は "ch:note"、mappings は "ch:note=Scene"）から Bank を作ります。
+// 同じトリガの行は後勝ち（NewRules と同じ）です。不正な行は読み捨て、その内容をエラーとしてまとめて返します。
+func ParseBank(name, selectTrigger, shiftTrigger string, lines []string) (Bank, error) {
+	var errs []error
+	b := Bank{Name: strings.TrimSpace(name)}
+	if s := strings.TrimSpace(selectTrigger); s != "" {
+		if m, err := ParseTrigger(s); err != nil {
+			errs = append(errs, fmt.Errorf("切替: %w: %q", err, s))
+		} else {
+			b.Select = &m
+		}
+	}
+	if s := strings.TrimSpace(shiftTrigger); s != "" {
+		if m, err := ParseTrigger(s); err != nil {
+			errs = append(errs, fmt.Errorf("シフト: %w: %q", err, s))
+		} else {
+			b.Shift = &m
+		}
+	}
+	seen := map[string]int{}
+	for i, ln := range lines {
+		if strings.TrimSpace(ln) == "" {
+			continue
+		}
+		m, err := ParseLine(ln)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%d行目: %w", i+1, err))
+			continue
+		}
+		key, _ := m.Key()
+		if j, dup := seen[key]; dup {
+			b.Mappings[j] = m
+			continue
+		}
+		seen[key] = len(b.Mappings)
+		b.Mappings = append(b.Mappings, m)
+	}
+	return b, errors.Join(errs...)
+}
+
+// ApplyBanks は banks を r に設定して r を返します（f が nil か banks が無ければ何もしません）。
+func (f *File) ApplyBanks(r *Rules) *Rules {
+	if f != nil && len(f.Banks) > 0 {
+		r.Banks, r.BankKeys = f.BankRules()
+	}
+	return r
+}
+
+// BankFeedback は active のバンクを示す MIDI メッセージ（切替トリガの LED 点灯・消灯）を返します。
+// note_on は velocity 127 / 0、control_change は値 127 / 0 を送ります。ProgramChange の切替は対象外です。
+func (r *Rules) BankFeedback(active int) [][]byte {
+	var out [][]byte
+	for i, b := range r.Banks {
+		if b.Select == nil {
+			continue
+		}
+		n, ok := b.Select.Number()
+		if !ok || b.Select.Channel < 1 || b.Select.Channel > 16 {
+			continue
+		}
+		v := byte(0)
+		if i == active {
+			v = 127
+		}
+		ch := byte(b.Select.Channel - 1)
+		switch b.Select.kind() {
+		case midi.NoteOn:
+			out = append(out, []byte{0x90 | ch, byte(n), v})
+		case midi.ControlChange:
+			out = append(out, []byte{0xB0 | ch, byte(n), v})
+		}
+	}
+	return out
+}
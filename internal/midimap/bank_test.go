@@ -0,0 +1,168 @@
+package midimap
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+const bankJSON = `{
+  "mappings": [{"type": "note_on", "channel": 1, "note": 50, "scene": "Black"}],
+  "feedback_device": "APC",
+  "banks": [
+    {"name": "Cams", "select": {"type": "note_on", "channel": 1, "note": 80},
+     "mappings": [{"type": "note_on", "channel": 1, "note": 36, "scene": "Cam1"}]},
+    {"name": "Slides", "select": {"type": "control_change", "channel": 1, "control": 81},
+     "shift": {"type": "note_on", "channel": 1, "note": 98},
+     "mappings": [{"type": "note_on", "channel": 1, "note": 36, "scene": "Slide1"}]}
+  ]
+}`
+
+func TestBanksSelectAndShift(t *testing.T) {
+	var f File
+	if err := json.Unmarshal([]byte(bankJSON), &f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	e := NewEvaluator(f.ApplyBanks(&Rules{Scenes: map[string]string{"1:50": "Black"}}))
+	now := time.Unix(0, 0)
+	ev := func(typ midi.Type, d1, d2 uint8) Result {
+		now = now.Add(time.Second)
+		return e.Evaluate(midi.Event{Type: typ, Channel: 1, Data1: d1, Data2: d2}, now)
+	}
+	expect := func(name string, res Result, scene string) {
+		t.Helper()
+		if !res.Fire() || res.Scene != scene {
+			t.Fatalf("%s: want %q, got %+v", name, scene, res)
+		}
+	}
+
+	if i, name := e.Bank(); i != 0 || name != "Cams" {
+		t.Fatalf("initial bank: %d %q", i, name)
+	}
+	expect("bank 0", ev(midi.NoteOn, 36, 100), "Cam1")
+	expect("top level", ev(midi.NoteOn, 50, 100), "Black")
+
+	// シフト中だけ Slides
+	if res := ev(midi.NoteOn, 98, 100); res.Fire() || res.Bank != "Slides" || res.BankIndex != 1 {
+		t.Fatalf("shift press: %+v", res)
+	}
+	expect("shifted", ev(midi.NoteOn, 36, 100), "Slide1")
+	if res := ev(midi.NoteOff, 98, 0); !res.Release || res.Bank != "Cams" {
+		t.Fatalf("shift release: %+v", res)
+	}
+	expect("unshifted", ev(midi.NoteOn, 36, 100), "Cam1")
+
+	// select（CC）で切り替え。値 0 は無視
+	if res := ev(midi.ControlChange, 81, 127); res.Bank != "Slides" {
+		t.Fatalf("select: %+v", res)
+	}
+	if res := ev(midi.ControlChange, 81, 0); res.Bank != "" || res.Fire() {
+		t.Fatalf("select release should be ignored: %+v", res)
+	}
+	expect("selected", ev(midi.NoteOn, 36, 100), "Slide1")
+	if i, name := e.Bank(); i != 1 || name != "Slides" {
+		t.Fatalf("bank after select: %d %q", i, name)
+	}
+
+	fb := e.Rules().BankFeedback(1)
+	want := [][]byte{{0x90, 80, 0}, {0xB0, 81, 127}}
+	if len(fb) != len(want) {
+		t.Fatalf("feedback: %v", fb)
+	}
+	for i := range want {
+		if string(fb[i]) != string(want[i]) {
+			t.Fatalf("feedback[%d]: want %v, got %v", i, want[i], fb[i])
+		}
+	}
+
+	// バンクが減ったら先頭に戻る
+	e.Swap(&Rules{Scenes: map[string]string{}})
+	if i, name := e.Bank(); i != 0 || name != "" {
+		t.Fatalf("bank after swap: %d %q", i, name)
+	}
+}
+
+func TestBankModesUseBankAltScene(t *testing.T) {
+	const js = `{
+  "mappings": [{"type": "note_on", "channel": 1, "note": 50, "scene": "Black"}],
+  "banks": [
+    {"name": "Cams", "mappings": [
+      {"type": "note_on", "channel": 1, "note": 36, "scene": "Cam1", "mode": "momentary", "alt_scene": "Wide"},
+      {"type": "note_on", "channel": 1, "note": 37, "scene": "Cam2", "mode": "toggle", "alt_scene": "Wide"}
+    ]}
+  ]
+}`
+	var f File
+	if err := json.Unmarshal([]byte(js), &f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	e := NewEvaluator(f.ApplyBanks(&Rules{Scenes: map[string]string{"1:50": "Black"}}))
+	e.SetCurrent("Prev")
+	now := time.Unix(0, 0)
+	ev := func(typ midi.Type, d1, d2 uint8) Result {
+		now = now.Add(time.Second)
+		return e.Evaluate(midi.Event{Type: typ, Channel: 1, Data1: d1, Data2: d2}, now)
+	}
+
+	if res := ev(midi.NoteOn, 36, 100); !res.Fire() || res.Scene != "Cam1" {
+		t.Fatalf("momentary press: %+v", res)
+	}
+	if res := ev(midi.NoteOff, 36, 0); !res.Fire() || res.Scene != "Wide" {
+		t.Fatalf("momentary release should go to the bank alt_scene: %+v", res)
+	}
+	if res := ev(midi.NoteOn, 37, 100); !res.Fire() || res.Scene != "Cam2" {
+		t.Fatalf("toggle on: %+v", res)
+	}
+	if res := ev(midi.NoteOn, 37, 100); !res.Fire() || res.Scene != "Wide" {
+		t.Fatalf("toggle off should go to the bank alt_scene: %+v", res)
+	}
+}
+
+func TestValidateBanks(t *testing.T) {
+	note := func(n int) *Mapping { return &Mapping{Type: midi.NoteOn, Channel: 1, Note: n} }
+	pc := 3
+	f := File{
+		Mappings: []Mapping{{Type: midi.NoteOn, Channel: 1, Note: 80, Scene: "A"}},
+		Banks: []Bank{
+			{Name: "A", Select: note(80)},
+			{Name: "A", Shift: &Mapping{Type: midi.ProgramChange, Channel: 1, Program: &pc}},
+			{Name: "C"},
+			{Name: "D", Select: note(80), Mappings: []Mapping{{Type: midi.NoteOn, Channel: 1, Note: 36}}},
+		},
+	}
+	err := f.Validate()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{
+		`banks[1]: name "A" は banks[0] と重複`,
+		"banks[1].shift (1:pc3)",
+		"banks[2]: select か shift が必要",
+		"banks[3].select (1:80): banks[0].select と同じトリガ",
+		"banks[3].mappings[0] (1:36): scene が空",
+		"mappings[0] (1:80): banks[0].select と同じトリガ",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+}
+
+func TestParseBank(t *testing.T) {
+	b, err := ParseBank(" FX ", "1:cc80", "1:98", []string{"1:36=Cam|momentary", "", "bad"})
+	if err == nil || !strings.Contains(err.Error(), "3行目") {
+		t.Fatalf("want error for line 3, got %v", err)
+	}
+	if b.Name != "FX" || b.Select == nil || b.Select.kind() != midi.ControlChange || b.Shift == nil || len(b.Mappings) != 1 {
+		t.Fatalf("bank: %+v", b)
+	}
+}
@@ -0,0 +1,248 @@
+package midimap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+// 連続値操作の種類（Mapping.Action）。
+const (
+	ActionVolume = "volume" // SetInputVolume（dB）
+	ActionTBar   = "tbar"   // SetTBarPosition（0..1）
+	ActionFilter = "filter" // SetSourceFilterSettings（setting の数値）
+)
+
+// 値のカーブ（Mapping.Curve）。入力 0..127 を 0..1 に正規化してから適用します。
+const (
+	CurveLinear = "linear"
+	CurveLog    = "log" // 下側で大きく動く（log10(1+9x)）
+	CurveExp    = "exp" // 上側で大きく動く（(10^x-1)/9）
+)
+
+// VolumeFloorDb はフェーダー最下端（CC 値 0）で送る音量です（OBS の下限）。
+const VolumeFloorDb = -100.0
+
+// DefaultControlInterval は送信の最短間隔の既定値です。
+const DefaultControlInterval = 30 * time.Millisecond
+
+// Control は連続値操作 1 件の設定です（Mapping から ControlSpec で作ります）。
+type Control struct {
+	Action    string
+	Input     string // volume の入力名 / filter のソース名
+	Filter    string
+	Setting   string
+	Min, Max  float64
+	Curve     string
+	Smoothing time.Duration
+	Interval  time.Duration
+	Hosts     []string // 送り先のホスト名（空なら全ホスト）
+}
+
+// ControlSpec は Action 付きマッピングを Control に変換します。Action が無ければ nil です。
+func (m Mapping) ControlSpec() (*Control, error) {
+	action := strings.ToLower(strings.TrimSpace(m.Action))
+	if action == "" {
+		return nil, nil
+	}
+	if m.kind() != midi.ControlChange {
+		return nil, fmt.Errorf("action は control_change でのみ使えます: %q", m.Type)
+	}
+	if strings.TrimSpace(m.Scene) != "" {
+		return nil, errors.New("action と scene は同時に指定できません")
+	}
+	c := &Control{Action: action, Curve: strings.ToLower(strings.TrimSpace(m.Curve)), Interval: DefaultControlInterval, Hosts: m.hostNames()}
+	switch action {
+	case ActionVolume:
+		c.Input = strings.TrimSpace(m.Input)
+		if c.Input == "" {
+			return nil, errors.New("volume には input が必要です")
+		}
+		c.Min, c.Max = -60, 0
+	case ActionTBar:
+		c.Min, c.Max = 0, 1
+	case ActionFilter:
+		c.Input, c.Filter, c.Setting = strings.TrimSpace(m.Source), strings.TrimSpace(m.Filter), strings.TrimSpace(m.Setting)
+		if c.Input == "" || c.Filter == "" || c.Setting == "" {
+			return nil, errors.New("filter には source / filter / setting が必要です")
+		}
+		c.Min, c.Max = 0, 1
+	default:
+		return nil, fmt.Errorf("未対応の action です: %q（volume / tbar / filter）", m.Action)
+	}
+	if m.Min != nil {
+		c.Min = *m.Min
+	}
+	if m.Max != nil {
+		c.Max = *m.Max
+	}
+	if action == ActionTBar && (c.Min < 0 || c.Max > 1) {
+		return nil, fmt.Errorf("tbar の min/max は 0..1 で指定してください: %g..%g", c.Min, c.Max)
+	}
+	switch c.Curve {
+	case "":
+		c.Curve = CurveLinear
+	case CurveLinear, CurveLog, CurveExp:
+	default:
+		return nil, fmt.Errorf("未対応の curve です: %q（linear / log / exp）", m.Curve)
+	}
+	if s := strings.TrimSpace(m.Smoothing); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("smoothing が不正です: %q", m.Smoothing)
+		}
+		c.Smoothing = d
+	}
+	if s := strings.TrimSpace(m.Interval); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("interval が不正です: %q", m.Interval)
+		}
+		c.Interval = d
+	}
+	return c, nil
+}
+
+// Target は操作対象を表す文字列です（ログ表示と、同じ対象への送信をまとめるのに使います）。
+func (c *Control) Target() string {
+	switch c.Action {
+	case ActionVolume:
+		return "volume:" + c.Input
+	case ActionFilter:
+		return "filter:" + c.Input + "/" + c.Filter + "/" + c.Setting
+	default:
+		return c.Action
+	}
+}
+
+// Scale は CC 値（0..127）をカーブと min/max で変換します。volume の 0 は VolumeFloorDb です。
+func (c *Control) Scale(raw uint8) float64 {
+	if c.Action == ActionVolume && raw == 0 && c.Min > VolumeFloorDb {
+		return VolumeFloorDb
+	}
+	x := float64(raw&0x7F) / 127
+	switch c.Curve {
+	case CurveLog:
+		x = math.Log10(1 + 9*x)
+	case CurveExp:
+		x = (math.Pow(10, x) - 1) / 9
+	}
+	return c.Min + (c.Max-c.Min)*x
+}
+
+// Controls は Action 付きマッピングを照合キー → Control にまとめます。不正なものは読み捨てます（検証は Validate）。
+func (f *File) Controls() map[string]*Control {
+	out := map[string]*Control{}
+	for _, m := range f.Mappings {
+		c, err := m.ControlSpec()
+		if err != nil || c == nil {
+			continue
+		}
+		key, err := m.Key()
+		if err != nil {
+			continue
+		}
+		out[key] = c
+	}
+	return out
+}
+
+// ControlSender は連続値を OBS に送ります。final は操作の終端（T バーなら離す）を表します。
+type ControlSender func(c *Control, value float64, final bool) error
+
+// ControlDriver は CC の値を目標値として受け取り、スムージングと送信間隔の制限をかけて ControlSender を呼びます。
+// 対象（Control.Target）ごとに最新の値だけを送り、前の送信が終わるまで次は送りません。
+type ControlDriver struct {
+	send  ControlSender
+	onErr func(c *Control, err error)
+
+	mu     sync.Mutex
+	states map[string]*controlState
+}
+
+type controlState struct {
+	c        *Control
+	target   float64
+	current  float64
+	sent     float64
+	hasSent  bool
+	stepAt   time.Time
+	lastSend time.Time
+	inflight bool
+}
+
+// NewControlDriver は send で送信する ControlDriver を作ります。onErr は送信エラーの通知先です（nil 可）。
+func NewControlDriver(send ControlSender, onErr func(c *Control, err error)) *ControlDriver {
+	return &ControlDriver{send: send, onErr: onErr, states: map[string]*controlState{}}
+}
+
+// Set は c の目標値を CC 値 raw にします。初回はスムージングせずにその値から始めます。
+func (d *ControlDriver) Set(c *Control, raw uint8, now time.Time) {
+	v := c.Scale(raw)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st, ok := d.states[c.Target()]
+	if !ok {
+		st = &controlState{current: v, stepAt: now}
+		d.states[c.Target()] = st
+	}
+	st.c = c
+	st.target = v
+}
+
+// Step は now 時点までスムージングを進め、送信が必要な対象を送ります（Run から周期的に呼ばれます）。
+func (d *ControlDriver) Step(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, st := range d.states {
+		c := st.c
+		if c.Smoothing > 0 && st.current != st.target {
+			dt := now.Sub(st.stepAt)
+			alpha := 1 - math.Exp(-float64(dt)/float64(c.Smoothing))
+			st.current += (st.target - st.current) * alpha
+			if math.Abs(st.target-st.current) <= math.Abs(c.Max-c.Min)*0.001 {
+				st.current = st.target
+			}
+		} else {
+			st.current = st.target
+		}
+		st.stepAt = now
+		if st.inflight || (st.hasSent && st.current == st.sent) || now.Sub(st.lastSend) < c.Interval {
+			continue
+		}
+		value := st.current
+		final := value == st.target && c.Action == ActionTBar && (value == c.Min || value == c.Max)
+		st.sent, st.hasSent, st.lastSend, st.inflight = value, true, now, true
+		go d.deliver(st, c, value, final)
+	}
+}
+
+func (d *ControlDriver) deliver(st *controlState, c *Control, value float64, final bool) {
+	err := d.send(c, value, final)
+	d.mu.Lock()
+	st.inflight = false
+	d.mu.Unlock()
+	if err != nil && d.onErr != nil {
+		d.onErr(c, err)
+	}
+}
+
+// Run は ctx が終わるまで tick ごとに Step を呼びます。
+func (d *ControlDriver) Run(ctx context.Context, tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			d.Step(now)
+		}
+	}
+}
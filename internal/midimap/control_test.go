@@ -0,0 +1,161 @@
+package midimap
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+func fptr(v float64) *float64 { return &v }
+
+func TestControlSpecAndScale(t *testing.T) {
+	cc := 7
+	vol, err := Mapping{Type: midi.ControlChange, Channel: 1, Control: &cc, Action: "volume", Input: "Mic"}.ControlSpec()
+	if err != nil || vol == nil {
+		t.Fatalf("volume spec: %v %v", vol, err)
+	}
+	if got := vol.Scale(0); got != VolumeFloorDb {
+		t.Fatalf("volume 0: %g", got)
+	}
+	if got := vol.Scale(127); got != 0 {
+		t.Fatalf("volume 127: %g", got)
+	}
+	if got := vol.Scale(127 / 2); math.Abs(got-(-60+60*63.0/127)) > 1e-9 {
+		t.Fatalf("volume mid: %g", got)
+	}
+
+	filt, err := Mapping{Type: midi.ControlChange, Channel: 1, Control: &cc, Action: "filter", Source: "Cam", Filter: "Color", Setting: "opacity", Max: fptr(100), Curve: "log"}.ControlSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filt.Scale(127); math.Abs(got-100) > 1e-9 || filt.Target() != "filter:Cam/Color/opacity" {
+		t.Fatalf("filter max: %g %s", got, filt.Target())
+	}
+	if lin := 100 * 32.0 / 127; filt.Scale(32) <= lin {
+		t.Fatalf("log curve should rise faster than linear: %g", filt.Scale(32))
+	}
+
+	bad := []struct {
+		m    Mapping
+		want string
+	}{
+		{Mapping{Type: midi.NoteOn, Channel: 1, Note: 36, Action: "tbar"}, "control_change"},
+		{Mapping{Type: midi.ControlChange, Channel: 1, Control: &cc, Action: "tbar", Scene: "X"}, "同時"},
+		{Mapping{Type: midi.ControlChange, Channel: 1, Control: &cc, Action: "volume"}, "input"},
+		{Mapping{Type: midi.ControlChange, Channel: 1, Control: &cc, Action: "tbar", Max: fptr(2)}, "0..1"},
+		{Mapping{Type: midi.ControlChange, Channel: 1, Control: &cc, Action: "tbar", Curve: "s"}, "curve"},
+		{Mapping{Type: midi.ControlChange, Channel: 1, Control: &cc, Action: "pan"}, "action"},
+	}
+	for _, b := range bad {
+		if _, err := b.m.ControlSpec(); err == nil || !strings.Contains(err.Error(), b.want) {
+			t.Fatalf("%+v: want error containing %q, got %v", b.m, b.want, err)
+		}
+	}
+}
+
+func TestLookupControlBypassesDebounce(t *testing.T) {
+	cc := 8
+	f := File{Mappings: []Mapping{
+		{Type: midi.ControlChange, Channel: 1, Control: &cc, Action: "tbar"},
+		{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "A"},
+	}}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("action mapping without scene should be valid: %v", err)
+	}
+	e := NewEvaluator(&Rules{Debounce: time.Second, Scenes: map[string]string{"1:36": "A"}, Controls: f.Controls()})
+	now := time.Unix(0, 0)
+	if res := e.Evaluate(midi.Event{Type: midi.NoteOn, Channel: 1, Data1: 36, Data2: 100}, now); !res.Fire() {
+		t.Fatalf("note should fire: %+v", res)
+	}
+	for _, v := range []uint8{0, 64} {
+		res := e.Evaluate(midi.Event{Type: midi.ControlChange, Channel: 1, Data1: 8, Data2: v}, now)
+		if res.Control == nil || res.Value != v || res.Skip != "" || res.Fire() {
+			t.Fatalf("cc %d should pass to control: %+v", v, res)
+		}
+	}
+}
+
+func waitIdle(t *testing.T, d *ControlDriver) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		busy := false
+		for _, st := range d.states {
+			busy = busy || st.inflight
+		}
+		d.mu.Unlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("send did not finish")
+}
+
+type sent struct {
+	v     float64
+	final bool
+}
+
+func TestControlDriverCoalescesAndSmooths(t *testing.T) {
+	ch := make(chan sent, 16)
+	d := NewControlDriver(func(c *Control, v float64, final bool) error {
+		ch <- sent{v, final}
+		return nil
+	}, nil)
+	recv := func() []sent {
+		var out []sent
+		for {
+			select {
+			case s := <-ch:
+				out = append(out, s)
+			default:
+				return out
+			}
+		}
+	}
+
+	tbar := &Control{Action: ActionTBar, Min: 0, Max: 1, Curve: CurveLinear, Interval: 30 * time.Millisecond}
+	t0 := time.Unix(100, 0)
+	// 間隔内の複数の値は最新だけ送る
+	d.Set(tbar, 10, t0)
+	d.Set(tbar, 20, t0)
+	d.Step(t0)
+	waitIdle(t, d)
+	d.Set(tbar, 30, t0.Add(10*time.Millisecond))
+	d.Set(tbar, 127, t0.Add(15*time.Millisecond))
+	d.Step(t0.Add(20 * time.Millisecond))
+	if got := recv(); len(got) != 1 || got[0].v != 20.0/127 || got[0].final {
+		t.Fatalf("first send: %+v", got)
+	}
+	d.Step(t0.Add(40 * time.Millisecond))
+	waitIdle(t, d)
+	if got := recv(); len(got) != 1 || got[0].v != 1 || !got[0].final {
+		t.Fatalf("end of travel should release: %+v", got)
+	}
+	d.Step(t0.Add(80 * time.Millisecond))
+	waitIdle(t, d)
+	if got := recv(); len(got) != 0 {
+		t.Fatalf("unchanged value should not be resent: %+v", got)
+	}
+
+	// スムージング: 時定数 100ms なら 100ms 後に約 63% 進む
+	vol := &Control{Action: ActionVolume, Input: "Mic", Min: -60, Max: 0, Curve: CurveLinear, Smoothing: 100 * time.Millisecond, Interval: time.Millisecond}
+	d.Set(vol, 1, t0)
+	d.Step(t0)
+	waitIdle(t, d)
+	recv()
+	d.Set(vol, 127, t0)
+	d.Step(t0.Add(100 * time.Millisecond))
+	waitIdle(t, d)
+	got := recv()
+	start := vol.Scale(1)
+	want := start + (0-start)*(1-math.Exp(-1))
+	if len(got) != 1 || math.Abs(got[0].v-want) > 1e-6 {
+		t.Fatalf("smoothed value: %+v want %g", got, want)
+	}
+}
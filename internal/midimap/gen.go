@@ -0,0 +1,148 @@
+package midimap
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"awesomeProject/internal/midi"
+)
+
+// GenOptions は Generate の割り当て規則です（obsctl midi gen-json 用）。
+type GenOptions struct {
+	Type       midi.Type      // NoteOn（既定）/ ProgramChange / ControlChange
+	Channels   []int          // 割り当てに使うチャネル。先頭から順に使い切る（空なら 1）
+	Start      int            // 各チャネルで最初に使う番号（0-127）
+	Filter     *regexp.Regexp // 一致したシーンだけ割り当てる（nil なら全て）
+	FromPrefix bool           // "012_オープニング" のような数字の接頭辞を番号にする（先頭のチャネル）
+	Transition string         // 追加したマッピングの transition（指定すると apply_transitions も有効にする）
+}
+
+// GenResult は Generate の結果です。
+type GenResult struct {
+	Added    []Mapping // 新しく割り当てたマッピング（scenes の順）
+	Kept     []string  // 既に割り当て済みで変更しなかったシーン
+	Filtered []string  // Filter に一致しなかったシーン
+	Fallback []string  // 接頭辞の番号が使えず（範囲外・使用済み）連番で割り当てたシーン
+	Skipped  []string  // 空き番号が無く割り当てられなかったシーン
+}
+
+// scenePrefix は "012_Name" / "12 Name" / "12-Name" の先頭の数字を返します。
+var scenePrefix = regexp.MustCompile(`^(\d+)[_\- ]`)
+
+// Generate は scenes のうち f にまだ無いシーンへ空いている番号を割り当て、f.Mappings の末尾に追加します。
+// 既存のマッピング（手で編集したものを含む）と、banks の切替トリガが使う番号はそのまま残します。
+func (f *File) Generate(scenes []string, opts GenOptions) GenResult {
+	if opts.Type == "" {
+		opts.Type = midi.NoteOn
+	}
+	if len(opts.Channels) == 0 {
+		opts.Channels = []int{1}
+	}
+	var res GenResult
+	used := map[string]bool{}
+	assigned := map[string]bool{}
+	for _, m := range f.Mappings {
+		if key, err := m.Key(); err == nil {
+			used[key] = true
+		}
+		if s := strings.TrimSpace(m.Scene); s != "" {
+			assigned[s] = true
+		}
+	}
+	for _, b := range f.Banks {
+		for _, t := range []*Mapping{b.Select, b.Shift} {
+			if t == nil {
+				continue
+			}
+			if key, err := t.Key(); err == nil {
+				used[key] = true
+			}
+		}
+		for _, m := range b.Mappings {
+			if s := strings.TrimSpace(m.Scene); s != "" {
+				assigned[s] = true
+			}
+		}
+	}
+
+	// 対象のシーンを決め、接頭辞の番号を先に確保する（連番がその番号を使わないように）
+	var targets []string
+	claims := map[string]int{}
+	for _, s := range scenes {
+		switch {
+		case assigned[s]:
+			res.Kept = append(res.Kept, s)
+			continue
+		case opts.Filter != nil && !opts.Filter.MatchString(s):
+			res.Filtered = append(res.Filtered, s)
+			continue
+		}
+		assigned[s] = true
+		targets = append(targets, s)
+		if !opts.FromPrefix {
+			continue
+		}
+		n := -1
+		if sm := scenePrefix.FindStringSubmatch(s); sm != nil {
+			if v, err := strconv.Atoi(sm[1]); err == nil && v <= 127 {
+				n = v
+			}
+		}
+		if key := TriggerKey(opts.Type, opts.Channels[0], n); n >= 0 && !used[key] {
+			used[key] = true
+			claims[s] = n
+		} else if scenePrefix.MatchString(s) {
+			res.Fallback = append(res.Fallback, s)
+		}
+	}
+
+	ci, next := 0, opts.Start
+	for _, s := range targets {
+		m := Mapping{Type: opts.Type, Scene: s, Transition: opts.Transition}
+		if n, ok := claims[s]; ok {
+			m.Channel = opts.Channels[0]
+			m.setNumber(n)
+			res.Added = append(res.Added, m)
+			continue
+		}
+		found := false
+		for ci < len(opts.Channels) && !found {
+			for ; next <= 127; next++ {
+				if key := TriggerKey(opts.Type, opts.Channels[ci], next); !used[key] {
+					used[key] = true
+					m.Channel = opts.Channels[ci]
+					m.setNumber(next)
+					found = true
+					next++
+					break
+				}
+			}
+			if !found {
+				ci, next = ci+1, opts.Start
+			}
+		}
+		if !found {
+			res.Skipped = append(res.Skipped, s)
+			continue
+		}
+		res.Added = append(res.Added, m)
+	}
+	f.Mappings = append(f.Mappings, res.Added...)
+	if len(res.Added) > 0 && strings.TrimSpace(opts.Transition) != "" {
+		f.ApplyTransitions = true
+	}
+	return res
+}
+
+// setNumber は kind に応じて note / control / program を設定します。
+func (m *Mapping) setNumber(n int) {
+	switch m.kind() {
+	case midi.ControlChange:
+		m.Control = &n
+	case midi.ProgramChange:
+		m.Program = &n
+	default:
+		m.Note = n
+	}
+}
@@ -0,0 +1,67 @@
+package midimap
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"awesomeProject/internal/midi"
+)
+
+func TestGenerateMergesAndAllocates(t *testing.T) {
+	f := &File{Mappings: []Mapping{
+		{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "手動"},
+		{Type: midi.NoteOn, Channel: 1, Note: 38, Scene: "010_Open"},
+	}}
+	res := f.Generate([]string{"010_Open", "Intro", "012_Talk", "Main", "999_Out", "_tmp"}, GenOptions{
+		Start:      36,
+		Filter:     regexp.MustCompile(`^[^_]`),
+		FromPrefix: true,
+		Transition: "fade",
+	})
+	got := []string{}
+	for _, m := range res.Added {
+		key, err := m.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, key+"="+m.Scene)
+	}
+	// 36 / 38 は既存、12 は接頭辞、999 は範囲外なので連番
+	want := "1:37=Intro 1:12=012_Talk 1:39=Main 1:40=999_Out"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("added: want %s, got %s", want, strings.Join(got, " "))
+	}
+	if len(res.Kept) != 1 || res.Kept[0] != "010_Open" {
+		t.Fatalf("kept: %v", res.Kept)
+	}
+	if len(res.Filtered) != 1 || res.Filtered[0] != "_tmp" {
+		t.Fatalf("filtered: %v", res.Filtered)
+	}
+	if len(res.Fallback) != 1 || res.Fallback[0] != "999_Out" {
+		t.Fatalf("fallback: %v", res.Fallback)
+	}
+	if len(f.Mappings) != 6 || f.Mappings[0].Scene != "手動" || f.Mappings[2].Transition != "fade" || !f.ApplyTransitions {
+		t.Fatalf("mappings: %+v", f.Mappings)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestGenerateProgramChangeAcrossChannels(t *testing.T) {
+	f := &File{}
+	res := f.Generate([]string{"A", "B", "C", "D"}, GenOptions{Type: midi.ProgramChange, Channels: []int{2, 3}, Start: 126})
+	got := []string{}
+	for _, m := range res.Added {
+		key, _ := m.Key()
+		got = append(got, key)
+	}
+	if want := "2:pc126 2:pc127 3:pc126 3:pc127"; strings.Join(got, " ") != want {
+		t.Fatalf("want %s, got %s", want, strings.Join(got, " "))
+	}
+	res = f.Generate([]string{"E"}, GenOptions{Type: midi.ProgramChange, Channels: []int{2, 3}, Start: 126})
+	if len(res.Added) != 0 || len(res.Skipped) != 1 {
+		t.Fatalf("want skipped when full: %+v", res)
+	}
+}
@@ -0,0 +1,149 @@
+package midimap
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Host は hosts の要素（切替先の OBS）です。hosts があれば obsctl midi は -addrs / -passwords の代わりに使います。
+type Host struct {
+	Name        string `json:"name"`
+	Addr        string `json:"addr"`
+	Password    string `json:"password,omitempty"`
+	PasswordEnv string `json:"password_env,omitempty"` // パスワードを読む環境変数名（設定されていれば password より優先）
+}
+
+// Secret は接続に使うパスワードを返します。
+func (h Host) Secret() string {
+	if env := strings.TrimSpace(h.PasswordEnv); env != "" {
+		if v, ok := os.LookupEnv(env); ok {
+			return v
+		}
+	}
+	return h.Password
+}
+
+// HostAddrs は hosts のアドレスとパスワードを同じ順で返します（Trigger / Pool にそのまま渡せる形）。
+func (f *File) HostAddrs() (addrs, passwords []string) {
+	for _, h := range f.Hosts {
+		addrs = append(addrs, strings.TrimSpace(h.Addr))
+		passwords = append(passwords, h.Secret())
+	}
+	return addrs, passwords
+}
+
+// hostNames は mapping の hosts を正規化します（空要素は除きます）。
+func (m Mapping) hostNames() []string {
+	var out []string
+	for _, h := range m.Hosts {
+		if h = strings.TrimSpace(h); h != "" {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// Route は発火時の送り先とトランジションです（照合キーごと）。
+type Route struct {
+	Hosts      []string // 送り先のホスト名（空なら全ホスト）
+	Transition string   // fade / cut / OBS のトランジション名（空なら OBS の現在のトランジション）
+}
+
+// route は hosts / transition を指定したマッピングの Route を返します。transition は withTransition のときだけ使います。
+func (m Mapping) route(withTransition bool) (Route, bool) {
+	r := Route{Hosts: m.hostNames()}
+	if withTransition {
+		r.Transition = strings.TrimSpace(m.Transition)
+	}
+	return r, len(r.Hosts) > 0 || r.Transition != ""
+}
+
+// Routes は hosts / transition を指定したマッピングを照合キー → Route にまとめます。不正なものは読み捨てます。
+// transition は apply_transitions が true のときだけ含めます。
+func (f *File) Routes() map[string]Route {
+	out := map[string]Route{}
+	for _, m := range f.Mappings {
+		key, err := m.Key()
+		if err != nil {
+			continue
+		}
+		if r, ok := m.route(f.ApplyTransitions); ok {
+			out[key] = r
+		}
+	}
+	return out
+}
+
+// validateHosts は hosts と、各マッピングの hosts が定義済みのホストを指しているかを検証します。
+func (f *File) validateHosts() []error {
+	var errs []error
+	names := map[string]int{}
+	for i, h := range f.Hosts {
+		name := strings.TrimSpace(h.Name)
+		switch {
+		case name == "":
+			errs = append(errs, fmt.Errorf("hosts[%d]: name が空です", i))
+		default:
+			if j, dup := names[name]; dup {
+				errs = append(errs, fmt.Errorf("hosts[%d]: name %q は hosts[%d] と重複しています", i, name, j))
+			}
+			names[name] = i
+		}
+		if strings.TrimSpace(h.Addr) == "" {
+			errs = append(errs, fmt.Errorf("hosts[%d] (%s): addr が空です", i, name))
+		}
+	}
+	check := func(prefix string, ms []Mapping) {
+		for i, m := range ms {
+			hosts := m.hostNames()
+			if len(hosts) == 0 {
+				continue
+			}
+			label := fmt.Sprintf("%smappings[%d]", prefix, i)
+			if key, err := m.Key(); err == nil {
+				label += " (" + key + ")"
+			}
+			if len(f.Hosts) == 0 {
+				errs = append(errs, fmt.Errorf("%s: hosts を使うにはトップレベルの hosts を定義してください", label))
+				continue
+			}
+			for _, h := range hosts {
+				if _, ok := names[h]; !ok {
+					errs = append(errs, fmt.Errorf("%s: 未定義のホストです: %q", label, h))
+				}
+			}
+		}
+	}
+	check("", f.Mappings)
+	for i, in := range f.Inputs {
+		check(fmt.Sprintf("inputs[%d].", i), in.Mappings)
+	}
+	for i, b := range f.Banks {
+		check(fmt.Sprintf("banks[%d].", i), b.Mappings)
+	}
+	return errs
+}
+
+// IgnoredTransitions は apply_transitions が無いために無視する transition を指定したマッピングの数を返します。
+func (f *File) IgnoredTransitions() int {
+	if f.ApplyTransitions {
+		return 0
+	}
+	n := 0
+	count := func(ms []Mapping) {
+		for _, m := range ms {
+			if strings.TrimSpace(m.Transition) != "" {
+				n++
+			}
+		}
+	}
+	count(f.Mappings)
+	for _, in := range f.Inputs {
+		count(in.Mappings)
+	}
+	for _, b := range f.Banks {
+		count(b.Mappings)
+	}
+	return n
+}
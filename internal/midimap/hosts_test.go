@@ -0,0 +1,88 @@
+package midimap
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+func TestHostsRoutesAndValidate(t *testing.T) {
+	const src = `{
+  "apply_transitions": true,
+  "hosts": [
+    {"name": "main", "addr": "10.0.0.1:4455", "password": "secret"},
+    {"name": "rec", "addr": "10.0.0.2:4455"}
+  ],
+  "mappings": [
+    {"type": "note_on", "channel": 1, "note": 36, "scene": "Cam", "hosts": ["rec"], "transition": "cut"},
+    {"type": "note_on", "channel": 1, "note": 37, "scene": "Wide"},
+    {"type": "control_change", "channel": 1, "control": 7, "action": "volume", "input": "Mic", "hosts": ["main"]}
+  ]
+}`
+	var f File
+	if err := json.Unmarshal([]byte(src), &f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	addrs, pws := f.HostAddrs()
+	if strings.Join(addrs, ",") != "10.0.0.1:4455,10.0.0.2:4455" || pws[0] != "secret" || pws[1] != "" {
+		t.Fatalf("HostAddrs: %v %v", addrs, pws)
+	}
+	r := &Rules{Scenes: map[string]string{"1:36": "Cam", "1:37": "Wide"}, Controls: f.Controls(), Routes: f.Routes()}
+	e := NewEvaluator(r)
+	res := e.Evaluate(midi.Event{Type: midi.NoteOn, Channel: 1, Data1: 36, Data2: 100}, time.Unix(1, 0))
+	if !res.Fire() || strings.Join(res.Route.Hosts, ",") != "rec" || res.Route.Transition != "cut" {
+		t.Fatalf("route for 1:36: %+v", res)
+	}
+	res = e.Evaluate(midi.Event{Type: midi.NoteOn, Channel: 1, Data1: 37, Data2: 100}, time.Unix(2, 0))
+	if !res.Fire() || len(res.Route.Hosts) != 0 || res.Route.Transition != "" {
+		t.Fatalf("route for 1:37: %+v", res)
+	}
+	res = e.Evaluate(midi.Event{Type: midi.ControlChange, Channel: 1, Data1: 7, Data2: 64}, time.Unix(3, 0))
+	if res.Control == nil || strings.Join(res.Control.Hosts, ",") != "main" {
+		t.Fatalf("control hosts: %+v", res)
+	}
+
+	// apply_transitions が無ければ transition は無視する（hosts は使う）
+	f.ApplyTransitions = false
+	if n := f.IgnoredTransitions(); n != 1 {
+		t.Fatalf("IgnoredTransitions: %d", n)
+	}
+	if r := f.Routes()["1:36"]; strings.Join(r.Hosts, ",") != "rec" || r.Transition != "" {
+		t.Fatalf("route without apply_transitions: %+v", r)
+	}
+}
+
+func TestValidateHosts(t *testing.T) {
+	f := File{
+		Hosts: []Host{{Name: "a", Addr: "x:4455"}, {Name: "a"}, {Addr: "y:4455"}},
+		Mappings: []Mapping{
+			{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "S", Hosts: []string{"a", "b"}},
+		},
+		Inputs: []InputConfig{{Device: "Foot", Mappings: []Mapping{{Type: midi.NoteOn, Channel: 2, Note: 1, Scene: "S", Hosts: []string{"c"}}}}},
+	}
+	err := f.Validate()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{
+		`hosts[1]: name "a" は hosts[0] と重複`,
+		"hosts[1] (a): addr が空",
+		"hosts[2]: name が空",
+		`mappings[0] (1:36): 未定義のホストです: "b"`,
+		`inputs[0].mappings[0] (2:1): 未定義のホストです: "c"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+	noHosts := File{Mappings: []Mapping{{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "S", Hosts: []string{"a"}}}}
+	if err := noHosts.Validate(); err == nil || !strings.Contains(err.Error(), "トップレベルの hosts を定義") {
+		t.Fatalf("want hosts required error, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package midimap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InputConfig は inputs の要素（追加の MIDI 入力デバイスとそのチャネル・マッピング）です。
+// debounce / rate_limit / msc_device_id はトップレベルの値を引き継ぎます。
+type InputConfig struct {
+	Device   string    `json:"device"`
+	Channel  int       `json:"channel,omitempty"`
+	Mappings []Mapping `json:"mappings"`
+}
+
+// DeviceFiles はデバイスごとの設定を返します。先頭はトップレベル（device / channel / mappings）、
+// 続いて inputs の順です。各要素は Device と Mappings 以外をトップレベルから引き継ぎます。
+func (f *File) DeviceFiles() []*File {
+	out := []*File{f}
+	for _, in := range f.Inputs {
+		out = append(out, f.inputFile(in))
+	}
+	return out
+}
+
+func (f *File) inputFile(in InputConfig) *File {
+	return &File{
+		Device:    strings.TrimSpace(in.Device),
+		Channel:   in.Channel,
+		Debounce:  f.Debounce,
+		RateLimit: f.RateLimit,
+		MSCDevice: f.MSCDevice,
+		Mappings:  in.Mappings,
+
+		ApplyTransitions: f.ApplyTransitions,
+	}
+}
+
+// validateInputs は inputs を検証します（Validate から呼ばれます）。
+func (f *File) validateInputs() []error {
+	var errs []error
+	seen := map[string]string{}
+	if d := strings.TrimSpace(f.Device); d != "" {
+		seen[d] = "device"
+	}
+	for i, in := range f.Inputs {
+		prefix := fmt.Sprintf("inputs[%d]", i)
+		d := strings.TrimSpace(in.Device)
+		if d == "" {
+			errs = append(errs, fmt.Errorf("%s: device が空です", prefix))
+		} else if prev, dup := seen[d]; dup {
+			errs = append(errs, fmt.Errorf("%s: device %q は %s と重複しています", prefix, d, prev))
+		} else {
+			seen[d] = prefix
+		}
+		sub := f.inputFile(in)
+		if sub.Channel < 0 || sub.Channel > 16 {
+			errs = append(errs, fmt.Errorf("%s: channel は 1..16 を指定してください: %d", prefix, sub.Channel))
+		}
+		for _, err := range sub.validateMappings() {
+			errs = append(errs, fmt.Errorf("%s.%w", prefix, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,59 @@
+package midimap
+
+import (
+	"strings"
+	"testing"
+
+	"awesomeProject/internal/midi"
+)
+
+func TestDeviceFilesInheritTopLevel(t *testing.T) {
+	id, cc := 3, 64
+	f := &File{
+		Device:    "Pad",
+		Channel:   10,
+		Debounce:  "50ms",
+		MSCDevice: &id,
+		Mappings:  []Mapping{{Type: midi.NoteOn, Channel: 10, Note: 36, Scene: "A"}},
+		Inputs: []InputConfig{
+			{Device: " Foot ", Channel: 1, Mappings: []Mapping{{Type: midi.ControlChange, Channel: 1, Control: &cc, Scene: "B"}}},
+		},
+	}
+	files := f.DeviceFiles()
+	if len(files) != 2 || files[0] != f {
+		t.Fatalf("unexpected device files: %+v", files)
+	}
+	foot := files[1]
+	if foot.Device != "Foot" || foot.Channel != 1 || foot.Debounce != "50ms" || foot.MSCDevice != &id || len(foot.Mappings) != 1 {
+		t.Fatalf("input should inherit top-level settings: %+v", foot)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateInputsPointsAtMapping(t *testing.T) {
+	f := &File{
+		Device: "Pad",
+		Inputs: []InputConfig{
+			{Device: "Foot", Mappings: []Mapping{
+				{Type: midi.NoteOn, Channel: 1, Note: 1, Scene: "A"},
+				{Type: midi.NoteOn, Channel: 1, Note: 2},
+			}},
+			{Device: "Pad", Channel: 17},
+			{},
+		},
+	}
+	err := f.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"inputs[0].mappings[1]", "inputs[1]: device \"Pad\"", "inputs[1]: channel", "inputs[2]: device が空"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error should mention %s: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "inputs[0].mappings[0]") {
+		t.Fatalf("valid mapping reported: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package midimap
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+// ErrInputClosed は Learn 中に MIDI 入力のチャネルが閉じられたことを表します。
+var ErrInputClosed = errors.New("MIDI入力が閉じられました")
+
+// Learn は events から次のトリガ（NoteOn / CC / ProgramChange）を待ち、シーン未設定の Mapping を返します。
+// since より前に発生したイベント（待機前に溜まっていた入力）と、channels に含まれないチャネルは読み捨てます。
+// channels が空なら全チャネルを受け付けます。
+func Learn(ctx context.Context, events <-chan midi.Event, since time.Time, channels []int) (Mapping, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return Mapping{}, ctx.Err()
+		case ev, ok := <-events:
+			if !ok {
+				return Mapping{}, ErrInputClosed
+			}
+			if !ev.Time.IsZero() && ev.Time.Before(since) {
+				continue
+			}
+			if !channelAllowed(channels, ev) {
+				continue
+			}
+			if m, ok := FromEvent(ev); ok {
+				return m, nil
+			}
+		}
+	}
+}
+
+func hasChannel(list []int, ch int) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, x := range list {
+		if x == ch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,389 @@
+// Package midimap は MIDI イベントと OBS シーンの対応表（マッピング）を扱います。
+// CLI の JSON 設定（obsctl midi -config）と GUI 設定の "ch:note=Scene" 形式の両方をここで解釈します。
+package midimap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"awesomeProject/internal/midi"
+)
+
+// Mapping は JSON 設定の mappings 要素（1 トリガ → 1 シーン）です。
+// type に応じて note（note_on）/ control（control_change）/ program（program_change）のいずれかを使います。
+// type が msc（MIDI Show Control）のときは channel を使わず、command（既定 go）/ cue / cue_list で照合します。
+type Mapping struct {
+	Type       midi.Type `json:"type"`
+	Channel    int       `json:"channel,omitempty"`
+	Note       int       `json:"note,omitempty"`
+	Control    *int      `json:"control,omitempty"`
+	Program    *int      `json:"program,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	Cue        string    `json:"cue,omitempty"`
+	CueList    string    `json:"cue_list,omitempty"`
+	Scene      string    `json:"scene"`
+	Transition string    `json:"transition,omitempty"` // fade / cut / OBS のトランジション名（切替の直前に設定）
+	// Hosts は切替・操作を送るホスト名（トップレベルの hosts の name）。未指定なら全ホストです。
+	Hosts []string `json:"hosts,omitempty"`
+	// Mode は trigger（既定）/ momentary / toggle / latch。AltScene は momentary で離したとき・toggle の 2 回目に切り替えるシーンです。
+	Mode     string `json:"mode,omitempty"`
+	AltScene string `json:"alt_scene,omitempty"`
+
+	// Action を指定した control_change はシーン切替ではなく連続値の操作になります（scene は不要）。
+	// volume は input の音量、tbar はスタジオモードの T バー、filter は source のフィルタ filter の setting を動かします。
+	Action    string   `json:"action,omitempty"`
+	Input     string   `json:"input,omitempty"`
+	Source    string   `json:"source,omitempty"`
+	Filter    string   `json:"filter,omitempty"`
+	Setting   string   `json:"setting,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Curve     string   `json:"curve,omitempty"`     // linear（既定）/ log / exp
+	Smoothing string   `json:"smoothing,omitempty"` // 目標値へ追従する時定数（例: 80ms）。未指定は即時
+	Interval  string   `json:"interval,omitempty"`  // 送信の最短間隔（既定 30ms）
+}
+
+// File は obsctl midi の JSON 設定ファイル全体です。
+type File struct {
+	Device    string `json:"device,omitempty"`
+	Channel   int    `json:"channel,omitempty"`
+	Debounce  string `json:"debounce,omitempty"`
+	RateLimit string `json:"rate_limit,omitempty"`
+	// MSCDevice は受け付ける MSC の device ID（0-126）。未指定なら全て。全体宛て（127）は常に受け付けます。
+	MSCDevice *int      `json:"msc_device_id,omitempty"`
+	Mappings  []Mapping `json:"mappings"`
+	// FrameRate と TimecodeCues は obsctl midi chase（MTC 追従）用です。frame_rate 未指定なら受信した MTC のレートを使います。
+	FrameRate    string        `json:"frame_rate,omitempty"`
+	TimecodeCues []TimecodeCue `json:"timecode_cues,omitempty"`
+	// Inputs は同時に開く追加の入力デバイスです（device / channel / mappings はトップレベルが 1 台目）。
+	Inputs []InputConfig `json:"inputs,omitempty"`
+	// Banks は切り替えて使うマッピングのページです（先頭が起動時のバンク）。トップレベルの device だけに適用します。
+	// FeedbackDevice を指定すると、有効なバンクの select トリガを MIDI 出力で点灯（127）・他を消灯（0）します。
+	Banks          []Bank `json:"banks,omitempty"`
+	FeedbackDevice string `json:"feedback_device,omitempty"`
+	// Hosts は切替先の OBS です。指定すると obsctl midi は -addrs / -passwords（未指定時）の代わりに使います。
+	Hosts []Host `json:"hosts,omitempty"`
+	// ApplyTransitions を true にすると、マッピングの transition を切替に使います。
+	// 以前の gen-json は全マッピングに "transition": "fade" を書き出していたため、未指定なら transition は無視します。
+	ApplyTransitions bool `json:"apply_transitions,omitempty"`
+}
+
+// TriggerKey はトリガの照合キーを返します。
+// NoteOn は従来どおり "ch:note"、CC は "ch:cc番号"、ProgramChange は "ch:pc番号" です。
+func TriggerKey(t midi.Type, channel, number int) string {
+	switch t {
+	case midi.ControlChange:
+		return fmt.Sprintf("%d:cc%d", channel, number)
+	case midi.ProgramChange:
+		return fmt.Sprintf("%d:pc%d", channel, number)
+	default:
+		return fmt.Sprintf("%d:%d", channel, number)
+	}
+}
+
+// MSCKey は MIDI Show Control の照合キーを返します。
+// 例: "msc:go:5"、キューリスト付きは "msc:go:5/2"、キュー番号なしは "msc:go"。
+func MSCKey(cmd midi.MSCCommand, cue, list string) string {
+	k := "msc:" + string(cmd)
+	if cue == "" {
+		return k
+	}
+	k += ":" + cue
+	if list != "" {
+		k += "/" + list
+	}
+	return k
+}
+
+// EventKeys は受信イベントの照合キーを優先順に返します。トリガでなければ nil です。
+// MSC のキューリスト付きは "msc:go:5/2" → "msc:go:5"（リスト不問）の順に照合します。
+func EventKeys(ev midi.Event) []string {
+	switch ev.Type {
+	case midi.NoteOn, midi.ProgramChange:
+	case midi.ControlChange:
+		if ev.Data2 == 0 {
+			return nil
+		}
+	case midi.ShowControl:
+		if ev.MSC == nil {
+			return nil
+		}
+		m := ev.MSC
+		if m.List != "" && m.Cue != "" {
+			return []string{MSCKey(m.Command, m.Cue, m.List), MSCKey(m.Command, m.Cue, "")}
+		}
+		return []string{MSCKey(m.Command, m.Cue, "")}
+	default:
+		return nil
+	}
+	return []string{TriggerKey(ev.Type, int(ev.Channel), int(ev.Data1))}
+}
+
+// EventKey は受信イベントの最も具体的な照合キーを返します。
+// NoteOn と ProgramChange は常に、CC は値が 0 より大きい（押下）ときだけ、MSC は対応コマンドのときトリガとして扱います。
+func EventKey(ev midi.Event) (string, bool) {
+	keys := EventKeys(ev)
+	if len(keys) == 0 {
+		return "", false
+	}
+	return keys[0], true
+}
+
+// channelAllowed はチャネルフィルタを通るかを返します。MSC（SysEx）はチャネルを持たないため常に通します。
+func channelAllowed(channels []int, ev midi.Event) bool {
+	return ev.Type == midi.ShowControl || hasChannel(channels, int(ev.Channel))
+}
+
+// FromEvent は受信イベントからシーン未設定の Mapping を作ります（MIDI Learn 用）。
+func FromEvent(ev midi.Event) (Mapping, bool) {
+	if _, ok := EventKey(ev); !ok {
+		return Mapping{}, false
+	}
+	if ev.Type == midi.ShowControl {
+		return Mapping{Type: midi.ShowControl, Command: string(ev.MSC.Command), Cue: ev.MSC.Cue, CueList: ev.MSC.List}, true
+	}
+	m := Mapping{Type: ev.Type, Channel: int(ev.Channel)}
+	n := int(ev.Data1)
+	switch ev.Type {
+	case midi.ControlChange:
+		m.Control = &n
+	case midi.ProgramChange:
+		m.Program = &n
+	default:
+		m.Note = n
+	}
+	return m, true
+}
+
+// kind は大文字小文字や前後の空白を無視した type を返します。
+func (m Mapping) kind() midi.Type {
+	return midi.Type(strings.ToLower(strings.TrimSpace(string(m.Type))))
+}
+
+// Number は type に応じたノート/CC/プログラム番号を返します。未指定なら false。
+func (m Mapping) Number() (int, bool) {
+	switch m.kind() {
+	case midi.NoteOn:
+		return m.Note, true
+	case midi.ControlChange:
+		if m.Control == nil {
+			return 0, false
+		}
+		return *m.Control, true
+	case midi.ProgramChange:
+		if m.Program == nil {
+			return 0, false
+		}
+		return *m.Program, true
+	default:
+		return 0, false
+	}
+}
+
+// mscCommand は command を正規化します（未指定は go）。
+func (m Mapping) mscCommand() midi.MSCCommand {
+	c := strings.ToLower(strings.TrimSpace(m.Command))
+	if c == "" {
+		return midi.MSCGo
+	}
+	return midi.MSCCommand(c)
+}
+
+// Key は検証済みの照合キーを返します。種別・チャネル・番号のいずれかが不正ならエラーです。
+func (m Mapping) Key() (string, error) {
+	switch m.kind() {
+	case midi.ShowControl:
+		switch cmd := m.mscCommand(); cmd {
+		case midi.MSCGo, midi.MSCStop, midi.MSCResume, midi.MSCLoad, midi.MSCAllOff, midi.MSCReset:
+		default:
+			return "", fmt.Errorf("未対応の MSC command です: %q", m.Command)
+		}
+		cue, list := strings.TrimSpace(m.Cue), strings.TrimSpace(m.CueList)
+		if !validCue(cue) || !validCue(list) {
+			return "", fmt.Errorf("cue / cue_list は数字と '.' で指定してください: %q / %q", m.Cue, m.CueList)
+		}
+		if cue == "" && list != "" {
+			return "", errors.New("cue_list を指定する場合は cue も必要です")
+		}
+		return MSCKey(m.mscCommand(), cue, list), nil
+	case midi.NoteOn, midi.ControlChange, midi.ProgramChange:
+	default:
+		return "", fmt.Errorf("未対応の type です: %q", m.Type)
+	}
+	if m.Channel < 1 || m.Channel > 16 {
+		return "", fmt.Errorf("channel は 1..16 を指定してください: %d", m.Channel)
+	}
+	n, ok := m.Number()
+	if !ok {
+		return "", fmt.Errorf("%s には番号（control/program）が必要です", m.Type)
+	}
+	if n < 0 || n > 127 {
+		return "", fmt.Errorf("番号は 0..127 を指定してください: %d", n)
+	}
+	return TriggerKey(m.kind(), m.Channel, n), nil
+}
+
+func validCue(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// Line は GUI 設定で使う "ch:note=Scene" 形式の文字列を返します。
+func (m Mapping) Line() string {
+	if m.kind() == midi.ShowControl {
+		return MSCKey(m.mscCommand(), strings.TrimSpace(m.Cue), strings.TrimSpace(m.CueList)) + "=" + m.Scene + m.lineSuffix()
+	}
+	n, _ := m.Number()
+	return TriggerKey(m.kind(), m.Channel, n) + "=" + m.Scene + m.lineSuffix()
+}
+
+// ParseLine は "ch:note=Scene"（CC は "ch:cc64=Scene"、ProgramChange は "ch:pc5=Scene"、
+// MSC は "msc:go:5=Scene" / "msc:go:5/2=Scene"）を解析します。
+// モードは "ch:note=Scene|momentary"、"ch:note=SceneA|toggle|SceneB" のように '|' で続けます。
+func ParseLine(s string) (Mapping, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return Mapping{}, fmt.Errorf("'=' がありません: %q", s)
+	}
+	left := strings.TrimSpace(parts[0])
+	right := strings.Split(parts[1], "|")
+	scene := strings.TrimSpace(right[0])
+	if scene == "" {
+		return Mapping{}, fmt.Errorf("シーン名が空です: %q", s)
+	}
+	if len(right) > 3 {
+		return Mapping{}, fmt.Errorf("'Scene|mode|alt' 形式ではありません: %q", s)
+	}
+	var mode, alt string
+	if len(right) > 1 {
+		mode = strings.TrimSpace(right[1])
+	}
+	if len(right) > 2 {
+		alt = strings.TrimSpace(right[2])
+	}
+	if rest, ok := strings.CutPrefix(strings.ToLower(left), "msc:"); ok {
+		m := Mapping{Type: midi.ShowControl, Scene: scene, Mode: mode, AltScene: alt}
+		cmd, cue, _ := strings.Cut(rest, ":")
+		m.Command = cmd
+		m.Cue, m.CueList, _ = strings.Cut(cue, "/")
+		if _, err := m.Key(); err != nil {
+			return Mapping{}, err
+		}
+		if err := m.validateMode(); err != nil {
+			return Mapping{}, err
+		}
+		return m, nil
+	}
+	m, err := ParseTrigger(left)
+	if err != nil {
+		return Mapping{}, fmt.Errorf("%w: %q", err, s)
+	}
+	m.Scene, m.Mode, m.AltScene = scene, mode, alt
+	if err := m.validateMode(); err != nil {
+		return Mapping{}, err
+	}
+	return m, nil
+}
+
+// ParseTrigger はシーンを含まないトリガ "ch:note"（CC は "ch:cc64"、ProgramChange は "ch:pc5"）を解析します。
+func ParseTrigger(s string) (Mapping, error) {
+	ln := strings.Split(strings.TrimSpace(s), ":")
+	if len(ln) != 2 {
+		return Mapping{}, errors.New("'ch:note' 形式ではありません")
+	}
+	ch, err := strconv.Atoi(strings.TrimSpace(ln[0]))
+	if err != nil {
+		return Mapping{}, errors.New("チャネルが数値ではありません")
+	}
+	num := strings.ToLower(strings.TrimSpace(ln[1]))
+	m := Mapping{Type: midi.NoteOn, Channel: ch}
+	switch {
+	case strings.HasPrefix(num, "cc"):
+		m.Type = midi.ControlChange
+		num = strings.TrimPrefix(num, "cc")
+	case strings.HasPrefix(num, "pc"):
+		m.Type = midi.ProgramChange
+		num = strings.TrimPrefix(num, "pc")
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return Mapping{}, errors.New("番号が数値ではありません")
+	}
+	switch m.Type {
+	case midi.ControlChange:
+		m.Control = &n
+	case midi.ProgramChange:
+		m.Program = &n
+	default:
+		m.Note = n
+	}
+	if _, err := m.Key(); err != nil {
+		return Mapping{}, err
+	}
+	return m, nil
+}
+
+// BindLines は GUI 設定のマッピング行に m を登録します。同じトリガの行は置き換えます。
+func BindLines(lines []string, m Mapping) []string {
+	key, _ := m.Key()
+	out := make([]string, 0, len(lines)+1)
+	for _, ln := range lines {
+		if old, err := ParseLine(ln); err == nil {
+			if k, _ := old.Key(); k == key {
+				continue
+			}
+		}
+		out = append(out, ln)
+	}
+	return append(out, m.Line())
+}
+
+// Bind は m を登録します。同じトリガの既存マッピングがあれば置き換えて true を返します。
+func (f *File) Bind(m Mapping) bool {
+	key, _ := m.Key()
+	for i, old := range f.Mappings {
+		if k, err := old.Key(); err == nil && k == key {
+			f.Mappings[i] = m
+			return true
+		}
+	}
+	f.Mappings = append(f.Mappings, m)
+	return false
+}
+
+// LoadFile は JSON 設定を読み込みます。
+func LoadFile(path string) (*File, error) {
+	bt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := json.Unmarshal(bt, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// SaveFile は JSON 設定をインデント付きで書き出します。
+func SaveFile(path string, f *File) error {
+	if f == nil {
+		return errors.New("nil file")
+	}
+	if f.Mappings == nil {
+		f.Mappings = []Mapping{}
+	}
+	bt, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bt, '\n'), 0o644)
+}
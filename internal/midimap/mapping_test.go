@@ -0,0 +1,166 @@
+package midimap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+func TestParseLineAndLine(t *testing.T) {
+	cases := map[string]string{
+		"1:36=SceneA":      "1:36=SceneA",
+		" 2:cc64 = SceneB": "2:cc64=SceneB",
+		"16:PC5=SceneC":    "16:pc5=SceneC",
+		"1:36=A=B":         "1:36=A=B",
+	}
+	for in, want := range cases {
+		m, err := ParseLine(in)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) error: %v", in, err)
+		}
+		if got := m.Line(); got != want {
+			t.Fatalf("ParseLine(%q).Line()=%q; want %q", in, got, want)
+		}
+	}
+
+	bad := []string{"1:128=Bad", "0:36=Bad", "x:y=Bad", "1:36=", "1:cc=Bad", "1-36=Bad"}
+	for _, in := range bad {
+		if _, err := ParseLine(in); err == nil {
+			t.Fatalf("ParseLine(%q) should fail", in)
+		}
+	}
+}
+
+func TestFromEvent(t *testing.T) {
+	m, ok := FromEvent(midi.Event{Type: midi.ControlChange, Channel: 3, Data1: 20, Data2: 127})
+	if !ok {
+		t.Fatalf("CC press should be learnable")
+	}
+	if k, _ := m.Key(); k != "3:cc20" {
+		t.Fatalf("key=%q", k)
+	}
+	if _, ok := FromEvent(midi.Event{Type: midi.ControlChange, Channel: 3, Data1: 20, Data2: 0}); ok {
+		t.Fatalf("CC release must not be learnable")
+	}
+	if _, ok := FromEvent(midi.Event{Type: midi.NoteOff, Channel: 1, Data1: 36}); ok {
+		t.Fatalf("NoteOff must not be learnable")
+	}
+	m, ok = FromEvent(midi.Event{Type: midi.ProgramChange, Channel: 1, Data1: 0})
+	if !ok || m.Program == nil || *m.Program != 0 {
+		t.Fatalf("program change 0 should be learnable: %+v", m)
+	}
+}
+
+func TestFileBindReplacesSameTrigger(t *testing.T) {
+	f := &File{}
+	n := 64
+	if replaced := f.Bind(Mapping{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "A"}); replaced {
+		t.Fatalf("first bind should append")
+	}
+	f.Bind(Mapping{Type: midi.ControlChange, Channel: 1, Control: &n, Scene: "B"})
+	if replaced := f.Bind(Mapping{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "C"}); !replaced {
+		t.Fatalf("same trigger should be replaced")
+	}
+	if len(f.Mappings) != 2 || f.Mappings[0].Scene != "C" {
+		t.Fatalf("unexpected mappings: %+v", f.Mappings)
+	}
+
+	path := filepath.Join(t.TempDir(), "midi.json")
+	if err := SaveFile(path, f); err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	got, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if k, _ := got.Mappings[1].Key(); k != "1:cc64" {
+		t.Fatalf("CC mapping lost after round trip: %+v", got.Mappings[1])
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file not written: %v", err)
+	}
+}
+
+func TestBindLines(t *testing.T) {
+	lines := []string{"1:36=A", "1:37=B", "garbage"}
+	out := BindLines(lines, Mapping{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "Z"})
+	want := []string{"1:37=B", "garbage", "1:36=Z"}
+	if len(out) != len(want) {
+		t.Fatalf("BindLines=%v", out)
+	}
+	for i := range want {
+		if out[i] != want[i] {
+			t.Fatalf("BindLines=%v; want %v", out, want)
+		}
+	}
+}
+
+func TestLearnSkipsStaleAndFilteredEvents(t *testing.T) {
+	since := time.Now()
+	events := make(chan midi.Event, 8)
+	events <- midi.Event{Type: midi.NoteOn, Channel: 1, Data1: 10, Data2: 100, Time: since.Add(-time.Second)}
+	events <- midi.Event{Type: midi.NoteOn, Channel: 2, Data1: 11, Data2: 100, Time: since.Add(time.Millisecond)}
+	events <- midi.Event{Type: midi.NoteOff, Channel: 1, Data1: 12, Time: since.Add(time.Millisecond)}
+	events <- midi.Event{Type: midi.NoteOn, Channel: 1, Data1: 13, Data2: 100, Time: since.Add(time.Millisecond)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m, err := Learn(ctx, events, since, []int{1})
+	if err != nil {
+		t.Fatalf("Learn error: %v", err)
+	}
+	if m.Type != midi.NoteOn || m.Channel != 1 || m.Note != 13 {
+		t.Fatalf("unexpected mapping: %+v", m)
+	}
+
+	close(events)
+	if _, err := Learn(ctx, events, since, nil); err != ErrInputClosed {
+		t.Fatalf("expected ErrInputClosed, got %v", err)
+	}
+}
+
+func TestMSCMappingAndLookup(t *testing.T) {
+	for in, want := range map[string]string{
+		"msc:go:5=A":     "msc:go:5=A",
+		"MSC:Stop=B":     "msc:stop=B",
+		"msc:go:1.5/2=C": "msc:go:1.5/2=C",
+	} {
+		m, err := ParseLine(in)
+		if err != nil {
+			t.Fatalf("ParseLine(%q): %v", in, err)
+		}
+		if got := m.Line(); got != want {
+			t.Fatalf("ParseLine(%q).Line()=%q; want %q", in, got, want)
+		}
+	}
+	for _, in := range []string{"msc:jump:1=X", "msc:go:a=X", "msc:go:/2=X"} {
+		if _, err := ParseLine(in); err == nil {
+			t.Fatalf("ParseLine(%q) should fail", in)
+		}
+	}
+
+	dev := uint8(1)
+	r := NewRules([]int{1}, 0, 0, []string{"msc:go:5=Any", "msc:go:5/2=List2"})
+	r.MSCDevice = &dev
+	msc := func(device uint8, cue, list string) midi.Event {
+		return midi.Event{Type: midi.ShowControl, MSC: &midi.MSC{DeviceID: device, Command: midi.MSCGo, Cue: cue, List: list}}
+	}
+	if res := r.Lookup(msc(1, "5", "2")); res.Scene != "List2" {
+		t.Fatalf("cue list specific mapping should win: %+v", res)
+	}
+	if res := r.Lookup(msc(midi.MSCAllCall, "5", "9")); res.Scene != "Any" {
+		t.Fatalf("should fall back to cue-only mapping: %+v", res)
+	}
+	if res := r.Lookup(msc(3, "5", "")); res.Skip != "device" {
+		t.Fatalf("other device should be skipped: %+v", res)
+	}
+
+	m, ok := FromEvent(msc(1, "5", "2"))
+	if !ok || m.Line() != "msc:go:5/2=" {
+		t.Fatalf("FromEvent(msc)=%+v", m)
+	}
+}
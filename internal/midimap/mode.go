@@ -0,0 +1,103 @@
+package midimap
+
+import (
+	"fmt"
+	"strings"
+
+	"awesomeProject/internal/midi"
+)
+
+// マッピングのモード（Mapping.Mode）。
+const (
+	ModeTrigger   = "trigger"   // 押すたびに scene へ切り替える（既定）
+	ModeMomentary = "momentary" // 押している間だけ scene、離すと alt_scene（未指定なら押す前のシーン）に戻す
+	ModeToggle    = "toggle"    // 押すたびに scene と alt_scene（未指定なら押す前のシーン）を交互に切り替える
+	ModeLatch     = "latch"     // scene へ切り替えて保持し、他のマッピングが発火するまで同じボタンの再押下を無視する
+)
+
+// ModeSpec は照合キーごとのモード設定です（trigger は登録しません）。
+type ModeSpec struct {
+	Mode     string
+	AltScene string
+}
+
+// mode は正規化したモードを返します（未指定は trigger）。
+func (m Mapping) mode() string {
+	s := strings.ToLower(strings.TrimSpace(m.Mode))
+	if s == "" {
+		return ModeTrigger
+	}
+	return s
+}
+
+// validateMode は mode / alt_scene の組み合わせを検証します。
+func (m Mapping) validateMode() error {
+	switch mode := m.mode(); mode {
+	case ModeTrigger:
+		if strings.TrimSpace(m.AltScene) != "" {
+			return fmt.Errorf("alt_scene は mode が momentary / toggle のときだけ指定できます")
+		}
+	case ModeMomentary:
+		// 離したことが分かるのはノートと CC だけ
+		if k := m.kind(); k != midi.NoteOn && k != midi.ControlChange {
+			return fmt.Errorf("momentary は note_on / control_change でのみ使えます: %q", m.Type)
+		}
+	case ModeToggle:
+	case ModeLatch:
+		if strings.TrimSpace(m.AltScene) != "" {
+			return fmt.Errorf("alt_scene は mode が momentary / toggle のときだけ指定できます")
+		}
+	default:
+		return fmt.Errorf("未対応の mode です: %q（trigger / momentary / toggle / latch）", m.Mode)
+	}
+	if m.mode() != ModeTrigger && strings.TrimSpace(m.Action) != "" {
+		return fmt.Errorf("mode は action と同時に指定できません")
+	}
+	return nil
+}
+
+// modeSpec は trigger 以外なら ModeSpec を返します。
+func (m Mapping) modeSpec() (ModeSpec, bool) {
+	if m.mode() == ModeTrigger || m.validateMode() != nil {
+		return ModeSpec{}, false
+	}
+	return ModeSpec{Mode: m.mode(), AltScene: strings.TrimSpace(m.AltScene)}, true
+}
+
+// Modes は trigger 以外のマッピングを照合キー → ModeSpec にまとめます。不正なものは読み捨てます。
+func (f *File) Modes() map[string]ModeSpec {
+	out := map[string]ModeSpec{}
+	for _, m := range f.Mappings {
+		key, err := m.Key()
+		if err != nil {
+			continue
+		}
+		if spec, ok := m.modeSpec(); ok {
+			out[key] = spec
+		}
+	}
+	return out
+}
+
+// lineSuffix は行形式の "|mode|alt" 部分を返します（trigger なら空）。
+func (m Mapping) lineSuffix() string {
+	if m.mode() == ModeTrigger {
+		return ""
+	}
+	s := "|" + m.mode()
+	if alt := strings.TrimSpace(m.AltScene); alt != "" {
+		s += "|" + alt
+	}
+	return s
+}
+
+// releaseKey は NoteOff / CC 値 0 が「離した」に当たるマッピングの照合キーを返します。
+func releaseKey(ev midi.Event) (string, bool) {
+	switch {
+	case ev.Type == midi.NoteOff:
+		return TriggerKey(midi.NoteOn, int(ev.Channel), int(ev.Data1)), true
+	case ev.Type == midi.ControlChange && ev.Data2 == 0:
+		return TriggerKey(midi.ControlChange, int(ev.Channel), int(ev.Data1)), true
+	}
+	return "", false
+}
@@ -0,0 +1,113 @@
+package midimap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+func TestModesMomentaryToggleLatch(t *testing.T) {
+	r := NewRules(nil, 0, 0, []string{
+		"1:36=Cam|momentary",
+		"1:37=Wide|momentary|Back",
+		"1:cc20=Slides|toggle",
+		"1:38=A|toggle|B",
+		"1:39=Live|latch",
+		"1:40=Other",
+	})
+	e := NewEvaluator(r)
+	e.SetCurrent("Main")
+	now := time.Unix(0, 0)
+	press := func(n uint8) Result {
+		now = now.Add(time.Second)
+		return e.Evaluate(midi.Event{Type: midi.NoteOn, Channel: 1, Data1: n, Data2: 100}, now)
+	}
+	release := func(n uint8) Result {
+		now = now.Add(time.Second)
+		return e.Evaluate(midi.Event{Type: midi.NoteOff, Channel: 1, Data1: n}, now)
+	}
+	cc := func(v uint8) Result {
+		now = now.Add(time.Second)
+		return e.Evaluate(midi.Event{Type: midi.ControlChange, Channel: 1, Data1: 20, Data2: v}, now)
+	}
+	expect := func(name string, res Result, scene string) {
+		t.Helper()
+		if !res.Fire() || res.Scene != scene {
+			t.Fatalf("%s: want %q, got %+v", name, scene, res)
+		}
+	}
+
+	// momentary: 押す前のシーンへ戻る。押していない離しは無視
+	if res := release(36); res.Fire() || res.Key != "" {
+		t.Fatalf("release without press should be ignored: %+v", res)
+	}
+	expect("momentary press", press(36), "Cam")
+	res := release(36)
+	expect("momentary release", res, "Main")
+	if !res.Release || res.Mode != ModeMomentary {
+		t.Fatalf("release flags: %+v", res)
+	}
+	expect("momentary alt press", press(37), "Wide")
+	expect("momentary alt release", release(37), "Back")
+
+	// toggle（CC）: 1 回目は scene、2 回目は押す前のシーン。CC 0 は無視
+	expect("toggle on", cc(127), "Slides")
+	if res := cc(0); res.Fire() {
+		t.Fatalf("cc 0 should not toggle: %+v", res)
+	}
+	expect("toggle off", cc(127), "Back")
+	expect("toggle alt on", press(38), "A")
+	expect("toggle alt off", press(38), "B")
+
+	// latch: 再押下は無視、他が発火すると解除
+	expect("latch", press(39), "Live")
+	if res := press(39); res.Skip != "latched" {
+		t.Fatalf("second latch press should be skipped: %+v", res)
+	}
+	expect("other", press(40), "Other")
+	expect("latch again", press(39), "Live")
+
+	// 戻り先が不明
+	e2 := NewEvaluator(r)
+	expect("momentary without current", press2(e2, 36), "Cam")
+	if res := e2.Evaluate(midi.Event{Type: midi.NoteOff, Channel: 1, Data1: 36}, time.Unix(10, 0)); res.Skip != "norevert" {
+		t.Fatalf("unknown revert target: %+v", res)
+	}
+}
+
+func press2(e *Evaluator, n uint8) Result {
+	return e.Evaluate(midi.Event{Type: midi.NoteOn, Channel: 1, Data1: n, Data2: 100}, time.Unix(5, 0))
+}
+
+func TestModeLineAndValidate(t *testing.T) {
+	m, err := ParseLine("2:cc64=A|toggle|B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Mode != "toggle" || m.AltScene != "B" || m.Line() != "2:cc64=A|toggle|B" {
+		t.Fatalf("unexpected: %+v %s", m, m.Line())
+	}
+	if m, _ := ParseLine("1:36=A"); m.Line() != "1:36=A" {
+		t.Fatalf("trigger line should have no suffix: %s", m.Line())
+	}
+	for _, ln := range []string{"1:pc5=A|momentary", "1:36=A|hold", "1:36=A|latch|B", "1:36=A|toggle|B|C"} {
+		if _, err := ParseLine(ln); err == nil {
+			t.Fatalf("%q should be rejected", ln)
+		}
+	}
+
+	prog := 5
+	f := File{Mappings: []Mapping{
+		{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "A", Mode: "momentary", AltScene: "B"},
+		{Type: midi.ProgramChange, Channel: 1, Program: &prog, Scene: "C", Mode: "momentary"},
+	}}
+	err = f.Validate()
+	if err == nil || !strings.Contains(err.Error(), "mappings[1] (1:pc5): momentary") {
+		t.Fatalf("expected momentary error on mappings[1]: %v", err)
+	}
+	if got := f.Modes(); len(got) != 1 || got["1:36"].AltScene != "B" {
+		t.Fatalf("modes: %+v", got)
+	}
+}
@@ -0,0 +1,46 @@
+package midimap
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+// 記録したイベント列を再生ドライバ経由でマッピングに通し、発火するシーンの並びを確かめる。
+func TestEvaluatorWithReplayedLog(t *testing.T) {
+	recs := []midi.Record{
+		{TMs: 0, Type: midi.NoteOn, Channel: 1, Data1: 36, Data2: 100},
+		{TMs: 5, Type: midi.NoteOn, Channel: 1, Data1: 37, Data2: 100},   // debounce 内
+		{TMs: 100, Type: midi.NoteOn, Channel: 1, Data1: 40, Data2: 100}, // momentary
+		{TMs: 300, Type: midi.NoteOff, Channel: 1, Data1: 40},
+		{TMs: 400, Type: midi.NoteOn, Channel: 2, Data1: 36, Data2: 100}, // チャネル外
+	}
+	in, events, err := midi.ReplayRecords(recs, midi.ReplayOptions{Speed: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	rules := NewRules([]int{1}, 30*time.Millisecond, 0, []string{"1:36=A", "1:37=B", "1:40=C|momentary"})
+	e := NewEvaluator(rules)
+	// 再生は実時間を縮めるので、判定は記録時刻で行う
+	start := time.Now()
+	var fired []string
+	i := 0
+	for ev := range events {
+		if r := e.Evaluate(ev, start.Add(recs[i].Offset())); r.Fire() {
+			fired = append(fired, r.Scene)
+		}
+		i++
+	}
+	want := []string{"A", "C", "A"}
+	if len(fired) != len(want) {
+		t.Fatalf("fired %v, want %v", fired, want)
+	}
+	for j := range want {
+		if fired[j] != want[j] {
+			t.Fatalf("fired %v, want %v", fired, want)
+		}
+	}
+}
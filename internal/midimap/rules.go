@@ -0,0 +1,383 @@
+package midimap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+// Rules は照合に使う設定一式です。生成後は変更せず、差し替えは Evaluator.Swap で行います。
+type Rules struct {
+	Channels  []int               // 受け付けるチャネル（空なら全て）
+	Debounce  time.Duration       // 直前の発火からこの間隔内のトリガは無視（全トリガ共通）
+	RateLimit time.Duration       // 同じトリガの最短発火間隔
+	Scenes    map[string]string   // 照合キー（TriggerKey / MSCKey）→ シーン名
+	MSCDevice *uint8              // 受け付ける MSC の device ID（nil なら全て）
+	Controls  map[string]*Control // CC の照合キー → 連続値操作（Scenes より優先）
+	Modes     map[string]ModeSpec // 照合キー → trigger 以外のモード
+	Banks     []*BankRules        // 切り替えて使うマッピング（有効なバンクを上記より優先）
+	BankKeys  map[string]BankKey  // バンク切替トリガの照合キー（マッピングより優先）
+	Routes    map[string]Route    // 照合キー → 送り先ホスト・トランジション（未登録は全ホスト）
+}
+
+// NewRules は lines（"ch:note=Scene" 形式）から Rules を作ります。不正な行は読み捨てます。
+func NewRules(channels []int, debounce, ratelimit time.Duration, lines []string) *Rules {
+	r := &Rules{Channels: channels, Debounce: debounce, RateLimit: ratelimit, Scenes: map[string]string{}, Modes: map[string]ModeSpec{}}
+	for _, ln := range lines {
+		m, err := ParseLine(ln)
+		if err != nil {
+			continue
+		}
+		key, _ := m.Key()
+		r.Scenes[key] = m.Scene
+		if spec, ok := m.modeSpec(); ok {
+			r.Modes[key] = spec
+		}
+	}
+	return r
+}
+
+// Validate は設定全体を検証し、問題のあるマッピングを mappings[i]（inputs の中なら inputs[n].mappings[i]）の形で列挙したエラーを返します。
+func (f *File) Validate() error {
+	var errs []error
+	if f.Channel < 0 || f.Channel > 16 {
+		errs = append(errs, fmt.Errorf("channel は 1..16 を指定してください: %d", f.Channel))
+	}
+	if f.MSCDevice != nil && (*f.MSCDevice < 0 || *f.MSCDevice > 126) {
+		errs = append(errs, fmt.Errorf("msc_device_id は 0..126 を指定してください: %d", *f.MSCDevice))
+	}
+	if d := strings.TrimSpace(f.Debounce); d != "" {
+		if _, err := time.ParseDuration(d); err != nil {
+			errs = append(errs, fmt.Errorf("debounce が不正です: %q", f.Debounce))
+		}
+	}
+	if r := strings.TrimSpace(f.RateLimit); r != "" {
+		if _, err := time.ParseDuration(r); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit が不正です: %q", f.RateLimit))
+		}
+	}
+	errs = append(errs, f.validateMappings()...)
+	errs = append(errs, f.validateTimecode()...)
+	errs = append(errs, f.validateInputs()...)
+	errs = append(errs, f.validateBanks()...)
+	errs = append(errs, f.validateHosts()...)
+	return errors.Join(errs...)
+}
+
+// validateMappings は mappings を検証します。
+func (f *File) validateMappings() []error {
+	var errs []error
+	seen := map[string]int{}
+	for i, m := range f.Mappings {
+		key, err := m.Key()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mappings[%d]: %w", i, err))
+			continue
+		}
+		if c, err := m.ControlSpec(); err != nil {
+			errs = append(errs, fmt.Errorf("mappings[%d] (%s): %w", i, key, err))
+		} else if c == nil && strings.TrimSpace(m.Scene) == "" {
+			errs = append(errs, fmt.Errorf("mappings[%d] (%s): scene が空です", i, key))
+		}
+		if err := m.validateMode(); err != nil {
+			errs = append(errs, fmt.Errorf("mappings[%d] (%s): %w", i, key, err))
+		}
+		if j, dup := seen[key]; dup {
+			errs = append(errs, fmt.Errorf("mappings[%d] (%s): mappings[%d] と同じトリガです", i, key, j))
+			continue
+		}
+		seen[key] = i
+	}
+	return errs
+}
+
+// MSCDeviceID は msc_device_id を Rules 用に変換します（未指定は nil）。
+func (f *File) MSCDeviceID() *uint8 {
+	if f.MSCDevice == nil || *f.MSCDevice < 0 || *f.MSCDevice > 126 {
+		return nil
+	}
+	d := uint8(*f.MSCDevice)
+	return &d
+}
+
+// Result は Evaluate の判定結果です。Skip が空で Scene があれば発火対象です。
+// Control がある場合は連続値操作で、Value（CC 値）を ControlDriver に渡します。
+// Release は momentary のマッピングを離したこと（NoteOff / CC 値 0）を表し、Scene は戻り先です。
+// Bank はバンク切替トリガ（シフトの押下・離しを含む）のときの切替後のバンク名で、BankIndex がその位置です。
+// Route は発火するマッピングの送り先とトランジションです（momentary の離しでは押したマッピングのもの）。
+type Result struct {
+	Key       string
+	Scene     string
+	Skip      string // "channel" / "device" / "unmapped" / "debounce" / "ratelimit" / "latched" / "norevert"（発火対象なら空）
+	Control   *Control
+	Value     uint8
+	Mode      string
+	AltScene  string // momentary / toggle の戻り先（ヒットしたバンクかトップレベルの設定。空なら直前のシーン）
+	Release   bool
+	Bank      string
+	BankIndex int
+	Route     Route
+}
+
+// Fire は発火対象かどうかを返します。
+func (r Result) Fire() bool { return r.Skip == "" && r.Scene != "" }
+
+// Lookup はチャネル・MSC device ID のフィルタとマッピングだけで ev を判定します（デバウンス等は見ません）。
+// banks があれば先頭のバンクを有効として扱います。照合キーは EventKeys の優先順に試します。
+func (r *Rules) Lookup(ev midi.Event) Result { return r.LookupBank(ev, 0) }
+
+// LookupBank は bank 番目のバンクを有効として ev を判定します。
+// バンク切替トリガはマッピングより先に照合し、シフトは離した（NoteOff / CC 値 0）ときも Release 付きで返します。
+func (r *Rules) LookupBank(ev midi.Event, bank int) Result {
+	if !channelAllowed(r.Channels, ev) {
+		return Result{Skip: "channel"}
+	}
+	if ev.MSC != nil && r.MSCDevice != nil && !ev.MSC.Matches(*r.MSCDevice) {
+		return Result{Skip: "device"}
+	}
+	var b *BankRules
+	if bank >= 0 && bank < len(r.Banks) {
+		b = r.Banks[bank]
+	}
+	if key, ok := releaseKey(ev); ok {
+		if k, ok := r.BankKeys[key]; ok && k.Shift {
+			return Result{Key: key, Release: true, Bank: r.Banks[k.Bank].Name, BankIndex: k.Bank}
+		}
+	}
+	if key, ok := EventKey(ev); ok {
+		if k, ok := r.BankKeys[key]; ok {
+			return Result{Key: key, Bank: r.Banks[k.Bank].Name, BankIndex: k.Bank}
+		}
+	}
+	if ev.Type == midi.ControlChange {
+		// 連続値操作は値 0 も含めて全て渡す
+		key := TriggerKey(ev.Type, int(ev.Channel), int(ev.Data1))
+		if b != nil {
+			if c, ok := b.Controls[key]; ok {
+				return Result{Key: key, Control: c, Value: ev.Data2}
+			}
+		}
+		if c, ok := r.Controls[key]; ok {
+			return Result{Key: key, Control: c, Value: ev.Data2}
+		}
+	}
+	if key, ok := releaseKey(ev); ok {
+		spec, ok := r.Modes[key]
+		if b != nil {
+			if bs, found := b.Modes[key]; found {
+				spec, ok = bs, true
+			} else if _, mapped := b.Scenes[key]; mapped {
+				ok = false
+			}
+		}
+		if ok && spec.Mode == ModeMomentary {
+			routes := r.Routes
+			if b != nil {
+				if _, mapped := b.Scenes[key]; mapped {
+					routes = b.Routes
+				}
+			}
+			return Result{Key: key, Mode: ModeMomentary, Release: true, Route: routes[key]}
+		}
+	}
+	keys := EventKeys(ev)
+	if len(keys) == 0 {
+		return Result{}
+	}
+	for _, k := range keys {
+		if b != nil {
+			if scene, ok := b.Scenes[k]; ok {
+				return lookupResult(k, scene, b.Modes, b.Routes)
+			}
+		}
+		if scene, ok := r.Scenes[k]; ok {
+			return lookupResult(k, scene, r.Modes, r.Routes)
+		}
+	}
+	return Result{Key: keys[0], Skip: "unmapped"}
+}
+
+// lookupResult はシーンが見つかったときの Result を作ります。
+func lookupResult(key, scene string, modes map[string]ModeSpec, routes map[string]Route) Result {
+	res := Result{Key: key, Scene: scene, Mode: ModeTrigger, Route: routes[key]}
+	if spec, ok := modes[key]; ok {
+		res.Mode, res.AltScene = spec.Mode, spec.AltScene
+	}
+	return res
+}
+
+// Evaluator は受信イベントを現在の Rules で判定します。
+// Rules は Swap で実行中に差し替えでき、レート制限の履歴とモードの状態（押下中・トグル・ラッチ）は差し替え後も引き継ぎます。
+type Evaluator struct {
+	rules atomic.Pointer[Rules]
+
+	mu       sync.Mutex
+	lastAt   map[string]time.Time
+	lastFire time.Time
+	current  string            // 直近に切り替えたシーン（momentary / toggle の戻り先）
+	held     map[string]string // momentary で押下中のキー → 離したときの戻り先
+	toggled  map[string]string // toggle で scene 側にいるキー → 2 回目の戻り先
+	latched  string            // latch で保持中のキー
+	bank     int               // 選択中のバンク（Rules.Banks の位置）
+	shiftKey string            // 押下中のシフトの照合キー（押している間は shiftTo のバンク）
+	shiftTo  int
+}
+
+// NewEvaluator は r で判定する Evaluator を作ります。
+func NewEvaluator(r *Rules) *Evaluator {
+	e := &Evaluator{lastAt: map[string]time.Time{}, held: map[string]string{}, toggled: map[string]string{}}
+	e.Swap(r)
+	return e
+}
+
+// SetCurrent は現在のプログラムシーンを知らせます（MIDI 以外での切替や起動時の状態）。
+// momentary / toggle で alt_scene が無いときの戻り先になります。
+func (e *Evaluator) SetCurrent(scene string) {
+	e.mu.Lock()
+	e.current = scene
+	e.mu.Unlock()
+}
+
+// Current は Evaluator が把握している現在のシーンを返します（不明なら空）。
+func (e *Evaluator) Current() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.current
+}
+
+// Swap は判定に使う Rules を差し替えます（nil は空のルール扱い）。
+func (e *Evaluator) Swap(r *Rules) {
+	if r == nil {
+		r = &Rules{Scenes: map[string]string{}}
+	}
+	e.rules.Store(r)
+}
+
+// Bank は有効なバンク（シフト中はシフト先）の位置と名前を返します。banks が無ければ (0, "") です。
+func (e *Evaluator) Bank() (int, string) {
+	r := e.rules.Load()
+	e.mu.Lock()
+	i := e.activeBank(r)
+	e.mu.Unlock()
+	if i >= len(r.Banks) {
+		return 0, ""
+	}
+	return i, r.Banks[i].Name
+}
+
+// activeBank は有効なバンクの位置を返します（Swap でバンクが減ったら先頭に戻します）。e.mu を保持して呼びます。
+func (e *Evaluator) activeBank(r *Rules) int {
+	if e.shiftKey != "" && e.shiftTo < len(r.Banks) {
+		return e.shiftTo
+	}
+	if e.bank >= len(r.Banks) {
+		return 0
+	}
+	return e.bank
+}
+
+// Rules は現在の Rules を返します。
+func (e *Evaluator) Rules() *Rules { return e.rules.Load() }
+
+// Evaluate は ev を判定し、発火対象なら発火時刻として now を記録します。
+// トリガ以外のイベント（NoteOff や CC の 0 など）は Key が空の Result を返します。
+// 連続値操作はデバウンス・レート制限の対象外です（ControlDriver の interval で間引きます）。
+func (e *Evaluator) Evaluate(ev midi.Event, now time.Time) Result {
+	r := e.rules.Load()
+	e.mu.Lock()
+	bank := e.activeBank(r)
+	e.mu.Unlock()
+	res := r.LookupBank(ev, bank)
+	if res.Skip != "" || res.Key == "" || res.Control != nil {
+		return res
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if res.Bank != "" {
+		return e.switchBank(r, res)
+	}
+	if res.Release {
+		// 押下が記録されていない（デバウンス等で押下を捨てた）離しは無視する
+		back, ok := e.held[res.Key]
+		if !ok {
+			return Result{}
+		}
+		delete(e.held, res.Key)
+		res.Scene = back
+		if back == "" {
+			res.Skip = "norevert"
+			return res
+		}
+		e.current = back
+		e.latched = ""
+		return res
+	}
+	if !e.lastFire.IsZero() && now.Sub(e.lastFire) < r.Debounce {
+		res.Skip = "debounce"
+		return res
+	}
+	if t, ok := e.lastAt[res.Key]; ok && now.Sub(t) < r.RateLimit {
+		res.Skip = "ratelimit"
+		return res
+	}
+	switch res.Mode {
+	case ModeMomentary:
+		back := res.AltScene
+		if back == "" {
+			back = e.current
+		}
+		e.held[res.Key] = back
+	case ModeToggle:
+		if back, on := e.toggled[res.Key]; on {
+			delete(e.toggled, res.Key)
+			if res.AltScene != "" {
+				back = res.AltScene
+			}
+			res.Scene = back
+			if back == "" {
+				res.Skip = "norevert"
+				return res
+			}
+		} else {
+			e.toggled[res.Key] = e.current
+		}
+	case ModeLatch:
+		if e.latched == res.Key {
+			res.Skip = "latched"
+			return res
+		}
+	}
+	e.latched = ""
+	if res.Mode == ModeLatch {
+		e.latched = res.Key
+	}
+	e.current = res.Scene
+	e.lastAt[res.Key] = now
+	e.lastFire = now
+	return res
+}
+
+// switchBank はバンク切替トリガを反映します（デバウンス・レート制限の対象外）。e.mu を保持して呼びます。
+// シフトを離したときは選択中のバンクに戻し、Result もそのバンクを指すように書き換えます。
+func (e *Evaluator) switchBank(r *Rules, res Result) Result {
+	switch {
+	case res.Release:
+		if e.shiftKey != res.Key {
+			return Result{}
+		}
+		e.shiftKey = ""
+		res.BankIndex = e.activeBank(r)
+		res.Bank = r.Banks[res.BankIndex].Name
+	case r.BankKeys[res.Key].Shift:
+		e.shiftKey, e.shiftTo = res.Key, res.BankIndex
+	default:
+		e.bank = res.BankIndex
+	}
+	return res
+}
@@ -0,0 +1,95 @@
+package midimap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+func TestEvaluatorDebounceRateLimitAndSwap(t *testing.T) {
+	e := NewEvaluator(NewRules([]int{1}, 10*time.Millisecond, 100*time.Millisecond, []string{"1:36=A", "1:37=B"}))
+	t0 := time.Now()
+	on := func(note uint8) midi.Event { return midi.Event{Type: midi.NoteOn, Channel: 1, Data1: note, Data2: 100} }
+
+	if r := e.Evaluate(on(36), t0); !r.Fire() || r.Scene != "A" {
+		t.Fatalf("first trigger should fire: %+v", r)
+	}
+	if r := e.Evaluate(on(37), t0.Add(5*time.Millisecond)); r.Skip != "debounce" {
+		t.Fatalf("expected debounce, got %+v", r)
+	}
+	if r := e.Evaluate(on(37), t0.Add(20*time.Millisecond)); !r.Fire() {
+		t.Fatalf("other trigger after debounce should fire: %+v", r)
+	}
+	if r := e.Evaluate(on(36), t0.Add(50*time.Millisecond)); r.Skip != "ratelimit" {
+		t.Fatalf("expected ratelimit, got %+v", r)
+	}
+	if r := e.Evaluate(midi.Event{Type: midi.NoteOn, Channel: 2, Data1: 36, Data2: 1}, t0.Add(time.Second)); r.Skip != "channel" {
+		t.Fatalf("expected channel filter, got %+v", r)
+	}
+
+	// 差し替え後は新しいマッピングで判定し、レート制限の履歴は引き継ぐ
+	e.Swap(NewRules(nil, 0, 100*time.Millisecond, []string{"1:36=Z", "2:cc1=C"}))
+	if r := e.Evaluate(on(36), t0.Add(60*time.Millisecond)); r.Skip != "ratelimit" || r.Scene != "Z" {
+		t.Fatalf("rate limit history should survive swap: %+v", r)
+	}
+	if r := e.Evaluate(on(37), t0.Add(time.Second)); r.Skip != "unmapped" {
+		t.Fatalf("removed mapping should be unmapped: %+v", r)
+	}
+	if r := e.Evaluate(midi.Event{Type: midi.ControlChange, Channel: 2, Data1: 1, Data2: 127}, t0.Add(2*time.Second)); !r.Fire() || r.Scene != "C" {
+		t.Fatalf("new CC mapping should fire: %+v", r)
+	}
+}
+
+func TestFileValidate(t *testing.T) {
+	n := 200
+	f := &File{
+		Debounce: "abc",
+		Mappings: []Mapping{
+			{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "A"},
+			{Type: midi.NoteOn, Channel: 1, Note: 36, Scene: "B"},
+			{Type: midi.ControlChange, Channel: 1, Control: &n, Scene: "C"},
+			{Type: midi.NoteOn, Channel: 2, Note: 1},
+		},
+	}
+	err := f.Validate()
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	for _, want := range []string{"debounce", "mappings[1]", "mappings[2]", "mappings[3]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error should mention %s: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "\nmappings[0]") || strings.HasPrefix(err.Error(), "mappings[0]") {
+		t.Fatalf("valid mapping reported: %v", err)
+	}
+	if err := (&File{Mappings: f.Mappings[:1]}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "midi.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 4)
+	go WatchFile(ctx, path, 5*time.Millisecond, func() { changed <- struct{}{} })
+
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(path, []byte(`{"mappings":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("change not detected")
+	}
+}
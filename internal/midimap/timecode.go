@@ -0,0 +1,164 @@
+package midimap
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+// TimecodeCue は timecode_cues の要素（タイムコード位置 → シーン）です。
+type TimecodeCue struct {
+	At    string `json:"at"` // "hh:mm:ss:ff"
+	Scene string `json:"scene"`
+}
+
+// Jump モード（タイムコードが不連続に変化したときの扱い）。
+const (
+	JumpChase = "chase" // 新しい位置の直前のキューのシーンに合わせる
+	JumpSkip  = "skip"  // 何も発火せず、次のキューから再開する
+)
+
+// TimecodeRate は frame_rate を返します。未指定なら空（受信した MTC のレートを使う）です。
+func (f *File) TimecodeRate() (midi.FrameRate, error) {
+	if strings.TrimSpace(f.FrameRate) == "" {
+		return "", nil
+	}
+	return midi.ParseFrameRate(f.FrameRate)
+}
+
+// validateTimecode は frame_rate と timecode_cues を検証します（Validate から呼ばれます）。
+func (f *File) validateTimecode() []error {
+	var errs []error
+	rate, err := f.TimecodeRate()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("frame_rate: %w", err))
+	}
+	if rate == "" {
+		rate = midi.FPS30
+	}
+	seen := map[string]int{}
+	for i, c := range f.TimecodeCues {
+		tc, err := midi.ParseTimecode(c.At, rate)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("timecode_cues[%d]: %w", i, err))
+			continue
+		}
+		if strings.TrimSpace(c.Scene) == "" {
+			errs = append(errs, fmt.Errorf("timecode_cues[%d] (%s): scene が空です", i, tc))
+		}
+		if j, dup := seen[tc.String()]; dup {
+			errs = append(errs, fmt.Errorf("timecode_cues[%d] (%s): timecode_cues[%d] と同じ位置です", i, tc, j))
+			continue
+		}
+		seen[tc.String()] = i
+	}
+	return errs
+}
+
+// ChaseCue は Chaser が発火を決めたキューです。Delay は ClockUpdate.At からの待ち時間です。
+type ChaseCue struct {
+	Index    int // timecode_cues 内の位置
+	Timecode midi.Timecode
+	At       time.Duration
+	Scene    string
+	Delay    time.Duration
+}
+
+// Chaser は MTCClock の更新に追従し、timecode_cues を発火させる時刻を決めます。
+// 再生中は Lookahead 先までのキューを前もって返し、停止・ジャンプ時は Cancel で未発火分の取り消しを求めます。
+type Chaser struct {
+	Lookahead time.Duration
+	JumpMode  string // JumpChase（既定）/ JumpSkip
+
+	rate    midi.FrameRate
+	cues    []ChaseCue // At の昇順
+	next    int        // 未スケジュールの先頭
+	fireAt  []time.Time
+	lastRun int // 発火済み（発火時刻を過ぎた）最後のキュー、無ければ -1
+}
+
+// ChaseResult は Chaser.Update の結果です。
+type ChaseResult struct {
+	Cancel bool       // 先にスケジュールした未発火のキューを取り消す
+	Due    []ChaseCue // 新たに発火させるキュー
+}
+
+// NewChaser は cues を rate で解釈した Chaser を作ります。cues の誤りは Validate と同じ形式で返します。
+func NewChaser(cues []TimecodeCue, rate midi.FrameRate) (*Chaser, error) {
+	if rate == "" {
+		rate = midi.FPS30
+	}
+	f := File{FrameRate: string(rate), TimecodeCues: cues}
+	if errs := f.validateTimecode(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	c := &Chaser{Lookahead: 500 * time.Millisecond, JumpMode: JumpChase, rate: rate, lastRun: -1}
+	for i, tc := range cues {
+		t, _ := midi.ParseTimecode(tc.At, rate)
+		c.cues = append(c.cues, ChaseCue{Index: i, Timecode: t, At: t.Duration(), Scene: tc.Scene})
+	}
+	sort.SliceStable(c.cues, func(i, j int) bool { return c.cues[i].At < c.cues[j].At })
+	c.fireAt = make([]time.Time, len(c.cues))
+	return c, nil
+}
+
+// Rate はキューの解釈に使ったフレームレートを返します。
+func (c *Chaser) Rate() midi.FrameRate { return c.rate }
+
+// Cues は At の昇順に並べたキューを返します。
+func (c *Chaser) Cues() []ChaseCue { return c.cues }
+
+// Update は u を取り込み、取り消しと新たな発火を返します。
+func (c *Chaser) Update(u midi.ClockUpdate) ChaseResult {
+	var res ChaseResult
+	if u.Jump || u.State != midi.ClockLocked {
+		res.Cancel = c.cancel(u.At)
+	}
+	if u.Jump {
+		// 新しい位置より後のキューから再開する
+		c.next = sort.Search(len(c.cues), func(i int) bool { return c.cues[i].At > u.Position })
+		if c.JumpMode != JumpSkip && c.next > 0 && c.next-1 != c.lastRun {
+			res.Due = append(res.Due, c.schedule(c.next-1, u.At, 0))
+		}
+	}
+	if u.State != midi.ClockLocked {
+		return res
+	}
+	for c.next < len(c.cues) && c.cues[c.next].At <= u.Position+c.Lookahead {
+		delay := c.cues[c.next].At - u.Position
+		if delay < 0 {
+			delay = 0
+		}
+		res.Due = append(res.Due, c.schedule(c.next, u.At, delay))
+		c.next++
+	}
+	return res
+}
+
+func (c *Chaser) schedule(i int, at time.Time, delay time.Duration) ChaseCue {
+	c.fireAt[i] = at.Add(delay)
+	cue := c.cues[i]
+	cue.Delay = delay
+	return cue
+}
+
+// cancel は now 時点で未発火のスケジュールを捨て、取り消しが必要なら true を返します。
+func (c *Chaser) cancel(now time.Time) bool {
+	pending := false
+	for i, t := range c.fireAt {
+		if t.IsZero() {
+			continue
+		}
+		if t.After(now) {
+			pending = true
+		} else {
+			c.lastRun = i
+		}
+		c.fireAt[i] = time.Time{}
+	}
+	return pending
+}
@@ -0,0 +1,82 @@
+package midimap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/midi"
+)
+
+func TestChaserScheduleJumpAndLoss(t *testing.T) {
+	c, err := NewChaser([]TimecodeCue{
+		{At: "00:00:05:00", Scene: "C"},
+		{At: "00:00:01:00", Scene: "A"},
+		{At: "00:00:02:00", Scene: "B"},
+	}, midi.FPS25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1000, 0)
+	locked := func(pos time.Duration, at time.Duration, jump bool) ChaseResult {
+		return c.Update(midi.ClockUpdate{State: midi.ClockLocked, Position: pos, At: t0.Add(at), Jump: jump})
+	}
+	scenes := func(r ChaseResult) string {
+		var s []string
+		for _, d := range r.Due {
+			s = append(s, d.Scene)
+		}
+		return strings.Join(s, ",")
+	}
+
+	r := locked(900*time.Millisecond, 0, true)
+	if scenes(r) != "A" || r.Due[0].Delay != 100*time.Millisecond || r.Cancel {
+		t.Fatalf("lock at 0.9s: %+v", r)
+	}
+	r = locked(1600*time.Millisecond, 700*time.Millisecond, false)
+	if scenes(r) != "B" || r.Due[0].Delay != 400*time.Millisecond {
+		t.Fatalf("advance to 1.6s: %+v", r)
+	}
+	// B の発火前に停止 → 取り消し
+	r = c.Update(midi.ClockUpdate{State: midi.ClockStopped, Position: 1700 * time.Millisecond, At: t0.Add(800 * time.Millisecond)})
+	if !r.Cancel || len(r.Due) != 0 {
+		t.Fatalf("loss should cancel pending B: %+v", r)
+	}
+	// 同じ位置で再ロック: A は発火済みなので追従不要、B を再スケジュール
+	r = locked(1700*time.Millisecond, 2*time.Second, true)
+	if scenes(r) != "B" || r.Cancel {
+		t.Fatalf("relock: %+v", r)
+	}
+	// 6s へジャンプ（B は発火済み）→ 直前のキュー C に合わせる
+	r = locked(6*time.Second, 3*time.Second, true)
+	if scenes(r) != "C" || r.Due[0].Delay != 0 {
+		t.Fatalf("jump forward: %+v", r)
+	}
+
+	c.JumpMode = JumpSkip
+	r = locked(1500*time.Millisecond, 4*time.Second, true)
+	if scenes(r) != "B" || r.Due[0].Delay != 500*time.Millisecond {
+		t.Fatalf("skip mode should not chase A, only schedule B: %+v", r)
+	}
+}
+
+func TestValidateTimecodeCues(t *testing.T) {
+	f := File{FrameRate: "25", TimecodeCues: []TimecodeCue{
+		{At: "00:00:01:00", Scene: "A"},
+		{At: "00:00:01:30", Scene: "B"},
+		{At: "00:00:01.00", Scene: "C"},
+		{At: "00:00:02:00"},
+	}}
+	err := f.Validate()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{"timecode_cues[1]:", "timecode_cues[2] (00:00:01:00): timecode_cues[0]", "timecode_cues[3] (00:00:02:00): scene"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("missing %q in:\n%v", want, err)
+		}
+	}
+	if _, err := (&File{FrameRate: "23.976"}).TimecodeRate(); err == nil {
+		t.Fatal("unsupported frame rate should fail")
+	}
+}
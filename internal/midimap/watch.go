@@ -0,0 +1,38 @@
+package midimap
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WatchFile は path を interval ごとに確認し、更新時刻かサイズが変わるたびに onChange を呼びます。
+// 保存途中などでファイルが一時的に見えない間は前回の状態を保ちます。ctx が終わるまでブロックします。
+func WatchFile(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	var lastMod time.Time
+	var lastSize int64 = -1
+	if st, err := os.Stat(path); err == nil {
+		lastMod, lastSize = st.ModTime(), st.Size()
+	}
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tk.C:
+			st, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if st.ModTime().Equal(lastMod) && st.Size() == lastSize {
+				continue
+			}
+			lastMod, lastSize = st.ModTime(), st.Size()
+			onChange()
+		}
+	}
+}
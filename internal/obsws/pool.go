@@ -0,0 +1,194 @@
+package obsws
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/andreykaipov/goobs"
+    "github.com/andreykaipov/goobs/api/requests/filters"
+    "github.com/andreykaipov/goobs/api/requests/inputs"
+    "github.com/andreykaipov/goobs/api/requests/scenes"
+    "github.com/andreykaipov/goobs/api/requests/transitions"
+)
+
+// Pool は複数の OBS への接続を保持し、同じリクエストを全接続先へ並行に送る。
+// 音量や T バーのように短い間隔で何度も送る操作向け（Trigger は呼ぶたびに接続し直す）。
+// 接続は初回の Do で張り、失敗・エラー時は切断して RetryAfter 経過後に張り直す。
+type Pool struct {
+    Timeout    time.Duration
+    RetryAfter time.Duration
+
+    targets []*poolTarget
+}
+
+type poolTarget struct {
+    addr     string
+    password string
+
+    mu       sync.Mutex
+    c        *goobs.Client
+    failedAt time.Time
+}
+
+// NewPool は addrs への Pool を作る（まだ接続しない）。passwords は Trigger と同じく addrs と同数のときだけ使う。
+func NewPool(addrs []string, password string, passwords []string, timeout time.Duration) *Pool {
+    p := &Pool{Timeout: timeout, RetryAfter: 2 * time.Second}
+    for i, raw := range addrs {
+        a := NormalizeObsAddr(strings.TrimSpace(raw))
+        if a == "" {
+            continue
+        }
+        pw := strings.TrimSpace(password)
+        if len(passwords) == len(addrs) {
+            pw = strings.TrimSpace(passwords[i])
+        }
+        p.targets = append(p.targets, &poolTarget{addr: a, password: pw})
+    }
+    return p
+}
+
+// Select は addrs（NormalizeObsAddr で照合）に当たる接続先だけを対象にした Pool を返す。
+// 接続は元の Pool と共有するので、切断は元の Pool の Close で行う。
+func (p *Pool) Select(addrs []string) *Pool {
+    want := map[string]bool{}
+    for _, a := range addrs {
+        want[NormalizeObsAddr(strings.TrimSpace(a))] = true
+    }
+    sub := &Pool{Timeout: p.Timeout, RetryAfter: p.RetryAfter}
+    for _, t := range p.targets {
+        if want[t.addr] {
+            sub.targets = append(sub.targets, t)
+        }
+    }
+    return sub
+}
+
+// Len は接続先の数を返す。
+func (p *Pool) Len() int { return len(p.targets) }
+
+// Do は全接続先で fn を並行に実行し、失敗した接続先のエラーをまとめて返す。
+func (p *Pool) Do(fn func(c *goobs.Client) error) error {
+    if len(p.targets) == 0 {
+        return errors.New("有効な接続先がありません。-addrs を確認してください。")
+    }
+    var wg sync.WaitGroup
+    errs := make([]error, len(p.targets))
+    for i, t := range p.targets {
+        wg.Add(1)
+        go func(i int, t *poolTarget) {
+            defer wg.Done()
+            errs[i] = p.do(t, fn)
+        }(i, t)
+    }
+    wg.Wait()
+    return errors.Join(errs...)
+}
+
+func (p *Pool) do(t *poolTarget, fn func(c *goobs.Client) error) error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.c == nil {
+        if !t.failedAt.IsZero() && time.Since(t.failedAt) < p.RetryAfter {
+            return fmt.Errorf("[%s] 再接続待ち", t.addr)
+        }
+        var c *goobs.Client
+        var err error
+        if t.password == "" {
+            c, err = goobs.New(t.addr)
+        } else {
+            c, err = goobs.New(t.addr, goobs.WithPassword(t.password))
+        }
+        if err != nil {
+            t.failedAt = time.Now()
+            return fmt.Errorf("[%s] 接続失敗: %w", t.addr, err)
+        }
+        t.c, t.failedAt = c, time.Time{}
+    }
+    if err := withTimeout(func() error { return fn(t.c) }, p.Timeout); err != nil {
+        if connectionBroken(err) {
+            _ = t.c.Disconnect()
+            t.c, t.failedAt = nil, time.Now()
+        }
+        return fmt.Errorf("[%s] %w", t.addr, err)
+    }
+    return nil
+}
+
+// CurrentProgramScene は接続先を順に試し、最初に応答した OBS の現在のプログラムシーンを返す。
+func (p *Pool) CurrentProgramScene() (string, error) {
+    var errs []error
+    for _, t := range p.targets {
+        var name string
+        err := p.do(t, func(c *goobs.Client) error {
+            resp, err := c.Scenes.GetCurrentProgramScene(&scenes.GetCurrentProgramSceneParams{})
+            if err != nil {
+                return err
+            }
+            name = resp.SceneName
+            if name == "" {
+                name = resp.CurrentProgramSceneName // obs-websocket 5.3 より前
+            }
+            return nil
+        })
+        if err == nil {
+            return name, nil
+        }
+        errs = append(errs, err)
+    }
+    if len(errs) == 0 {
+        return "", errors.New("有効な接続先がありません。-addrs を確認してください。")
+    }
+    return "", errors.Join(errs...)
+}
+
+// SetInputVolume は全接続先で input の音量を db（dB）にする。
+func (p *Pool) SetInputVolume(input string, db float64) error {
+    return p.Do(func(c *goobs.Client) error {
+        _, err := c.Inputs.SetInputVolume(&inputs.SetInputVolumeParams{InputName: &input, InputVolumeDb: &db})
+        return err
+    })
+}
+
+// SetTBarPosition は全接続先でスタジオモードの T バーを pos（0..1）に動かす。release で T バーを離す。
+func (p *Pool) SetTBarPosition(pos float64, release bool) error {
+    return p.Do(func(c *goobs.Client) error {
+        _, err := c.Transitions.SetTBarPosition(&transitions.SetTBarPositionParams{Position: &pos, Release: &release})
+        return err
+    })
+}
+
+// SetFilterSetting は全接続先で source のフィルタ filter の設定 key を value にする（他の設定は維持）。
+func (p *Pool) SetFilterSetting(source, filter, key string, value float64) error {
+    overlay := true
+    return p.Do(func(c *goobs.Client) error {
+        _, err := c.Filters.SetSourceFilterSettings(&filters.SetSourceFilterSettingsParams{
+            SourceName:     &source,
+            FilterName:     &filter,
+            FilterSettings: map[string]any{key: value},
+            Overlay:        &overlay,
+        })
+        return err
+    })
+}
+
+// connectionBroken は接続を張り直すべきエラー（切断・応答なし）かを返す。
+// OBS がリクエストを拒否しただけ（入力が無い等）の場合は接続を維持する。
+func connectionBroken(err error) bool {
+    s := strings.ToLower(err.Error())
+    return strings.Contains(s, "disconnected") || strings.Contains(s, "timeout") || strings.Contains(s, "mismatched id")
+}
+
+// Close は全ての接続を切断する。
+func (p *Pool) Close() {
+    for _, t := range p.targets {
+        t.mu.Lock()
+        if t.c != nil {
+            _ = t.c.Disconnect()
+            t.c = nil
+        }
+        t.mu.Unlock()
+    }
+}
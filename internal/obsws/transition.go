@@ -0,0 +1,128 @@
+package obsws
+
+import (
+    "log"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/andreykaipov/goobs"
+    "github.com/andreykaipov/goobs/api/requests/transitions"
+)
+
+// マッピングごとのトランジション（TriggerOptions.Transition / GUI のテイク）は、切替の間だけ OBS の現在の
+// トランジションを変え、トランジションが終わったら元に戻す。同じ OBS への切替が戻す前に続いたときは、
+// 最初に変える前のトランジションを覚えておき、最後の切替のトランジションが終わったらそこへ戻す。
+
+// transitionWaitMax はトランジションの終了を待つ上限。
+const transitionWaitMax = 10 * time.Second
+
+// transitionRestore は OBS（接続先のアドレス）ごとの、戻す前のトランジションの状態。
+type transitionRestore struct {
+    mu      sync.Mutex
+    orig    string // 最初に変える前のトランジション（pending > 0 の間だけ有効）
+    pending int    // まだ戻していない UseTransition の数
+    gen     int    // 最後に変えた UseTransition の番号（これが戻す）
+}
+
+var (
+    restoreMu sync.Mutex
+    restores  = map[string]*transitionRestore{}
+    restoring sync.WaitGroup // Trigger が裏で戻している数
+)
+
+func restoreFor(key string) *transitionRestore {
+    restoreMu.Lock()
+    defer restoreMu.Unlock()
+    r := restores[key]
+    if r == nil {
+        r = &transitionRestore{}
+        restores[key] = r
+    }
+    return r
+}
+
+// UseTransition は want を OBS の現在のトランジションにし、元のトランジションに戻す関数を返す。
+// key は接続先の OBS を表す（アドレス）。戻す関数はシーン切替のトランジションが終わるまで待ってから戻すので、
+// 切替の後（切り替えなかったときも）に呼ぶ。待つ間は呼び出し元を止めるので、必要なら goroutine で呼ぶ。
+func UseTransition(c *goobs.Client, key, want string) (func(), error) {
+    r := restoreFor(key)
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    orig := r.orig
+    if r.pending == 0 {
+        prev, err := c.Transitions.GetCurrentSceneTransition(&transitions.GetCurrentSceneTransitionParams{})
+        if err != nil {
+            return nil, err
+        }
+        orig = prev.TransitionName
+    }
+    name := transitionName(c, want)
+    if r.pending == 0 && name == orig {
+        return func() {}, nil
+    }
+    if _, err := c.Transitions.SetCurrentSceneTransition(&transitions.SetCurrentSceneTransitionParams{TransitionName: &name}); err != nil {
+        return nil, err
+    }
+    var minWait time.Duration
+    if cur, err := c.Transitions.GetCurrentSceneTransition(&transitions.GetCurrentSceneTransitionParams{}); err == nil && !cur.TransitionFixed {
+        minWait = time.Duration(cur.TransitionDuration * float64(time.Millisecond))
+    }
+    r.orig = orig
+    r.pending++
+    r.gen++
+    gen := r.gen
+    return func() {
+        waitTransitionEnd(c, minWait)
+        r.mu.Lock()
+        defer r.mu.Unlock()
+        r.pending--
+        if gen != r.gen {
+            return // 後の切替がトランジションを変えたので、そちらが終わってから戻す
+        }
+        if _, err := c.Transitions.SetCurrentSceneTransition(&transitions.SetCurrentSceneTransitionParams{TransitionName: &orig}); err != nil {
+            log.Printf("[%s] トランジションを %q に戻せませんでした: %v", key, orig, err)
+        }
+    }, nil
+}
+
+// WaitTransitionRestores は Trigger が裏で戻しているトランジションが全て戻るまで（最長 timeout）待つ。
+// プロセスを終える前に呼ぶ（戻す前に終了すると、切替のトランジションが OBS に残る）。
+func WaitTransitionRestores(timeout time.Duration) {
+    done := make(chan struct{})
+    go func() {
+        restoring.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(timeout):
+    }
+}
+
+// transitionName は fade / cut を OBS 上の実際の名称に解決し、それ以外は OBS のトランジション名としてそのまま返す。
+func transitionName(c *goobs.Client, want string) string {
+    want = strings.TrimSpace(want)
+    switch strings.ToLower(want) {
+    case "fade", "cut":
+        return resolveTransitionName(c, want)
+    }
+    return want
+}
+
+// waitTransitionEnd は minWait（最低 100ms）待った後、実行中のトランジションが終わるまで待つ。
+// 途中で戻すと OBS は実行中のトランジションを差し替えてしまう。
+func waitTransitionEnd(c *goobs.Client, minWait time.Duration) {
+    if minWait < 100*time.Millisecond {
+        minWait = 100 * time.Millisecond
+    }
+    time.Sleep(minWait)
+    deadline := time.Now().Add(transitionWaitMax)
+    for time.Now().Before(deadline) {
+        cur, err := c.Transitions.GetCurrentSceneTransitionCursor(&transitions.GetCurrentSceneTransitionCursorParams{})
+        if err != nil || cur.TransitionCursor >= 1 {
+            return
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+}
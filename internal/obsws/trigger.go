@@ -6,6 +6,7 @@ import (
     "log"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
 
     "github.com/andreykaipov/goobs"
@@ -17,14 +18,19 @@ type TriggerOptions struct {
     Password  string   // common password (fallback)
     Passwords []string // optional: aligned with Addrs for per-connection passwords
     Scene     string
+    Transition string // optional: used for this switch only (fade / cut / OBS transition name); restored afterwards
     Media     string
     Action    string
     FireTime  time.Time
     SpinWin   time.Duration
     Timeout   time.Duration
     SkewLog   bool
+    Abort     <-chan struct{} // optional: closing it before FireTime cancels the switch
 }
 
+// ErrAborted は FireTime までに Abort が閉じられ、シーンを切り替えなかったことを表す。
+var ErrAborted = errors.New("発火前に取り消されました")
+
 func Trigger(opts TriggerOptions) error {
     if opts.Scene == "" && (opts.Media == "" || strings.ToLower(opts.Action) == "none") {
         return errors.New("実行内容がありません。-scene か、-media と -action のいずれかを指定してください。")
@@ -32,8 +38,9 @@ func Trigger(opts TriggerOptions) error {
 
     // 事前接続
     type clientWrap struct {
-        addr string
-        c    *goobs.Client
+        addr    string
+        c       *goobs.Client
+        restore func() // per-mapping のトランジションを元に戻す（nil なら変えていない）
     }
     var clients []clientWrap
     var failed []string
@@ -62,8 +69,15 @@ func Trigger(opts TriggerOptions) error {
             failed = append(failed, a)
             continue
         }
-        clients = append(clients, clientWrap{addr: a, c: c})
+        cw := clientWrap{addr: a, c: c}
         log.Printf("接続完了[%d]: ws://%s", i, a)
+        // トランジションは発火時刻の前に設定しておく（失敗しても現在のトランジションで切り替える）
+        if opts.Scene != "" && strings.TrimSpace(opts.Transition) != "" {
+            if cw.restore, err = UseTransition(c, a, opts.Transition); err != nil {
+                log.Printf("[%s] トランジション %q の設定に失敗（現在のトランジションで切替）: %v", a, opts.Transition, err)
+            }
+        }
+        clients = append(clients, cw)
     }
     if len(clients) == 0 {
         if len(failed) > 0 {
@@ -76,7 +90,9 @@ func Trigger(opts TriggerOptions) error {
     }
     defer func() {
         for _, cw := range clients {
-            _ = cw.c.Disconnect()
+            if cw.restore == nil { // トランジションを戻す接続は戻した後に切断する
+                _ = cw.c.Disconnect()
+            }
         }
     }()
 
@@ -92,12 +108,19 @@ func Trigger(opts TriggerOptions) error {
     // 同時発火
     var wg sync.WaitGroup
     errCh := make(chan error, len(clients))
+    var aborted atomic.Int32
 
     for _, cw := range clients {
         wg.Add(1)
         go func(cw clientWrap) {
             defer wg.Done()
             WaitUntil(opts.FireTime, opts.SpinWin)
+            select {
+            case <-opts.Abort:
+                aborted.Add(1)
+                return
+            default:
+            }
             firedAt := time.Now()
             if opts.SkewLog {
                 delta := firedAt.Sub(opts.FireTime)
@@ -130,6 +153,18 @@ func Trigger(opts TriggerOptions) error {
     wg.Wait()
     close(errCh)
 
+    // トランジションは裏で戻す（戻し終えてから切断する）。次の MIDI イベントの処理を待たせない
+    for _, cw := range clients {
+        if cw.restore != nil {
+            restoring.Add(1)
+            go func(cw clientWrap) {
+                defer restoring.Done()
+                cw.restore()
+                _ = cw.c.Disconnect()
+            }(cw)
+        }
+    }
+
     var hadErr bool
     for e := range errCh {
         hadErr = true
@@ -138,6 +173,9 @@ func Trigger(opts TriggerOptions) error {
     if hadErr {
         return errors.New("一部インスタンスで失敗しました。ログをご確認ください。")
     }
+    if aborted.Load() > 0 {
+        return ErrAborted
+    }
     if len(failed) > 0 {
         log.Println("接続できなかったインスタンスがありました。接続済みインスタンスのみで完了しました。")
         return nil